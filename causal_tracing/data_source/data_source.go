@@ -15,6 +15,9 @@ import (
 	"github.com/ilhamster/traceviz/causal_tracing/rendertrace"
 	"github.com/ilhamster/traceviz/server/go/category"
 	"github.com/ilhamster/traceviz/server/go/color"
+	"github.com/ilhamster/traceviz/server/go/flight"
+	"github.com/ilhamster/traceviz/server/go/metrics"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
 	"github.com/ilhamster/traceviz/server/go/table"
 	"github.com/ilhamster/traceviz/server/go/util"
 	criticalpath "github.com/ilhamster/tracey/critical_path"
@@ -307,11 +310,22 @@ func (c *Collection) convertedTrace(traceID string, transformTemplate string) (*
 	return transformedTrace, nil
 }
 
+// collectionCacheLookups counts DataSource collection cache hits and misses.
+var collectionCacheLookups = metrics.Default.Counter(
+	"traceviz_collection_cache_lookups_total",
+	"Collection cache lookups, by cache and result ('hit' or 'miss').",
+	"cache", "result",
+)
+
 // DataSource loads, caches, and serves causal trace data through TraceViz
 // DataSeriesRequests.
 type DataSource struct {
 	defaultTracePath string
 	fetcher          TraceFetcher
+	fetches          flight.Group[*Collection]
+	// If non-nil, a cache of responses to this source's queries, whose
+	// entries for a corpus are invalidated whenever that corpus is loaded.
+	responseCache *querydispatcher.Cache
 
 	mu  sync.Mutex
 	lru *simplelru.LRU
@@ -331,6 +345,36 @@ func New(defaultTracePath string, fetcher TraceFetcher) (*DataSource, error) {
 	}, nil
 }
 
+// WithResponseCache configures ds to invalidate the provided Cache's entries
+// for each corpus it loads, including corpora reloaded after being evicted
+// from its LRU, so that responses computed from an earlier version of the
+// corpus file aren't served.  The Cache should be the one serving ds's
+// queries.
+func (ds *DataSource) WithResponseCache(cache *querydispatcher.Cache) *DataSource {
+	ds.responseCache = cache
+	return ds
+}
+
+// invalidateResponses invalidates the cached responses to queries on the
+// provided corpus path.
+func (ds *DataSource) invalidateResponses(tracePath string) error {
+	if ds.responseCache == nil {
+		return nil
+	}
+	// Requests for the default corpus may carry no path filter, so their
+	// entries can't be singled out.
+	if tracePath == ds.defaultTracePath {
+		ds.responseCache.Purge()
+		return nil
+	}
+	for _, key := range []string{corpusPathKey, tracePathKey} {
+		if err := ds.responseCache.Invalidate(key, util.StringValue(tracePath)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SupportedDataSeriesQueries returns the DataSeriesRequest query names this
 // source supports.
 func (ds *DataSource) SupportedDataSeriesQueries() []string {
@@ -352,259 +396,134 @@ func (ds *DataSource) SupportedDataSeriesQueries() []string {
 	}
 }
 
-func (ds *DataSource) corpusPath(globalFilters map[string]*util.V) (string, error) {
-	if corpusPathValue, ok := globalFilters[corpusPathKey]; ok {
-		corpusPath, err := util.ExpectStringValue(corpusPathValue)
-		if err != nil {
-			return "", fmt.Errorf("global filter %q must be a string: %w", corpusPathKey, err)
-		}
-		if corpusPath != "" {
-			return corpusPath, nil
-		}
-	}
-	// Compatibility with the first loader UI.
-	if tracePathValue, ok := globalFilters[tracePathKey]; ok {
-		tracePath, err := util.ExpectStringValue(tracePathValue)
-		if err != nil {
-			return "", fmt.Errorf("global filter %q must be a string: %w", tracePathKey, err)
-		}
-		if tracePath != "" {
-			return tracePath, nil
-		}
-	}
-	if ds.defaultTracePath == "" {
-		return "", fmt.Errorf("no trace path provided")
-	}
-	return ds.defaultTracePath, nil
+// minimapDependencies are the global filters consumed by traceMinimapQuery.
+var minimapDependencies = []string{
+	corpusPathKey,
+	tracePathKey,
+	traceIDKey,
+	transformTemplateKey,
+	hierarchyTypeKey,
+	expandedCategoryIDsKey,
+	temporalDomainStartKey,
+	temporalDomainEndKey,
+	criticalPathStartKey,
+	criticalPathEndKey,
+	criticalPathStrategyKey,
+	searchKey,
+	expandMatchesKey,
+	hideNonMatchingKey,
+	hideEmptyKey,
+	showOnlyCriticalPathKey,
+	themeKey,
 }
 
-func traceID(globalFilters map[string]*util.V) (string, error) {
-	traceIDValue, ok := globalFilters[traceIDKey]
-	if !ok {
-		return "", nil
+// CacheableQueries returns the DataSeriesRequest query names whose responses
+// may be cached, and the global filters each depends on.  The minimap is
+// cacheable since it is unaffected by focus and, unless empty categories are
+// hidden, by the temporal domain, so it is reissued unchanged as users
+// navigate the trace.
+func (ds *DataSource) CacheableQueries() map[string][]string {
+	return map[string][]string{
+		traceMinimapQuery: minimapDependencies,
 	}
-	traceID, err := util.ExpectStringValue(traceIDValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", traceIDKey, err)
-	}
-	return traceID, nil
 }
 
-func focusSpanIDs(globalFilters map[string]*util.V) ([]string, error) {
-	focusValue, ok := globalFilters[focusSpanIDsKey]
-	if !ok {
-		return nil, nil
-	}
-	focusSpanIDs, err := util.ExpectStringsValue(focusValue)
-	if err != nil {
-		return nil, fmt.Errorf("global filter %q must be a string list: %w", focusSpanIDsKey, err)
-	}
-	return focusSpanIDs, nil
-}
+// boolFilter is a bool global filter.  For compatibility with clients that
+// encode booleans as strings, the strings "true", "false", and "" are also
+// accepted.
+type boolFilter bool
 
-func expandedCategoryIDs(globalFilters map[string]*util.V) ([]string, error) {
-	expandedValue, ok := globalFilters[expandedCategoryIDsKey]
-	if !ok {
-		return nil, nil
-	}
-	expandedCategoryIDs, err := util.ExpectStringsValue(expandedValue)
-	if err != nil {
-		return nil, fmt.Errorf("global filter %q must be a string set: %w", expandedCategoryIDsKey, err)
-	}
-	return expandedCategoryIDs, nil
-}
-
-func temporalDomain(globalFilters map[string]*util.V) (*rendertrace.TimeRange, error) {
-	startValue, hasStart := globalFilters[temporalDomainStartKey]
-	endValue, hasEnd := globalFilters[temporalDomainEndKey]
-	if !hasStart && !hasEnd {
-		return nil, nil
-	}
-	if hasStart != hasEnd {
-		return nil, fmt.Errorf("global filters %q and %q must be provided together", temporalDomainStartKey, temporalDomainEndKey)
-	}
-	start, err := util.ExpectDurationValue(startValue)
-	if err != nil {
-		return nil, fmt.Errorf("global filter %q must be a duration: %w", temporalDomainStartKey, err)
-	}
-	end, err := util.ExpectDurationValue(endValue)
-	if err != nil {
-		return nil, fmt.Errorf("global filter %q must be a duration: %w", temporalDomainEndKey, err)
-	}
-	if start == 0 && end == 0 {
-		return nil, nil
-	}
-	return &rendertrace.TimeRange{Start: start, End: end}, nil
-}
-
-func criticalPathControls(globalFilters map[string]*util.V) (start, end, strategy string, err error) {
-	if startValue, ok := globalFilters[criticalPathStartKey]; ok {
-		start, err = util.ExpectStringValue(startValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", criticalPathStartKey, err)
-		}
-	}
-	if endValue, ok := globalFilters[criticalPathEndKey]; ok {
-		end, err = util.ExpectStringValue(endValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", criticalPathEndKey, err)
-		}
-	}
-	if strategyValue, ok := globalFilters[criticalPathStrategyKey]; ok {
-		strategy, err = util.ExpectStringValue(strategyValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", criticalPathStrategyKey, err)
-		}
-	}
-	return start, end, strategy, nil
-}
-
-func draftCriticalPathControls(globalFilters map[string]*util.V) (start, end, strategy string, err error) {
-	if startValue, ok := globalFilters[draftCriticalPathStartKey]; ok {
-		start, err = util.ExpectStringValue(startValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", draftCriticalPathStartKey, err)
-		}
-	}
-	if endValue, ok := globalFilters[draftCriticalPathEndKey]; ok {
-		end, err = util.ExpectStringValue(endValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", draftCriticalPathEndKey, err)
-		}
-	}
-	if strategyValue, ok := globalFilters[draftCriticalPathStrategyKey]; ok {
-		strategy, err = util.ExpectStringValue(strategyValue)
-		if err != nil {
-			return "", "", "", fmt.Errorf("global filter %q must be a string: %w", draftCriticalPathStrategyKey, err)
-		}
-	}
-	return start, end, strategy, nil
-}
-
-func searchControls(globalFilters map[string]*util.V) (search string, expandMatches bool, err error) {
-	if searchValue, ok := globalFilters[searchKey]; ok {
-		search, err = util.ExpectStringValue(searchValue)
-		if err != nil {
-			return "", false, fmt.Errorf("global filter %q must be a string: %w", searchKey, err)
-		}
-	}
-	if expandMatchesValue, ok := globalFilters[expandMatchesKey]; ok {
-		expandMatches, err = expectStringBoolGlobalFilter(expandMatchesKey, expandMatchesValue)
-		if err != nil {
-			return "", false, err
-		}
-	}
-	return search, expandMatches, nil
-}
-
-func displayControls(globalFilters map[string]*util.V) (hideNonMatching, hideEmpty, showOnlyCriticalPath bool, err error) {
-	if hideNonMatchingValue, ok := globalFilters[hideNonMatchingKey]; ok {
-		hideNonMatching, err = expectStringBoolGlobalFilter(hideNonMatchingKey, hideNonMatchingValue)
-		if err != nil {
-			return false, false, false, err
-		}
-	}
-	if hideEmptyValue, ok := globalFilters[hideEmptyKey]; ok {
-		hideEmpty, err = expectStringBoolGlobalFilter(hideEmptyKey, hideEmptyValue)
-		if err != nil {
-			return false, false, false, err
-		}
-	}
-	if showOnlyCriticalPathValue, ok := globalFilters[showOnlyCriticalPathKey]; ok {
-		showOnlyCriticalPath, err = expectStringBoolGlobalFilter(showOnlyCriticalPathKey, showOnlyCriticalPathValue)
-		if err != nil {
-			return false, false, false, err
-		}
-	}
-	return hideNonMatching, hideEmpty, showOnlyCriticalPath, nil
-}
-
-func theme(globalFilters map[string]*util.V) (rendertrace.Theme, error) {
-	themeValue, ok := globalFilters[themeKey]
-	if !ok {
-		return rendertrace.ThemeLight, nil
-	}
-	themeName, err := util.ExpectStringValue(themeValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", themeKey, err)
-	}
-	switch rendertrace.Theme(themeName) {
-	case "", rendertrace.ThemeLight:
-		return rendertrace.ThemeLight, nil
-	case rendertrace.ThemeDark:
-		return rendertrace.ThemeDark, nil
-	default:
-		return rendertrace.ThemeLight, nil
+func (bf *boolFilter) UnmarshalValue(value *util.V) error {
+	if value.T == util.BoolValueType {
+		b, err := util.ExpectBoolValue(value)
+		*bf = boolFilter(b)
+		return err
 	}
-}
-
-func expectStringBoolGlobalFilter(key string, value *util.V) (bool, error) {
 	stringValue, err := util.ExpectStringValue(value)
 	if err != nil {
-		return false, fmt.Errorf("global filter %q must be a string bool: %w", key, err)
+		return fmt.Errorf("must be a bool or string bool: %w", err)
 	}
 	switch stringValue {
 	case "true":
-		return true, nil
+		*bf = true
 	case "", "false":
-		return false, nil
+		*bf = false
 	default:
-		return false, fmt.Errorf("global filter %q must be \"true\" or \"false\"", key)
+		return fmt.Errorf("must be \"true\" or \"false\"")
 	}
+	return nil
 }
 
-func draftSearch(globalFilters map[string]*util.V) (string, error) {
-	draftSearchValue, ok := globalFilters[draftSearchKey]
-	if !ok {
-		return "", nil
-	}
-	search, err := util.ExpectStringValue(draftSearchValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", draftSearchKey, err)
-	}
-	return search, nil
+// globalFilterOptions holds the DataRequest global filters read by
+// DataSource.
+type globalFilterOptions struct {
+	CorpusPath string `traceviz:"corpus_path"`
+	// Compatibility with the first loader UI.
+	TracePath                 string         `traceviz:"trace_path"`
+	TraceID                   string         `traceviz:"trace_id"`
+	FocusSpanIDs              []string       `traceviz:"focus_span_ids"`
+	ExpandedCategoryIDs       []string       `traceviz:"expanded_category_ids"`
+	TemporalDomainStart       *time.Duration `traceviz:"temporal_domain_start"`
+	TemporalDomainEnd         *time.Duration `traceviz:"temporal_domain_end"`
+	CriticalPathStart         string         `traceviz:"critical_path_start"`
+	CriticalPathEnd           string         `traceviz:"critical_path_end"`
+	CriticalPathStrategy      string         `traceviz:"critical_path_strategy"`
+	DraftCriticalPathStart    string         `traceviz:"draft_critical_path_start"`
+	DraftCriticalPathEnd      string         `traceviz:"draft_critical_path_end"`
+	DraftCriticalPathStrategy string         `traceviz:"draft_critical_path_strategy"`
+	Search                    string         `traceviz:"search"`
+	ExpandMatches             boolFilter     `traceviz:"expand_matches"`
+	HideNonMatching           boolFilter     `traceviz:"hide_non_matching"`
+	HideEmpty                 boolFilter     `traceviz:"hide_empty"`
+	ShowOnlyCriticalPath      boolFilter     `traceviz:"show_only_critical_path"`
+	Theme                     string         `traceviz:"theme"`
+	DraftSearch               string         `traceviz:"draft_search"`
+	TransformTemplate         string         `traceviz:"transform_template"`
+	DraftTransformTemplate    string         `traceviz:"draft_transform_template"`
+	HierarchyName             string         `traceviz:"hierarchy_type"`
 }
 
-func transformTemplate(globalFilters map[string]*util.V) (string, error) {
-	transformTemplateValue, ok := globalFilters[transformTemplateKey]
-	if !ok {
-		return "", nil
+func (ds *DataSource) corpusPath(gf *globalFilterOptions) (string, error) {
+	if gf.CorpusPath != "" {
+		return gf.CorpusPath, nil
 	}
-	template, err := util.ExpectStringValue(transformTemplateValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", transformTemplateKey, err)
+	if gf.TracePath != "" {
+		return gf.TracePath, nil
 	}
-	return template, nil
+	if ds.defaultTracePath == "" {
+		return "", fmt.Errorf("no trace path provided")
+	}
+	return ds.defaultTracePath, nil
 }
 
-func draftTransformTemplate(globalFilters map[string]*util.V) (string, error) {
-	draftTransformValue, ok := globalFilters[draftTransformKey]
-	if !ok {
-		return "", nil
+func temporalDomain(gf *globalFilterOptions) (*rendertrace.TimeRange, error) {
+	if gf.TemporalDomainStart == nil && gf.TemporalDomainEnd == nil {
+		return nil, nil
 	}
-	template, err := util.ExpectStringValue(draftTransformValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", draftTransformKey, err)
+	if gf.TemporalDomainStart == nil || gf.TemporalDomainEnd == nil {
+		return nil, fmt.Errorf("global filters %q and %q must be provided together", temporalDomainStartKey, temporalDomainEndKey)
+	}
+	start, end := *gf.TemporalDomainStart, *gf.TemporalDomainEnd
+	if start == 0 && end == 0 {
+		return nil, nil
 	}
-	return template, nil
+	return &rendertrace.TimeRange{Start: start, End: end}, nil
 }
 
-func hierarchyName(globalFilters map[string]*util.V) (string, error) {
-	hierarchyValue, ok := globalFilters[hierarchyTypeKey]
-	if !ok {
-		return "", nil
-	}
-	hierarchyName, err := util.ExpectStringValue(hierarchyValue)
-	if err != nil {
-		return "", fmt.Errorf("global filter %q must be a string: %w", hierarchyTypeKey, err)
+func theme(gf *globalFilterOptions) rendertrace.Theme {
+	switch rendertrace.Theme(gf.Theme) {
+	case rendertrace.ThemeDark:
+		return rendertrace.ThemeDark
+	default:
+		return rendertrace.ThemeLight
 	}
-	return hierarchyName, nil
 }
 
 func (ds *DataSource) fetchCollection(ctx context.Context, tracePath string) (*Collection, error) {
 	ds.mu.Lock()
 	if collIf, ok := ds.lru.Get(tracePath); ok {
 		ds.mu.Unlock()
+		collectionCacheLookups.With("causal_tracing", "hit").Inc()
 		coll, ok := collIf.(*Collection)
 		if !ok {
 			return nil, fmt.Errorf("cached corpus %q has unexpected type %T", tracePath, collIf)
@@ -612,22 +531,30 @@ func (ds *DataSource) fetchCollection(ctx context.Context, tracePath string) (*C
 		return coll, nil
 	}
 	ds.mu.Unlock()
-	coll, err := ds.fetcher.Fetch(ctx, tracePath)
-	if err != nil {
-		return nil, err
-	}
-	ds.mu.Lock()
-	if collIf, ok := ds.lru.Get(tracePath); ok {
+	collectionCacheLookups.With("causal_tracing", "miss").Inc()
+	// Concurrent loads of the same corpus share a single fetch.
+	coll, _, err := ds.fetches.Do(ctx, tracePath, func(ctx context.Context) (*Collection, error) {
+		coll, err := ds.fetcher.Fetch(ctx, tracePath)
+		if err != nil {
+			return nil, err
+		}
+		ds.mu.Lock()
+		if collIf, ok := ds.lru.Get(tracePath); ok {
+			ds.mu.Unlock()
+			cachedColl, ok := collIf.(*Collection)
+			if !ok {
+				return nil, fmt.Errorf("cached corpus %q has unexpected type %T", tracePath, collIf)
+			}
+			return cachedColl, nil
+		}
+		ds.lru.Add(tracePath, coll)
 		ds.mu.Unlock()
-		cachedColl, ok := collIf.(*Collection)
-		if !ok {
-			return nil, fmt.Errorf("cached corpus %q has unexpected type %T", tracePath, collIf)
+		if err := ds.invalidateResponses(tracePath); err != nil {
+			return nil, err
 		}
-		return cachedColl, nil
-	}
-	ds.lru.Add(tracePath, coll)
-	ds.mu.Unlock()
-	return coll, nil
+		return coll, nil
+	})
+	return coll, err
 }
 
 // HandleDataSeriesRequests handles TraceViz data requests.
@@ -637,57 +564,35 @@ func (ds *DataSource) HandleDataSeriesRequests(
 	drb *util.DataResponseBuilder,
 	reqs []*util.DataSeriesRequest,
 ) error {
-	start := time.Now()
-	defer func() {
-		fmt.Printf("Handled causal tracing queries in %s\n", time.Since(start))
-	}()
-	corpusPath, corpusPathErr := ds.corpusPath(globalFilters)
-	selectedTraceID, traceIDErr := traceID(globalFilters)
-	selectedFocusSpanIDs, focusSpanIDsErr := focusSpanIDs(globalFilters)
-	selectedExpandedCategoryIDs, expandedCategoryIDsErr := expandedCategoryIDs(globalFilters)
-	selectedTemporalDomain, temporalDomainErr := temporalDomain(globalFilters)
-	criticalPathStart, criticalPathEnd, criticalPathStrategy, criticalPathControlsErr := criticalPathControls(globalFilters)
-	draftCriticalPathStart, draftCriticalPathEnd, draftCriticalPathStrategy, draftCriticalPathControlsErr := draftCriticalPathControls(globalFilters)
-	search, expandMatches, searchControlsErr := searchControls(globalFilters)
-	hideNonMatching, hideEmpty, showOnlyCriticalPath, displayControlsErr := displayControls(globalFilters)
-	selectedTheme, themeErr := theme(globalFilters)
-	searchDraft, draftSearchErr := draftSearch(globalFilters)
-	committedTransformTemplate, transformTemplateErr := transformTemplate(globalFilters)
-	draftTransformTemplate, draftTransformErr := draftTransformTemplate(globalFilters)
-	selectedHierarchyName, hierarchyNameErr := hierarchyName(globalFilters)
+	// Decoding stops at the first malformed filter, but any path filters
+	// preceding it are still used to report load status.
+	gf := &globalFilterOptions{}
+	globalFiltersErr := util.DecodeGlobalFilters(globalFilters, gf)
+	corpusPath, corpusPathErr := ds.corpusPath(gf)
+	selectedTemporalDomain, temporalDomainErr := temporalDomain(gf)
 	var coll *Collection
 	var loadErr error
-	if corpusPathErr != nil {
+	if globalFiltersErr != nil {
+		loadErr = globalFiltersErr
+	} else if corpusPathErr != nil {
 		loadErr = corpusPathErr
-	} else if traceIDErr != nil {
-		loadErr = traceIDErr
-	} else if focusSpanIDsErr != nil {
-		loadErr = focusSpanIDsErr
-	} else if expandedCategoryIDsErr != nil {
-		loadErr = expandedCategoryIDsErr
 	} else if temporalDomainErr != nil {
 		loadErr = temporalDomainErr
-	} else if criticalPathControlsErr != nil {
-		loadErr = criticalPathControlsErr
-	} else if draftCriticalPathControlsErr != nil {
-		loadErr = draftCriticalPathControlsErr
-	} else if searchControlsErr != nil {
-		loadErr = searchControlsErr
-	} else if displayControlsErr != nil {
-		loadErr = displayControlsErr
-	} else if themeErr != nil {
-		loadErr = themeErr
-	} else if draftSearchErr != nil {
-		loadErr = draftSearchErr
-	} else if transformTemplateErr != nil {
-		loadErr = transformTemplateErr
-	} else if draftTransformErr != nil {
-		loadErr = draftTransformErr
-	} else if hierarchyNameErr != nil {
-		loadErr = hierarchyNameErr
 	} else {
 		coll, loadErr = ds.fetchCollection(ctx, corpusPath)
 	}
+	selectedTraceID := gf.TraceID
+	selectedFocusSpanIDs := gf.FocusSpanIDs
+	selectedExpandedCategoryIDs := gf.ExpandedCategoryIDs
+	criticalPathStart, criticalPathEnd, criticalPathStrategy := gf.CriticalPathStart, gf.CriticalPathEnd, gf.CriticalPathStrategy
+	draftCriticalPathStart, draftCriticalPathEnd, draftCriticalPathStrategy := gf.DraftCriticalPathStart, gf.DraftCriticalPathEnd, gf.DraftCriticalPathStrategy
+	search, expandMatches := gf.Search, bool(gf.ExpandMatches)
+	hideNonMatching, hideEmpty, showOnlyCriticalPath := bool(gf.HideNonMatching), bool(gf.HideEmpty), bool(gf.ShowOnlyCriticalPath)
+	selectedTheme := theme(gf)
+	searchDraft := gf.DraftSearch
+	committedTransformTemplate := gf.TransformTemplate
+	draftTransformTemplate := gf.DraftTransformTemplate
+	selectedHierarchyName := gf.HierarchyName
 	for _, req := range reqs {
 		series := drb.DataSeries(req)
 		switch req.QueryName {
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
 	"github.com/ilhamster/traceviz/server/go/util"
 )
 
@@ -323,3 +324,94 @@ func TestTraceStatusAndDiagnosticsQueriesUseSelectedTrace(t *testing.T) {
 		}
 	}
 }
+
+func TestBoolFilter(t *testing.T) {
+	for _, test := range []struct {
+		value   *util.V
+		want    bool
+		wantErr bool
+	}{
+		{value: util.BoolValue(true), want: true},
+		{value: util.BoolValue(false), want: false},
+		{value: stringValue("true"), want: true},
+		{value: stringValue("false"), want: false},
+		{value: stringValue(""), want: false},
+		{value: stringValue("yes"), wantErr: true},
+		{value: util.IntegerValue(1), wantErr: true},
+	} {
+		gf := &globalFilterOptions{}
+		err := util.DecodeGlobalFilters(map[string]*util.V{hideEmptyKey: test.value}, gf)
+		if (err != nil) != test.wantErr {
+			t.Errorf("DecodeGlobalFilters(%v) yielded error %v, wanted error: %t", test.value, err, test.wantErr)
+			continue
+		}
+		if got := bool(gf.HideEmpty); got != test.want {
+			t.Errorf("DecodeGlobalFilters(%v) decoded %s as %t, want %t", test.value, hideEmptyKey, got, test.want)
+		}
+	}
+}
+
+func TestLoadInvalidatesCachedResponses(t *testing.T) {
+	tests := []struct {
+		name             string
+		defaultTracePath string
+		globalFilters    map[string]*util.V
+	}{
+		{
+			name: "corpus path",
+			globalFilters: map[string]*util.V{
+				corpusPathKey: util.StringValue(traceyTrace1CorpusPath),
+				traceIDKey:    util.StringValue("tracey-trace1"),
+			},
+		},
+		{
+			name: "trace path",
+			globalFilters: map[string]*util.V{
+				tracePathKey: util.StringValue(traceyTrace1CorpusPath),
+				traceIDKey:   util.StringValue("tracey-trace1"),
+			},
+		},
+		{
+			name:             "default trace path",
+			defaultTracePath: traceyTrace1CorpusPath,
+			globalFilters: map[string]*util.V{
+				traceIDKey: util.StringValue("tracey-trace1"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := querydispatcher.NewCache(1<<20, 0)
+			dataSource := newTestDataSource(t, test.defaultTracePath).WithResponseCache(cache)
+			dispatcher, err := querydispatcher.NewWithOptions([]querydispatcher.Option{querydispatcher.WithCache(cache)}, dataSource)
+			if err != nil {
+				t.Fatalf("NewWithOptions() failed: %v", err)
+			}
+			if _, err := dispatcher.HandleDataRequest(context.Background(), &util.DataRequest{
+				GlobalFilters: test.globalFilters,
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  traceMinimapQuery,
+					SeriesName: "minimap",
+					Options: map[string]*util.V{
+						traceViewWidthPxKey: util.IntegerValue(800),
+					},
+				}},
+			}); err != nil {
+				t.Fatalf("HandleDataRequest() failed: %v", err)
+			}
+			if cache.Bytes() == 0 {
+				t.Fatalf("minimap response wasn't cached")
+			}
+			// Evict the corpus, so that it is reloaded.
+			dataSource.mu.Lock()
+			dataSource.lru.Remove(traceyTrace1CorpusPath)
+			dataSource.mu.Unlock()
+			if _, err := dataSource.fetchCollection(context.Background(), traceyTrace1CorpusPath); err != nil {
+				t.Fatalf("fetchCollection(%q) failed: %v", traceyTrace1CorpusPath, err)
+			}
+			if got := cache.Bytes(); got != 0 {
+				t.Errorf("after reloading the corpus, cache holds %d bytes, want 0", got)
+			}
+		})
+	}
+}
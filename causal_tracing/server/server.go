@@ -10,10 +10,14 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
 	"syscall"
 	"time"
 
 	"github.com/ilhamster/traceviz/causal_tracing/service"
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/handlers/wrappers"
+	"github.com/ilhamster/traceviz/server/go/replay"
 )
 
 var (
@@ -23,6 +27,10 @@ var (
 	defaultTracePath = flag.String("default_trace_path", "testdata/compose-post-ct-logs.json", "Default extended-OTel trace path")
 	clientWatchCmd   = flag.String("client_watch_cmd", "", "Optional shell command to run a frontend watch build; terminated when the server exits")
 	clientWatchCWD   = flag.String("client_watch_cwd", "", "Working directory for --client_watch_cmd")
+	// Authentication and CORS; see the wrappers package.
+	wrapperFlags = wrappers.RegisterFlags(flag.CommandLine)
+	// Request recording; see the replay package.
+	replayFlags = replay.RegisterFlags(flag.CommandLine)
 )
 
 func main() {
@@ -58,7 +66,11 @@ func main() {
 	mux := http.DefaultServeMux
 	service.RegisterHandlers(mux)
 	if *reactRoot != "" {
-		mux.Handle("/react/", http.StripPrefix("/react/", http.FileServer(http.Dir(*reactRoot))))
+		// Vite emits content-hashed bundle files under assets/.
+		reactAsset := handlers.NewFSAsset(os.DirFS(*reactRoot), "/react/").
+			WithSPAFallback("index.html").
+			WithHashedAssetPattern(regexp.MustCompile(`^assets/`))
+		mux.HandleFunc("/react/", reactAsset.HTTPHandler)
 		mux.HandleFunc("/react", func(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/react/", http.StatusFound)
 		})
@@ -71,6 +83,17 @@ func main() {
 		})
 	}
 
+	// Requests are recorded inside authentication, so rejected requests aren't
+	// recorded.
+	wrapFuncs, err := replayFlags.WrapFuncs()
+	if err != nil {
+		log.Fatalf("Failed to configure request recording: %s", err)
+	}
+	authWrapFuncs, err := wrapperFlags.WrapFuncs()
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %s", err)
+	}
+	wrapFuncs = append(wrapFuncs, authWrapFuncs...)
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatalf("Failed to get hostname: %s", err)
@@ -82,7 +105,7 @@ func main() {
 	fmt.Printf("Serving causal tracing at \x1B]8;;http://%[1]s:%[2]d%[3]s\x07http://%[1]s:%[2]d%[3]s\x1B]8;;\x07\n", hostname, *port, basePath)
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", *port),
-		Handler: mux,
+		Handler: wrappers.WrapHandler(mux, wrapFuncs...),
 	}
 	serverDone := make(chan error, 1)
 	go func() {
@@ -3,13 +3,26 @@
 package service
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	datasource "github.com/ilhamster/traceviz/causal_tracing/data_source"
 	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/metrics"
 	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/selftrace"
 )
 
+// The byte budget and entry lifetime of the service's response cache.
+const (
+	responseCacheBytes = 64 << 20
+	responseCacheTTL   = 10 * time.Minute
+)
+
+// The number of recent requests retained for the self-trace view.
+const selfTraceCapacity = 100
+
 // Service owns the TraceViz query handlers for the causal tracing tool.
 type Service struct {
 	queryHandler handlers.QueryHandler
@@ -22,12 +35,27 @@ func New(traceRoot, defaultTracePath string) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
-	dispatcher, err := querydispatcher.New(dataSource)
+	responseCache := querydispatcher.NewCache(responseCacheBytes, responseCacheTTL)
+	dataSource.WithResponseCache(responseCache)
+	selfTraceRecorder := selftrace.NewRecorder(selfTraceCapacity)
+	dispatcher, err := querydispatcher.NewWithOptions(
+		[]querydispatcher.Option{
+			querydispatcher.WithSelfTracing(selfTraceRecorder),
+			querydispatcher.WithInterceptors(
+				querydispatcher.RecordMetrics(metrics.Default),
+				querydispatcher.LogInvocations(log.Printf),
+			),
+			querydispatcher.WithDeduplication(),
+			querydispatcher.WithCache(responseCache),
+		},
+		dataSource,
+		selftrace.NewDataSource(selfTraceRecorder),
+	)
 	if err != nil {
 		return nil, err
 	}
 	return &Service{
-		queryHandler: handlers.NewQueryHandler(dispatcher),
+		queryHandler: handlers.NewQueryHandlerWithOptions(dispatcher, handlers.WithMetrics(metrics.Default)),
 	}, nil
 }
 
@@ -36,4 +64,5 @@ func (s *Service) RegisterHandlers(mux *http.ServeMux) {
 	for path, handler := range s.queryHandler.HandlersByPath() {
 		mux.HandleFunc(path, handler)
 	}
+	mux.HandleFunc("/metrics", metrics.Default.HTTPHandler)
 }
@@ -6,7 +6,9 @@ require (
 	github.com/google/go-cmp v0.6.0
 	github.com/google/safehtml v0.1.0
 	github.com/hashicorp/golang-lru v0.6.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.47.0
 	golang.org/x/sync v0.19.0
-	golang.org/x/text v0.3.8
+	golang.org/x/text v0.33.0
 	golang.org/x/tools v0.41.0
 )
@@ -21,6 +21,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/golang-lru/simplelru"
@@ -28,6 +29,8 @@ import (
 	"github.com/ilhamster/traceviz/server/go/category"
 	"github.com/ilhamster/traceviz/server/go/color"
 	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/flight"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
 	"github.com/ilhamster/traceviz/server/go/table"
 	"github.com/ilhamster/traceviz/server/go/util"
 )
@@ -119,51 +122,49 @@ func (qf *queryFilters) clampTimerange(lt *logtrace.LogTrace) {
 	}
 }
 
+// themeName is the name of an app theme.  For compatibility, non-string
+// values are taken as the 'light' theme.
+type themeName string
+
+func (tn *themeName) UnmarshalValue(v *util.V) error {
+	name, err := util.ExpectStringValue(v)
+	if err != nil {
+		name = "light"
+	}
+	*tn = themeName(name)
+	return nil
+}
+
+// globalFilterOptions holds the DataRequest global filters read by
+// DataSource.
+type globalFilterOptions struct {
+	CollectionName      string     `traceviz:"collection_name,required"`
+	AppTheme            themeName  `traceviz:"app_theme,default=light"`
+	StartTimestamp      *time.Time `traceviz:"start_timestamp"`
+	EndTimestamp        *time.Time `traceviz:"end_timestamp"`
+	Pan                 string     `traceviz:"pan,default=none"`
+	Zoom                string     `traceviz:"zoom,default=none"`
+	FilteredSourceFiles []string   `traceviz:"filtered_source_files"`
+}
+
 // filterFromGlobalFilters returns a queryFilters constructed from the provided
-// TraceViz DataRequest global filters key-value map.
-func filterFromGlobalFilters(lt *logtrace.LogTrace, options map[string]*util.V) (*queryFilters, error) {
+// decoded TraceViz DataRequest global filters.
+func filterFromGlobalFilters(lt *logtrace.LogTrace, gf *globalFilterOptions) (*queryFilters, error) {
 	qf := &queryFilters{}
-	var err error
 	// Populate the filtered timestamps.
 	startTs, endTs := lt.TimeRange()
-	if tsv, ok := options[startTimestampKey]; ok {
-		qf.startTimestamp, err = util.ExpectTimestampValue(tsv)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		qf.startTimestamp = startTs
+	qf.startTimestamp, qf.endTimestamp = startTs, endTs
+	if gf.StartTimestamp != nil {
+		qf.startTimestamp = *gf.StartTimestamp
 	}
-	if tsv, ok := options[endTimestampKey]; ok {
-		qf.endTimestamp, err = util.ExpectTimestampValue(tsv)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		qf.endTimestamp = endTs
+	if gf.EndTimestamp != nil {
+		qf.endTimestamp = *gf.EndTimestamp
 	}
 	qf.clampTimerange(lt)
 	// Adjust the filter timestamps according to pan and zoom.
-	var pan, zoom string
-	if pv, ok := options[panKey]; ok {
-		pan, err = util.ExpectStringValue(pv)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		pan = none
-	}
-	if zv, ok := options[zoomKey]; ok {
-		zoom, err = util.ExpectStringValue(zv)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		zoom = none
-	}
 	halfWidth := qf.endTimestamp.Sub(qf.startTimestamp) / 2
 	midpoint := qf.startTimestamp.Add(halfWidth)
-	switch zoom {
+	switch gf.Zoom {
 	case zoomIn:
 		halfWidth = time.Duration(float64(halfWidth) / zoomFactor)
 		if float64(endTs.Sub(startTs))/(2*float64(halfWidth)) > maxZoom {
@@ -172,7 +173,7 @@ func filterFromGlobalFilters(lt *logtrace.LogTrace, options map[string]*util.V)
 	case zoomOut:
 		halfWidth = time.Duration(float64(halfWidth) * zoomFactor)
 	}
-	switch pan {
+	switch gf.Pan {
 	case panLeft:
 		midpoint = midpoint.Add(-time.Duration(2 * float64(halfWidth) * panFactor))
 		if startTs.Add(halfWidth).After(midpoint) {
@@ -188,18 +189,12 @@ func filterFromGlobalFilters(lt *logtrace.LogTrace, options map[string]*util.V)
 	qf.startTimestamp = midpoint.Add(-halfWidth)
 	qf.clampTimerange(lt)
 	// Populate the filtered source files.
-	if filteredSourceFiles, ok := options[filteredSourceFilesKey]; ok {
-		filteredSourceFileNames, err := util.ExpectStringsValue(filteredSourceFiles)
-		if err != nil {
-			return nil, err
-		}
-		for _, sourceFileName := range filteredSourceFileNames {
-			sourceFile, ok := lt.SourceFilesByID[sourceFileName]
-			if !ok {
-				return nil, fmt.Errorf("'%s' does not specify a known source file", sourceFileName)
-			}
-			qf.sourceFiles = append(qf.sourceFiles, sourceFile)
+	for _, sourceFileName := range gf.FilteredSourceFiles {
+		sourceFile, ok := lt.SourceFilesByID[sourceFileName]
+		if !ok {
+			return nil, fmt.Errorf("'%s' does not specify a known source file", sourceFileName)
 		}
+		qf.sourceFiles = append(qf.sourceFiles, sourceFile)
 	}
 	return qf, nil
 }
@@ -231,6 +226,21 @@ type DataSource struct {
 	lru *simplelru.LRU
 	// A log fetcher used to fetch uncached logs.
 	fetcher LogTraceFetcher
+	// If non-nil, a cache of responses to DataSource's queries, whose entries
+	// for a log are invalidated whenever that log is (re)loaded.
+	responseCache *querydispatcher.Cache
+	// Coalesces concurrent fetches of the same log.
+	fetches flight.Group[*Collection]
+	// Guards lru and subscriptions.
+	mu sync.Mutex
+	// The change subscriptions to each log, by collection name; see
+	// NotifyChanges.
+	subscriptions map[string]map[*changeSubscription]struct{}
+}
+
+// changeSubscription is a subscription to changes in a log.
+type changeSubscription struct {
+	notify func()
 }
 
 // New returns a new DataSource with the specified cache capacity, and using
@@ -241,11 +251,21 @@ func New(cap int, fetcher LogTraceFetcher) (*DataSource, error) {
 		return nil, err
 	}
 	return &DataSource{
-		lru:     lru,
-		fetcher: fetcher,
+		lru:           lru,
+		fetcher:       fetcher,
+		subscriptions: map[string]map[*changeSubscription]struct{}{},
 	}, nil
 }
 
+// WithResponseCache configures the receiver to invalidate the provided
+// Cache's entries for each log it loads or reloads, so that responses computed
+// from an earlier version of the log aren't served.  The Cache should be the
+// one serving the receiver's queries.
+func (ds *DataSource) WithResponseCache(cache *querydispatcher.Cache) *DataSource {
+	ds.responseCache = cache
+	return ds
+}
+
 // SupportedDataSeriesQueries returns the DataSeriesRequest query names
 // supported by DataSource.
 func (ds *DataSource) SupportedDataSeriesQueries() []string {
@@ -258,11 +278,81 @@ func (ds *DataSource) SupportedDataSeriesQueries() []string {
 	}
 }
 
+// queryDependencies are the global filters consumed by DataSource's queries.
+var queryDependencies = []string{
+	collectionNameKey,
+	appThemeKey,
+	startTimestampKey,
+	endTimestampKey,
+	panKey,
+	zoomKey,
+	filteredSourceFilesKey,
+}
+
+// CacheableQueries returns the DataSeriesRequest query names whose responses
+// may be cached, and the global filters each depends on.  Since loaded logs
+// change only when (re)loaded, which invalidates the responses cached for them
+// (see WithResponseCache), all of DataSource's queries are cacheable.
+func (ds *DataSource) CacheableQueries() map[string][]string {
+	ret := map[string][]string{}
+	for _, queryName := range ds.SupportedDataSeriesQueries() {
+		ret[queryName] = queryDependencies
+	}
+	return ret
+}
+
+// DescribeQueries returns descriptions of DataSource's queries, for discovery
+// by frontend authors.
+func (ds *DataSource) DescribeQueries() []*util.QueryDescription {
+	searchRegexOption := &util.OptionDescription{
+		Key:         searchRegexKey,
+		Type:        "string",
+		Description: "A regular expression that log messages must match.",
+	}
+	return []*util.QueryDescription{{
+		Name:          aggregateSourceFilesTableQuery,
+		Description:   "A table of the log's source files, with the number of filtered-in entries from each.",
+		Options:       []*util.OptionDescription{searchRegexOption},
+		GlobalFilters: queryDependencies,
+	}, {
+		Name:          rawEntriesQuery,
+		Description:   "A table of the log's filtered-in entries.",
+		Options:       []*util.OptionDescription{searchRegexOption},
+		GlobalFilters: queryDependencies,
+	}, {
+		Name:        timeseriesQuery,
+		Description: "A timeseries of the log's filtered-in entry rate.",
+		Options: []*util.OptionDescription{{
+			Key:         binCountKey,
+			Type:        "integer",
+			Required:    true,
+			Description: "The number of time bins; must be greater than 1.",
+		}, {
+			Key:         aggregateByKey,
+			Type:        "string",
+			Required:    true,
+			Description: fmt.Sprintf("How entries are grouped into series; only '%s' is supported.", levelNameKey),
+		}},
+		GlobalFilters: queryDependencies,
+	}, {
+		Name:          traceQuery,
+		Description:   "A trace of the log's filtered-in entries, nested by source file path.",
+		GlobalFilters: queryDependencies,
+	}, {
+		Name:          panAndZoomQuery,
+		Description:   "The filtered time range, after applying the pan and zoom global filters.",
+		GlobalFilters: queryDependencies,
+	}}
+}
+
 // fetchCollection returns the specified collection from the LRU if it's
 // present there.  If it isn't already in the LRU, it is fetched and added to
 // the LRU before being returned.
+// Concurrent fetches of the same collection are coalesced into one.
 func (ds *DataSource) fetchCollection(ctx context.Context, collectionName string) (*Collection, error) {
+	ds.mu.Lock()
 	collIf, ok := ds.lru.Get(collectionName)
+	ds.mu.Unlock()
 	if ok {
 		coll, ok := collIf.(*Collection)
 		if !ok {
@@ -270,50 +360,107 @@ func (ds *DataSource) fetchCollection(ctx context.Context, collectionName string
 		}
 		return coll, nil
 	}
+	coll, _, err := ds.fetches.Do(ctx, collectionName, func(ctx context.Context) (*Collection, error) {
+		return ds.loadCollection(ctx, collectionName)
+	})
+	return coll, err
+}
+
+// loadCollection fetches the specified collection and adds it to the LRU,
+// replacing any earlier version, or drops any earlier version if the fetch
+// fails.  Either way, it invalidates any cached responses computed from an
+// earlier version and, if the collection changed, notifies its subscribers.
+func (ds *DataSource) loadCollection(ctx context.Context, collectionName string) (*Collection, error) {
 	coll, err := ds.fetcher.Fetch(ctx, collectionName)
+	ds.mu.Lock()
+	prevIf, reloaded := ds.lru.Peek(collectionName)
+	if err != nil {
+		ds.lru.Remove(collectionName)
+	} else {
+		ds.lru.Add(collectionName, coll)
+	}
+	var notifies []func()
+	if prev, _ := prevIf.(*Collection); reloaded && (err != nil || prev != coll) {
+		for sub := range ds.subscriptions[collectionName] {
+			notifies = append(notifies, sub.notify)
+		}
+	}
+	ds.mu.Unlock()
+	if ds.responseCache != nil {
+		if invalidateErr := ds.responseCache.Invalidate(collectionNameKey, util.StringValue(collectionName)); err == nil {
+			err = invalidateErr
+		}
+	}
+	for _, notify := range notifies {
+		notify()
+	}
 	if err != nil {
 		return nil, err
 	}
-	ds.lru.Add(collectionName, coll)
 	return coll, nil
 }
 
+// NotifyChanges arranges for notify to be called whenever the log specified
+// by the provided global filters is reloaded with changes, until ctx is done.
+func (ds *DataSource) NotifyChanges(ctx context.Context, globalFilters map[string]*util.V, notify func()) {
+	gf := &globalFilterOptions{}
+	if err := util.DecodeGlobalFilters(globalFilters, gf); err != nil {
+		// The subscribed request will fail.
+		return
+	}
+	sub := &changeSubscription{
+		notify: notify,
+	}
+	ds.mu.Lock()
+	if ds.subscriptions[gf.CollectionName] == nil {
+		ds.subscriptions[gf.CollectionName] = map[*changeSubscription]struct{}{}
+	}
+	ds.subscriptions[gf.CollectionName][sub] = struct{}{}
+	ds.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		delete(ds.subscriptions[gf.CollectionName], sub)
+		if len(ds.subscriptions[gf.CollectionName]) == 0 {
+			delete(ds.subscriptions, gf.CollectionName)
+		}
+	})
+}
+
+// Reload fetches anew each log in the receiver's LRU, for instance after the
+// underlying log files have changed, notifying the subscribers to any that
+// changed (see NotifyChanges).  Logs that fail to reload are dropped from the
+// LRU, and the first such failure is returned.
+func (ds *DataSource) Reload(ctx context.Context) error {
+	ds.mu.Lock()
+	keys := ds.lru.Keys()
+	ds.mu.Unlock()
+	var firstErr error
+	for _, key := range keys {
+		collectionName := key.(string)
+		if _, err := ds.loadCollection(ctx, collectionName); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to reload log '%s': %w", collectionName, err)
+		}
+	}
+	return firstErr
+}
+
 // HandleDataSeriesRequests handles the provided set of DataSeriesRequests, with
 // the provided global filters.  It assembles its responses in the provided
 // DataResponseBuilder.
 func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalFilters map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
-	// Log how long it takes to handle each DataRequest.
-	start := time.Now()
-	queryNames := make([]string, 0, len(reqs))
-	for _, req := range reqs {
-		queryNames = append(queryNames, req.QueryName)
-	}
-	defer func() {
-		fmt.Printf("Handled [%s] queries in %s\n", strings.Join(queryNames, ", "), time.Since(start))
-	}()
-	// Pull the collection name from the global filters.
-	collectionNameVal, ok := globalFilters[collectionNameKey]
-	if !ok {
-		return fmt.Errorf("missing required filter option '%s'", collectionNameKey)
-	}
-	collectionName, err := util.ExpectStringValue(collectionNameVal)
-	if err != nil {
-		return fmt.Errorf("required filter option '%s' must be a string", collectionNameKey)
-	}
-	appTheme := "light"
-	if appThemeVal, ok := globalFilters[appThemeKey]; ok {
-		appTheme, err = util.ExpectStringValue(appThemeVal)
-		if err != nil {
-			appTheme = "light"
-		}
+	gf := &globalFilterOptions{}
+	if err := util.DecodeGlobalFilters(globalFilters, gf); err != nil {
+		return err
 	}
+	appTheme := string(gf.AppTheme)
 	// Fetch the collection, from the cache if it's there.
-	coll, err := ds.fetchCollection(ctx, collectionName)
+	coll, err := ds.fetchCollection(ctx, gf.CollectionName)
 	if err != nil {
 		return err
 	}
 	// Build the queryFilters, just once, for all DataSeriesRequests.
-	qf, err := filterFromGlobalFilters(coll.lt, globalFilters)
+	qf, err := filterFromGlobalFilters(coll.lt, gf)
 	if err != nil {
 		return err
 	}
@@ -402,18 +549,21 @@ var (
 	}
 )
 
+// searchOptions holds the DataSeriesRequest options of queries supporting
+// regular expression search.
+type searchOptions struct {
+	SearchRegex string `traceviz:"search_regex"`
+}
+
 func handleSourceFileTableQuery(coll *Collection, qf *queryFilters, tableDb util.DataBuilder, reqOpts map[string]*util.V) error {
-	var err error
-	searchRegexStr := ""
-	if searchRegexVal, ok := reqOpts[searchRegexKey]; ok {
-		searchRegexStr, err = util.ExpectStringValue(searchRegexVal)
-		if err != nil {
-			return err
-		}
+	opts := &searchOptions{}
+	if err := util.DecodeOptions(reqOpts, opts); err != nil {
+		return err
 	}
 	var searchRegex *regexp.Regexp
-	if searchRegexStr != "" {
-		searchRegex, err = regexp.Compile(searchRegexStr)
+	if opts.SearchRegex != "" {
+		var err error
+		searchRegex, err = regexp.Compile(opts.SearchRegex)
 		if err != nil {
 			return err
 		}
@@ -532,17 +682,14 @@ var colorSpacesByThemeAndLevelWeight = map[string]map[int]*color.Space{
 }
 
 func handleRawEntriesQuery(coll *Collection, appTheme string, qf *queryFilters, tableDb util.DataBuilder, reqOpts map[string]*util.V) error {
-	var err error
-	searchRegexStr := ""
-	if searchRegexVal, ok := reqOpts[searchRegexKey]; ok {
-		searchRegexStr, err = util.ExpectStringValue(searchRegexVal)
-		if err != nil {
-			return err
-		}
+	opts := &searchOptions{}
+	if err := util.DecodeOptions(reqOpts, opts); err != nil {
+		return err
 	}
 	var searchRegex *regexp.Regexp
-	if searchRegexStr != "" {
-		searchRegex, err = regexp.Compile(searchRegexStr)
+	if opts.SearchRegex != "" {
+		var err error
+		searchRegex, err = regexp.Compile(opts.SearchRegex)
 		if err != nil {
 			return err
 		}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -544,3 +545,136 @@ func TestQueries(t *testing.T) {
 		})
 	}
 }
+
+// reloadingLogTraceFetcher fetches a single log whose contents can change.
+type reloadingLogTraceFetcher struct {
+	mu  sync.Mutex
+	log string
+}
+
+func (rlf *reloadingLogTraceFetcher) setLog(log string) {
+	rlf.mu.Lock()
+	defer rlf.mu.Unlock()
+	rlf.log = log
+}
+
+func (rlf *reloadingLogTraceFetcher) Fetch(ctx context.Context, collectionName string) (*Collection, error) {
+	rlf.mu.Lock()
+	defer rlf.mu.Unlock()
+	lt, err := logtrace.NewLogTrace(testLogReader(collectionName, rlf.log))
+	if err != nil {
+		return nil, err
+	}
+	return NewCollection(lt), nil
+}
+
+func TestReloadInvalidatesCache(t *testing.T) {
+	fetcher := &reloadingLogTraceFetcher{log: log1}
+	ds, err := New(10, fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	cache := querydispatcher.NewCache(1<<20, 0)
+	qd, err := querydispatcher.NewWithOptions([]querydispatcher.Option{querydispatcher.WithCache(cache)}, ds.WithResponseCache(cache))
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  panAndZoomQuery,
+			SeriesName: "pan_and_zoom",
+		}},
+	}
+	checkTimeRange := func(start, end time.Time) {
+		t.Helper()
+		gotData, err := qd.HandleDataRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+		drb := util.NewDataResponseBuilder()
+		drb.DataSeries(req.SeriesRequests[0]).With(
+			util.TimestampProperty(startTimestampKey, start),
+			util.TimestampProperty(endTimestampKey, end),
+		)
+		if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+			t.Fatalf("Failed to compare data responses: %s", err)
+		}
+	}
+	checkTimeRange(ts(0), ts(time.Minute*30))
+	if cache.Bytes() == 0 {
+		t.Fatalf("Response wasn't cached")
+	}
+	fetcher.setLog(log2)
+	// Until the log is reloaded, the cached response is served.
+	checkTimeRange(ts(0), ts(time.Minute*30))
+	if err := ds.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() yielded unexpected error %s", err)
+	}
+	if got := cache.Bytes(); got != 0 {
+		t.Errorf("After Reload(), cache holds %d bytes, want 0", got)
+	}
+	checkTimeRange(ts(time.Minute*5), ts(time.Minute*35))
+}
+
+func TestSubscribeToReloads(t *testing.T) {
+	fetcher := &reloadingLogTraceFetcher{log: log1}
+	ds, err := New(10, fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  panAndZoomQuery,
+			SeriesName: "pan_and_zoom",
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan *util.Data)
+	done := make(chan error, 1)
+	go func() {
+		done <- qd.Subscribe(ctx, req, func(data *util.Data) error {
+			emitted <- data
+			return nil
+		})
+	}()
+	checkTimeRange := func(start, end time.Time) {
+		t.Helper()
+		var gotData *util.Data
+		select {
+		case gotData = <-emitted:
+		case err := <-done:
+			t.Fatalf("Subscribe() returned early with %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Subscribe() emitted nothing")
+		}
+		drb := util.NewDataResponseBuilder()
+		drb.DataSeries(req.SeriesRequests[0]).With(
+			util.TimestampProperty(startTimestampKey, start),
+			util.TimestampProperty(endTimestampKey, end),
+		)
+		if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+			t.Fatalf("Failed to compare data responses: %s", err)
+		}
+	}
+	checkTimeRange(ts(0), ts(time.Minute*30))
+	fetcher.setLog(log2)
+	if err := ds.Reload(context.Background()); err != nil {
+		t.Fatalf("Reload() yielded unexpected error %s", err)
+	}
+	checkTimeRange(ts(time.Minute*5), ts(time.Minute*35))
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Subscribe() yielded unexpected error %s", err)
+	}
+}
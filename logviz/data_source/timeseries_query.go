@@ -26,24 +26,19 @@ import (
 	xychart "github.com/ilhamster/traceviz/server/go/xy_chart"
 )
 
+// timeseriesOptions holds the DataSeriesRequest options of timeseriesQuery.
+type timeseriesOptions struct {
+	BinCount    int64  `traceviz:"bin_count,required"`
+	AggregateBy string `traceviz:"aggregate_by,required,enum=level_name"`
+}
+
 func handleTimeseriesQuery(coll *Collection, appTheme string, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
 	// Handle query parameters.
-	var binCount int64
-	var aggregateBy string
-	var err error
-	for key, val := range reqOpts {
-		switch key {
-		case binCountKey:
-			binCount, err = util.ExpectIntegerValue(val)
-		case aggregateByKey:
-			aggregateBy, err = util.ExpectStringValue(val)
-		default:
-			return fmt.Errorf("unsupported option '%s'", key)
-		}
-		if err != nil {
-			return err
-		}
+	opts := &timeseriesOptions{}
+	if err := util.DecodeOptions(reqOpts, opts); err != nil {
+		return err
 	}
+	binCount, aggregateBy := opts.BinCount, opts.AggregateBy
 	if binCount <= 1 {
 		return fmt.Errorf("timeseries bin count must be >1")
 	}
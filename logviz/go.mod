@@ -10,8 +10,10 @@ require (
 
 require (
 	github.com/google/safehtml v0.1.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	golang.org/x/crypto v0.47.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
-	golang.org/x/text v0.3.8 // indirect
+	golang.org/x/text v0.33.0 // indirect
 )
 
 replace github.com/ilhamster/traceviz/server/go => ../server/go
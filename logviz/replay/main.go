@@ -0,0 +1,52 @@
+// Binary replay replays a recording of LogViz data requests, made with the
+// LogViz server's --record_requests flag, against the logs under --log_root,
+// reporting any requests whose responses changed.  It exits with status 1 if
+// any did.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/ilhamster/traceviz/logviz/service"
+	"github.com/ilhamster/traceviz/server/go/replay"
+)
+
+var (
+	recording = flag.String("recording", "", "The path to a recording made with the LogViz server's --record_requests flag")
+	logRoot   = flag.String("log_root", ".", "The root path for visualizable logs")
+)
+
+func main() {
+	flag.Parse()
+	if *recording == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	qd, err := service.NewQueryDispatcher(*logRoot, 10)
+	if err != nil {
+		log.Fatalf("Failed to create LogViz query dispatcher: %s", err)
+	}
+	f, err := os.Open(*recording)
+	if err != nil {
+		log.Fatalf("Failed to open recording: %s", err)
+	}
+	defer f.Close()
+	summary, err := replay.Replay(qd, f, func(change *replay.Change) {
+		var queryNames []string
+		for _, seriesReq := range change.Record.Request.SeriesRequests {
+			queryNames = append(queryNames, seriesReq.QueryName)
+		}
+		fmt.Printf("Request %d [%s], recorded %s: %s\n", change.Index, strings.Join(queryNames, ", "), change.Record.Time.Format("2006-01-02 15:04:05"), change.Description)
+	})
+	if err != nil {
+		log.Fatalf("Replay failed: %s", err)
+	}
+	fmt.Printf("Replayed %d requests; %d changed.\n", summary.Replayed, summary.Changed)
+	if summary.Changed > 0 {
+		os.Exit(1)
+	}
+}
@@ -10,10 +10,14 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"regexp"
 	"syscall"
 	"time"
 
 	"github.com/ilhamster/traceviz/logviz/service"
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/handlers/wrappers"
+	"github.com/ilhamster/traceviz/server/go/replay"
 )
 
 var (
@@ -28,6 +32,10 @@ var (
 	//   --client_watch_cwd .. --client_watch_cmd "pnpm --filter ./logviz/react-client exec vite build --watch"
 	clientWatchCmd = flag.String("client_watch_cmd", "", "Optional shell command to run a frontend watch build; terminated when the server exits")
 	clientWatchCWD = flag.String("client_watch_cwd", "", "Working directory for --client_watch_cmd (defaults to current working directory)")
+	// Authentication and CORS; see the wrappers package.
+	wrapperFlags = wrappers.RegisterFlags(flag.CommandLine)
+	// Request recording; see the replay package.
+	replayFlags = replay.RegisterFlags(flag.CommandLine)
 )
 
 func main() {
@@ -63,17 +71,32 @@ func main() {
 		}()
 	}
 
+	// SIGHUP reloads the loaded logs, picking up any changes to their files.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := service.Reload(ctx); err != nil {
+				log.Printf("Failed to reload logs: %s", err)
+			}
+		}
+	}()
+
 	mux := http.DefaultServeMux
 	service.RegisterHandlers(mux)
 
 	if *angularRoot != "" {
-		mux.Handle("/angular/", http.StripPrefix("/angular/", http.FileServer(http.Dir(*angularRoot))))
+		// The service serves the Angular client under /angular/.
 		mux.HandleFunc("/angular", func(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/angular/", http.StatusFound)
 		})
 	}
 	if *reactRoot != "" {
-		mux.Handle("/react/", http.StripPrefix("/react/", http.FileServer(http.Dir(*reactRoot))))
+		// Vite emits content-hashed bundle files under assets/.
+		reactAsset := handlers.NewFSAsset(os.DirFS(*reactRoot), "/react/").
+			WithSPAFallback("index.html").
+			WithHashedAssetPattern(regexp.MustCompile(`^assets/`))
+		mux.HandleFunc("/react/", reactAsset.HTTPHandler)
 		mux.HandleFunc("/react", func(w http.ResponseWriter, r *http.Request) {
 			http.Redirect(w, r, "/react/", http.StatusFound)
 		})
@@ -95,6 +118,17 @@ func main() {
 			http.Redirect(w, r, "/react/", http.StatusFound)
 		})
 	}
+	// Requests are recorded inside authentication, so rejected requests aren't
+	// recorded.
+	wrapFuncs, err := replayFlags.WrapFuncs()
+	if err != nil {
+		log.Fatalf("Failed to configure request recording: %s", err)
+	}
+	authWrapFuncs, err := wrapperFlags.WrapFuncs()
+	if err != nil {
+		log.Fatalf("Failed to configure authentication: %s", err)
+	}
+	wrapFuncs = append(wrapFuncs, authWrapFuncs...)
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatalf("Failed to get hostname: %s", err)
@@ -112,7 +146,7 @@ func main() {
 	fmt.Printf("Serving LogViz at \x1B]8;;http://%[1]s:%[2]d%[3]s\x07http://%[1]s:%[2]d%[3]s\x1B]8;;\x07", hostname, *port, basePath)
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", *port),
-		Handler: mux,
+		Handler: wrappers.WrapHandler(mux, wrapFuncs...),
 	}
 	serverDone := make(chan error, 1)
 	go func() {
@@ -17,16 +17,27 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path"
+	"time"
 
 	"github.com/hashicorp/golang-lru/simplelru"
 	logreader "github.com/ilhamster/traceviz/logviz/analysis/log_reader"
 	logtrace "github.com/ilhamster/traceviz/logviz/analysis/log_trace"
 	datasource "github.com/ilhamster/traceviz/logviz/data_source"
 	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/metrics"
 	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/selftrace"
+)
+
+// collectionCacheLookups counts collectionFetcher cache hits and misses.
+var collectionCacheLookups = metrics.Default.Counter(
+	"traceviz_collection_cache_lookups_total",
+	"Collection cache lookups, by cache and result ('hit' or 'miss').",
+	"cache", "result",
 )
 
 type collectionFetcher struct {
@@ -34,6 +45,14 @@ type collectionFetcher struct {
 	lru            *simplelru.LRU
 }
 
+// fetchedCollection is a collection in a collectionFetcher's LRU, along with
+// the modification time and size its file had when it was loaded.
+type fetchedCollection struct {
+	coll    *datasource.Collection
+	modTime time.Time
+	size    int64
+}
+
 func newCollectionFetcher(collectionRoot string, cap int) (*collectionFetcher, error) {
 	lru, err := simplelru.NewLRU(cap, nil /* no onEvict policy */)
 	if err != nil {
@@ -45,19 +64,32 @@ func newCollectionFetcher(collectionRoot string, cap int) (*collectionFetcher, e
 	}, nil
 }
 
+// Fetch returns the specified collection, loading it from its file unless it
+// is in the receiver's LRU and the file hasn't since changed.
 func (cf *collectionFetcher) Fetch(ctx context.Context, collectionName string) (*datasource.Collection, error) {
-	collIf, ok := cf.lru.Get(collectionName)
+	file, err := os.Open(path.Join(cf.collectionRoot, collectionName))
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fcIf, ok := cf.lru.Get(collectionName)
 	if ok {
-		coll, ok := collIf.(*datasource.Collection)
+		fc, ok := fcIf.(*fetchedCollection)
 		if !ok {
+			file.Close()
 			return nil, fmt.Errorf("fetched collection wasn't a LogTrace")
 		}
-		return coll, nil
-	}
-	file, err := os.Open(path.Join(cf.collectionRoot, collectionName))
-	if err != nil {
-		return nil, err
+		if fc.modTime.Equal(info.ModTime()) && fc.size == info.Size() {
+			collectionCacheLookups.With("logviz", "hit").Inc()
+			file.Close()
+			return fc.coll, nil
+		}
 	}
+	collectionCacheLookups.With("logviz", "miss").Inc()
 	// The TextLogReader takes ownership of the file.
 	lr := logreader.New(
 		collectionName,
@@ -72,52 +104,102 @@ func (cf *collectionFetcher) Fetch(ctx context.Context, collectionName string) (
 		return nil, err
 	}
 	coll := datasource.NewCollection(lt)
-	cf.lru.Add(collectionName, coll)
+	cf.lru.Add(collectionName, &fetchedCollection{
+		coll:    coll,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	})
 	return coll, nil
 }
 
+// The byte budget and entry lifetime of the service's response cache.
+const (
+	responseCacheBytes = 64 << 20
+	responseCacheTTL   = 10 * time.Minute
+)
+
+// The number of recent requests retained for the self-trace view.
+const selfTraceCapacity = 100
+
 type Service struct {
+	dataSource   *datasource.DataSource
 	queryHandler handlers.QueryHandler
 	assetHandler *handlers.AssetHandler
 }
 
-func New(assetRoot, collectionRoot string, cap int) (*Service, error) {
+// NewQueryDispatcher returns the QueryDispatcher serving LogViz queries on
+// the logs under collectionRoot, caching up to cap parsed logs.  Its recent
+// requests are also served as a self-trace; see the selftrace package.
+func NewQueryDispatcher(collectionRoot string, cap int) (*querydispatcher.QueryDispatcher, error) {
+	qd, _, err := newQueryDispatcher(collectionRoot, cap)
+	return qd, err
+}
+
+// newQueryDispatcher is NewQueryDispatcher, also returning the LogViz
+// DataSource.
+func newQueryDispatcher(collectionRoot string, cap int) (*querydispatcher.QueryDispatcher, *datasource.DataSource, error) {
 	cf, err := newCollectionFetcher(collectionRoot, cap)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	ds, err := datasource.New(10, cf)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	responseCache := querydispatcher.NewCache(responseCacheBytes, responseCacheTTL)
+	ds.WithResponseCache(responseCache)
+	selfTraceRecorder := selftrace.NewRecorder(selfTraceCapacity)
+	qd, err := querydispatcher.NewWithOptions(
+		[]querydispatcher.Option{
+			querydispatcher.WithSelfTracing(selfTraceRecorder),
+			querydispatcher.WithInterceptors(
+				querydispatcher.RecordMetrics(metrics.Default),
+				querydispatcher.LogInvocations(log.Printf),
+			),
+			querydispatcher.WithDeduplication(),
+			querydispatcher.WithCache(responseCache),
+		},
+		ds,
+		selftrace.NewDataSource(selfTraceRecorder),
+	)
+	if err != nil {
+		return nil, nil, err
 	}
-	qd, err := querydispatcher.New(ds)
+	return qd, ds, nil
+}
+
+func New(assetRoot, collectionRoot string, cap int) (*Service, error) {
+	qd, ds, err := newQueryDispatcher(collectionRoot, cap)
 	if err != nil {
 		return nil, err
 	}
 	assetHandler := handlers.NewAssetHandler()
-	addFileAsset := func(resourceName, resourceType, filename string) {
+	if assetRoot != "" {
 		assetHandler.With(
-			resourceName,
-			handlers.NewFileAsset(
-				path.Join(assetRoot, filename),
-				resourceType,
-			),
+			"/angular/",
+			handlers.NewFSAsset(os.DirFS(assetRoot), "/angular/").WithSPAFallback("index.html"),
 		)
 	}
-	addFileAsset("/angular/logviz-theme.css", "text/css", "logviz-theme.css")
-	addFileAsset("/angular/index.html", "text/html", "index.html")
-	addFileAsset("/angular/main.js", "application/javascript", "main.js")
-	addFileAsset("/angular/polyfills.js", "application/javascript", "polyfills.js")
-	addFileAsset("/angular/runtime.js", "application/javascript", "runtime.js")
-	addFileAsset("/angular/favicon.ico", "image/x-icon", "favicon.ico")
 	return &Service{
-		queryHandler: handlers.NewQueryHandler(qd),
+		dataSource:   ds,
+		queryHandler: handlers.NewQueryHandlerWithOptions(qd, handlers.WithMetrics(metrics.Default)),
 		assetHandler: assetHandler,
 	}, nil
 }
 
+// Reload reloads the loaded logs, rereading those whose files have changed
+// since they were read, drops the cached responses computed from them, and
+// notifies their subscribers of any changes.
+func (s *Service) Reload(ctx context.Context) error {
+	return s.dataSource.Reload(ctx)
+}
+
 func (s *Service) RegisterHandlers(mux *http.ServeMux) {
 	for path, handler := range s.queryHandler.HandlersByPath() {
 		mux.HandleFunc(path, handler)
 	}
+	for path, handler := range s.assetHandler.HandlersByPath() {
+		mux.HandleFunc(path, handler)
+	}
+	mux.HandleFunc("/metrics", metrics.Default.HTTPHandler)
 }
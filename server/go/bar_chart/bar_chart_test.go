@@ -14,8 +14,10 @@
 package barchart
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ilhamster/traceviz/server/go/category"
 	categoryaxis "github.com/ilhamster/traceviz/server/go/category_axis"
 	"github.com/ilhamster/traceviz/server/go/color"
@@ -253,3 +255,52 @@ func TestBarChart(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		bc := New(db, dblAxis, renderSettings)
+		fruit := bc.Category(category.New("fruit", "fruit", "fruit"))
+		fruit.Bar(0, 10).With(util.StringProperty("name", "apples"))
+		stackedBars := fruit.StackedBars()
+		stackedBars.Bar(0, 5)
+		stackedBars.Bar(5, 8)
+		bc.Category(category.New("weights", "weights", "weights")).BoxPlot(1, 2, 3, 4, 5)
+	})
+	got, err := Decode[float64](root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if got.ValueAxis.Category.ID() != "axis" || got.ValueAxis.Max != 100 {
+		t.Errorf("Decode() yielded value axis %v, want 'axis' to 100", got.ValueAxis)
+	}
+	// Summarize each category's lanes.
+	gotLanes := map[string][]string{}
+	for _, cat := range got.Categories {
+		for _, lane := range cat.Lanes {
+			var summary string
+			switch {
+			case lane.Bar != nil:
+				summary = fmt.Sprintf("bar %v-%v", lane.Bar.Lower, lane.Bar.Upper)
+			case lane.StackedBars != nil:
+				summary = "stacked"
+				for _, bar := range lane.StackedBars.Bars {
+					summary += fmt.Sprintf(" %v-%v", bar.Lower, bar.Upper)
+				}
+			case lane.BoxPlot != nil:
+				bp := lane.BoxPlot
+				summary = fmt.Sprintf("box %v %v %v %v %v", bp.Min, bp.Q1, bp.Q2, bp.Q3, bp.Max)
+			}
+			gotLanes[cat.Category.ID()] = append(gotLanes[cat.Category.ID()], summary)
+		}
+	}
+	wantLanes := map[string][]string{
+		"fruit":   {"bar 0-10", "stacked 0-5 5-8"},
+		"weights": {"box 1 2 3 4 5"},
+	}
+	if diff := cmp.Diff(wantLanes, gotLanes); diff != "" {
+		t.Errorf("Decode() yielded lanes %v, diff (-want +got) %s", gotLanes, diff)
+	}
+	if name, err := got.Categories[0].Lanes[0].Bar.Properties.String("name"); err != nil || name != "apples" {
+		t.Errorf("Decode() yielded bar name %q (err %v), want 'apples'", name, err)
+	}
+}
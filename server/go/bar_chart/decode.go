@@ -0,0 +1,167 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package barchart
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedBarChart is a BarChart decoded from a Datum.  Each decoded element
+// retains a reader over its Datum's Properties, from which decorators may be
+// read.
+type DecodedBarChart[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	ValueAxis  *continuousaxis.DecodedAxis[T]
+	Categories []*DecodedCategory[T]
+}
+
+// DecodedCategory is a bar chart Category decoded from a Datum.
+type DecodedCategory[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Category   *category.Category
+	// The category's lanes, in definition order.
+	Lanes []*DecodedLane[T]
+}
+
+// DecodedLane is a single lane within a bar chart Category.  Exactly one of
+// its StackedBars, Bar, and BoxPlot is non-nil.
+type DecodedLane[T float64 | time.Duration | time.Time] struct {
+	StackedBars *DecodedStackedBars[T]
+	Bar         *DecodedBar[T]
+	BoxPlot     *DecodedBoxPlot[T]
+}
+
+// DecodedStackedBars is a StackedBars decoded from a Datum.
+type DecodedStackedBars[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Bars       []*DecodedBar[T]
+}
+
+// DecodedBar is a Bar decoded from a Datum.
+type DecodedBar[T float64 | time.Duration | time.Time] struct {
+	Properties   *util.DatumReader
+	Lower, Upper T
+}
+
+// DecodedBoxPlot is a BoxPlot decoded from a Datum.
+type DecodedBoxPlot[T float64 | time.Duration | time.Time] struct {
+	Properties           *util.DatumReader
+	Min, Q1, Q2, Q3, Max T
+}
+
+// Decode decodes the provided Datum, which must have been populated by a
+// BarChart[T] and whose strings are in the provided string table, back into a
+// DecodedBarChart.
+func Decode[T float64 | time.Duration | time.Time](d *util.Datum, stringTable []string) (*DecodedBarChart[T], error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	axis, err := continuousaxis.Decode[T](dr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bar chart value axis: %w", err)
+	}
+	ret := &DecodedBarChart[T]{
+		Properties: dr,
+		ValueAxis:  axis,
+	}
+	for _, catDr := range dr.Children() {
+		cat, err := category.Decode(catDr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode bar chart category: %w", err)
+		}
+		decodedCat := &DecodedCategory[T]{
+			Properties: catDr,
+			Category:   cat,
+		}
+		for _, laneDr := range catDr.Children() {
+			lane, err := decodeLane[T](laneDr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode bar chart category '%s': %w", cat.ID(), err)
+			}
+			decodedCat.Lanes = append(decodedCat.Lanes, lane)
+		}
+		ret.Categories = append(ret.Categories, decodedCat)
+	}
+	return ret, nil
+}
+
+func decodeLane[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedLane[T], error) {
+	dataType, err := dr.String(dataTypeKey)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DecodedLane[T]{}
+	switch dataType {
+	case stackedBarsKey:
+		ret.StackedBars = &DecodedStackedBars[T]{
+			Properties: dr,
+		}
+		for _, barDr := range dr.Children() {
+			bar, err := decodeBar[T](barDr)
+			if err != nil {
+				return nil, err
+			}
+			ret.StackedBars.Bars = append(ret.StackedBars.Bars, bar)
+		}
+	case barKey:
+		if ret.Bar, err = decodeBar[T](dr); err != nil {
+			return nil, err
+		}
+	case boxPlotKey:
+		bp := &DecodedBoxPlot[T]{
+			Properties: dr,
+		}
+		for _, field := range []struct {
+			key string
+			val *T
+		}{
+			{boxPlotMinKey, &bp.Min},
+			{boxPlotQ1Key, &bp.Q1},
+			{boxPlotQ2Key, &bp.Q2},
+			{boxPlotQ3Key, &bp.Q3},
+			{boxPlotMaxKey, &bp.Max},
+		} {
+			if *field.val, err = continuousaxis.DecodeValue[T](dr, field.key); err != nil {
+				return nil, err
+			}
+		}
+		ret.BoxPlot = bp
+	default:
+		return nil, fmt.Errorf("unsupported bar chart data type '%s'", dataType)
+	}
+	return ret, nil
+}
+
+func decodeBar[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedBar[T], error) {
+	if dataType, err := dr.String(dataTypeKey); err != nil || dataType != barKey {
+		return nil, fmt.Errorf("expected a bar")
+	}
+	ret := &DecodedBar[T]{
+		Properties: dr,
+	}
+	var err error
+	if ret.Lower, err = continuousaxis.DecodeValue[T](dr, barLowerExtentKey); err != nil {
+		return nil, err
+	}
+	if ret.Upper, err = continuousaxis.DecodeValue[T](dr, barUpperExtentKey); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
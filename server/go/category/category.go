@@ -73,3 +73,40 @@ func Tag(cats ...*Category) util.PropertyUpdate {
 	}
 	return util.StringsPropertyExtended(categoryIDsKey, categoryIDs...)
 }
+
+// DisplayName returns the category's display name.
+func (c *Category) DisplayName() string {
+	return c.displayName
+}
+
+// Description returns the category's description.
+func (c *Category) Description() string {
+	return c.description
+}
+
+// Decode returns the Category defined on the provided DatumReader, or an
+// error if none is defined there.
+func Decode(dr *util.DatumReader) (*Category, error) {
+	id, err := dr.String(categoryDefinedIDKey)
+	if err != nil {
+		return nil, err
+	}
+	displayName, err := dr.String(categoryDisplayNameKey)
+	if err != nil {
+		return nil, err
+	}
+	description, err := dr.String(categoryDescriptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return New(id, displayName, description), nil
+}
+
+// DecodeTags returns the IDs of the categories tagging the provided
+// DatumReader, or nil if it is not tagged.
+func DecodeTags(dr *util.DatumReader) ([]string, error) {
+	if !dr.Has(categoryIDsKey) {
+		return nil, nil
+	}
+	return dr.Strings(categoryIDsKey)
+}
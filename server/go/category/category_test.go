@@ -16,6 +16,7 @@ package category
 import (
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	testutil "github.com/ilhamster/traceviz/server/go/test_util"
 	"github.com/ilhamster/traceviz/server/go/util"
 )
@@ -97,3 +98,32 @@ func TestCategoryDefinitionAndTagging(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	cars := New("cars", "Cars", "Personal vehicles")
+	trucks := New("trucks", "Trucks", "Work vehicles")
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		db.With(cars.Define()).Child().With(Tag(cars, trucks))
+	})
+	dr, err := util.NewDatumReader(root, st)
+	if err != nil {
+		t.Fatalf("NewDatumReader() yielded unexpected error %s", err)
+	}
+	gotCat, err := Decode(dr)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if gotCat.ID() != "cars" || gotCat.DisplayName() != "Cars" || gotCat.Description() != "Personal vehicles" {
+		t.Errorf("Decode() = %v, want %v", gotCat, cars)
+	}
+	if _, err := Decode(dr.Children()[0]); err == nil {
+		t.Errorf("Decode() of an undefined category succeeded, wanted error")
+	}
+	gotTags, err := DecodeTags(dr.Children()[0])
+	if err != nil {
+		t.Fatalf("DecodeTags() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]string{"cars", "trucks"}, gotTags); diff != "" {
+		t.Errorf("DecodeTags() = %v, diff (-want +got) %s", gotTags, diff)
+	}
+}
@@ -0,0 +1,335 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package client provides a Go client for the TraceViz /GetData protocol.
+// Requests are assembled with a RequestBuilder:
+//
+//	req := client.NewRequest(client.String("collection_name", "foo")).
+//	  Series("my_query", "1", client.Integer("max_depth", 3)).
+//	  Request()
+//
+// and sent with a Client, which may talk to a TraceViz server over HTTP:
+//
+//	c := client.NewHTTP("http://localhost:8080/GetData", client.WithRetries(3, time.Second))
+//
+// or directly to a QueryDispatcher in the same process:
+//
+//	c := client.NewInProcess(qd)
+//
+// Both kinds of Client handle requests identically, so tests and tools can
+// share one code path.  Responses resolve string-index values, so series
+// contents may be read with util.DatumReader or with the typed decoders in the
+// structured-data packages:
+//
+//	resp, err := c.Fetch(ctx, req)
+//	series, err := resp.Series("1")
+//	depth, err := series.Root.Integer("depth")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// HTTPError is returned when a TraceViz server responds to a request with a
+// non-OK status.
+type HTTPError struct {
+	StatusCode int
+	// The body of the response, generally describing the failure.
+	Message string
+}
+
+func (he *HTTPError) Error() string {
+	return fmt.Sprintf("TraceViz server responded %d %s: %s", he.StatusCode, http.StatusText(he.StatusCode), he.Message)
+}
+
+// retryable returns true if the receiver describes a failure that might not
+// recur on retry.
+func (he *HTTPError) retryable() bool {
+	return he.StatusCode >= http.StatusInternalServerError || he.StatusCode == http.StatusTooManyRequests
+}
+
+// fetchFn sends a DataRequest and returns its response.  If it fails, it also
+// returns whether the failure might not recur on retry.
+type fetchFn func(ctx context.Context, req *util.DataRequest) (data *util.Data, retryable bool, err error)
+
+// Client sends DataRequests to a TraceViz server or QueryDispatcher.
+type Client struct {
+	fetch      fetchFn
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	timeout    time.Duration
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient configures a Client to send HTTP requests with the provided
+// http.Client.  By default, http.DefaultClient is used.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries configures a Client to retry failed requests up to the
+// specified number of times, if the failure might not recur.  Network errors,
+// timeouts, and HTTP 5xx and 429 responses are retried.  The Client waits
+// the specified backoff before the first retry, doubling it for each
+// subsequent retry.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithTimeout configures a Client to abandon each attempt at a request after
+// the specified timeout.  This is in addition to any deadline on the Context
+// passed to Fetch, which bounds all attempts together.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+func newClient(opts []Option) *Client {
+	ret := &Client{
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// NewHTTP returns a new Client sending requests to the TraceViz /GetData
+// endpoint at the provided URL.
+func NewHTTP(endpoint string, opts ...Option) *Client {
+	ret := newClient(opts)
+	ret.fetch = func(ctx context.Context, req *util.DataRequest) (*util.Data, bool, error) {
+		return fetchHTTP(ctx, ret.httpClient, endpoint, req)
+	}
+	return ret
+}
+
+// NewInProcess returns a new Client handling requests with the provided
+// QueryDispatcher.  Requests are encoded and decoded just as they are for
+// HTTP requests, so are interpreted identically.
+func NewInProcess(qd *querydispatcher.QueryDispatcher, opts ...Option) *Client {
+	ret := newClient(opts)
+	ret.fetch = func(ctx context.Context, req *util.DataRequest) (*util.Data, bool, error) {
+		reqJSON, err := encodeRequest(req)
+		if err != nil {
+			return nil, false, err
+		}
+		decodedReq, err := util.DataRequestFromJSON(reqJSON)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := qd.HandleDataRequest(ctx, decodedReq)
+		return data, false, err
+	}
+	return ret
+}
+
+// Fetch sends the provided DataRequest and returns its response.
+func (c *Client) Fetch(ctx context.Context, req *util.DataRequest) (*Response, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		data, retryable, err := c.attempt(ctx, req)
+		if err == nil {
+			return &Response{
+				Data: data,
+			}, nil
+		}
+		if !retryable || attempt >= c.retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, req *util.DataRequest) (*util.Data, bool, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	data, retryable, err := c.fetch(ctx, req)
+	if err != nil && ctx.Err() != nil {
+		// This attempt timed out; if the caller's context is still live, a retry
+		// may succeed.
+		return nil, true, err
+	}
+	return data, retryable, err
+}
+
+// escapeValue returns a copy of the provided value with all string contents
+// URL-escaped, as TraceViz clients send them.
+func escapeValue(v *util.V) *util.V {
+	switch v.T {
+	case util.StringValueType:
+		return util.StringValue(url.QueryEscape(v.V.(string)))
+	case util.StringsValueType:
+		strs := v.V.([]string)
+		escaped := make([]string, len(strs))
+		for idx, str := range strs {
+			escaped[idx] = url.QueryEscape(str)
+		}
+		return util.StringsValue(escaped...)
+	case util.ListValueType:
+		vals := v.V.([]*util.V)
+		escaped := make([]*util.V, len(vals))
+		for idx, val := range vals {
+			escaped[idx] = escapeValue(val)
+		}
+		return util.ListValue(escaped...)
+	case util.MapValueType:
+		vals := v.V.(map[string]*util.V)
+		escaped := make(map[string]*util.V, len(vals))
+		for k, val := range vals {
+			escaped[k] = escapeValue(val)
+		}
+		return util.MapValue(escaped)
+	default:
+		return v
+	}
+}
+
+func escapeValues(vals map[string]*util.V) map[string]*util.V {
+	ret := make(map[string]*util.V, len(vals))
+	for k, v := range vals {
+		if v == nil {
+			ret[k] = nil
+			continue
+		}
+		ret[k] = escapeValue(v)
+	}
+	return ret
+}
+
+// encodeRequest returns the JSON encoding of the provided DataRequest, as sent
+// in a /GetData request.
+func encodeRequest(req *util.DataRequest) ([]byte, error) {
+	escaped := &util.DataRequest{
+		GlobalFilters:       escapeValues(req.GlobalFilters),
+		SeriesRequests:      make([]*util.DataSeriesRequest, len(req.SeriesRequests)),
+		AllowPartialResults: req.AllowPartialResults,
+	}
+	for idx, seriesReq := range req.SeriesRequests {
+		escaped.SeriesRequests[idx] = &util.DataSeriesRequest{
+			QueryName:  seriesReq.QueryName,
+			SeriesName: seriesReq.SeriesName,
+			Options:    escapeValues(seriesReq.Options),
+		}
+	}
+	return json.Marshal(escaped)
+}
+
+func fetchHTTP(ctx context.Context, httpClient *http.Client, endpoint string, req *util.DataRequest) (*util.Data, bool, error) {
+	reqJSON, err := encodeRequest(req)
+	if err != nil {
+		return nil, false, err
+	}
+	form := url.Values{"req": []string{string(reqJSON)}}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, false, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", handlers.BinaryContentType+", "+handlers.JSONContentType+";q=0.5")
+	httpResp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, true, err
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		he := &HTTPError{
+			StatusCode: httpResp.StatusCode,
+			Message:    string(bytes.TrimSpace(body)),
+		}
+		return nil, he.retryable(), he
+	}
+	data := &util.Data{}
+	if httpResp.Header.Get("Content-Type") == handlers.BinaryContentType {
+		err = data.UnmarshalBinary(body)
+	} else {
+		err = json.Unmarshal(body, data)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode TraceViz response: %w", err)
+	}
+	return data, false, nil
+}
+
+// Response is a TraceViz data response.
+type Response struct {
+	Data *util.Data
+}
+
+// Series is a single DataSeries within a Response.
+type Series struct {
+	Name string
+	// A reader over the series' root Datum, resolving string-index values
+	// against the response's string table.
+	Root *util.DatumReader
+	// If non-nil, the series was truncated to fit the server's response
+	// limits.
+	Truncated *util.Truncation
+}
+
+// Series returns the DataSeries with the specified series name in the
+// receiver.  It returns an error if there is no such series, or if that
+// series failed.
+func (r *Response) Series(seriesName string) (*Series, error) {
+	for _, series := range r.Data.DataSeries {
+		if series.SeriesName != seriesName {
+			continue
+		}
+		if series.Error != nil {
+			return nil, fmt.Errorf("series '%s' failed in query '%s': %s", seriesName, series.Error.QueryName, series.Error.Message)
+		}
+		root, err := util.NewDatumReader(series.Root, r.Data.StringTable)
+		if err != nil {
+			return nil, err
+		}
+		return &Series{
+			Name:      seriesName,
+			Root:      root,
+			Truncated: series.Truncated,
+		}, nil
+	}
+	return nil, fmt.Errorf("response has no series '%s'", seriesName)
+}
@@ -0,0 +1,224 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// echoDataSource handles the 'echo' query, echoing its 'greeting' global
+// filter, its 'names' option, and its 'count' option back as properties.
+type echoDataSource struct{}
+
+func (eds *echoDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"echo"}
+}
+
+func (eds *echoDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	greeting, err := util.ExpectStringValue(globalState["greeting"])
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		names, err := util.ExpectStringsValue(req.Options["names"])
+		if err != nil {
+			return err
+		}
+		count, err := util.ExpectIntegerValue(req.Options["count"])
+		if err != nil {
+			return err
+		}
+		drb.DataSeries(req).With(
+			util.StringProperty("greeting", greeting),
+			util.StringsProperty("names", names...),
+			util.IntegerProperty("count", count),
+		)
+	}
+	return nil
+}
+
+func newQueryDispatcher(t *testing.T) *querydispatcher.QueryDispatcher {
+	t.Helper()
+	qd, err := querydispatcher.New(&echoDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	return qd
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, handler := range handlers.NewQueryHandler(newQueryDispatcher(t)).HandlersByPath() {
+		mux.HandleFunc(path, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	server := newServer(t)
+	for _, test := range []struct {
+		description string
+		client      *Client
+	}{{
+		description: "http",
+		client:      NewHTTP(server.URL + "/GetData"),
+	}, {
+		description: "in-process",
+		client:      NewInProcess(newQueryDispatcher(t)),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			// Strings containing URL-escaping metacharacters are delivered intact.
+			req := NewRequest(String("greeting", "50% + 50% = hello")).
+				Series("echo", "1", Strings("names", "a b", "c+d"), Integer("count", 2)).
+				Request()
+			resp, err := test.client.Fetch(context.Background(), req)
+			if err != nil {
+				t.Fatalf("Fetch() yielded unexpected error %s", err)
+			}
+			series, err := resp.Series("1")
+			if err != nil {
+				t.Fatalf("Series() yielded unexpected error %s", err)
+			}
+			greeting, err := series.Root.String("greeting")
+			if err != nil {
+				t.Fatalf("failed to read greeting: %s", err)
+			}
+			if greeting != "50% + 50% = hello" {
+				t.Errorf("Got greeting %q, want '50%% + 50%% = hello'", greeting)
+			}
+			names, err := series.Root.Strings("names")
+			if err != nil {
+				t.Fatalf("failed to read names: %s", err)
+			}
+			if diff := cmp.Diff([]string{"a b", "c+d"}, names); diff != "" {
+				t.Errorf("Got names %v, diff (-want +got) %s", names, diff)
+			}
+			if count, err := series.Root.Integer("count"); err != nil || count != 2 {
+				t.Errorf("Got count %d (err %v), want 2", count, err)
+			}
+			if _, err := resp.Series("2"); err == nil {
+				t.Errorf("Series() of a missing series succeeded, wanted error")
+			}
+		})
+	}
+}
+
+func TestFetchFailedSeries(t *testing.T) {
+	req := NewRequest(String("greeting", "hi")).
+		Series("unsupported", "1").
+		AllowPartialResults().
+		Request()
+	resp, err := NewInProcess(newQueryDispatcher(t)).Fetch(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Fetch() yielded unexpected error %s", err)
+	}
+	if _, err := resp.Series("1"); err == nil {
+		t.Errorf("Series() of a failed series succeeded, wanted error")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, test := range []struct {
+		description  string
+		failures     int
+		failStatus   int
+		retries      int
+		wantAttempts int32
+		wantStatus   int
+	}{{
+		description:  "retries until success",
+		failures:     2,
+		failStatus:   http.StatusServiceUnavailable,
+		retries:      2,
+		wantAttempts: 3,
+	}, {
+		description:  "retries exhausted",
+		failures:     2,
+		failStatus:   http.StatusServiceUnavailable,
+		retries:      1,
+		wantAttempts: 2,
+		wantStatus:   http.StatusServiceUnavailable,
+	}, {
+		description:  "bad requests are not retried",
+		failures:     1,
+		failStatus:   http.StatusBadRequest,
+		retries:      2,
+		wantAttempts: 1,
+		wantStatus:   http.StatusBadRequest,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			handler := handlers.NewQueryHandler(newQueryDispatcher(t)).HandlersByPath()["/GetData"]
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(attempts.Add(1)) <= test.failures {
+					http.Error(w, "try again", test.failStatus)
+					return
+				}
+				handler(w, r)
+			}))
+			defer server.Close()
+			client := NewHTTP(server.URL, WithRetries(test.retries, time.Millisecond))
+			req := NewRequest(String("greeting", "hi")).
+				Series("echo", "1", Strings("names"), Integer("count", 1)).
+				Request()
+			_, err := client.Fetch(context.Background(), req)
+			if got := attempts.Load(); got != test.wantAttempts {
+				t.Errorf("Fetch() made %d attempts, want %d", got, test.wantAttempts)
+			}
+			if test.wantStatus == 0 {
+				if err != nil {
+					t.Errorf("Fetch() yielded unexpected error %s", err)
+				}
+				return
+			}
+			var httpErr *HTTPError
+			if !errors.As(err, &httpErr) || httpErr.StatusCode != test.wantStatus {
+				t.Errorf("Fetch() yielded error %v, want an HTTPError with status %d", err, test.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var attempts atomic.Int32
+	// Handlers block until the test ends.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	client := NewHTTP(server.URL, WithTimeout(10*time.Millisecond), WithRetries(1, time.Millisecond))
+	if _, err := client.Fetch(context.Background(), NewRequest().Request()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Fetch() yielded error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("Fetch() made %d attempts, want 2", got)
+	}
+}
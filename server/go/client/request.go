@@ -0,0 +1,135 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package client
+
+import (
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Param is a typed key-value pair, used as a global filter or a series
+// option in a DataRequest.
+type Param struct {
+	Key   string
+	Value *util.V
+}
+
+// String returns a string-valued Param.
+func String(key, value string) Param {
+	return Param{key, util.StringValue(value)}
+}
+
+// Strings returns a strings-valued Param.
+func Strings(key string, values ...string) Param {
+	if values == nil {
+		values = []string{}
+	}
+	return Param{key, util.StringsValue(values...)}
+}
+
+// Integer returns an integer-valued Param.
+func Integer(key string, value int64) Param {
+	return Param{key, util.IntValue(value)}
+}
+
+// Integers returns an integers-valued Param.
+func Integers(key string, values ...int64) Param {
+	if values == nil {
+		values = []int64{}
+	}
+	return Param{key, util.IntsValue(values...)}
+}
+
+// Double returns a double-valued Param.
+func Double(key string, value float64) Param {
+	return Param{key, util.DoubleValue(value)}
+}
+
+// Duration returns a duration-valued Param.
+func Duration(key string, value time.Duration) Param {
+	return Param{key, util.DurationValue(value)}
+}
+
+// Timestamp returns a timestamp-valued Param.
+func Timestamp(key string, value time.Time) Param {
+	return Param{key, util.TimestampValue(value)}
+}
+
+// Bool returns a bool-valued Param.
+func Bool(key string, value bool) Param {
+	return Param{key, util.BoolValue(value)}
+}
+
+// Value returns a Param with an arbitrary value, such as a list or map.
+// String index values are not permitted in requests.
+func Value(key string, value *util.V) Param {
+	return Param{key, value}
+}
+
+func paramMap(params []Param) map[string]*util.V {
+	ret := make(map[string]*util.V, len(params))
+	for _, param := range params {
+		ret[param.Key] = param.Value
+	}
+	return ret
+}
+
+// RequestBuilder assembles a util.DataRequest.
+type RequestBuilder struct {
+	req *util.DataRequest
+}
+
+// NewRequest returns a new RequestBuilder with the provided global filters.
+func NewRequest(globalFilters ...Param) *RequestBuilder {
+	return &RequestBuilder{
+		req: &util.DataRequest{
+			GlobalFilters: paramMap(globalFilters),
+		},
+	}
+}
+
+// WithGlobalFilters adds the provided global filters to the receiver,
+// replacing any existing filters with the same keys.
+func (rb *RequestBuilder) WithGlobalFilters(globalFilters ...Param) *RequestBuilder {
+	for _, param := range globalFilters {
+		rb.req.GlobalFilters[param.Key] = param.Value
+	}
+	return rb
+}
+
+// Series adds a DataSeriesRequest for the specified query, with the provided
+// options, to the receiver.  Its response DataSeries will have the specified
+// series name, which should be unique within the request.
+func (rb *RequestBuilder) Series(queryName, seriesName string, options ...Param) *RequestBuilder {
+	rb.req.SeriesRequests = append(rb.req.SeriesRequests, &util.DataSeriesRequest{
+		QueryName:  queryName,
+		SeriesName: seriesName,
+		Options:    paramMap(options),
+	})
+	return rb
+}
+
+// AllowPartialResults specifies that the request accepts partial results:
+// failing series are reported in their Error, rather than failing the entire
+// request.
+func (rb *RequestBuilder) AllowPartialResults() *RequestBuilder {
+	rb.req.AllowPartialResults = true
+	return rb
+}
+
+// Request returns the assembled DataRequest.
+func (rb *RequestBuilder) Request() *util.DataRequest {
+	return rb.req
+}
@@ -204,3 +204,60 @@ func NewAxis[T float64 | time.Duration | time.Time](
 		return nil, fmt.Errorf("unsupported type %T for axis", v)
 	}
 }
+
+// DecodeValue returns the value of type T with the specified key on the
+// provided DatumReader, as annotated by an Axis[T]'s Value().
+func DecodeValue[T float64 | time.Duration | time.Time](dr *util.DatumReader, key string) (T, error) {
+	var ret T
+	var err error
+	switch p := any(&ret).(type) {
+	case *float64:
+		*p, err = dr.Double(key)
+	case *time.Duration:
+		*p, err = dr.Duration(key)
+	case *time.Time:
+		*p, err = dr.Timestamp(key)
+	}
+	return ret, err
+}
+
+// DecodedAxis is an axis decoded from a Datum.
+type DecodedAxis[T float64 | time.Duration | time.Time] struct {
+	Category *category.Category
+	Min, Max T
+}
+
+// Decode returns the Axis[T] defined on the provided DatumReader, or an error
+// if no axis of type T is defined there.
+func Decode[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedAxis[T], error) {
+	var wantAxisType string
+	switch any(*new(T)).(type) {
+	case float64:
+		wantAxisType = doubleAxisType
+	case time.Duration:
+		wantAxisType = durationAxisType
+	case time.Time:
+		wantAxisType = timestampAxisType
+	}
+	axisType, err := dr.String(axisTypeKey)
+	if err != nil {
+		return nil, err
+	}
+	if axisType != wantAxisType {
+		return nil, fmt.Errorf("expected a %s axis, got a %s axis", wantAxisType, axisType)
+	}
+	cat, err := category.Decode(dr)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DecodedAxis[T]{
+		Category: cat,
+	}
+	if ret.Min, err = DecodeValue[T](dr, axisMinKey); err != nil {
+		return nil, err
+	}
+	if ret.Max, err = DecodeValue[T](dr, axisMaxKey); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -106,3 +106,32 @@ func TestAxis(t *testing.T) {
 		},
 	}})
 }
+
+func TestDecode(t *testing.T) {
+	cat := category.New("x_axis", "Time from start", "Time from start of trace")
+	axis := NewDurationAxis(cat, 10*time.Millisecond, 100*time.Millisecond)
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		db.With(axis.Define(), axis.Value("point", 50*time.Millisecond))
+	})
+	dr, err := util.NewDatumReader(root, st)
+	if err != nil {
+		t.Fatalf("NewDatumReader() yielded unexpected error %s", err)
+	}
+	got, err := Decode[time.Duration](dr)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if got.Category.ID() != cat.ID() || got.Min != 10*time.Millisecond || got.Max != 100*time.Millisecond {
+		t.Errorf("Decode() = %v, want a %s axis from 10ms to 100ms", got, cat.ID())
+	}
+	point, err := DecodeValue[time.Duration](dr, "point")
+	if err != nil {
+		t.Fatalf("DecodeValue() yielded unexpected error %s", err)
+	}
+	if point != 50*time.Millisecond {
+		t.Errorf("DecodeValue() = %v, want 50ms", point)
+	}
+	if _, err := Decode[time.Time](dr); err == nil {
+		t.Errorf("Decode() of a mistyped axis succeeded, wanted error")
+	}
+}
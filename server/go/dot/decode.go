@@ -0,0 +1,182 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package dot
+
+import (
+	"fmt"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Types of DecodedStatements.
+const (
+	NodeStatement     = nodeStatement
+	EdgeStatement     = edgeStatement
+	SubgraphStatement = subgraphStatement
+	AttrStatement     = attrStatement
+)
+
+// Targets of attr DecodedStatements.
+const (
+	GraphAttr = graphAttr
+	NodeAttr  = nodeAttr
+	EdgeAttr  = edgeAttr
+)
+
+// Get returns the value of the last definition of the specified attribute in
+// the receiver, and true; or the empty string and false if it is not defined.
+func (a *Attributes) Get(attr string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	for idx := len(a.attrs) - 1; idx >= 0; idx-- {
+		if a.attrs[idx].attr == attr {
+			return a.attrs[idx].value, true
+		}
+	}
+	return "", false
+}
+
+// DecodedGraph is a Graph decoded from a Datum.  Each decoded element retains
+// a reader over its Datum's Properties, from which decorators may be read.
+type DecodedGraph struct {
+	Properties   *util.DatumReader
+	Strict       bool
+	Directed     bool
+	LayoutEngine string
+	// The graph's Attributes, or nil if it has none.
+	Attributes *Attributes
+	Statements []*DecodedStatement
+}
+
+// DecodedStatement is a single statement within a Graph, decoded from a
+// Datum.
+type DecodedStatement struct {
+	Properties *util.DatumReader
+	// The statement's type: NodeStatement, EdgeStatement, SubgraphStatement,
+	// or AttrStatement.
+	Type string
+	// The ID of the node, edge, or subgraph.  Empty for attr statements.
+	ID string
+	// The endpoints of an edge.
+	StartNodeID, EndNodeID string
+	// The target of an attr statement: GraphAttr, NodeAttr, or EdgeAttr.
+	AttrTarget string
+	// The statement's Attributes, or nil if it has none.
+	Attributes *Attributes
+	// The statements within a subgraph.
+	Statements []*DecodedStatement
+}
+
+// Decode decodes the provided Datum, which must have been populated by a
+// Graph and whose strings are in the provided string table, back into a
+// DecodedGraph.
+func Decode(d *util.Datum, stringTable []string) (*DecodedGraph, error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DecodedGraph{
+		Properties: dr,
+	}
+	strictness, err := dr.String(strictnessKey)
+	if err != nil {
+		return nil, err
+	}
+	ret.Strict = strictness == strict
+	directionality, err := dr.String(directionalityKey)
+	if err != nil {
+		return nil, err
+	}
+	ret.Directed = directionality == directed
+	if ret.LayoutEngine, err = dr.String(layoutEngineKey); err != nil {
+		return nil, err
+	}
+	if ret.Attributes, err = decodeAttributes(dr); err != nil {
+		return nil, err
+	}
+	if ret.Statements, err = decodeStatements(dr); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func decodeAttributes(dr *util.DatumReader) (*Attributes, error) {
+	if !dr.Has(attributesKey) {
+		return nil, nil
+	}
+	strs, err := dr.Strings(attributesKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(strs)%2 != 0 {
+		return nil, fmt.Errorf("dot attribute list must have even length, got %d", len(strs))
+	}
+	ret := NewAttributes()
+	for idx := 0; idx < len(strs); idx += 2 {
+		ret.With(strs[idx], strs[idx+1])
+	}
+	return ret, nil
+}
+
+func decodeStatements(dr *util.DatumReader) ([]*DecodedStatement, error) {
+	var ret []*DecodedStatement
+	for _, child := range dr.Children() {
+		stmt, err := decodeStatement(child)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, stmt)
+	}
+	return ret, nil
+}
+
+func decodeStatement(dr *util.DatumReader) (*DecodedStatement, error) {
+	stmtType, err := dr.String(statementTypeKey)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DecodedStatement{
+		Properties: dr,
+		Type:       stmtType,
+	}
+	if ret.Attributes, err = decodeAttributes(dr); err != nil {
+		return nil, err
+	}
+	switch stmtType {
+	case nodeStatement:
+		ret.ID, err = dr.String(nodeIDKey)
+	case edgeStatement:
+		if ret.ID, err = dr.String(edgeIDKey); err != nil {
+			return nil, err
+		}
+		if ret.StartNodeID, err = dr.String(startNodeIDKey); err != nil {
+			return nil, err
+		}
+		ret.EndNodeID, err = dr.String(endNodeIDKey)
+	case subgraphStatement:
+		if ret.ID, err = dr.String(subgraphIDKey); err != nil {
+			return nil, err
+		}
+		ret.Statements, err = decodeStatements(dr)
+	case attrStatement:
+		ret.AttrTarget, err = dr.String(attrStatementTargetKey)
+	default:
+		return nil, fmt.Errorf("unsupported dot statement type '%s'", stmtType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -14,8 +14,10 @@
 package dot
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	testutil "github.com/ilhamster/traceviz/server/go/test_util"
 	"github.com/ilhamster/traceviz/server/go/util"
 )
@@ -96,3 +98,66 @@ func TestGraph(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		g, err := NewGraph(db, Strict(), Directed(), Neato())
+		if err != nil {
+			t.Fatalf("Unexpected error initializing graph: %s", err)
+		}
+		g.WithAttributes(NewAttributes().With("rankdir", "LR"))
+		g.WithNodeAttrs(NewAttributes().With("color", "blue"))
+		g.AddNode("A", nil).With(util.IntegerProperty("node_id", 0))
+		sg := g.AddSubgraph("cluster_0")
+		sg.AddNode("B", NewAttributes().WithString("label", "bee"))
+		g.AddEdge("A->B", "A", "B", nil)
+	})
+	got, err := Decode(root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if !got.Strict || !got.Directed || got.LayoutEngine != neatoEngine {
+		t.Errorf("Decode() yielded strict %t, directed %t, engine %s; want strict, directed, neato", got.Strict, got.Directed, got.LayoutEngine)
+	}
+	if rankdir, ok := got.Attributes.Get("rankdir"); !ok || rankdir != "LR" {
+		t.Errorf("Decode() yielded graph rankdir %q, want 'LR'", rankdir)
+	}
+	// Render each statement as a line, indented by subgraph depth.
+	var gotLines []string
+	var render func(indent string, stmts []*DecodedStatement)
+	render = func(indent string, stmts []*DecodedStatement) {
+		for _, stmt := range stmts {
+			line := indent + stmt.Type
+			switch stmt.Type {
+			case NodeStatement, SubgraphStatement:
+				line += " " + stmt.ID
+			case EdgeStatement:
+				line += fmt.Sprintf(" %s: %s %s", stmt.ID, stmt.StartNodeID, stmt.EndNodeID)
+			case AttrStatement:
+				line += " " + stmt.AttrTarget
+			}
+			if color, ok := stmt.Attributes.Get("color"); ok {
+				line += " color=" + color
+			}
+			if label, ok := stmt.Attributes.Get("label"); ok {
+				line += " label=" + label
+			}
+			gotLines = append(gotLines, line)
+			render(indent+"  ", stmt.Statements)
+		}
+	}
+	render("", got.Statements)
+	wantLines := []string{
+		"attr node color=blue",
+		"node A",
+		"subgraph cluster_0",
+		`  node B label="bee"`,
+		"edge A->B: A B",
+	}
+	if diff := cmp.Diff(wantLines, gotLines); diff != "" {
+		t.Errorf("Decode() yielded statements %v, diff (-want +got) %s", gotLines, diff)
+	}
+	if nodeID, err := got.Statements[1].Properties.Integer("node_id"); err != nil || nodeID != 0 {
+		t.Errorf("Decode() yielded node_id %d (err %v), want 0", nodeID, err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package flight provides Group, which coalesces concurrent identical calls,
+// such as fetches of the same collection, into a single shared call.
+//
+// Unlike golang.org/x/sync/singleflight, each caller of a Group may cancel its
+// wait without affecting the others: the shared call runs under its own
+// Context, which is cancelled only once every caller waiting on it has given
+// up.
+package flight
+
+import (
+	"context"
+	"sync"
+)
+
+// call is a single in-flight call to a Group.
+type call[V any] struct {
+	done chan struct{}
+	// val and err are set before done is closed.
+	val V
+	err error
+	// The following are guarded by the Group's mu.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Group coalesces concurrent calls with the same key.  The zero Group is
+// ready to use.  A Group must not be copied after first use.
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+// Do calls fn and returns its results, unless a call with the same key is
+// already in flight, in which case it waits for and returns that call's
+// results instead.  shared reports whether the results were delivered to
+// more than one caller.
+//
+// fn runs in its own goroutine, and receives a Context carrying the values,
+// but not the deadline or cancellation, of the Context of the caller that
+// started it.  If ctx is done before the call completes, Do returns ctx's
+// error; once all of a call's callers have returned this way, the Context
+// passed to fn is cancelled, and a subsequent Do with the same key starts a
+// new call.
+func (g *Group[V]) Do(ctx context.Context, key string, fn func(ctx context.Context) (V, error)) (v V, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[V]{}
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		c = &call[V]{
+			done: make(chan struct{}),
+		}
+		var callCtx context.Context
+		callCtx, c.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		g.calls[key] = c
+		go g.run(callCtx, key, c, fn)
+	}
+	c.waiters++
+	g.mu.Unlock()
+	select {
+	case <-c.done:
+		g.mu.Lock()
+		shared = c.waiters > 1
+		g.mu.Unlock()
+		return c.val, shared, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return v, false, ctx.Err()
+	}
+}
+
+// run runs fn for the provided call, then publishes its results.
+func (g *Group[V]) run(ctx context.Context, key string, c *call[V], fn func(ctx context.Context) (V, error)) {
+	c.val, c.err = fn(ctx)
+	g.mu.Lock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+	c.cancel()
+}
+
+// leave records that a caller of the provided call has stopped waiting for
+// it, cancelling the call if no callers remain.
+func (g *Group[V]) leave(key string, c *call[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+	c.cancel()
+	// The abandoned call may not return immediately; don't let new callers
+	// join it.
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package flight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDoCoalesces(t *testing.T) {
+	var g Group[int]
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		<-release
+		return 42, nil
+	}
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make([]int, callers)
+	shareds := make([]bool, callers)
+	started := make(chan struct{}, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			started <- struct{}{}
+			v, shared, err := g.Do(context.Background(), "key", fn)
+			if err != nil {
+				t.Errorf("Do() yielded unexpected error %s", err)
+			}
+			results[i], shareds[i] = v, shared
+		}(i)
+	}
+	for i := 0; i < callers; i++ {
+		<-started
+	}
+	// Wait for all callers to join the call before releasing it.
+	for {
+		g.mu.Lock()
+		c := g.calls["key"]
+		joined := c != nil && c.waiters == callers
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+	if got := calls.Load(); got != 1 {
+		t.Errorf("fn was called %d times, want 1", got)
+	}
+	for i := 0; i < callers; i++ {
+		if results[i] != 42 || !shareds[i] {
+			t.Errorf("Do() = %d, shared %t; want 42, shared true", results[i], shareds[i])
+		}
+	}
+	// Once complete, calls are not reused.
+	if v, shared, err := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 7, nil
+	}); err != nil || v != 7 || shared {
+		t.Errorf("Do() = %d, shared %t, err %v; want 7, shared false", v, shared, err)
+	}
+}
+
+func TestDoCancellation(t *testing.T) {
+	var g Group[int]
+	release := make(chan struct{})
+	fnCtxs := make(chan context.Context, 2)
+	fn := func(ctx context.Context) (int, error) {
+		fnCtxs <- ctx
+		select {
+		case <-release:
+			return 1, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	errs := make(chan error, 2)
+	vals := make(chan int, 2)
+	do := func(ctx context.Context) {
+		v, _, err := g.Do(ctx, "key", fn)
+		vals <- v
+		errs <- err
+	}
+	go do(ctx1)
+	fnCtx := <-fnCtxs
+	go do(ctx2)
+	for {
+		g.mu.Lock()
+		joined := g.calls["key"].waiters == 2
+		g.mu.Unlock()
+		if joined {
+			break
+		}
+	}
+	// Cancelling one caller returns its error, but leaves the call running for
+	// the other.
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled Do() yielded error %v, want %v", err, context.Canceled)
+	}
+	<-vals
+	if fnCtx.Err() != nil {
+		t.Fatalf("Shared call was cancelled with callers remaining")
+	}
+	close(release)
+	if err := <-errs; err != nil {
+		t.Errorf("Do() yielded unexpected error %s", err)
+	}
+	if v := <-vals; v != 1 {
+		t.Errorf("Do() = %d, want 1", v)
+	}
+	// Cancelling every caller cancels the call.
+	release = make(chan struct{})
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	go do(ctx3)
+	fnCtx = <-fnCtxs
+	cancel3()
+	<-errs
+	<-vals
+	<-fnCtx.Done()
+}
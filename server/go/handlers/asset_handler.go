@@ -28,7 +28,7 @@ type Asset interface {
 }
 
 // FileAsset represents an HTTP-served static asset served from the local
-// filesystem.
+// filesystem.  To serve a whole directory tree, use FSAsset.
 type FileAsset struct {
 	path        string
 	contentType string
@@ -115,6 +115,7 @@ func (afa *AnnotatedFileAsset) HTTPHandler(w http.ResponseWriter, req *http.Requ
 // CSS, etc.)
 type AssetHandler struct {
 	handlersByPath map[string]func(http.ResponseWriter, *http.Request)
+	wrappers       []WrapFunc
 }
 
 // NewAssetHandler returns a new, empty Handler.
@@ -131,8 +132,23 @@ func (ah *AssetHandler) With(requestPath string, asset Asset) *AssetHandler {
 	return ah
 }
 
+// Wrap wraps all of the receiver's handlers with the provided WrapFuncs,
+// e.g. adding authentication.
+func (ah *AssetHandler) Wrap(wrappers ...WrapFunc) *AssetHandler {
+	ah.wrappers = append(ah.wrappers, wrappers...)
+	return ah
+}
+
 // HandlersByPath returns a mapping of HTTP request path to HTTP handler for
 // this Handler.
 func (ah *AssetHandler) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
-	return ah.handlersByPath
+	ret := make(map[string]func(http.ResponseWriter, *http.Request), len(ah.handlersByPath))
+	for path, handler := range ah.handlersByPath {
+		var h HandlerFunc = handler
+		for _, wrapper := range ah.wrappers {
+			h = wrapper(h)
+		}
+		ret[path] = h
+	}
+	return ret
 }
@@ -0,0 +1,171 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// contentEncoding is a supported HTTP response content coding.
+type contentEncoding struct {
+	// The coding's name in Accept-Encoding and Content-Encoding headers.
+	name      string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+}
+
+// zstdWindowSize is the largest window that zstd-encoded responses use.  RFC
+// 9659 permits HTTP clients to reject larger windows, and browsers do.
+const zstdWindowSize = 8 << 20
+
+// contentEncodings lists the supported content codings, most preferred first.
+var contentEncodings = []*contentEncoding{{
+	name: "zstd",
+	newWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithWindowSize(zstdWindowSize), zstd.WithEncoderConcurrency(1))
+	},
+}, {
+	name: "gzip",
+	newWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+}}
+
+// minCompressedSize is the smallest response body that is compressed; smaller
+// bodies aren't worth the overhead.
+const minCompressedSize = 1024
+
+// negotiateEncoding returns the supported content coding most acceptable
+// under the provided Accept-Encoding header value, or nil if the response
+// should not be encoded.  Among equally acceptable codings, the earliest in
+// contentEncodings wins.
+func negotiateEncoding(acceptEncoding string) *contentEncoding {
+	qualities := map[string]float64{}
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(coding, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				q = parsed
+			}
+		}
+		qualities[name] = q
+	}
+	var ret *contentEncoding
+	bestQ := 0.0
+	for _, ce := range contentEncodings {
+		q, ok := qualities[ce.name]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > bestQ {
+			ret, bestQ = ce, q
+		}
+	}
+	return ret
+}
+
+// entityTag returns a strong entity tag for the provided response body, sent
+// with the provided content coding (or none, if nil).  Each coding of a body
+// is a distinct representation, so has a distinct tag.
+func entityTag(body []byte, ce *contentEncoding) string {
+	sum := sha256.Sum256(body)
+	tag := hex.EncodeToString(sum[:16])
+	if ce != nil {
+		tag += "-" + ce.name
+	}
+	return `"` + tag + `"`
+}
+
+// etagMatches returns true if the provided If-None-Match header value matches
+// the provided entity tag.  As RFC 9110 specifies for If-None-Match, the
+// comparison is weak: a W/ prefix is ignored.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBody sends the provided response body, of the provided content type,
+// along the provided http.ResponseWriter.  The body is tagged with a strong
+// ETag, and if the provided request is a GET or HEAD whose If-None-Match
+// header matches that tag, only a 304 Not Modified status is sent (RFC 9110
+// permits 304 only for these methods).  Otherwise, the body is compressed
+// with the content coding negotiated from the request's Accept-Encoding
+// header, if any.
+func writeBody(w http.ResponseWriter, req *http.Request, contentType string, body []byte) {
+	var ce *contentEncoding
+	if len(body) >= minCompressedSize {
+		ce = negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	}
+	etag := entityTag(body, ce)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if (req.Method == http.MethodGet || req.Method == http.MethodHead) &&
+		etagMatches(req.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if ce != nil {
+		var buf bytes.Buffer
+		cw, err := ce.newWriter(&buf)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, &ErrorResponse{
+				Code:    ErrorCodeInternal,
+				Message: "Failed to compress response: " + err.Error(),
+			})
+			return
+		}
+		if _, err := cw.Write(body); err != nil {
+			writeError(w, http.StatusInternalServerError, &ErrorResponse{
+				Code:    ErrorCodeInternal,
+				Message: "Failed to compress response: " + err.Error(),
+			})
+			return
+		}
+		if err := cw.Close(); err != nil {
+			writeError(w, http.StatusInternalServerError, &ErrorResponse{
+				Code:    ErrorCodeInternal,
+				Message: "Failed to compress response: " + err.Error(),
+			})
+			return
+		}
+		body = buf.Bytes()
+		w.Header().Set("Content-Encoding", ce.name)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
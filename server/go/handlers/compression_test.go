@@ -0,0 +1,200 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, test := range []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip;q=0.5", "gzip"},
+		{"GZIP", "gzip"},
+		{"gzip;q=0", ""},
+		{"*", "zstd"},
+		{"*;q=0", ""},
+		{"*, gzip;q=0", "zstd"},
+		{"zstd", "zstd"},
+		{"gzip;q=0.5, zstd", "zstd"},
+		{"zstd, gzip", "zstd"},
+		{"gzip, zstd;q=0.5", "gzip"},
+		{"*, gzip;q=0, zstd;q=0", ""},
+	} {
+		t.Run(test.acceptEncoding, func(t *testing.T) {
+			got := ""
+			if ce := negotiateEncoding(test.acceptEncoding); ce != nil {
+				got = ce.name
+			}
+			if got != test.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", test.acceptEncoding, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteBody(t *testing.T) {
+	smallBody := []byte("hello")
+	largeBody := bytes.Repeat([]byte("hello "), 1000)
+	for _, test := range []struct {
+		description    string
+		method         string
+		body           []byte
+		acceptEncoding string
+		ifNoneMatch    string
+		wantStatus     int
+		wantETag       string
+		wantEncoding   string
+	}{{
+		description: "small body is not compressed",
+		body:        smallBody,
+		wantStatus:  http.StatusOK,
+		wantETag:    entityTag(smallBody, nil),
+	}, {
+		description:    "small body is not compressed even if accepted",
+		body:           smallBody,
+		acceptEncoding: "gzip",
+		wantStatus:     http.StatusOK,
+		wantETag:       entityTag(smallBody, nil),
+	}, {
+		description:    "large body is compressed if accepted",
+		body:           largeBody,
+		acceptEncoding: "gzip",
+		wantStatus:     http.StatusOK,
+		wantETag:       entityTag(largeBody, contentEncodings[1]),
+		wantEncoding:   "gzip",
+	}, {
+		description:    "large body is compressed with zstd if preferred",
+		body:           largeBody,
+		acceptEncoding: "gzip;q=0.5, zstd",
+		wantStatus:     http.StatusOK,
+		wantETag:       entityTag(largeBody, contentEncodings[0]),
+		wantEncoding:   "zstd",
+	}, {
+		description: "large body is not compressed if not accepted",
+		body:        largeBody,
+		wantStatus:  http.StatusOK,
+		wantETag:    entityTag(largeBody, nil),
+	}, {
+		description: "matching If-None-Match",
+		body:        smallBody,
+		ifNoneMatch: `"abc", ` + entityTag(smallBody, nil),
+		wantStatus:  http.StatusNotModified,
+		wantETag:    entityTag(smallBody, nil),
+	}, {
+		description: "weak If-None-Match",
+		body:        smallBody,
+		ifNoneMatch: "W/" + entityTag(smallBody, nil),
+		wantStatus:  http.StatusNotModified,
+		wantETag:    entityTag(smallBody, nil),
+	}, {
+		description:    "If-None-Match for another encoding",
+		body:           largeBody,
+		acceptEncoding: "gzip",
+		ifNoneMatch:    entityTag(largeBody, nil),
+		wantStatus:     http.StatusOK,
+		wantETag:       entityTag(largeBody, contentEncodings[1]),
+		wantEncoding:   "gzip",
+	}, {
+		description: "matching If-None-Match on POST",
+		method:      http.MethodPost,
+		body:        smallBody,
+		ifNoneMatch: entityTag(smallBody, nil),
+		wantStatus:  http.StatusOK,
+		wantETag:    entityTag(smallBody, nil),
+	}, {
+		description: "matching If-None-Match on HEAD",
+		method:      http.MethodHead,
+		body:        smallBody,
+		ifNoneMatch: entityTag(smallBody, nil),
+		wantStatus:  http.StatusNotModified,
+		wantETag:    entityTag(smallBody, nil),
+	}, {
+		description: "changed body",
+		body:        largeBody,
+		ifNoneMatch: entityTag(smallBody, nil),
+		wantStatus:  http.StatusOK,
+		wantETag:    entityTag(largeBody, nil),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, dataMethod, nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			if test.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			writeBody(rec, req, JSONContentType, test.body)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("Got status %d, want %d", rec.Code, test.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != test.wantETag {
+				t.Errorf("Got ETag %q, want %q", got, test.wantETag)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Got Vary %q, want 'Accept-Encoding'", got)
+			}
+			if test.wantStatus == http.StatusNotModified {
+				if rec.Body.Len() != 0 {
+					t.Errorf("Got body %q with status %d, want none", rec.Body.String(), rec.Code)
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != test.wantEncoding {
+				t.Errorf("Got Content-Encoding %q, want %q", got, test.wantEncoding)
+			}
+			body := rec.Body.Bytes()
+			switch test.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("Failed to read compressed response: %s", err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("Failed to read compressed response: %s", err)
+				}
+			case "zstd":
+				zr, err := zstd.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("Failed to read compressed response: %s", err)
+				}
+				defer zr.Close()
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("Failed to read compressed response: %s", err)
+				}
+			}
+			if diff := cmp.Diff(string(test.body), string(body)); diff != "" {
+				t.Errorf("Got body diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
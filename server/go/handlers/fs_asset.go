@@ -0,0 +1,166 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHashedAssetPattern matches the names of bundle files whose names
+// include a hexadecimal content hash, as produced by Angular and webpack
+// builds, like 'main.3f2a1b4c5d6e7f80.js'.
+var DefaultHashedAssetPattern = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[^./]+$`)
+
+// FSAsset is an Asset serving a whole directory tree from an fs.FS, such as
+// an embed.FS compiled into the server binary or an os.DirFS.  Requests are
+// resolved relative to a URL path prefix, so an FSAsset is generally
+// registered with an AssetHandler under a path ending in '/', like
+// '/angular/'.  Content types are set by file extension, and each file is
+// served with a strong ETag.  Files whose names include a content hash are
+// served with long-lived cache headers; all others must be revalidated.
+//
+// Each file is read and hashed once, when first requested, and then served
+// from memory until its size or modification time changes.
+type FSAsset struct {
+	fsys          fs.FS
+	prefix        string
+	fallback      string
+	hashedPattern *regexp.Regexp
+
+	mu          sync.Mutex
+	filesByName map[string]*fsAssetFile
+}
+
+// fsAssetFile is a file read by an FSAsset.
+type fsAssetFile struct {
+	// The file's size and modification time when it was read.
+	size    int64
+	modTime time.Time
+	// The file's contents, and their ETag.
+	contents []byte
+	etag     string
+}
+
+// NewFSAsset returns a new FSAsset serving the provided fs.FS under the
+// provided URL path prefix.
+func NewFSAsset(fsys fs.FS, prefix string) *FSAsset {
+	return &FSAsset{
+		fsys:          fsys,
+		prefix:        prefix,
+		hashedPattern: DefaultHashedAssetPattern,
+		filesByName:   map[string]*fsAssetFile{},
+	}
+}
+
+// WithSPAFallback configures the receiver to serve the provided file, such as
+// 'index.html', in response to requests for missing paths without file
+// extensions, so that single-page apps can handle their own routes.
+func (fa *FSAsset) WithSPAFallback(fallback string) *FSAsset {
+	fa.fallback = fallback
+	return fa
+}
+
+// WithHashedAssetPattern configures the receiver to serve files whose names
+// match the provided pattern with long-lived cache headers, instead of those
+// matching DefaultHashedAssetPattern.  A nil pattern matches no files.
+func (fa *FSAsset) WithHashedAssetPattern(pattern *regexp.Regexp) *FSAsset {
+	fa.hashedPattern = pattern
+	return fa
+}
+
+// resolve returns the name, within the receiver's fs.FS, of the file to serve
+// in response to the provided URL path, and its FileInfo, or an error
+// satisfying errors.Is(err, fs.ErrNotExist) if there is none.
+func (fa *FSAsset) resolve(urlPath string) (string, fs.FileInfo, error) {
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(urlPath, fa.prefix)), "/")
+	if name == "" {
+		name = "."
+	}
+	info, err := fs.Stat(fa.fsys, name)
+	if err == nil && info.IsDir() {
+		name = path.Join(name, "index.html")
+		info, err = fs.Stat(fa.fsys, name)
+	}
+	if err == nil {
+		return name, info, nil
+	}
+	if errors.Is(err, fs.ErrNotExist) && fa.fallback != "" && path.Ext(name) == "" {
+		info, err := fs.Stat(fa.fsys, fa.fallback)
+		if err != nil {
+			return "", nil, err
+		}
+		return fa.fallback, info, nil
+	}
+	return "", nil, err
+}
+
+// file returns the named file, with the provided FileInfo, reading it only if
+// it hasn't been read since it last changed.
+func (fa *FSAsset) file(name string, info fs.FileInfo) (*fsAssetFile, error) {
+	fa.mu.Lock()
+	file, ok := fa.filesByName[name]
+	fa.mu.Unlock()
+	if ok && file.size == info.Size() && file.modTime.Equal(info.ModTime()) {
+		return file, nil
+	}
+	contents, err := fs.ReadFile(fa.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	file = &fsAssetFile{
+		size:     info.Size(),
+		modTime:  info.ModTime(),
+		contents: contents,
+		etag:     entityTag(contents, nil),
+	}
+	fa.mu.Lock()
+	fa.filesByName[name] = file
+	fa.mu.Unlock()
+	return file, nil
+}
+
+// HTTPHandler serves the file requested from the receiving FSAsset.
+func (fa *FSAsset) HTTPHandler(w http.ResponseWriter, req *http.Request) {
+	name, info, err := fa.resolve(req.URL.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, req)
+			return
+		}
+		http.Error(w, "Failed to fetch asset", http.StatusInternalServerError)
+		return
+	}
+	file, err := fa.file(name, info)
+	if err != nil {
+		http.Error(w, "Failed to fetch asset", http.StatusInternalServerError)
+		return
+	}
+	if fa.hashedPattern != nil && fa.hashedPattern.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", file.etag)
+	// ServeContent sets the content type by extension, and handles
+	// If-None-Match and range requests.
+	http.ServeContent(w, req, name, time.Time{}, bytes.NewReader(file.contents))
+}
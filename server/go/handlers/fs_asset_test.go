@@ -0,0 +1,197 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFSAsset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":                {Data: []byte("<html>app</html>")},
+		"main.3f2a1b4c5d6e7f80.js":  {Data: []byte("main()")},
+		"styles.css":                {Data: []byte("body {}")},
+		"docs/index.html":           {Data: []byte("<html>docs</html>")},
+		"assets/logo-0123abcd.svg":  {Data: []byte("<svg></svg>")},
+		"assets/main-component.js":  {Data: []byte("component()")},
+		"assets/data/unhashed.json": {Data: []byte("{}")},
+	}
+	for _, test := range []struct {
+		description      string
+		path             string
+		noFallback       bool
+		wantStatus       int
+		wantBody         string
+		wantContentType  string
+		wantCacheControl string
+	}{{
+		description:      "root serves index",
+		path:             "/app/",
+		wantStatus:       http.StatusOK,
+		wantBody:         "<html>app</html>",
+		wantContentType:  "text/html; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}, {
+		description:      "hashed bundle",
+		path:             "/app/main.3f2a1b4c5d6e7f80.js",
+		wantStatus:       http.StatusOK,
+		wantBody:         "main()",
+		wantContentType:  "text/javascript; charset=utf-8",
+		wantCacheControl: "public, max-age=31536000, immutable",
+	}, {
+		description:      "unhashed file",
+		path:             "/app/styles.css",
+		wantStatus:       http.StatusOK,
+		wantBody:         "body {}",
+		wantContentType:  "text/css; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}, {
+		description:      "nested hashed file",
+		path:             "/app/assets/logo-0123abcd.svg",
+		wantStatus:       http.StatusOK,
+		wantBody:         "<svg></svg>",
+		wantContentType:  "image/svg+xml",
+		wantCacheControl: "public, max-age=31536000, immutable",
+	}, {
+		description:      "dashed name isn't a hash",
+		path:             "/app/assets/main-component.js",
+		wantStatus:       http.StatusOK,
+		wantBody:         "component()",
+		wantContentType:  "text/javascript; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}, {
+		description:      "directory serves its index",
+		path:             "/app/docs",
+		wantStatus:       http.StatusOK,
+		wantBody:         "<html>docs</html>",
+		wantContentType:  "text/html; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}, {
+		description:      "app route falls back to index",
+		path:             "/app/collections/foo",
+		wantStatus:       http.StatusOK,
+		wantBody:         "<html>app</html>",
+		wantContentType:  "text/html; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}, {
+		description: "missing file doesn't fall back",
+		path:        "/app/missing.js",
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description: "app route without fallback",
+		path:        "/app/collections/foo",
+		noFallback:  true,
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description:      "path traversal stays within the tree",
+		path:             "/app/../../index.html",
+		wantStatus:       http.StatusOK,
+		wantBody:         "<html>app</html>",
+		wantContentType:  "text/html; charset=utf-8",
+		wantCacheControl: "no-cache",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			asset := NewFSAsset(fsys, "/app/")
+			if !test.noFallback {
+				asset.WithSPAFallback("index.html")
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.URL.Path = test.path
+			rec := httptest.NewRecorder()
+			asset.HTTPHandler(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("Got status %d, want %d", rec.Code, test.wantStatus)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rec.Body.String(); got != test.wantBody {
+				t.Errorf("Got body %q, want %q", got, test.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Got Content-Type %q, want %q", got, test.wantContentType)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != test.wantCacheControl {
+				t.Errorf("Got Cache-Control %q, want %q", got, test.wantCacheControl)
+			}
+		})
+	}
+}
+
+func TestFSAssetConditionalGet(t *testing.T) {
+	asset := NewFSAsset(fstest.MapFS{
+		"index.html": {Data: []byte("<html>app</html>")},
+	}, "/")
+	rec := httptest.NewRecorder()
+	asset.HTTPHandler(rec, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Got no ETag")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/index.html", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	asset.HTTPHandler(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Got status %d, want %d", rec.Code, http.StatusNotModified)
+	}
+}
+
+// readCountingFS is an fstest.MapFS counting the files read from it.
+type readCountingFS struct {
+	fstest.MapFS
+	reads int
+}
+
+func (rcfs *readCountingFS) ReadFile(name string) ([]byte, error) {
+	rcfs.reads++
+	return rcfs.MapFS.ReadFile(name)
+}
+
+func TestFSAssetCachesFiles(t *testing.T) {
+	fsys := &readCountingFS{
+		MapFS: fstest.MapFS{
+			"index.html": {Data: []byte("<html>app</html>"), ModTime: time.Unix(1, 0)},
+		},
+	}
+	asset := NewFSAsset(fsys, "/")
+	get := func() (body, etag string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		asset.HTTPHandler(rec, httptest.NewRequest(http.MethodGet, "/index.html", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Got status %d, want %d", rec.Code, http.StatusOK)
+		}
+		return rec.Body.String(), rec.Header().Get("ETag")
+	}
+	_, etag := get()
+	if body, gotETag := get(); body != "<html>app</html>" || gotETag != etag {
+		t.Errorf("Got body %q with ETag %s, want %q with ETag %s", body, gotETag, "<html>app</html>", etag)
+	}
+	if fsys.reads != 1 {
+		t.Errorf("Read %d files, want 1", fsys.reads)
+	}
+	// A changed file is read again.
+	fsys.MapFS["index.html"] = &fstest.MapFile{Data: []byte("<html>new app</html>"), ModTime: time.Unix(2, 0)}
+	if body, gotETag := get(); body != "<html>new app</html>" || gotETag == etag {
+		t.Errorf("Got body %q with ETag %s, want %q with a new ETag", body, gotETag, "<html>new app</html>")
+	}
+	if fsys.reads != 2 {
+		t.Errorf("Read %d files, want 2", fsys.reads)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/metrics"
+)
+
+// WithMetrics configures a QueryHandler to record, in the provided Registry,
+// the number of requests to each of its paths by HTTP status, their latency
+// and response size, and their failures by ErrorCode.  Serve the Registry,
+// e.g. at '/metrics', with its HTTPHandler.
+func WithMetrics(registry *metrics.Registry) QueryHandlerOption {
+	return func(qh *queryHandler) {
+		qh.metrics = newHandlerMetrics(registry)
+	}
+}
+
+// handlerMetrics holds a QueryHandler's metrics.
+type handlerMetrics struct {
+	requests      *metrics.CounterVec
+	latencies     *metrics.HistogramVec
+	responseSizes *metrics.HistogramVec
+	failures      *metrics.CounterVec
+}
+
+func newHandlerMetrics(registry *metrics.Registry) *handlerMetrics {
+	return &handlerMetrics{
+		requests: registry.Counter(
+			"traceviz_http_requests_total",
+			"HTTP requests, by path and status code.",
+			"path", "code",
+		),
+		latencies: registry.Histogram(
+			"traceviz_http_request_duration_seconds",
+			"HTTP request latency in seconds, by path.",
+			metrics.DefaultLatencyBuckets,
+			"path",
+		),
+		responseSizes: registry.Histogram(
+			"traceviz_http_response_bytes",
+			"HTTP response body size in bytes, as sent, by path.",
+			metrics.DefaultSizeBuckets,
+			"path",
+		),
+		failures: registry.Counter(
+			"traceviz_http_request_errors_total",
+			"Failed HTTP requests, by path and error code.",
+			"path", "code",
+		),
+	}
+}
+
+// instrument returns a HandlerFunc recording the provided HandlerFunc's
+// requests under the provided path.
+func (hm *handlerMetrics) instrument(path string, next HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		mw := &metricsResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		next(mw, req)
+		hm.requests.With(path, strconv.Itoa(mw.status)).Inc()
+		hm.latencies.With(path).Observe(time.Since(start).Seconds())
+		hm.responseSizes.With(path).Observe(float64(mw.bytes))
+		if mw.errorCode != "" {
+			hm.failures.With(path, string(mw.errorCode)).Inc()
+		}
+	}
+}
+
+// metricsResponseWriter is an http.ResponseWriter recording the status,
+// size, and ErrorCode of a response.
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	status    int
+	bytes     int
+	errorCode ErrorCode
+}
+
+func (mw *metricsResponseWriter) WriteHeader(status int) {
+	mw.status = status
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
+	n, err := mw.ResponseWriter.Write(b)
+	mw.bytes += n
+	return n, err
+}
+
+// Flush flushes the underlying ResponseWriter, if it supports flushing.
+func (mw *metricsResponseWriter) Flush() {
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
+
+// noteErrorCode records the provided ErrorCode as the outcome of the request
+// whose response the provided ResponseWriter writes, if it is being
+// instrumented.
+func noteErrorCode(w http.ResponseWriter, code ErrorCode) {
+	if mw, ok := w.(*metricsResponseWriter); ok {
+		mw.errorCode = code
+	}
+}
@@ -0,0 +1,81 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/metrics"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestMetrics(t *testing.T) {
+	qd, err := querydispatcher.New(&testDataSource{}, &failingDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	registry := metrics.NewRegistry()
+	handlersByPath := NewQueryHandlerWithOptions(qd, WithMetrics(registry)).HandlersByPath()
+	for _, dataReq := range []*util.DataRequest{
+		greetingReq,
+		greetingReq,
+		{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName:  "failure",
+				SeriesName: "1",
+			}},
+		},
+		{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName:  "farewell",
+				SeriesName: "1",
+			}},
+		},
+	} {
+		req := httptest.NewRequest(http.MethodGet, dataMethod+"?"+dataRequestForm(t, dataReq), nil)
+		handlersByPath[dataMethod](httptest.NewRecorder(), req)
+	}
+	handlersByPath[listQueriesMethod](httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, listQueriesMethod, nil))
+
+	var sb strings.Builder
+	if err := registry.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText() yielded unexpected error %s", err)
+	}
+	var got []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if strings.HasPrefix(line, "traceviz_http_requests_total{") ||
+			strings.HasPrefix(line, "traceviz_http_request_errors_total{") ||
+			strings.HasPrefix(line, "traceviz_http_request_duration_seconds_count{") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		`traceviz_http_request_duration_seconds_count{path="/GetData"} 4`,
+		`traceviz_http_request_duration_seconds_count{path="/ListQueries"} 1`,
+		`traceviz_http_request_errors_total{path="/GetData",code="DATA_SOURCE_ERROR"} 1`,
+		`traceviz_http_request_errors_total{path="/GetData",code="UNSUPPORTED_QUERY"} 1`,
+		`traceviz_http_requests_total{path="/GetData",code="200"} 2`,
+		`traceviz_http_requests_total{path="/GetData",code="400"} 1`,
+		`traceviz_http_requests_total{path="/GetData",code="500"} 1`,
+		`traceviz_http_requests_total{path="/ListQueries",code="200"} 1`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got metrics %v, diff (-want +got) %s", got, diff)
+	}
+}
@@ -16,8 +16,15 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
 	"github.com/ilhamster/traceviz/server/go/util"
@@ -41,36 +48,194 @@ type QueryHandler interface {
 	Wrap(...WrapFunc) Handler
 }
 
-// sendHTTPResponse serializes the provided protobuf and sends it along the
-// provided http.ResponseWriter.  Any failures during serialization yield an
-// HTTP internal status error.
-func sendHTTPResponse(resp *util.Data, w http.ResponseWriter) {
-	respStr, err := json.Marshal(resp)
+const (
+	// JSONContentType is the content type of the compact JSON encoding of
+	// TraceViz data responses.
+	JSONContentType = "application/json"
+	// BinaryContentType is the content type of the binary encoding of TraceViz
+	// data responses (see util.Data.MarshalBinary).  Clients may request it by
+	// listing it in their Accept header.
+	BinaryContentType = "application/x-traceviz-binary"
+)
+
+// acceptsBinary returns true if the provided Accept header value lists
+// BinaryContentType with nonzero quality.
+func acceptsBinary(accept string) bool {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(mediaRange, ";")
+		if strings.TrimSpace(mediaType) != BinaryContentType {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			key, val, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// sendHTTPResponse serializes the provided Data and sends it along the
+// provided http.ResponseWriter, in the binary encoding if the provided request
+// accepts it and in JSON otherwise.  The response is compressed and tagged as
+// described at writeBody.  Any failures during serialization yield an HTTP
+// internal status error.
+func sendHTTPResponse(resp *util.Data, w http.ResponseWriter, req *http.Request) {
+	var respBytes []byte
+	var err error
+	contentType := JSONContentType
+	if acceptsBinary(req.Header.Get("Accept")) {
+		contentType = BinaryContentType
+		respBytes, err = resp.MarshalBinary()
+	} else {
+		respBytes, err = json.Marshal(resp)
+	}
 	if err != nil {
-		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, &ErrorResponse{
+			Code:    ErrorCodeInternal,
+			Message: "Failed to marshal response: " + err.Error(),
+		})
+		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprint(w, string(respStr))
+	w.Header().Add("Vary", "Accept")
+	writeBody(w, req, contentType, respBytes)
 }
 
+// ErrorCode classifies a failed TraceViz request, so that clients can react
+// to failures without parsing messages.
+type ErrorCode string
+
+const (
+	// ErrorCodeBadRequest indicates a malformed or oversized request, including
+	// one with missing, unknown, or malformed options.
+	ErrorCodeBadRequest ErrorCode = "BAD_REQUEST"
+	// ErrorCodeUnsupportedQuery indicates a request for a query no data source
+	// supports.
+	ErrorCodeUnsupportedQuery ErrorCode = "UNSUPPORTED_QUERY"
+	// ErrorCodeDataSourceError indicates that a data source failed a query.
+	ErrorCodeDataSourceError ErrorCode = "DATA_SOURCE_ERROR"
+	// ErrorCodeTimeout indicates that a data source did not respond in time.
+	ErrorCodeTimeout ErrorCode = "TIMEOUT"
+	// ErrorCodeOverloaded indicates that a data source was too busy to accept
+	// a query; the request may be retried later.
+	ErrorCodeOverloaded ErrorCode = "OVERLOADED"
+	// ErrorCodeUnauthenticated indicates a request lacking valid credentials.
+	ErrorCodeUnauthenticated ErrorCode = "UNAUTHENTICATED"
+	// ErrorCodeInternal indicates any other failure.
+	ErrorCodeInternal ErrorCode = "INTERNAL"
+)
+
+// ErrorResponse is the JSON body of a failed TraceViz request.
+type ErrorResponse struct {
+	Code ErrorCode
+	// The query names of the failing DataSeriesRequests, if known.
+	QueryNames []string `json:",omitempty"`
+	// A human-readable description of the failure.
+	Message string
+}
+
+// writeError responds with the provided HTTP status and ErrorResponse.
+func writeError(w http.ResponseWriter, status int, errResp *ErrorResponse) {
+	noteErrorCode(w, errResp.Code)
+	respBytes, err := json.Marshal(errResp)
+	if err != nil {
+		http.Error(w, errResp.Message, status)
+		return
+	}
+	w.Header().Set("Content-Type", JSONContentType)
+	w.WriteHeader(status)
+	w.Write(respBytes)
+}
+
+// DefaultMaxRequestBytes is the default limit on the size of a TraceViz data
+// request's body.
+const DefaultMaxRequestBytes = 8 << 20
+
+// DefaultSubscribeKeepalive is the default interval between keepalive
+// comments sent on idle /Subscribe streams, so that proxies don't close them.
+const DefaultSubscribeKeepalive = 30 * time.Second
+
 // queryHandler is an http.Handler serving TraceViz queries.
 type queryHandler struct {
-	qd       *querydispatcher.QueryDispatcher
-	wrappers []WrapFunc
+	qd              *querydispatcher.QueryDispatcher
+	wrappers        []WrapFunc
+	maxRequestBytes int64
+	// The interval between keepalive comments on /Subscribe streams; if not
+	// positive, none are sent.
+	subscribeKeepalive time.Duration
+	// If non-nil, records request metrics.
+	metrics *handlerMetrics
+}
+
+// QueryHandlerOption configures a QueryHandler.
+type QueryHandlerOption func(qh *queryHandler)
+
+// WithMaxRequestBytes configures a QueryHandler to reject request bodies
+// larger than the provided number of bytes, instead of
+// DefaultMaxRequestBytes.
+func WithMaxRequestBytes(maxRequestBytes int64) QueryHandlerOption {
+	return func(qh *queryHandler) {
+		qh.maxRequestBytes = maxRequestBytes
+	}
+}
+
+// WithSubscribeKeepalive configures a QueryHandler to send keepalive comments
+// on /Subscribe streams at the provided interval, instead of
+// DefaultSubscribeKeepalive.  A non-positive interval disables them.
+func WithSubscribeKeepalive(interval time.Duration) QueryHandlerOption {
+	return func(qh *queryHandler) {
+		qh.subscribeKeepalive = interval
+	}
 }
 
 // NewQueryHandler returns a new Handler serving TraceViz requests using the
 // provided QueryDispatcher.
 func NewQueryHandler(qd *querydispatcher.QueryDispatcher) QueryHandler {
-	return &queryHandler{
-		qd: qd,
+	return NewQueryHandlerWithOptions(qd)
+}
+
+// NewQueryHandlerWithOptions returns a new Handler serving TraceViz requests
+// using the provided QueryDispatcher, configured with the provided Options.
+func NewQueryHandlerWithOptions(qd *querydispatcher.QueryDispatcher, opts ...QueryHandlerOption) QueryHandler {
+	qh := &queryHandler{
+		qd:                 qd,
+		maxRequestBytes:    DefaultMaxRequestBytes,
+		subscribeKeepalive: DefaultSubscribeKeepalive,
+	}
+	for _, opt := range opts {
+		opt(qh)
 	}
+	return qh
 }
 
 const (
-	dataMethod = "/GetData"
+	dataMethod        = "/GetData"
+	streamDataMethod  = "/GetDataStream"
+	listQueriesMethod = "/ListQueries"
+	subscribeMethod   = "/Subscribe"
 )
 
+// NDJSONContentType is the content type of streamed TraceViz data responses:
+// newline-delimited JSON, one util.DataFrame per line.
+const NDJSONContentType = "application/x-ndjson"
+
+// EventStreamContentType is the content type of TraceViz data subscriptions:
+// Server-Sent Events.
+const EventStreamContentType = "text/event-stream"
+
+// streamError is the final line of a streamed TraceViz data response that
+// failed after streaming began.
+type streamError struct {
+	Error      string
+	Code       ErrorCode
+	QueryNames []string `json:",omitempty"`
+}
+
 type contextKey string
 
 var (
@@ -101,30 +266,282 @@ func (qh *queryHandler) Wrap(wrappers ...WrapFunc) Handler {
 // this Handler.
 func (qh *queryHandler) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
 	var dh HandlerFunc = qh.getDataHandler
+	var sdh HandlerFunc = qh.getDataStreamHandler
+	var lqh HandlerFunc = qh.listQueriesHandler
+	var sh HandlerFunc = qh.subscribeHandler
+	if qh.metrics != nil {
+		dh = qh.metrics.instrument(dataMethod, dh)
+		sdh = qh.metrics.instrument(streamDataMethod, sdh)
+		lqh = qh.metrics.instrument(listQueriesMethod, lqh)
+		sh = qh.metrics.instrument(subscribeMethod, sh)
+	}
 	for _, wrapper := range qh.wrappers {
 		dh = wrapper(dh)
+		sdh = wrapper(sdh)
+		lqh = wrapper(lqh)
+		sh = wrapper(sh)
 	}
 	return map[string]func(http.ResponseWriter, *http.Request){
-		dataMethod: dh,
+		dataMethod:        dh,
+		streamDataMethod:  sdh,
+		listQueriesMethod: lqh,
+		subscribeMethod:   sh,
 	}
 }
 
-func (qh *queryHandler) getDataHandler(w http.ResponseWriter, req *http.Request) {
-	dataReq := &util.DataRequest{}
-	if err := req.ParseForm(); err != nil {
-		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+// badRequest responds to a malformed request with the provided message and
+// error.  Bodies exceeding the size limit yield a 413 status; other failures,
+// a 400.
+func badRequest(w http.ResponseWriter, msg string, err error) {
+	status := http.StatusBadRequest
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		status = http.StatusRequestEntityTooLarge
 	}
-	if err := json.Unmarshal([]byte(req.Form.Get("req")), &dataReq); err != nil {
-		http.Error(w, "Failed to parse DataRequest: "+err.Error(), http.StatusBadRequest)
+	writeError(w, status, &ErrorResponse{
+		Code:    ErrorCodeBadRequest,
+		Message: msg + ": " + err.Error(),
+	})
+}
+
+// parseDataRequest parses the DataRequest in the provided HTTP request: the
+// request body, if it is a POST with content type JSONContentType, and the
+// 'req' form value otherwise.  On failure, it responds with an HTTP error and
+// returns false.
+func (qh *queryHandler) parseDataRequest(w http.ResponseWriter, req *http.Request) (*util.DataRequest, bool) {
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(w, req.Body, qh.maxRequestBytes)
+	}
+	var reqJSON []byte
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Method == http.MethodPost && mediaType == JSONContentType {
+		var err error
+		if reqJSON, err = io.ReadAll(req.Body); err != nil {
+			badRequest(w, "Failed to read request body", err)
+			return nil, false
+		}
+	} else {
+		if err := req.ParseForm(); err != nil {
+			badRequest(w, "Failed to parse form", err)
+			return nil, false
+		}
+		reqJSON = []byte(req.Form.Get("req"))
+	}
+	dataReq, err := util.DataRequestFromJSON(reqJSON)
+	if err != nil {
+		badRequest(w, "Failed to parse DataRequest", err)
+		return nil, false
+	}
+	return dataReq, true
+}
+
+// dataRequestErrorStatus returns the HTTP status with which to report the
+// provided DataRequest failure.
+func dataRequestErrorStatus(err error) int {
+	var uqe *querydispatcher.UnsupportedQueryError
+	var oe *util.OptionError
+	switch {
+	case errors.As(err, &uqe), errors.As(err, &oe):
+		return http.StatusBadRequest
+	case errors.Is(err, querydispatcher.ErrOverloaded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, querydispatcher.ErrTimedOut), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// dataRequestErrorResponse returns the ErrorResponse with which to report the
+// provided DataRequest failure.
+func dataRequestErrorResponse(err error) *ErrorResponse {
+	ret := &ErrorResponse{
+		Code:    ErrorCodeInternal,
+		Message: "DataRequest failed: " + err.Error(),
+	}
+	var uqe *querydispatcher.UnsupportedQueryError
+	var dse *querydispatcher.DataSourceError
+	if errors.As(err, &uqe) {
+		ret.Code = ErrorCodeUnsupportedQuery
+		ret.QueryNames = []string{uqe.QueryName}
+		return ret
+	}
+	if errors.As(err, &dse) {
+		ret.Code = ErrorCodeDataSourceError
+		ret.QueryNames = dse.QueryNames
+	}
+	var oe *util.OptionError
+	switch {
+	case errors.As(err, &oe):
+		ret.Code = ErrorCodeBadRequest
+	case errors.Is(err, querydispatcher.ErrOverloaded):
+		ret.Code = ErrorCodeOverloaded
+	case errors.Is(err, querydispatcher.ErrTimedOut), errors.Is(err, context.DeadlineExceeded):
+		ret.Code = ErrorCodeTimeout
+	}
+	return ret
+}
+
+// writeDataRequestError responds with the provided DataRequest failure.
+func writeDataRequestError(w http.ResponseWriter, err error) {
+	writeError(w, dataRequestErrorStatus(err), dataRequestErrorResponse(err))
+}
+
+func (qh *queryHandler) getDataHandler(w http.ResponseWriter, req *http.Request) {
+	dataReq, ok := qh.parseDataRequest(w, req)
+	if !ok {
 		return
 	}
 	ctx := req.Context()
 	resp, err := qh.qd.HandleDataRequest(context.WithValue(ctx, httpReqKey, req), dataReq)
 	if err != nil {
-		http.Error(w, "DataRequest failed: "+err.Error(), http.StatusInternalServerError)
+		writeDataRequestError(w, err)
+		return
+	}
+	sendHTTPResponse(resp, w, req)
+}
+
+// getDataStreamHandler handles a DataRequest like getDataHandler, but streams
+// its response as newline-delimited JSON util.DataFrames, flushing each frame
+// as soon as the data source producing it completes.  If the request fails
+// before any frame is sent, an HTTP error is returned; if it fails afterwards,
+// the stream ends with a streamError line.
+func (qh *queryHandler) getDataStreamHandler(w http.ResponseWriter, req *http.Request) {
+	dataReq, ok := qh.parseDataRequest(w, req)
+	if !ok {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	streaming := false
+	enc := json.NewEncoder(w)
+	ctx := req.Context()
+	err := qh.qd.HandleDataRequestStreaming(context.WithValue(ctx, httpReqKey, req), dataReq, func(frame *util.DataFrame) error {
+		if !streaming {
+			w.Header().Add("Content-Type", NDJSONContentType)
+			streaming = true
+		}
+		if err := enc.Encode(frame); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		return
+	}
+	if !streaming {
+		writeDataRequestError(w, err)
+		return
+	}
+	errResp := dataRequestErrorResponse(err)
+	noteErrorCode(w, errResp.Code)
+	enc.Encode(&streamError{
+		Error:      errResp.Message,
+		Code:       errResp.Code,
+		QueryNames: errResp.QueryNames,
+	})
+}
+
+// writeEvent writes a Server-Sent Event of the provided type, with the
+// provided value's JSON encoding as its data.
+func writeEvent(w io.Writer, eventType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
+
+// subscribeHandler subscribes to a DataRequest, parsed like getDataHandler's,
+// streaming its response and subsequent updates as Server-Sent Events until
+// the client disconnects; see QueryDispatcher.Subscribe.  Each 'data' event
+// carries a JSON util.Data, whose DataSeries replace any previously sent with
+// the same SeriesName.  If the subscription fails before any event is sent,
+// an HTTP error is returned; if it fails afterwards, a final 'error' event
+// carries an ErrorResponse.  While streaming, a ':keepalive' comment is sent
+// at the QueryHandler's keepalive interval.  Because browsers' EventSource
+// only issues GET requests, the DataRequest is generally provided in the
+// 'req' form value.
+func (qh *queryHandler) subscribeHandler(w http.ResponseWriter, req *http.Request) {
+	dataReq, ok := qh.parseDataRequest(w, req)
+	if !ok {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	// Guards writes to w, which events and keepalives both make.
+	var mu sync.Mutex
+	streaming := false
+	stopKeepalive := make(chan struct{})
+	var keepaliveDone sync.WaitGroup
+	ctx := req.Context()
+	err := qh.qd.Subscribe(context.WithValue(ctx, httpReqKey, req), dataReq, func(data *util.Data) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !streaming {
+			w.Header().Set("Content-Type", EventStreamContentType)
+			w.Header().Set("Cache-Control", "no-cache")
+			streaming = true
+			if qh.subscribeKeepalive > 0 {
+				keepaliveDone.Add(1)
+				go func() {
+					defer keepaliveDone.Done()
+					ticker := time.NewTicker(qh.subscribeKeepalive)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-stopKeepalive:
+							return
+						case <-ticker.C:
+							mu.Lock()
+							io.WriteString(w, ":keepalive\n\n")
+							flush()
+							mu.Unlock()
+						}
+					}
+				}()
+			}
+		}
+		if err := writeEvent(w, "data", data); err != nil {
+			return err
+		}
+		flush()
+		return nil
+	})
+	close(stopKeepalive)
+	keepaliveDone.Wait()
+	if err == nil {
+		return
+	}
+	if !streaming {
+		writeDataRequestError(w, err)
+		return
+	}
+	errResp := dataRequestErrorResponse(err)
+	noteErrorCode(w, errResp.Code)
+	writeEvent(w, "error", errResp)
+}
+
+// listQueriesHandler responds with a JSON catalog of the queries supported by
+// the QueryDispatcher's data sources; see QueryDispatcher.ListQueries.
+func (qh *queryHandler) listQueriesHandler(w http.ResponseWriter, req *http.Request) {
+	respBytes, err := json.Marshal(qh.qd.ListQueries())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, &ErrorResponse{
+			Code:    ErrorCodeInternal,
+			Message: "Failed to marshal response: " + err.Error(),
+		})
 		return
 	}
-	sendHTTPResponse(resp, w)
+	w.Header().Add("Content-Type", JSONContentType)
+	w.Write(respBytes)
 }
 
 // HTTPRequestFromContext returns the *http.Request stored in the provided context, or nil if no
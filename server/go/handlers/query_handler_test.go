@@ -0,0 +1,424 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+type testDataSource struct{}
+
+func (tds *testDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"greeting"}
+}
+
+func (tds *testDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		drb.DataSeries(req).With(util.StringProperty("greeting", "hello"))
+	}
+	return nil
+}
+
+func newTestQueryHandler(t *testing.T, method string) http.HandlerFunc {
+	t.Helper()
+	qd, err := querydispatcher.New(&testDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	return NewQueryHandler(qd).HandlersByPath()[method]
+}
+
+func dataRequestForm(t *testing.T, req *util.DataRequest) string {
+	t.Helper()
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal DataRequest: %s", err)
+	}
+	return url.Values{"req": []string{string(reqJSON)}}.Encode()
+}
+
+var greetingReq = &util.DataRequest{
+	SeriesRequests: []*util.DataSeriesRequest{{
+		QueryName:  "greeting",
+		SeriesName: "1",
+	}},
+}
+
+const greetingPrettyPrint = `Data:
+  Series 1
+    Root:
+      Prop 'greeting': 'hello'`
+
+func TestAcceptsBinary(t *testing.T) {
+	for _, test := range []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"*/*", false},
+		{BinaryContentType, true},
+		{"application/json;q=0.5, " + BinaryContentType, true},
+		{BinaryContentType + ";q=0.9", true},
+		{BinaryContentType + "; q=0", false},
+	} {
+		if got := acceptsBinary(test.accept); got != test.want {
+			t.Errorf("acceptsBinary(%q) = %t, want %t", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestGetDataEncodings(t *testing.T) {
+	for _, test := range []struct {
+		description     string
+		accept          string
+		wantContentType string
+		decode          func(body []byte) (*util.Data, error)
+	}{{
+		description:     "json",
+		wantContentType: JSONContentType,
+		decode: func(body []byte) (*util.Data, error) {
+			data := &util.Data{}
+			return data, json.Unmarshal(body, data)
+		},
+	}, {
+		description:     "binary",
+		accept:          BinaryContentType,
+		wantContentType: BinaryContentType,
+		decode: func(body []byte) (*util.Data, error) {
+			data := &util.Data{}
+			return data, data.UnmarshalBinary(body)
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			handler := newTestQueryHandler(t, dataMethod)
+			req := httptest.NewRequest(http.MethodGet, dataMethod+"?"+dataRequestForm(t, greetingReq), nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+			}
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Got Content-Type %q, want %q", got, test.wantContentType)
+			}
+			data, err := test.decode(rec.Body.Bytes())
+			if err != nil {
+				t.Fatalf("Failed to decode response: %s", err)
+			}
+			if diff := cmp.Diff(greetingPrettyPrint, data.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+func TestGetDataStream(t *testing.T) {
+	handler := newTestQueryHandler(t, streamDataMethod)
+	req := httptest.NewRequest(http.MethodGet, streamDataMethod+"?"+dataRequestForm(t, greetingReq), nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != NDJSONContentType {
+		t.Errorf("Got Content-Type %q, want %q", got, NDJSONContentType)
+	}
+	var frames []*util.DataFrame
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		frame := &util.DataFrame{}
+		if err := dec.Decode(frame); err != nil {
+			t.Fatalf("Failed to decode frame: %s", err)
+		}
+		frames = append(frames, frame)
+	}
+	data := util.ReassembleDataFrames(frames...)
+	if diff := cmp.Diff(greetingPrettyPrint, data.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+	}
+}
+
+func TestListQueries(t *testing.T) {
+	handler := newTestQueryHandler(t, listQueriesMethod)
+	req := httptest.NewRequest(http.MethodGet, listQueriesMethod, nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != JSONContentType {
+		t.Errorf("Got Content-Type %q, want %q", got, JSONContentType)
+	}
+	want := `[{"Name":"*handlers.testDataSource","Queries":[{"Name":"greeting"}]}]`
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("Got catalog %s, diff (-want +got):\n%s", rec.Body.String(), diff)
+	}
+}
+
+func TestDataRequestErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		err         error
+		want        int
+	}{{
+		description: "overloaded",
+		err:         fmt.Errorf("%w: busy", querydispatcher.ErrOverloaded),
+		want:        http.StatusServiceUnavailable,
+	}, {
+		description: "timed out",
+		err:         fmt.Errorf("%w: slow", querydispatcher.ErrTimedOut),
+		want:        http.StatusGatewayTimeout,
+	}, {
+		description: "deadline exceeded",
+		err:         fmt.Errorf("slow: %w", context.DeadlineExceeded),
+		want:        http.StatusGatewayTimeout,
+	}, {
+		description: "unsupported query",
+		err:         &querydispatcher.UnsupportedQueryError{QueryName: "q"},
+		want:        http.StatusBadRequest,
+	}, {
+		description: "malformed option",
+		err:         &querydispatcher.DataSourceError{Err: &util.OptionError{Err: errors.New("bad")}},
+		want:        http.StatusBadRequest,
+	}, {
+		description: "other",
+		err:         fmt.Errorf("oops"),
+		want:        http.StatusInternalServerError,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if got := dataRequestErrorStatus(test.err); got != test.want {
+				t.Errorf("dataRequestErrorStatus() = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetDataPost(t *testing.T) {
+	handler := newTestQueryHandler(t, dataMethod)
+	reqJSON, err := json.Marshal(greetingReq)
+	if err != nil {
+		t.Fatalf("Failed to marshal DataRequest: %s", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, dataMethod, bytes.NewReader(reqJSON))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	data := &util.Data{}
+	if err := json.Unmarshal(rec.Body.Bytes(), data); err != nil {
+		t.Fatalf("Failed to decode response: %s", err)
+	}
+	if diff := cmp.Diff(greetingPrettyPrint, data.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+	}
+}
+
+// failingDataSource fails every 'failure' query, and every 'named'
+// query lacking its required option.
+type failingDataSource struct{}
+
+func (fds *failingDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"failure", "named"}
+}
+
+func (fds *failingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		if req.QueryName == "named" {
+			opts := &struct {
+				Name string `traceviz:"name,required"`
+			}{}
+			if err := util.DecodeOptions(req.Options, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return errors.New("oops")
+}
+
+func TestGetDataErrors(t *testing.T) {
+	dataRequestBody := func(queryName string) string {
+		reqJSON, err := json.Marshal(&util.DataRequest{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName:  queryName,
+				SeriesName: "1",
+			}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to marshal DataRequest: %s", err)
+		}
+		return string(reqJSON)
+	}
+	for _, test := range []struct {
+		description string
+		body        string
+		wantStatus  int
+		wantResp    *ErrorResponse
+	}{{
+		description: "malformed request",
+		body:        "{",
+		wantStatus:  http.StatusBadRequest,
+		wantResp: &ErrorResponse{
+			Code:    ErrorCodeBadRequest,
+			Message: "Failed to parse DataRequest: unexpected end of JSON input",
+		},
+	}, {
+		description: "oversized request",
+		body:        dataRequestBody(strings.Repeat("a", 100)),
+		wantStatus:  http.StatusRequestEntityTooLarge,
+		wantResp: &ErrorResponse{
+			Code:    ErrorCodeBadRequest,
+			Message: "Failed to read request body: http: request body too large",
+		},
+	}, {
+		description: "unsupported query",
+		body:        dataRequestBody("farewell"),
+		wantStatus:  http.StatusBadRequest,
+		wantResp: &ErrorResponse{
+			Code:       ErrorCodeUnsupportedQuery,
+			QueryNames: []string{"farewell"},
+			Message:    "DataRequest failed: unsupported data query `farewell`",
+		},
+	}, {
+		description: "failing data source",
+		body:        dataRequestBody("failure"),
+		wantStatus:  http.StatusInternalServerError,
+		wantResp: &ErrorResponse{
+			Code:       ErrorCodeDataSourceError,
+			QueryNames: []string{"failure"},
+			Message:    "DataRequest failed: data source *handlers.failingDataSource failed handling [failure]: oops",
+		},
+	}, {
+		description: "missing option",
+		body:        dataRequestBody("named"),
+		wantStatus:  http.StatusBadRequest,
+		wantResp: &ErrorResponse{
+			Code:       ErrorCodeBadRequest,
+			QueryNames: []string{"named"},
+			Message:    "DataRequest failed: data source *handlers.failingDataSource failed handling [named]: missing required option 'name'",
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			qd, err := querydispatcher.New(&testDataSource{}, &failingDataSource{})
+			if err != nil {
+				t.Fatalf("Failed to create QueryDispatcher: %s", err)
+			}
+			handler := NewQueryHandlerWithOptions(qd, WithMaxRequestBytes(100)).HandlersByPath()[dataMethod]
+			req := httptest.NewRequest(http.MethodPost, dataMethod, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", JSONContentType)
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, want %d", rec.Code, test.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Type"); got != JSONContentType {
+				t.Errorf("Got Content-Type %q, want %q", got, JSONContentType)
+			}
+			gotResp := &ErrorResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), gotResp); err != nil {
+				t.Fatalf("Failed to decode error response %q: %s", rec.Body.String(), err)
+			}
+			if diff := cmp.Diff(test.wantResp, gotResp); diff != "" {
+				t.Errorf("Got error response %v, diff (-want +got):\n%s", gotResp, diff)
+			}
+		})
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := httptest.NewServer(newTestQueryHandler(t, subscribeMethod))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+subscribeMethod+"?"+dataRequestForm(t, greetingReq), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Subscription failed: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := resp.Header.Get("Content-Type"); got != EventStreamContentType {
+		t.Errorf("Got Content-Type %q, want %q", got, EventStreamContentType)
+	}
+	// Read the initial event.
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || lines[0] != "event: data" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("Got event %q, want a data event", lines)
+	}
+	data := &util.Data{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), data); err != nil {
+		t.Fatalf("Failed to decode event data: %s", err)
+	}
+	if diff := cmp.Diff(greetingPrettyPrint, data.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+	}
+}
+
+func TestSubscribeKeepalive(t *testing.T) {
+	qd, err := querydispatcher.New(&testDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(NewQueryHandlerWithOptions(qd, WithSubscribeKeepalive(time.Millisecond)).HandlersByPath()[subscribeMethod]))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+subscribeMethod+"?"+dataRequestForm(t, greetingReq), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %s", err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Subscription failed: %s", err)
+	}
+	defer resp.Body.Close()
+	// Skip the initial event; keepalives follow.
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() && scanner.Text() != "" {
+	}
+	if !scanner.Scan() {
+		t.Fatalf("Stream ended after the initial event: %v", scanner.Err())
+	}
+	if got, want := scanner.Text(), ":keepalive"; got != want {
+		t.Errorf("Got line %q after the initial event, want %q", got, want)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package wrappers provides ready-made handlers.WrapFuncs for access control:
+// authentication with static bearer tokens or htpasswd-style basic auth, and
+// CORS.  Authenticated requests carry their util.Principal in their Context,
+// so data sources can authorize requests per collection with
+// util.PrincipalFrom.
+package wrappers
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Authenticator authenticates HTTP requests using a single HTTP
+// authentication scheme.
+type Authenticator interface {
+	// Scheme returns the HTTP authentication scheme, like 'Basic', used in
+	// Authorization and WWW-Authenticate headers.
+	Scheme() string
+	// Authenticate returns the Principal whose credentials the provided
+	// request carries, or false if it carries no credentials valid for this
+	// Authenticator.
+	Authenticate(req *http.Request) (*util.Principal, bool)
+}
+
+// RequireAuth returns a WrapFunc rejecting, with a 401 status, requests not
+// authenticated by any of the provided Authenticators.  Authenticated
+// requests proceed with their Principal in their Context; see
+// util.PrincipalFrom.
+// The provided realm is reported to rejected clients.
+func RequireAuth(realm string, authenticators ...Authenticator) handlers.WrapFunc {
+	return func(next handlers.HandlerFunc) handlers.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			for _, authenticator := range authenticators {
+				if principal, ok := authenticator.Authenticate(req); ok {
+					next(w, req.WithContext(util.WithPrincipal(req.Context(), principal)))
+					return
+				}
+			}
+			for _, authenticator := range authenticators {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf("%s realm=%q", authenticator.Scheme(), realm))
+			}
+			respBytes, err := json.Marshal(&handlers.ErrorResponse{
+				Code:    handlers.ErrorCodeUnauthenticated,
+				Message: "Authentication required",
+			})
+			if err != nil {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", handlers.JSONContentType)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write(respBytes)
+		}
+	}
+}
+
+// BearerTokens is an Authenticator accepting static bearer tokens, each
+// associated with a principal name.
+type BearerTokens struct {
+	namesByToken map[string]string
+}
+
+// NewBearerTokens returns a new BearerTokens accepting the provided tokens,
+// which are mapped to their principals' names.
+func NewBearerTokens(namesByToken map[string]string) *BearerTokens {
+	return &BearerTokens{
+		namesByToken: namesByToken,
+	}
+}
+
+// ParseBearerTokens parses a bearer tokens file from the provided Reader.
+// Each line has the form 'name:token'; blank lines and lines beginning with
+// '#' are ignored.
+func ParseBearerTokens(r io.Reader) (*BearerTokens, error) {
+	namesByToken := map[string]string{}
+	if err := parseColonSeparated(r, func(name, token string) error {
+		if token == "" {
+			return fmt.Errorf("empty token for '%s'", name)
+		}
+		if _, ok := namesByToken[token]; ok {
+			return fmt.Errorf("duplicate token for '%s'", name)
+		}
+		namesByToken[token] = name
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to parse bearer tokens: %w", err)
+	}
+	return NewBearerTokens(namesByToken), nil
+}
+
+// LoadBearerTokens parses the bearer tokens file at the provided path; see
+// ParseBearerTokens.
+func LoadBearerTokens(path string) (*BearerTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseBearerTokens(f)
+}
+
+// Scheme returns 'Bearer'.
+func (bt *BearerTokens) Scheme() string {
+	return "Bearer"
+}
+
+// Authenticate authenticates requests whose Authorization header carries
+// one of the receiver's tokens.
+func (bt *BearerTokens) Authenticate(req *http.Request) (*util.Principal, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, bt.Scheme()) {
+		return nil, false
+	}
+	token = strings.TrimSpace(token)
+	// Compare against every token, in constant time, so that response timing
+	// reveals nothing about valid tokens.
+	var name string
+	found := false
+	for candidate, candidateName := range bt.namesByToken {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			name, found = candidateName, true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+	return &util.Principal{
+		Name:   name,
+		Scheme: bt.Scheme(),
+	}, true
+}
+
+// parseColonSeparated calls the provided function with the two fields of
+// each 'a:b' line in the provided Reader, skipping blank lines and lines
+// beginning with '#'.
+func parseColonSeparated(r io.Reader, fn func(a, b string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		a, b, ok := strings.Cut(line, ":")
+		if !ok || a == "" {
+			return fmt.Errorf("line %d: expected 'name:value'", lineNum)
+		}
+		if err := fn(a, b); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
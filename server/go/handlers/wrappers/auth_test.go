@@ -0,0 +1,154 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// principalEcho is a HandlerFunc writing the name of the request's Principal.
+func principalEcho(w http.ResponseWriter, req *http.Request) {
+	principal := util.PrincipalFrom(req.Context())
+	if principal == nil {
+		w.Write([]byte("<none>"))
+		return
+	}
+	w.Write([]byte(principal.Scheme + " " + principal.Name))
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestRequireAuth(t *testing.T) {
+	bearerTokens, err := ParseBearerTokens(strings.NewReader(`
+# Dashboards
+dashboard:abc123
+cron:def456
+`))
+	if err != nil {
+		t.Fatalf("ParseBearerTokens() yielded unexpected error %s", err)
+	}
+	htpasswd, err := ParseHtpasswd(strings.NewReader("alice:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n"))
+	if err != nil {
+		t.Fatalf("ParseHtpasswd() yielded unexpected error %s", err)
+	}
+	handler := RequireAuth("TraceViz", bearerTokens, htpasswd)(principalEcho)
+	for _, test := range []struct {
+		description   string
+		authorization string
+		wantStatus    int
+		wantBody      string
+		wantChallenge []string
+	}{{
+		description:   "bearer token",
+		authorization: "Bearer def456",
+		wantStatus:    http.StatusOK,
+		wantBody:      "Bearer cron",
+	}, {
+		description:   "basic auth",
+		authorization: basicAuth("alice", "secret"),
+		wantStatus:    http.StatusOK,
+		wantBody:      "Basic alice",
+	}, {
+		description:   "no credentials",
+		wantStatus:    http.StatusUnauthorized,
+		wantBody:      `{"Code":"UNAUTHENTICATED","Message":"Authentication required"}`,
+		wantChallenge: []string{`Bearer realm="TraceViz"`, `Basic realm="TraceViz"`},
+	}, {
+		description:   "wrong token",
+		authorization: "Bearer abc",
+		wantStatus:    http.StatusUnauthorized,
+		wantBody:      `{"Code":"UNAUTHENTICATED","Message":"Authentication required"}`,
+		wantChallenge: []string{`Bearer realm="TraceViz"`, `Basic realm="TraceViz"`},
+	}, {
+		description:   "wrong password",
+		authorization: basicAuth("alice", "guess"),
+		wantStatus:    http.StatusUnauthorized,
+		wantBody:      `{"Code":"UNAUTHENTICATED","Message":"Authentication required"}`,
+		wantChallenge: []string{`Bearer realm="TraceViz"`, `Basic realm="TraceViz"`},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/GetData", nil)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, wanted %d", rec.Code, test.wantStatus)
+			}
+			if diff := cmp.Diff(test.wantBody, rec.Body.String()); diff != "" {
+				t.Errorf("Got body %s, diff (-want +got) %s", rec.Body.String(), diff)
+			}
+			if diff := cmp.Diff(test.wantChallenge, rec.Header().Values("WWW-Authenticate")); diff != "" {
+				t.Errorf("Got WWW-Authenticate %v, diff (-want +got) %s", rec.Header().Values("WWW-Authenticate"), diff)
+			}
+		})
+	}
+}
+
+func TestParseBearerTokensErrors(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		input       string
+	}{{
+		description: "missing colon",
+		input:       "dashboard",
+	}, {
+		description: "empty token",
+		input:       "dashboard:",
+	}, {
+		description: "duplicate token",
+		input:       "dashboard:abc\ncron:abc",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := ParseBearerTokens(strings.NewReader(test.input)); err == nil {
+				t.Errorf("ParseBearerTokens() yielded no error, wanted one")
+			}
+		})
+	}
+}
+
+func TestWrapHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whoami", principalEcho)
+	handler := WrapHandler(mux,
+		RequireAuth("TraceViz", NewBearerTokens(map[string]string{"abc123": "dashboard"})),
+		CORS(CORSPolicy{AllowedOrigins: []string{"https://example.com"}}),
+	)
+	// CORS is outermost, so preflight requests succeed without credentials.
+	req := httptest.NewRequest(http.MethodOptions, "/whoami", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Got preflight status %d, wanted %d", rec.Code, http.StatusNoContent)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/whoami", nil)
+	req.Header.Set("Authorization", "Bearer abc123")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got, want := rec.Body.String(), "Bearer dashboard"; got != want {
+		t.Errorf("Got body '%s', wanted '%s'", got, want)
+	}
+}
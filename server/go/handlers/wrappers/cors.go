@@ -0,0 +1,111 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+)
+
+// CORSPolicy describes which cross-origin requests a server permits.
+type CORSPolicy struct {
+	// The origins, like 'https://example.com', permitted to make requests.
+	// '*' permits all other origins, but only without credentials: responses
+	// to them carry a literal '*' origin and never permit credentials.
+	AllowedOrigins []string
+	// The methods permitted in cross-origin requests.  If empty, GET and POST
+	// are permitted.
+	AllowedMethods []string
+	// The request headers permitted in cross-origin requests.  If empty,
+	// Authorization and Content-Type are permitted.
+	AllowedHeaders []string
+	// Whether cross-origin requests may carry credentials, such as cookies or
+	// Authorization headers.
+	AllowCredentials bool
+	// How long clients may cache preflight responses.  If zero, clients use
+	// their own default.
+	MaxAge time.Duration
+}
+
+// allowsOrigin returns true if the receiver permits requests from the
+// provided origin, and whether it does so only through a '*' wildcard.
+func (cp *CORSPolicy) allowsOrigin(origin string) (allowed, wildcard bool) {
+	for _, allowedOrigin := range cp.AllowedOrigins {
+		if allowedOrigin == origin {
+			return true, false
+		}
+		if allowedOrigin == "*" {
+			wildcard = true
+		}
+	}
+	return wildcard, wildcard
+}
+
+// CORS returns a WrapFunc applying the provided CORSPolicy.  Preflight
+// requests from permitted origins are answered directly, without calling the
+// wrapped handler, so CORS should be applied outside any authentication
+// WrapFunc: that is, after it in calls to Wrap.  Requests from other origins
+// are passed through without CORS headers, so browsers will not expose their
+// responses.
+func CORS(policy CORSPolicy) handlers.WrapFunc {
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost}
+	}
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Authorization", "Content-Type"}
+	}
+	return func(next handlers.HandlerFunc) handlers.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Add("Vary", "Origin")
+			origin := req.Header.Get("Origin")
+			if origin == "" {
+				next(w, req)
+				return
+			}
+			allowed, wildcard := policy.allowsOrigin(origin)
+			switch {
+			case !allowed:
+				next(w, req)
+				return
+			case wildcard:
+				// Credentialed requests must never be permitted from arbitrary
+				// origins, or any site could read responses with its visitors'
+				// credentials.
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			default:
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if policy.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+				next(w, req)
+				return
+			}
+			// This is a preflight request.
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
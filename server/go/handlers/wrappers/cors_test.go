@@ -0,0 +1,152 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCORS(t *testing.T) {
+	policy := CORSPolicy{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+	corsHeaders := []string{
+		"Access-Control-Allow-Origin",
+		"Access-Control-Allow-Credentials",
+		"Access-Control-Allow-Methods",
+		"Access-Control-Allow-Headers",
+		"Access-Control-Max-Age",
+	}
+	for _, test := range []struct {
+		description string
+		// If nil, policy is used.
+		policy      *CORSPolicy
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantHeaders map[string]string
+	}{{
+		description: "same origin",
+		method:      http.MethodGet,
+		wantStatus:  http.StatusOK,
+		wantHeaders: map[string]string{},
+	}, {
+		description: "allowed origin",
+		method:      http.MethodGet,
+		origin:      "https://example.com",
+		wantStatus:  http.StatusOK,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin":      "https://example.com",
+			"Access-Control-Allow-Credentials": "true",
+		},
+	}, {
+		description: "disallowed origin",
+		method:      http.MethodGet,
+		origin:      "https://evil.example",
+		wantStatus:  http.StatusOK,
+		wantHeaders: map[string]string{},
+	}, {
+		description: "preflight",
+		method:      http.MethodOptions,
+		origin:      "https://example.com",
+		preflight:   true,
+		wantStatus:  http.StatusNoContent,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin":      "https://example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, POST",
+			"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+			"Access-Control-Max-Age":           "3600",
+		},
+	}, {
+		description: "wildcard origin with credentials",
+		policy: &CORSPolicy{
+			AllowedOrigins:   []string{"https://example.com", "*"},
+			AllowCredentials: true,
+		},
+		method:     http.MethodGet,
+		origin:     "https://evil.example",
+		wantStatus: http.StatusOK,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin": "*",
+		},
+	}, {
+		description: "listed origin alongside wildcard with credentials",
+		policy: &CORSPolicy{
+			AllowedOrigins:   []string{"*", "https://example.com"},
+			AllowCredentials: true,
+		},
+		method:     http.MethodGet,
+		origin:     "https://example.com",
+		wantStatus: http.StatusOK,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin":      "https://example.com",
+			"Access-Control-Allow-Credentials": "true",
+		},
+	}, {
+		description: "wildcard preflight with credentials",
+		policy: &CORSPolicy{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+		},
+		method:     http.MethodOptions,
+		origin:     "https://evil.example",
+		preflight:  true,
+		wantStatus: http.StatusNoContent,
+		wantHeaders: map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "GET, POST",
+			"Access-Control-Allow-Headers": "Authorization, Content-Type",
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			policy := policy
+			if test.policy != nil {
+				policy = *test.policy
+			}
+			req := httptest.NewRequest(test.method, "/GetData", nil)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			CORS(policy)(func(w http.ResponseWriter, req *http.Request) {})(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, wanted %d", rec.Code, test.wantStatus)
+			}
+			gotHeaders := map[string]string{}
+			for _, header := range corsHeaders {
+				if value := rec.Header().Get(header); value != "" {
+					gotHeaders[header] = value
+				}
+			}
+			if diff := cmp.Diff(test.wantHeaders, gotHeaders); diff != "" {
+				t.Errorf("Got CORS headers %v, diff (-want +got) %s", gotHeaders, diff)
+			}
+			if got := rec.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Got Vary '%s', wanted 'Origin'", got)
+			}
+		})
+	}
+}
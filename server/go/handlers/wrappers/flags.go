@@ -0,0 +1,90 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"flag"
+	"net/http"
+	"strings"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+)
+
+// Flags configures authentication and CORS wrappers from command-line flags.
+type Flags struct {
+	bearerTokensFile   *string
+	htpasswdFile       *string
+	realm              *string
+	corsAllowedOrigins *string
+}
+
+// RegisterFlags registers flags configuring authentication and CORS on the
+// provided FlagSet, such as flag.CommandLine, returning a Flags from which
+// the configured WrapFuncs may be obtained once the flags are parsed.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		bearerTokensFile:   fs.String("bearer_tokens_file", "", "If set, the path to a file of 'name:token' lines; requests may authenticate with any of these bearer tokens"),
+		htpasswdFile:       fs.String("htpasswd_file", "", "If set, the path to an htpasswd file; requests may authenticate with HTTP basic auth as any of its users"),
+		realm:              fs.String("auth_realm", "TraceViz", "The authentication realm reported to unauthenticated clients"),
+		corsAllowedOrigins: fs.String("cors_allowed_origins", "", "A comma-separated list of origins permitted to make cross-origin requests; '*' permits all other origins, without credentials"),
+	}
+}
+
+// WrapFuncs returns the WrapFuncs configured by the receiver's flags, in the
+// order in which they should be applied.  If no authentication flag is set,
+// requests are not authenticated.
+func (f *Flags) WrapFuncs() ([]handlers.WrapFunc, error) {
+	var authenticators []Authenticator
+	if *f.bearerTokensFile != "" {
+		bt, err := LoadBearerTokens(*f.bearerTokensFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, bt)
+	}
+	if *f.htpasswdFile != "" {
+		h, err := LoadHtpasswd(*f.htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, h)
+	}
+	var ret []handlers.WrapFunc
+	if len(authenticators) > 0 {
+		ret = append(ret, RequireAuth(*f.realm, authenticators...))
+	}
+	if *f.corsAllowedOrigins != "" {
+		policy := CORSPolicy{
+			AllowCredentials: len(authenticators) > 0,
+		}
+		for _, origin := range strings.Split(*f.corsAllowedOrigins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				policy.AllowedOrigins = append(policy.AllowedOrigins, origin)
+			}
+		}
+		ret = append(ret, CORS(policy))
+	}
+	return ret, nil
+}
+
+// WrapHandler returns an http.Handler applying the provided WrapFuncs, in
+// order, to the provided http.Handler, such as a server's whole
+// http.ServeMux.  The last WrapFunc is outermost.
+func WrapHandler(h http.Handler, wrapFuncs ...handlers.WrapFunc) http.Handler {
+	var hf handlers.HandlerFunc = h.ServeHTTP
+	for _, wrapFunc := range wrapFuncs {
+		hf = wrapFunc(hf)
+	}
+	return http.HandlerFunc(hf)
+}
@@ -0,0 +1,195 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd is an Authenticator accepting HTTP basic auth credentials listed in
+// an htpasswd-style file.
+type Htpasswd struct {
+	hashesByUser map[string]string
+}
+
+const (
+	apr1Prefix = "$apr1$"
+	shaPrefix  = "{SHA}"
+)
+
+// bcryptPrefixes are the prefixes of bcrypt hashes; htpasswd -B produces the
+// first.
+var bcryptPrefixes = []string{"$2y$", "$2a$", "$2b$"}
+
+// hasBcryptPrefix returns true if the provided hash is a bcrypt hash.
+func hasBcryptPrefix(hash string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHtpasswd parses an htpasswd-style file from the provided Reader.  Each
+// line has the form 'user:hash'; blank lines and lines beginning with '#' are
+// ignored.  Hashes may be in the bcrypt format ('$2y$...', htpasswd -B),
+// which is recommended, the Apache MD5 format ('$apr1$...', htpasswd's
+// default), or the SHA-1 format ('{SHA}...', htpasswd -s).  Other formats are
+// unsupported.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	hashesByUser := map[string]string{}
+	if err := parseColonSeparated(r, func(user, hash string) error {
+		if !hasBcryptPrefix(hash) && !strings.HasPrefix(hash, apr1Prefix) && !strings.HasPrefix(hash, shaPrefix) {
+			return fmt.Errorf("unsupported hash format for user '%s'; use htpasswd -B", user)
+		}
+		if _, ok := hashesByUser[user]; ok {
+			return fmt.Errorf("duplicate user '%s'", user)
+		}
+		hashesByUser[user] = hash
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to parse htpasswd: %w", err)
+	}
+	return &Htpasswd{
+		hashesByUser: hashesByUser,
+	}, nil
+}
+
+// LoadHtpasswd parses the htpasswd-style file at the provided path; see
+// ParseHtpasswd.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// Scheme returns 'Basic'.
+func (h *Htpasswd) Scheme() string {
+	return "Basic"
+}
+
+// Authenticate authenticates requests whose basic auth credentials match an
+// entry in the receiver.
+func (h *Htpasswd) Authenticate(req *http.Request) (*util.Principal, bool) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	hash, ok := h.hashesByUser[user]
+	if !ok || !checkPassword(hash, password) {
+		return nil, false
+	}
+	return &util.Principal{
+		Name:   user,
+		Scheme: h.Scheme(),
+	}, true
+}
+
+// checkPassword returns true if the provided password matches the provided
+// htpasswd hash.
+func checkPassword(hash, password string) bool {
+	var want string
+	switch {
+	case hasBcryptPrefix(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		want = shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, apr1Prefix):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, apr1Prefix), "$")
+		want = apr1(password, salt)
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(want)) == 1
+}
+
+// apr1Alphabet is the base-64 alphabet used by crypt(3)-style hashes.
+const apr1Alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// apr1 returns the Apache MD5 hash of the provided password with the provided
+// salt, as produced by htpasswd -m.
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(apr1Prefix))
+	ctx.Write([]byte(salt))
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(sum)
+		} else {
+			round.Write(pw)
+		}
+		sum = round.Sum(nil)
+	}
+	var encoded strings.Builder
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded.WriteByte(apr1Alphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, idxs := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[idxs[0]])<<16|uint(sum[idxs[1]])<<8|uint(sum[idxs[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+	return apr1Prefix + salt + "$" + encoded.String()
+}
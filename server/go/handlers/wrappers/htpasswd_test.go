@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package wrappers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		hash        string
+		password    string
+		want        bool
+	}{{
+		description: "bcrypt match",
+		hash:        "$2y$05$wNoS0lUZnFFXcD2adKJGku4iHz2CMjB4HId8t3t7dpKcTRejY6rUy",
+		password:    "secret",
+		want:        true,
+	}, {
+		description: "bcrypt mismatch",
+		hash:        "$2y$05$wNoS0lUZnFFXcD2adKJGku4iHz2CMjB4HId8t3t7dpKcTRejY6rUy",
+		password:    "Secret",
+		want:        false,
+	}, {
+		description: "bcrypt $2a$ match",
+		hash:        "$2a$05$wNoS0lUZnFFXcD2adKJGku4iHz2CMjB4HId8t3t7dpKcTRejY6rUy",
+		password:    "secret",
+		want:        true,
+	}, {
+		description: "malformed bcrypt",
+		hash:        "$2y$05$abcdefghijklmnopqrstuv",
+		password:    "secret",
+		want:        false,
+	}, {
+		description: "apr1 match",
+		hash:        "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+		password:    "secret",
+		want:        true,
+	}, {
+		description: "apr1 mismatch",
+		hash:        "$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0",
+		password:    "Secret",
+		want:        false,
+	}, {
+		description: "sha match",
+		hash:        "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		password:    "secret",
+		want:        true,
+	}, {
+		description: "sha mismatch",
+		hash:        "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		password:    "",
+		want:        false,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if got := checkPassword(test.hash, test.password); got != test.want {
+				t.Errorf("checkPassword(%s, %s) = %t, wanted %t", test.hash, test.password, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseHtpasswdErrors(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		input       string
+		wantErr     string
+	}{{
+		description: "crypt",
+		input:       "alice:saX3zD0xPTm5Y",
+		wantErr:     "failed to parse htpasswd: line 1: unsupported hash format for user 'alice'; use htpasswd -B",
+	}, {
+		description: "duplicate user",
+		input:       "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		wantErr:     "failed to parse htpasswd: line 2: duplicate user 'alice'",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := ParseHtpasswd(strings.NewReader(test.input))
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("ParseHtpasswd() yielded error %v, wanted '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -25,3 +25,9 @@ const (
 func SelfMagnitude(selfMagnitude float64) util.PropertyUpdate {
 	return util.DoubleProperty(selfMagnitudeKey, selfMagnitude)
 }
+
+// DecodeSelfMagnitude returns the self-magnitude annotated on the provided
+// DatumReader.
+func DecodeSelfMagnitude(dr *util.DatumReader) (float64, error) {
+	return dr.Double(selfMagnitudeKey)
+}
@@ -0,0 +1,359 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package metrics provides minimal counters and histograms for monitoring
+// TraceViz servers, served in the Prometheus text exposition format (see
+// https://prometheus.io/docs/instrumenting/exposition_formats/) without
+// depending on any Prometheus library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TextContentType is the content type of the Prometheus text exposition
+// format.
+const TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	// DefaultLatencyBuckets are histogram bucket upper bounds suitable for
+	// request latencies, in seconds.
+	DefaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	// DefaultSizeBuckets are histogram bucket upper bounds suitable for
+	// response sizes, in bytes.
+	DefaultSizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+)
+
+// Default is the Registry used by TraceViz's own instrumentation.
+var Default = NewRegistry()
+
+// family is a named metric and all of its labeled series.
+type family interface {
+	name() string
+	labels() []string
+	write(w *bufio.Writer)
+}
+
+// Registry is a set of metric families.  Registry is safe for concurrent use.
+type Registry struct {
+	mu           sync.Mutex
+	familyByName map[string]family
+}
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		familyByName: map[string]family{},
+	}
+}
+
+// register returns the family registered under the provided name, creating
+// it with newFamily if there is none.  It panics if the registered family
+// isn't of type F or has different label names.
+func register[F family](r *Registry, name string, labelNames []string, newFamily func() F) F {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.familyByName[name]; ok {
+		f, ok := existing.(F)
+		if !ok || !slices.Equal(existing.labels(), labelNames) {
+			panic(fmt.Sprintf("metric '%s' is already registered differently", name))
+		}
+		return f
+	}
+	f := newFamily()
+	r.familyByName[name] = f
+	return f
+}
+
+// Counter returns the CounterVec registered in the receiver under the
+// provided name, registering a new one with the provided help text and label
+// names if there is none.  It panics if the name is registered to a different
+// kind of metric, or with different label names.
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	return register(r, name, labelNames, func() *CounterVec {
+		return &CounterVec{
+			vec: newVec(name, help, labelNames, func() *Counter {
+				return &Counter{}
+			}),
+		}
+	})
+}
+
+// Histogram returns the HistogramVec registered in the receiver under the
+// provided name, registering a new one with the provided help text, bucket
+// upper bounds, and label names if there is none.  It panics if the name is
+// registered to a different kind of metric, or with different label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = slices.Clone(buckets)
+	sort.Float64s(buckets)
+	return register(r, name, labelNames, func() *HistogramVec {
+		return &HistogramVec{
+			vec: newVec(name, help, labelNames, func() *Histogram {
+				return &Histogram{
+					buckets: buckets,
+					counts:  make([]uint64, len(buckets)),
+				}
+			}),
+		}
+	})
+}
+
+// WriteText writes all of the receiver's metrics to the provided Writer in
+// the Prometheus text exposition format, ordered by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]family, 0, len(r.familyByName))
+	for _, f := range r.familyByName {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(a, b int) bool {
+		return families[a].name() < families[b].name()
+	})
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// HTTPHandler serves the receiver's metrics in the Prometheus text exposition
+// format, e.g. at '/metrics'.
+func (r *Registry) HTTPHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", TextContentType)
+	r.WriteText(w)
+}
+
+// vec is a metric family whose series, of type S, are distinguished by label
+// values.
+type vec[S any] struct {
+	metricName string
+	help       string
+	labelNames []string
+	newSeries  func() S
+
+	mu            sync.Mutex
+	seriesByLabel map[string]S
+	labelValues   map[string][]string
+}
+
+func newVec[S any](name, help string, labelNames []string, newSeries func() S) vec[S] {
+	return vec[S]{
+		metricName:    name,
+		help:          help,
+		labelNames:    slices.Clone(labelNames),
+		newSeries:     newSeries,
+		seriesByLabel: map[string]S{},
+		labelValues:   map[string][]string{},
+	}
+}
+
+func (v *vec[S]) name() string {
+	return v.metricName
+}
+
+func (v *vec[S]) labels() []string {
+	return v.labelNames
+}
+
+// with returns the series with the provided label values, creating it if
+// necessary.  It panics if the wrong number of label values is provided.
+func (v *vec[S]) with(labelValues []string) S {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric '%s' expects %d label values, got %d", v.metricName, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	series, ok := v.seriesByLabel[key]
+	if !ok {
+		series = v.newSeries()
+		v.seriesByLabel[key] = series
+		v.labelValues[key] = slices.Clone(labelValues)
+	}
+	return series
+}
+
+// each calls fn with each of the receiver's series and its label values,
+// ordered by label values.
+func (v *vec[S]) each(fn func(labelValues []string, series S)) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.seriesByLabel))
+	for key := range v.seriesByLabel {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seriesList := make([]S, len(keys))
+	labelValuesList := make([][]string, len(keys))
+	for idx, key := range keys {
+		seriesList[idx] = v.seriesByLabel[key]
+		labelValuesList[idx] = v.labelValues[key]
+	}
+	v.mu.Unlock()
+	for idx, series := range seriesList {
+		fn(labelValuesList[idx], series)
+	}
+}
+
+// writeHeader writes the receiver's HELP and TYPE lines.
+func (v *vec[S]) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, metricType)
+}
+
+// CounterVec is a family of Counters distinguished by label values.
+type CounterVec struct {
+	vec[*Counter]
+}
+
+// With returns the Counter with the provided label values, which must
+// correspond to the CounterVec's label names.
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.with(labelValues)
+}
+
+func (cv *CounterVec) write(w *bufio.Writer) {
+	cv.writeHeader(w, "counter")
+	cv.each(func(labelValues []string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.metricName, formatLabels(cv.labelNames, labelValues), formatFloat(c.Value()))
+	})
+}
+
+// Counter is a monotonically increasing value.  Counter is safe for
+// concurrent use.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+// Inc increments the receiver by 1.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds the provided value, which must not be negative, to the receiver.
+func (c *Counter) Add(v float64) {
+	for {
+		old := c.bits.Load()
+		if c.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value returns the receiver's current value.
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+// HistogramVec is a family of Histograms distinguished by label values.
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+// With returns the Histogram with the provided label values, which must
+// correspond to the HistogramVec's label names.
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.with(labelValues)
+}
+
+func (hv *HistogramVec) write(w *bufio.Writer) {
+	hv.writeHeader(w, "histogram")
+	bucketLabelNames := append(slices.Clone(hv.labelNames), "le")
+	hv.each(func(labelValues []string, h *Histogram) {
+		h.mu.Lock()
+		counts := slices.Clone(h.counts)
+		count, sum := h.count, h.sum
+		h.mu.Unlock()
+		cumulative := uint64(0)
+		for idx, upperBound := range h.buckets {
+			cumulative += counts[idx]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metricName, formatLabels(bucketLabelNames, append(slices.Clone(labelValues), formatFloat(upperBound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", hv.metricName, formatLabels(bucketLabelNames, append(slices.Clone(labelValues), "+Inf")), count)
+		labels := formatLabels(hv.labelNames, labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", hv.metricName, labels, formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", hv.metricName, labels, count)
+	})
+}
+
+// Histogram counts observed values in buckets.  Histogram is safe for
+// concurrent use.
+type Histogram struct {
+	// Bucket upper bounds, in increasing order.
+	buckets []float64
+
+	mu sync.Mutex
+	// Non-cumulative observation counts, by bucket.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records the provided value in the receiver.
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.count++
+	h.sum += v
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// formatLabels returns the '{name="value",...}' label set for the provided
+// label names and values, or the empty string if there are no labels.
+func formatLabels(labelNames, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for idx, labelName := range labelNames {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, labelName, labelValueEscaper.Replace(labelValues[idx]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
@@ -0,0 +1,110 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests handled.", "path", "code")
+	requests.With("/GetData", "200").Inc()
+	requests.With("/GetData", "200").Add(2)
+	requests.With("/GetData", "504").Inc()
+	// Registering again yields the same CounterVec.
+	r.Counter("requests_total", "Requests handled.", "path", "code").With("/ListQueries", "200").Inc()
+	latency := r.Histogram("latency_seconds", "Request latency.\nIn seconds.", []float64{1, .1}, "query")
+	latency.With("trace").Observe(.05)
+	latency.With("trace").Observe(.5)
+	latency.With("trace").Observe(5)
+	latency.With(`a "quoted" \ query`).Observe(.1)
+	r.Counter("unlabeled_total", "An unlabeled counter.").With().Inc()
+
+	want := `# HELP latency_seconds Request latency.\nIn seconds.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{query="a \"quoted\" \\ query",le="0.1"} 1
+latency_seconds_bucket{query="a \"quoted\" \\ query",le="1"} 1
+latency_seconds_bucket{query="a \"quoted\" \\ query",le="+Inf"} 1
+latency_seconds_sum{query="a \"quoted\" \\ query"} 0.1
+latency_seconds_count{query="a \"quoted\" \\ query"} 1
+latency_seconds_bucket{query="trace",le="0.1"} 1
+latency_seconds_bucket{query="trace",le="1"} 2
+latency_seconds_bucket{query="trace",le="+Inf"} 3
+latency_seconds_sum{query="trace"} 5.55
+latency_seconds_count{query="trace"} 3
+# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{path="/GetData",code="200"} 3
+requests_total{path="/GetData",code="504"} 1
+requests_total{path="/ListQueries",code="200"} 1
+# HELP unlabeled_total An unlabeled counter.
+# TYPE unlabeled_total counter
+unlabeled_total 1
+`
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("WriteText() = %s, diff (-want +got) %s", sb.String(), diff)
+	}
+
+	rec := httptest.NewRecorder()
+	r.HTTPHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != TextContentType {
+		t.Errorf("Got Content-Type '%s', wanted '%s'", got, TextContentType)
+	}
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("HTTPHandler() served %s, diff (-want +got) %s", rec.Body.String(), diff)
+	}
+}
+
+func TestRegistrationConflicts(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		register    func(r *Registry)
+	}{{
+		description: "different kind",
+		register: func(r *Registry) {
+			r.Histogram("requests_total", "Requests handled.", DefaultLatencyBuckets, "path")
+		},
+	}, {
+		description: "different labels",
+		register: func(r *Registry) {
+			r.Counter("requests_total", "Requests handled.", "path", "code")
+		},
+	}, {
+		description: "wrong label value count",
+		register: func(r *Registry) {
+			r.Counter("requests_total", "Requests handled.", "path").With("/GetData", "200")
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			r := NewRegistry()
+			r.Counter("requests_total", "Requests handled.", "path")
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected a panic, but got none")
+				}
+			}()
+			test.register(r)
+		})
+	}
+}
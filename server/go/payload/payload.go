@@ -53,3 +53,9 @@ func New(parent Payloader, payloadType string) util.DataBuilder {
 		util.StringProperty(TypeKey, payloadType),
 	)
 }
+
+// DecodeType returns the type of the payload read by the provided
+// DatumReader, or an error if it is not a payload.
+func DecodeType(dr *util.DatumReader) (string, error) {
+	return dr.String(TypeKey)
+}
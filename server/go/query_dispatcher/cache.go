@@ -0,0 +1,318 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// cacheableDataSource is implemented by dataSources some of whose queries
+// may have their responses cached.
+type cacheableDataSource interface {
+	// CacheableQueries returns a mapping from each cacheable query name to the
+	// keys of the global filters its responses depend on.  A cacheable query's
+	// response must be fully determined by its options and those global
+	// filters.
+	CacheableQueries() map[string][]string
+}
+
+// Cache caches the responses to cacheable DataSeriesRequests.  A
+// DataSeriesRequest is cacheable if its dataSource implements
+// CacheableQueries and lists its query there.  Cached responses are keyed on
+// the request's query name and options, on the global filters its query
+// depends on, and on the requesting util.Principal, if any, so that a
+// response is never served to a principal other than the one it was computed
+// for.  Series that fail or are truncated are never cached.
+//
+// Cache is an LRU cache bounded by the total encoded size of its entries, and
+// entries may also expire.  Responses to requests that must be freshly
+// computed, such as Subscribe's updates after a change, are never served
+// from the Cache, but do replace its entries.  Cache is safe for concurrent
+// use.
+type Cache struct {
+	maxBytes int
+	ttl      time.Duration
+	// Returns the current time.  Replaceable for testing.
+	now func() time.Time
+
+	mu sync.Mutex
+	// The cache entries, most recently used at the front.
+	lru       *list.List
+	entryByID map[string]*list.Element
+	bytes     int
+}
+
+// cacheEntry is a single cached DataSeries.
+type cacheEntry struct {
+	id string
+	// The canonical encodings of the global filters this entry depends on,
+	// by key.
+	globalFilters map[string]string
+	root          *util.Datum
+	stringTable   []string
+	bytes         int
+	expires       time.Time
+}
+
+// NewCache returns a new, empty Cache holding up to maxBytes bytes of encoded
+// responses.  If ttl is positive, entries expire after ttl.
+func NewCache(maxBytes int, ttl time.Duration) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		ttl:       ttl,
+		now:       time.Now,
+		lru:       list.New(),
+		entryByID: map[string]*list.Element{},
+	}
+}
+
+// WithCache configures a QueryDispatcher to serve cacheable
+// DataSeriesRequests from the provided Cache.  The Cache acts as an
+// Interceptor, placed in the interceptor chain in the order this Option
+// appears among other Options; a dataSource invocation satisfied entirely
+// from the Cache does not proceed further along the chain.
+func WithCache(cache *Cache) Option {
+	return WithInterceptors(cache.intercept)
+}
+
+// canonicalValue returns a canonical encoding of the provided value.
+func canonicalValue(v *util.V) (string, error) {
+	// Values' JSON encodings are deterministic; maps are encoded with sorted
+	// keys.
+	ret, err := json.Marshal(v)
+	return string(ret), err
+}
+
+// cacheKey returns the ID of the cache entry for the provided request to the
+// specified dataSource on behalf of the provided Principal, which may be nil,
+// and the canonical encodings of the global filters it depends on.
+func cacheKey(dataSource string, principal *util.Principal, req *util.DataSeriesRequest, globalFilters map[string]*util.V, deps []string) (id string, depFilters map[string]string, err error) {
+	depFilters = map[string]string{}
+	for _, dep := range deps {
+		v, ok := globalFilters[dep]
+		if !ok {
+			continue
+		}
+		if depFilters[dep], err = canonicalValue(v); err != nil {
+			return "", nil, err
+		}
+	}
+	key, err := json.Marshal(struct {
+		DataSource    string
+		Principal     *util.Principal
+		QueryName     string
+		Options       map[string]*util.V
+		GlobalFilters map[string]string
+	}{dataSource, principal, req.QueryName, req.Options, depFilters})
+	if err != nil {
+		return "", nil, err
+	}
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:]), depFilters, nil
+}
+
+// removeLocked removes the provided element from the receiver.  c.mu must be
+// held.
+func (c *Cache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entryByID, entry.id)
+	c.bytes -= entry.bytes
+}
+
+// get returns the unexpired entry with the specified ID, or nil if there is
+// none.
+func (c *Cache) get(id string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entryByID[id]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && !c.now().Before(entry.expires) {
+		c.removeLocked(elem)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// put adds the provided DataSeries root, whose strings are in the provided
+// string table, to the receiver with the specified ID, evicting the least
+// recently used entries as needed to stay within the receiver's byte budget.
+func (c *Cache) put(id string, globalFilters map[string]string, root *util.Datum, stringTable []string) error {
+	// Copy the series into its own response, so that its string table holds
+	// only its own strings, and measure its encoded size.
+	drb := util.NewDataResponseBuilder()
+	if err := util.Replay(drb.DataSeries(&util.DataSeriesRequest{}), root, stringTable); err != nil {
+		return err
+	}
+	data, err := drb.Data()
+	if err != nil {
+		return err
+	}
+	encoded, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	entry := &cacheEntry{
+		id:            id,
+		globalFilters: globalFilters,
+		root:          data.DataSeries[0].Root,
+		stringTable:   data.StringTable,
+		bytes:         len(encoded),
+	}
+	if entry.bytes > c.maxBytes {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl > 0 {
+		entry.expires = c.now().Add(c.ttl)
+	}
+	if elem, ok := c.entryByID[id]; ok {
+		c.removeLocked(elem)
+	}
+	for c.bytes+entry.bytes > c.maxBytes {
+		c.removeLocked(c.lru.Back())
+	}
+	c.entryByID[id] = c.lru.PushFront(entry)
+	c.bytes += entry.bytes
+	return nil
+}
+
+// Invalidate removes all entries depending on the specified global filter
+// having the provided value; for instance, all entries for a collection that
+// has been reloaded.
+func (c *Cache) Invalidate(globalFilterKey string, value *util.V) error {
+	canonical, err := canonicalValue(value)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for elem := c.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if dep, ok := elem.Value.(*cacheEntry).globalFilters[globalFilterKey]; ok && dep == canonical {
+			c.removeLocked(elem)
+		}
+		elem = next
+	}
+	return nil
+}
+
+// Purge removes all entries from the receiver.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entryByID = map[string]*list.Element{}
+	c.bytes = 0
+}
+
+// Bytes returns the total encoded size of the receiver's entries.
+func (c *Cache) Bytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// pendingEntry is a cacheable DataSeriesRequest missing from the cache.
+type pendingEntry struct {
+	id            string
+	globalFilters map[string]string
+}
+
+// intercept is an Interceptor serving cacheable requests in the provided
+// Invocation from the receiver.  The remaining requests are passed along the
+// chain, and if any of them are cacheable, their responses are recorded,
+// added to the receiver, and then replayed into drb.
+func (c *Cache) intercept(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+	cds, ok := inv.ds.(cacheableDataSource)
+	if !ok {
+		return next(ctx, inv, drb)
+	}
+	cacheable := cds.CacheableQueries()
+	principal := util.PrincipalFrom(ctx)
+	fresh := freshResultsRequested(ctx)
+	hits := map[*util.DataSeriesRequest]*cacheEntry{}
+	pending := map[*util.DataSeriesRequest]*pendingEntry{}
+	var remaining []*util.DataSeriesRequest
+	for _, req := range inv.SeriesRequests {
+		deps, ok := cacheable[req.QueryName]
+		if !ok {
+			remaining = append(remaining, req)
+			continue
+		}
+		id, globalFilters, err := cacheKey(inv.DataSource, principal, req, inv.GlobalFilters, deps)
+		if err != nil {
+			return err
+		}
+		if entry := c.get(id); entry != nil && !fresh {
+			hits[req] = entry
+			continue
+		}
+		pending[req] = &pendingEntry{id, globalFilters}
+		remaining = append(remaining, req)
+	}
+	if len(remaining) > 0 {
+		remainingInv := *inv
+		remainingInv.SeriesRequests = remaining
+		if len(pending) == 0 {
+			if err := next(ctx, &remainingInv, drb); err != nil {
+				return err
+			}
+		} else if err := c.record(ctx, &remainingInv, drb, pending, next); err != nil {
+			return err
+		}
+	}
+	for req, entry := range hits {
+		if err := util.Replay(drb.DataSeries(req), entry.root, entry.stringTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record handles the provided Invocation, adds the responses to its pending
+// requests to the receiver, and replays all its responses into drb.
+func (c *Cache) record(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, pending map[*util.DataSeriesRequest]*pendingEntry, next Handler) error {
+	rec, err := record(ctx, inv, next)
+	if err != nil {
+		return err
+	}
+	for _, req := range inv.SeriesRequests {
+		series, ok := rec.series[req]
+		if !ok {
+			continue
+		}
+		if pe, ok := pending[req]; ok && series.Error == nil && series.Truncated == nil {
+			if err := c.put(pe.id, pe.globalFilters, series.Root, rec.stringTable); err != nil {
+				return err
+			}
+		}
+		if err := replaySeries(drb, req, series, rec.stringTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
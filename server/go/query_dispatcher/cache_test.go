@@ -0,0 +1,538 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// echoDataSource handles 'Echo' queries, which are cacheable and depend
+// on the 'collection' global filter, and uncacheable 'Uncached' queries.
+// Each responds with its 'collection' global filter and 'value' option, or
+// fails its series if 'value' is 'fail'.  It counts the series it handles.
+type echoDataSource struct {
+	handled int
+}
+
+func (eds *echoDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Echo", "Uncached"}
+}
+
+func (eds *echoDataSource) CacheableQueries() map[string][]string {
+	return map[string][]string{
+		"Echo": {"collection"},
+	}
+}
+
+func (eds *echoDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	collection, err := util.ExpectStringValue(globalState["collection"])
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		eds.handled++
+		value, err := util.ExpectStringValue(req.Options["value"])
+		if err != nil {
+			return err
+		}
+		if value == "fail" {
+			drb.DataSeries(req).With(util.ErrorProperty(errors.New("failed")))
+			continue
+		}
+		drb.DataSeries(req).With(
+			util.StringProperty("collection", collection),
+			util.StringProperty("value", value),
+		).Child().With(
+			util.IntegerProperty("count", int64(len(value))),
+		)
+	}
+	return nil
+}
+
+func sortSeries(dataSeries []*util.DataSeries) {
+	sort.Slice(dataSeries, func(a, b int) bool {
+		return dataSeries[a].SeriesName < dataSeries[b].SeriesName
+	})
+}
+
+func TestCache(t *testing.T) {
+	eds := &echoDataSource{}
+	now := time.Unix(0, 0)
+	cache := NewCache(1<<20, time.Minute)
+	cache.now = func() time.Time { return now }
+	qd, err := NewWithOptions([]Option{WithCache(cache)}, eds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	request := func(collection, unrelated string, reqs ...*util.DataSeriesRequest) *util.DataRequest {
+		return &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection": util.StringValue(collection),
+				"unrelated":  util.StringValue(unrelated),
+			},
+			SeriesRequests:      reqs,
+			AllowPartialResults: true,
+		}
+	}
+	series := func(queryName, seriesName, value string) *util.DataSeriesRequest {
+		return &util.DataSeriesRequest{
+			QueryName:  queryName,
+			SeriesName: seriesName,
+			Options: map[string]*util.V{
+				"value": util.StringValue(value),
+			},
+		}
+	}
+	for _, test := range []struct {
+		description string
+		advance     time.Duration
+		invalidate  string
+		req         *util.DataRequest
+		wantHandled int
+		want        string
+	}{{
+		description: "cold cache",
+		req:         request("a", "x", series("Echo", "1", "v"), series("Uncached", "2", "v")),
+		wantHandled: 2,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1
+  Series 2
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}, {
+		description: "cached query hits, ignoring unrelated filters and series names",
+		req:         request("a", "y", series("Echo", "3", "v"), series("Uncached", "2", "v")),
+		wantHandled: 1,
+		want: `Data:
+  Series 2
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1
+  Series 3
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}, {
+		description: "different options miss",
+		req:         request("a", "x", series("Echo", "1", "vv")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'vv'
+      Child:
+        Prop 'count': 2`,
+	}, {
+		description: "different dependency filters miss",
+		req:         request("b", "x", series("Echo", "1", "v")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'b'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}, {
+		description: "entirely cached request",
+		req:         request("b", "x", series("Echo", "1", "v")),
+		wantHandled: 0,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'b'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}, {
+		description: "invalidated entries miss",
+		invalidate:  "b",
+		req:         request("b", "x", series("Echo", "1", "v")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'b'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}, {
+		description: "other entries survive invalidation",
+		req:         request("a", "x", series("Echo", "1", "vv")),
+		wantHandled: 0,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'vv'
+      Child:
+        Prop 'count': 2`,
+	}, {
+		description: "failed series are not cached",
+		req:         request("a", "x", series("Echo", "1", "fail")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Error in query 'Echo' (data source ''): failed
+    Root:
+`,
+	}, {
+		description: "failed series are not cached (again)",
+		req:         request("a", "x", series("Echo", "1", "fail")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Error in query 'Echo' (data source ''): failed
+    Root:
+`,
+	}, {
+		description: "expired entries miss",
+		advance:     time.Minute,
+		req:         request("a", "x", series("Echo", "1", "v")),
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			now = now.Add(test.advance)
+			if test.invalidate != "" {
+				if err := cache.Invalidate("collection", util.StringValue(test.invalidate)); err != nil {
+					t.Fatalf("Invalidate() yielded unexpected error %s", err)
+				}
+			}
+			eds.handled = 0
+			data, err := qd.HandleDataRequest(context.Background(), test.req)
+			if err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if eds.handled != test.wantHandled {
+				t.Errorf("Data source handled %d series, want %d", eds.handled, test.wantHandled)
+			}
+			sortSeries(data.DataSeries)
+			if diff := cmp.Diff(test.want, data.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+func TestCacheSeriesSharingNames(t *testing.T) {
+	eds := &echoDataSource{}
+	qd, err := NewWithOptions([]Option{WithCache(NewCache(1<<20, 0))}, eds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	series := func(value string) *util.DataSeriesRequest {
+		return &util.DataSeriesRequest{
+			QueryName:  "Echo",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"value": util.StringValue(value),
+			},
+		}
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			"collection": util.StringValue("a"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{series("v"), series("vv")},
+	}
+	want := []string{`Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'v'
+      Child:
+        Prop 'count': 1`, `Data:
+  Series 1
+    Root:
+      Prop 'collection': 'a'
+      Prop 'value': 'vv'
+      Child:
+        Prop 'count': 2`}
+	// The first request is recorded into the cache, and the second served from
+	// it.
+	for _, wantHandled := range []int{2, 0} {
+		eds.handled = 0
+		data, err := qd.HandleDataRequest(context.Background(), req)
+		if err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+		if eds.handled != wantHandled {
+			t.Errorf("Data source handled %d series, want %d", eds.handled, wantHandled)
+		}
+		var got []string
+		for _, series := range data.DataSeries {
+			got = append(got, (&util.Data{
+				DataSeries:  []*util.DataSeries{series},
+				StringTable: data.StringTable,
+			}).PrettyPrint())
+		}
+		sort.Strings(got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Got series %v, diff (-want +got):\n%s", got, diff)
+		}
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	eds := &echoDataSource{}
+	cache := NewCache(1<<20, 0)
+	qd, err := NewWithOptions([]Option{WithCache(cache)}, eds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	fetch := func(value string) {
+		t.Helper()
+		if _, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection": util.StringValue("a"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName:  "Echo",
+				SeriesName: "1",
+				Options: map[string]*util.V{
+					"value": util.StringValue(value),
+				},
+			}},
+		}); err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+	}
+	fetch("x")
+	entryBytes := cache.Bytes()
+	// Shrink the cache to hold just two (equally-sized) entries.
+	cache.maxBytes = 2 * entryBytes
+	fetch("y")
+	fetch("x") // 'x' is now most recently used...
+	fetch("z") // ...so 'y' is evicted.
+	if cache.Bytes() > cache.maxBytes {
+		t.Errorf("Cache holds %d bytes, exceeding its budget of %d", cache.Bytes(), cache.maxBytes)
+	}
+	eds.handled = 0
+	for _, value := range []string{"x", "z", "y"} {
+		fetch(value)
+	}
+	if eds.handled != 1 {
+		t.Errorf("Data source handled %d series, want 1 (only the evicted one)", eds.handled)
+	}
+	cache.Purge()
+	if cache.Bytes() != 0 {
+		t.Errorf("Purged cache holds %d bytes, want 0", cache.Bytes())
+	}
+}
+
+// wideDataSource handles cacheable 'Wide' queries, each responding with as
+// many children as its 'children' option.  It counts the series it handles.
+type wideDataSource struct {
+	handled int
+}
+
+func (wds *wideDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Wide"}
+}
+
+func (wds *wideDataSource) CacheableQueries() map[string][]string {
+	return map[string][]string{
+		"Wide": nil,
+	}
+}
+
+func (wds *wideDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		wds.handled++
+		children, err := util.ExpectIntegerValue(req.Options["children"])
+		if err != nil {
+			return err
+		}
+		series := drb.DataSeries(req)
+		for idx := int64(0); idx < children; idx++ {
+			series.Child().With(util.IntegerProperty("idx", idx))
+		}
+	}
+	return nil
+}
+
+func TestCacheWithResponseLimits(t *testing.T) {
+	wds := &wideDataSource{}
+	qd, err := NewWithOptions([]Option{
+		WithResponseLimits(util.ResponseLimits{
+			MaxSeriesDatums: 2,
+		}),
+		WithCache(NewCache(1<<20, 0)),
+	}, wds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		children    int64
+		wantHandled int
+		want        string
+	}{{
+		description: "series within limits",
+		children:    2,
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Root:
+      Child:
+        Prop 'idx': 0
+      Child:
+        Prop 'idx': 1`,
+	}, {
+		description: "series within limits are cached",
+		children:    2,
+		wantHandled: 0,
+		want: `Data:
+  Series 1
+    Root:
+      Child:
+        Prop 'idx': 0
+      Child:
+        Prop 'idx': 1`,
+	}, {
+		// The data source's own response is truncated, and the truncation is
+		// reported to the client.
+		description: "truncated series",
+		children:    5,
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Truncated at MaxSeriesDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'idx': 0
+      Child:
+        Prop 'idx': 1`,
+	}, {
+		description: "truncated series are not cached",
+		children:    5,
+		wantHandled: 1,
+		want: `Data:
+  Series 1
+    Truncated at MaxSeriesDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'idx': 0
+      Child:
+        Prop 'idx': 1`,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			wds.handled = 0
+			data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  "Wide",
+					SeriesName: "1",
+					Options: map[string]*util.V{
+						"children": util.IntegerValue(test.children),
+					},
+				}},
+			})
+			if err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if wds.handled != test.wantHandled {
+				t.Errorf("Data source handled %d series, want %d", wds.handled, test.wantHandled)
+			}
+			if diff := cmp.Diff(test.want, data.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got):\n%s", data.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+func TestCachePrincipals(t *testing.T) {
+	eds := &echoDataSource{}
+	qd, err := NewWithOptions([]Option{WithCache(NewCache(1<<20, 0))}, eds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	alice := &util.Principal{Name: "alice", Scheme: "Bearer"}
+	bob := &util.Principal{Name: "bob", Scheme: "Bearer"}
+	for _, test := range []struct {
+		description string
+		principal   *util.Principal
+		wantHandled int
+	}{{
+		description: "first principal misses",
+		principal:   alice,
+		wantHandled: 1,
+	}, {
+		description: "first principal hits",
+		principal:   alice,
+		wantHandled: 0,
+	}, {
+		description: "second principal misses",
+		principal:   bob,
+		wantHandled: 1,
+	}, {
+		description: "unauthenticated request misses",
+		wantHandled: 1,
+	}, {
+		description: "second principal hits",
+		principal:   bob,
+		wantHandled: 0,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+			if test.principal != nil {
+				ctx = util.WithPrincipal(ctx, test.principal)
+			}
+			eds.handled = 0
+			if _, err := qd.HandleDataRequest(ctx, &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					"collection": util.StringValue("a"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  "Echo",
+					SeriesName: "1",
+					Options: map[string]*util.V{
+						"value": util.StringValue("v"),
+					},
+				}},
+			}); err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if eds.handled != test.wantHandled {
+				t.Errorf("Data source handled %d series, want %d", eds.handled, test.wantHandled)
+			}
+		})
+	}
+}
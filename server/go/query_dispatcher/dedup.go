@@ -0,0 +1,207 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// seriesFlight is an in-flight computation of a single DataSeries, whose
+// result may be shared by several identical DataSeriesRequests.
+type seriesFlight struct {
+	batch *flightBatch
+	done  chan struct{}
+	// The following are set before done is closed.  If err is nil and series
+	// is nil, the dataSource produced no series for the request.
+	series      *util.DataSeries
+	stringTable []string
+	err         error
+}
+
+// flightBatch is a single dataSource invocation computing one or more
+// seriesFlights.
+type flightBatch struct {
+	cancel context.CancelFunc
+	// The requests computed by this batch, and their flights and keys.
+	reqs    []*util.DataSeriesRequest
+	flights []*seriesFlight
+	keys    []string
+	// The number of invocations waiting on any of this batch's flights.
+	// Guarded by the deduplicator's mu.
+	waiters int
+}
+
+// deduplicator coalesces identical, concurrently in-flight
+// DataSeriesRequests.
+type deduplicator struct {
+	mu sync.Mutex
+	// In-flight series, by key.
+	flights map[string]*seriesFlight
+}
+
+func newDeduplicator() *deduplicator {
+	return &deduplicator{
+		flights: map[string]*seriesFlight{},
+	}
+}
+
+// WithDeduplication configures a QueryDispatcher to coalesce identical
+// DataSeriesRequests that are in flight at the same time, whether in the
+// same DataRequest or in different ones, so that a single computation feeds
+// all of them.  DataSeriesRequests are identical if they have the same query
+// and options, and are made with the same global filters on behalf of the
+// same util.Principal, if any.  A shared
+// computation is cancelled only once every request waiting on it has been
+// cancelled.  Requests that must be freshly computed, such as Subscribe's
+// updates after a change, never join a computation already in flight, though
+// later requests may join theirs.
+//
+// Deduplication acts as an Interceptor, placed in the interceptor chain in
+// the order this Option appears among other Options.
+func WithDeduplication() Option {
+	return WithInterceptors(newDeduplicator().intercept)
+}
+
+// seriesKey returns the key identifying the provided request within the
+// provided Invocation, made on behalf of the provided Principal, which may be
+// nil.
+func seriesKey(principal *util.Principal, inv *Invocation, req *util.DataSeriesRequest) (string, error) {
+	keys := make([]string, 0, len(inv.GlobalFilters))
+	for key := range inv.GlobalFilters {
+		keys = append(keys, key)
+	}
+	id, _, err := cacheKey(inv.DataSource, principal, req, inv.GlobalFilters, keys)
+	return id, err
+}
+
+// intercept is an Interceptor joining each request in the provided Invocation
+// to an identical in-flight request, if there is one, and otherwise
+// computing it in a new flightBatch.  Once all requests are complete, their
+// responses are replayed into drb.
+func (d *deduplicator) intercept(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+	principal := util.PrincipalFrom(ctx)
+	fresh := freshResultsRequested(ctx)
+	keys := make([]string, len(inv.SeriesRequests))
+	for idx, req := range inv.SeriesRequests {
+		var err error
+		if keys[idx], err = seriesKey(principal, inv, req); err != nil {
+			return err
+		}
+	}
+	flights := make([]*seriesFlight, len(inv.SeriesRequests))
+	batches := map[*flightBatch]struct{}{}
+	var newBatch *flightBatch
+	d.mu.Lock()
+	for idx, req := range inv.SeriesRequests {
+		f, ok := d.flights[keys[idx]]
+		if !ok || (fresh && f.batch != newBatch) {
+			if newBatch == nil {
+				newBatch = &flightBatch{}
+			}
+			f = &seriesFlight{
+				batch: newBatch,
+				done:  make(chan struct{}),
+			}
+			d.flights[keys[idx]] = f
+			newBatch.reqs = append(newBatch.reqs, req)
+			newBatch.flights = append(newBatch.flights, f)
+			newBatch.keys = append(newBatch.keys, keys[idx])
+		}
+		flights[idx] = f
+		if _, ok := batches[f.batch]; !ok {
+			batches[f.batch] = struct{}{}
+			f.batch.waiters++
+		}
+	}
+	if newBatch != nil {
+		var batchCtx context.Context
+		batchCtx, newBatch.cancel = context.WithCancel(context.WithoutCancel(ctx))
+		batchInv := *inv
+		batchInv.SeriesRequests = newBatch.reqs
+		go d.run(batchCtx, newBatch, &batchInv, next)
+	}
+	d.mu.Unlock()
+	for _, f := range flights {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			d.leave(batches)
+			return ctx.Err()
+		}
+	}
+	d.leave(batches)
+	for idx, req := range inv.SeriesRequests {
+		f := flights[idx]
+		if f.err != nil {
+			return f.err
+		}
+		if f.series == nil {
+			continue
+		}
+		if err := replaySeries(drb, req, f.series, f.stringTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run computes the provided batch's flights with the provided Invocation,
+// whose requests are the batch's, and publishes their results.
+func (d *deduplicator) run(ctx context.Context, batch *flightBatch, inv *Invocation, next Handler) {
+	defer batch.cancel()
+	rec, err := record(ctx, inv, next)
+	d.mu.Lock()
+	d.removeLocked(batch)
+	d.mu.Unlock()
+	for idx, req := range batch.reqs {
+		f := batch.flights[idx]
+		if err != nil {
+			f.err = err
+		} else {
+			f.series = rec.series[req]
+			f.stringTable = rec.stringTable
+		}
+		close(f.done)
+	}
+}
+
+// leave records that an invocation is no longer waiting on the provided
+// batches, cancelling any that no invocation is waiting on.
+func (d *deduplicator) leave(batches map[*flightBatch]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for batch := range batches {
+		batch.waiters--
+		if batch.waiters > 0 {
+			continue
+		}
+		batch.cancel()
+		// The cancelled batch may not complete immediately; don't let new
+		// requests join it.
+		d.removeLocked(batch)
+	}
+}
+
+// removeLocked removes the provided batch's flights from the receiver, so
+// that no new requests join them.  d.mu must be held.
+func (d *deduplicator) removeLocked(batch *flightBatch) {
+	for idx, key := range batch.keys {
+		if d.flights[key] == batch.flights[idx] {
+			delete(d.flights, key)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// blockingDataSource handles 'Block' queries, responding with their 'value'
+// option once released.  It reports the Context of each invocation on
+// started, and counts the series it handles.
+type blockingDataSource struct {
+	started chan context.Context
+	release chan struct{}
+	// If true, invocations wait to be released even if cancelled.
+	ignoreCancellation bool
+	mu                 sync.Mutex
+	handled            map[string]int
+}
+
+func newBlockingDataSource() *blockingDataSource {
+	return &blockingDataSource{
+		started: make(chan context.Context, 10),
+		release: make(chan struct{}),
+		handled: map[string]int{},
+	}
+}
+
+func (bds *blockingDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Block"}
+}
+
+func (bds *blockingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	bds.started <- ctx
+	if bds.ignoreCancellation {
+		<-bds.release
+	} else {
+		select {
+		case <-bds.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, req := range reqs {
+		value, err := util.ExpectStringValue(req.Options["value"])
+		if err != nil {
+			return err
+		}
+		bds.mu.Lock()
+		bds.handled[value]++
+		bds.mu.Unlock()
+		drb.DataSeries(req).With(util.StringProperty("value", value))
+	}
+	return nil
+}
+
+func blockRequest(seriesValues ...string) *util.DataRequest {
+	ret := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{},
+	}
+	for idx := 0; idx < len(seriesValues); idx += 2 {
+		ret.SeriesRequests = append(ret.SeriesRequests, &util.DataSeriesRequest{
+			QueryName:  "Block",
+			SeriesName: seriesValues[idx],
+			Options: map[string]*util.V{
+				"value": util.StringValue(seriesValues[idx+1]),
+			},
+		})
+	}
+	return ret
+}
+
+func TestDeduplication(t *testing.T) {
+	bds := newBlockingDataSource()
+	qd, err := NewWithOptions([]Option{WithDeduplication()}, bds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	type result struct {
+		data *util.Data
+		err  error
+	}
+	results := make(chan result, 2)
+	handle := func(req *util.DataRequest) {
+		data, err := qd.HandleDataRequest(context.Background(), req)
+		results <- result{data, err}
+	}
+	// The first request's series are both computed in one invocation, with
+	// its duplicate series sharing the computation of the first...
+	go handle(blockRequest("1", "a", "2", "b", "3", "a"))
+	<-bds.started
+	// ...and the second request only invokes the data source for its novel
+	// series.
+	go handle(blockRequest("1", "b", "2", "c"))
+	<-bds.started
+	close(bds.release)
+	want := []string{`Data:
+  Series 1
+    Root:
+      Prop 'value': 'a'
+  Series 2
+    Root:
+      Prop 'value': 'b'
+  Series 3
+    Root:
+      Prop 'value': 'a'`, `Data:
+  Series 1
+    Root:
+      Prop 'value': 'b'
+  Series 2
+    Root:
+      Prop 'value': 'c'`}
+	var got []string
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", res.err)
+		}
+		sortSeries(res.data.DataSeries)
+		got = append(got, res.data.PrettyPrint())
+	}
+	if got[0] > got[1] {
+		got[0], got[1] = got[1], got[0]
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got data %v, diff (-want +got):\n%s", got, diff)
+	}
+	if diff := cmp.Diff(map[string]int{"a": 1, "b": 1, "c": 1}, bds.handled); diff != "" {
+		t.Errorf("Data source handled series %v, diff (-want +got):\n%s", bds.handled, diff)
+	}
+}
+
+func TestDeduplicationCancellation(t *testing.T) {
+	bds := newBlockingDataSource()
+	dedup := newDeduplicator()
+	qd, err := NewWithOptions([]Option{WithInterceptors(dedup.intercept)}, bds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	errs := make(chan error, 2)
+	go func() {
+		_, err := qd.HandleDataRequest(ctx1, blockRequest("1", "a"))
+		errs <- err
+	}()
+	dsCtx := <-bds.started
+	go func() {
+		_, err := qd.HandleDataRequest(ctx2, blockRequest("1", "a"))
+		errs <- err
+	}()
+	// Wait for the second request to join the first's computation.
+	for joined := false; !joined; {
+		dedup.mu.Lock()
+		for _, f := range dedup.flights {
+			joined = f.batch.waiters == 2
+		}
+		dedup.mu.Unlock()
+	}
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled HandleDataRequest() yielded error %v, want %v", err, context.Canceled)
+	}
+	if dsCtx.Err() != nil {
+		t.Fatalf("Shared computation was cancelled with requests still waiting")
+	}
+	cancel2()
+	<-errs
+	<-dsCtx.Done()
+}
+
+func TestDeduplicationPrincipals(t *testing.T) {
+	bds := newBlockingDataSource()
+	qd, err := NewWithOptions([]Option{WithDeduplication()}, bds)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	errs := make(chan error, 2)
+	for _, name := range []string{"alice", "bob"} {
+		ctx := util.WithPrincipal(context.Background(), &util.Principal{Name: name, Scheme: "Bearer"})
+		go func() {
+			_, err := qd.HandleDataRequest(ctx, blockRequest("1", "a"))
+			errs <- err
+		}()
+	}
+	// Identical requests from different principals are computed separately.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-bds.started:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Data source was invoked %d times, want 2", i)
+		}
+	}
+	close(bds.release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+	}
+	if diff := cmp.Diff(map[string]int{"a": 2}, bds.handled); diff != "" {
+		t.Errorf("Data source handled series %v, diff (-want +got):\n%s", bds.handled, diff)
+	}
+}
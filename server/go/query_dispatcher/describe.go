@@ -0,0 +1,79 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// describedDataSource is a dataSource that describes its queries.
+type describedDataSource interface {
+	dataSource
+	// DescribeQueries returns descriptions of some or all of the queries in
+	// SupportedDataSeriesQueries.  Descriptions of unsupported queries are
+	// ignored.
+	DescribeQueries() []*util.QueryDescription
+}
+
+// DataSourceDescription describes the queries supported by a single
+// dataSource.
+type DataSourceDescription struct {
+	// The dataSource's name, as reported in errors.
+	Name string
+	// The dataSource's queries, in the order it lists them.
+	Queries []*util.QueryDescription
+}
+
+// ListQueries returns a catalog of the queries supported by each of the
+// receiver's dataSources.  Queries whose dataSources don't describe them are
+// listed by name only, though if they are cacheable, the global filters they
+// depend on are listed too.
+func (qd *QueryDispatcher) ListQueries() []*DataSourceDescription {
+	qd.mu.RLock()
+	dss := make([]dataSource, len(qd.registrations))
+	for idx, reg := range qd.registrations {
+		dss[idx] = reg.ds
+	}
+	qd.mu.RUnlock()
+	ret := make([]*DataSourceDescription, 0, len(dss))
+	for _, ds := range dss {
+		descriptionsByName := map[string]*util.QueryDescription{}
+		if dds, ok := ds.(describedDataSource); ok {
+			for _, desc := range dds.DescribeQueries() {
+				descriptionsByName[desc.Name] = desc
+			}
+		}
+		var cacheable map[string][]string
+		if cds, ok := ds.(cacheableDataSource); ok {
+			cacheable = cds.CacheableQueries()
+		}
+		dsDesc := &DataSourceDescription{
+			Name: dataSourceName(ds),
+		}
+		for _, queryName := range ds.SupportedDataSeriesQueries() {
+			desc := &util.QueryDescription{
+				Name: queryName,
+			}
+			if described, ok := descriptionsByName[queryName]; ok {
+				*desc = *described
+			}
+			if deps, ok := cacheable[queryName]; ok && desc.GlobalFilters == nil {
+				desc.GlobalFilters = deps
+			}
+			dsDesc.Queries = append(dsDesc.Queries, desc)
+		}
+		ret = append(ret, dsDesc)
+	}
+	return ret
+}
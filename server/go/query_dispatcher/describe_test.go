@@ -0,0 +1,103 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// describedEchoDataSource is an echoDataSource describing its 'Echo' query, and
+// a query it doesn't support.
+type describedEchoDataSource struct {
+	echoDataSource
+}
+
+func (deds *describedEchoDataSource) DescribeQueries() []*util.QueryDescription {
+	return []*util.QueryDescription{{
+		Name:        "Echo",
+		Description: "Echoes its value.",
+		Options: []*util.OptionDescription{{
+			Key:      "value",
+			Type:     "string",
+			Required: true,
+		}},
+	}, {
+		Name: "Unsupported",
+	}}
+}
+
+func TestListQueries(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		dataSources []dataSource
+		want        []*DataSourceDescription
+	}{{
+		description: "undescribed",
+		dataSources: []dataSource{&countingDataSource{}},
+		want: []*DataSourceDescription{{
+			Name: "*querydispatcher.countingDataSource",
+			Queries: []*util.QueryDescription{{
+				Name: "Count",
+			}},
+		}},
+	}, {
+		description: "cacheable",
+		dataSources: []dataSource{&echoDataSource{}},
+		want: []*DataSourceDescription{{
+			Name: "*querydispatcher.echoDataSource",
+			Queries: []*util.QueryDescription{{
+				Name:          "Echo",
+				GlobalFilters: []string{"collection"},
+			}, {
+				Name: "Uncached",
+			}},
+		}},
+	}, {
+		description: "described",
+		dataSources: []dataSource{&describedEchoDataSource{}, &countingDataSource{}},
+		want: []*DataSourceDescription{{
+			Name: "*querydispatcher.describedEchoDataSource",
+			Queries: []*util.QueryDescription{{
+				Name:        "Echo",
+				Description: "Echoes its value.",
+				Options: []*util.OptionDescription{{
+					Key:      "value",
+					Type:     "string",
+					Required: true,
+				}},
+				GlobalFilters: []string{"collection"},
+			}, {
+				Name: "Uncached",
+			}},
+		}, {
+			Name: "*querydispatcher.countingDataSource",
+			Queries: []*util.QueryDescription{{
+				Name: "Count",
+			}},
+		}},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			qd, err := New(test.dataSources...)
+			if err != nil {
+				t.Fatalf("New() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, qd.ListQueries()); diff != "" {
+				t.Errorf("ListQueries() = diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
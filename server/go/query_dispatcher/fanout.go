@@ -0,0 +1,114 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+	"golang.org/x/sync/errgroup"
+)
+
+// Merger populates the provided DataBuilder with the merger of the provided
+// DataSeries roots, each responding to the same DataSeriesRequest from a
+// different dataSource, and each with its strings in the corresponding string
+// table.  Roots are provided in dataSource registration order.  trace.Union is
+// a Merger for traces.
+type Merger func(db util.DataBuilder, roots []*util.Datum, stringTables [][]string) error
+
+// WithFanOut configures a QueryDispatcher to fan the provided query out to
+// every registered dataSource supporting it, rather than permitting only one
+// dataSource to support it, and to merge their responses with the provided
+// Merger.  Each DataSeriesRequest for a fan-out query is dispatched in its own
+// Invocation of each dataSource.  If any of these fails, or if the Merger
+// fails, the DataSeries fails as if a single dataSource had failed.
+func WithFanOut(queryName string, merge Merger) Option {
+	return func(qd *QueryDispatcher) {
+		qd.fanOutMergers[queryName] = merge
+	}
+}
+
+// fanOut is a DataSeriesRequest for a fan-out query, and the registrations of
+// the dataSources it is dispatched to.
+type fanOut struct {
+	req   *util.DataSeriesRequest
+	regs  []*registration
+	merge Merger
+}
+
+// done marks done the in-flight invocations of the receiver's dataSources.
+func (fo *fanOut) done() {
+	for _, reg := range fo.regs {
+		reg.inflight.Done()
+	}
+}
+
+// handleFanOut dispatches the provided fan-out DataSeriesRequest to each of
+// its dataSources, then merges their responses into drb.
+func (qd *QueryDispatcher) handleFanOut(ctx context.Context, fo *fanOut, globalFilters map[string]*util.V, drb *util.DataResponseBuilder) error {
+	recs := make([]*recording, len(fo.regs))
+	errg, errgCtx := errgroup.WithContext(ctx)
+	for idx, reg := range fo.regs {
+		errg.Go(func() error {
+			inv := qd.newInvocation(reg, globalFilters, []*util.DataSeriesRequest{fo.req})
+			rec, err := record(errgCtx, inv, qd.handle)
+			if err != nil {
+				return dataSourceError(inv, err)
+			}
+			if series, ok := rec.series[fo.req]; ok && series.Error != nil {
+				return dataSourceError(inv, errors.New(series.Error.Message))
+			}
+			recs[idx] = rec
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return err
+	}
+	var roots []*util.Datum
+	var stringTables [][]string
+	var truncations []*util.Truncation
+	for _, rec := range recs {
+		// dataSources that didn't respond to the request contribute nothing.
+		if series, ok := rec.series[fo.req]; ok && series.Root != nil {
+			roots = append(roots, series.Root)
+			stringTables = append(stringTables, rec.stringTable)
+			if series.Truncated != nil {
+				truncations = append(truncations, series.Truncated)
+			}
+		}
+	}
+	if len(roots) == 0 {
+		drb.DataSeries(fo.req)
+		return nil
+	}
+	// The Merger may fail partway, so it populates its own
+	// DataResponseBuilder, which is replayed into drb only on success.  The
+	// merged series is subject to the response limits, and is truncated if
+	// any of its inputs were.
+	merged := util.NewDataResponseBuilder().WithLimits(qd.responseLimits).WithContext(ctx)
+	if err := fo.merge(merged.DataSeries(fo.req), roots, stringTables); err != nil {
+		return fmt.Errorf("failed merging fan-out query `%s`: %w", fo.req.QueryName, err)
+	}
+	for _, truncation := range truncations {
+		merged.MarkTruncated(truncation, fo.req)
+	}
+	data, err := merged.Data()
+	if err != nil {
+		return err
+	}
+	return replaySeries(drb, fo.req, data.DataSeries[0], data.StringTable)
+}
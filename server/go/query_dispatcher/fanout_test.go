@@ -0,0 +1,226 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// shardDataSource handles 'Shard' queries, responding with a single child
+// bearing its shard name, or failing if its shard name is 'fail'.
+type shardDataSource struct {
+	shard string
+}
+
+func (sds *shardDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Shard"}
+}
+
+func (sds *shardDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	if sds.shard == "fail" {
+		return errors.New("oops")
+	}
+	for _, req := range reqs {
+		drb.DataSeries(req).Child().With(util.StringProperty("shard", sds.shard))
+	}
+	return nil
+}
+
+// concatenate is a Merger concatenating the children of its roots, failing if
+// any child has the shard name 'conflict'.
+func concatenate(db util.DataBuilder, roots []*util.Datum, stringTables [][]string) error {
+	for idx, root := range roots {
+		for _, child := range root.Children {
+			dr, err := util.NewDatumReader(child, stringTables[idx])
+			if err != nil {
+				return err
+			}
+			if shard, err := dr.String("shard"); err == nil && shard == "conflict" {
+				return errors.New("conflict")
+			}
+			if err := util.Replay(db.Child(), child, stringTables[idx]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var shardRequest = &util.DataRequest{
+	SeriesRequests: []*util.DataSeriesRequest{{
+		QueryName:  "Shard",
+		SeriesName: "1",
+	}},
+}
+
+func TestFanOut(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		shards      []string
+		wantShards  []string
+		wantErr     string
+	}{{
+		description: "single data source",
+		shards:      []string{"a"},
+		wantShards:  []string{"a"},
+	}, {
+		description: "multiple data sources",
+		shards:      []string{"a", "b", "c"},
+		wantShards:  []string{"a", "b", "c"},
+	}, {
+		description: "failing data source",
+		shards:      []string{"a", "fail"},
+		wantErr:     "data source *querydispatcher.shardDataSource failed handling [Shard]: oops",
+	}, {
+		description: "failing merger",
+		shards:      []string{"a", "conflict"},
+		wantErr:     "failed merging fan-out query `Shard`: conflict",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var dss []dataSource
+			for _, shard := range test.shards {
+				dss = append(dss, &shardDataSource{shard})
+			}
+			qd, err := NewWithOptions([]Option{WithFanOut("Shard", concatenate)}, dss...)
+			if err != nil {
+				t.Fatalf("NewWithOptions() yielded unexpected error %s", err)
+			}
+			wantDrb := util.NewDataResponseBuilder()
+			wantDb := wantDrb.DataSeries(shardRequest.SeriesRequests[0])
+			for _, shard := range test.wantShards {
+				wantDb.Child().With(util.StringProperty("shard", shard))
+			}
+			wantData, err := wantDrb.Data()
+			if err != nil {
+				t.Fatalf("encountered unexpected error building the response: %s", err)
+			}
+			gotData, err := qd.HandleDataRequest(context.Background(), shardRequest)
+			if test.wantErr != "" {
+				if err == nil {
+					t.Fatalf("HandleDataRequest() yielded no error, wanted %q", test.wantErr)
+				}
+				if diff := cmp.Diff(test.wantErr, err.Error()); diff != "" {
+					t.Errorf("HandleDataRequest() yielded error %q, diff (-want +got) %s", err, diff)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+			}
+			var frames []*util.DataFrame
+			if err := qd.HandleDataRequestStreaming(context.Background(), shardRequest, func(frame *util.DataFrame) error {
+				frames = append(frames, frame)
+				return nil
+			}); err != nil {
+				t.Fatalf("HandleDataRequestStreaming() yielded unexpected error %s", err)
+			}
+			gotData = util.ReassembleDataFrames(frames...)
+			if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+				t.Errorf("Got streamed data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+func TestFanOutPartialResults(t *testing.T) {
+	qd, err := NewWithOptions([]Option{WithFanOut("Shard", concatenate)}, &shardDataSource{"a"}, &shardDataSource{"fail"})
+	if err != nil {
+		t.Fatalf("NewWithOptions() yielded unexpected error %s", err)
+	}
+	req := &util.DataRequest{
+		SeriesRequests:      shardRequest.SeriesRequests,
+		AllowPartialResults: true,
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	wantData := &util.Data{
+		DataSeries: []*util.DataSeries{{
+			SeriesName: "1",
+			Root:       emptyDatum(),
+			Error: &util.SeriesError{
+				QueryName: "Shard",
+				Message:   "data source *querydispatcher.shardDataSource failed handling [Shard]: oops",
+			},
+		}},
+	}
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+}
+
+func TestFanOutRegistration(t *testing.T) {
+	if _, err := New(&shardDataSource{"a"}, &shardDataSource{"b"}); err == nil {
+		t.Errorf("New() with conflicting queries and no fan-out unexpectedly succeeded")
+	}
+	b := &shardDataSource{"b"}
+	qd, err := NewWithOptions([]Option{WithFanOut("Shard", concatenate)}, &shardDataSource{"a"}, b)
+	if err != nil {
+		t.Fatalf("NewWithOptions() yielded unexpected error %s", err)
+	}
+	if err := qd.Unregister(context.Background(), b); err != nil {
+		t.Fatalf("Unregister() yielded unexpected error %s", err)
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), shardRequest)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if got := len(gotData.DataSeries[0].Root.Children); got != 1 {
+		t.Errorf("HandleDataRequest() after Unregister() yielded %d shards, want 1", got)
+	}
+}
+
+func TestFanOutResponseLimits(t *testing.T) {
+	qd, err := NewWithOptions([]Option{
+		WithResponseLimits(util.ResponseLimits{
+			MaxSeriesDatums: 1,
+		}),
+		WithFanOut("Wide", concatenate),
+	}, &wideDataSource{}, &wideDataSource{})
+	if err != nil {
+		t.Fatalf("NewWithOptions() yielded unexpected error %s", err)
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Wide",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"children": util.IntegerValue(2),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	// Each data source's response drops one child, and the merged response
+	// drops the second data source's remaining child.
+	want := `Data:
+  Series 1
+    Truncated at MaxSeriesDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'idx': 0`
+	if diff := cmp.Diff(want, gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+}
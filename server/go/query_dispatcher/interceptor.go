@@ -0,0 +1,183 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Invocation describes a single call to a dataSource's
+// HandleDataSeriesRequests.
+type Invocation struct {
+	// The name of the invoked dataSource, as reported in errors.
+	DataSource string
+	// The DataRequest's global filters.
+	GlobalFilters map[string]*util.V
+	// The DataSeriesRequests handled by this invocation.
+	SeriesRequests []*util.DataSeriesRequest
+	ds             dataSource
+	limiter        *limiter
+	// The QueryDispatcher's response limits, which also bound recordings.
+	responseLimits util.ResponseLimits
+}
+
+// QueryNames returns the query names of the receiver's DataSeriesRequests, in
+// order.
+func (inv *Invocation) QueryNames() []string {
+	ret := make([]string, len(inv.SeriesRequests))
+	for idx, seriesReq := range inv.SeriesRequests {
+		ret[idx] = seriesReq.QueryName
+	}
+	return ret
+}
+
+// Handler handles an Invocation, populating the provided DataResponseBuilder.
+type Handler func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder) error
+
+// Interceptor wraps the handling of an Invocation.  Interceptors should
+// generally call next to proceed with the Invocation, but may instead return
+// without calling it, or may call it with a modified Context or Invocation.
+// Any error an Interceptor returns is treated as if the dataSource returned
+// it.
+type Interceptor func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error
+
+// WithInterceptors configures a QueryDispatcher to pass each dataSource
+// invocation through the provided Interceptors.  The first Interceptor is
+// outermost: it is called first, and its next Handler calls the second.
+// Multiple WithInterceptors Options append to the chain.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(qd *QueryDispatcher) {
+		qd.interceptors = append(qd.interceptors, interceptors...)
+	}
+}
+
+// invokeDataSource is the innermost Handler, calling the Invocation's
+// dataSource.
+func invokeDataSource(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder) error {
+	return inv.ds.HandleDataSeriesRequests(ctx, inv.GlobalFilters, drb, inv.SeriesRequests)
+}
+
+// chain returns a Handler applying the provided Interceptors, in order, around
+// the provided Handler.
+func chain(interceptors []Interceptor, handler Handler) Handler {
+	for idx := len(interceptors) - 1; idx >= 0; idx-- {
+		interceptor, next := interceptors[idx], handler
+		handler = func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder) error {
+			return interceptor(ctx, inv, drb, next)
+		}
+	}
+	return handler
+}
+
+type freshResultsKey struct{}
+
+// withFreshResults returns a copy of the provided Context requesting that
+// responses be freshly computed, rather than served from a Cache or shared
+// with identical in-flight requests that may have begun before the data
+// changed.
+func withFreshResults(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshResultsKey{}, true)
+}
+
+// freshResultsRequested returns true if the provided Context was returned by
+// withFreshResults.
+func freshResultsRequested(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshResultsKey{}).(bool)
+	return fresh
+}
+
+// recording is the response to an Invocation, recorded in its own
+// DataResponseBuilder.
+type recording struct {
+	// The recorded DataSeries, by the DataSeriesRequest they respond to.
+	series      map[*util.DataSeriesRequest]*util.DataSeries
+	stringTable []string
+}
+
+// record handles the provided Invocation with next, recording the response in
+// a new DataResponseBuilder rather than the request's own.  This allows
+// Interceptors to retain or share the response, and later replay it with
+// replaySeries.  The recording is subject to the QueryDispatcher's response
+// limits, so recorded series may be truncated.
+func record(ctx context.Context, inv *Invocation, next Handler) (*recording, error) {
+	// Partial results are always allowed in the recording, so that a failure in
+	// one series can be told apart from others; replaySeries then handles
+	// those failures as they would have been had they occurred in the
+	// request's own DataResponseBuilder.
+	recorder := util.NewDataResponseBuilder().
+		AllowPartialResults().
+		WithLimits(inv.responseLimits).
+		WithContext(ctx)
+	if err := next(ctx, inv, recorder); err != nil {
+		return nil, err
+	}
+	data, seriesByRequest, err := recorder.DataByRequest()
+	if err != nil {
+		return nil, err
+	}
+	ret := &recording{
+		series:      make(map[*util.DataSeriesRequest]*util.DataSeries, len(inv.SeriesRequests)),
+		stringTable: data.StringTable,
+	}
+	requested := make(map[*util.DataSeriesRequest]bool, len(inv.SeriesRequests))
+	for _, req := range inv.SeriesRequests {
+		requested[req] = true
+	}
+	for req, series := range seriesByRequest {
+		if !requested[req] {
+			return nil, fmt.Errorf("data source responded with unrequested series '%s'", series.SeriesName)
+		}
+		ret.series[req] = series
+	}
+	return ret, nil
+}
+
+// replaySeries replays the provided recorded DataSeries, whose strings are in
+// the provided string table, into drb as the response to the provided
+// DataSeriesRequest.  If the recorded series was truncated, so is the
+// replayed one.
+func replaySeries(drb *util.DataResponseBuilder, req *util.DataSeriesRequest, series *util.DataSeries, stringTable []string) error {
+	if series.Error != nil {
+		drb.FailSeries(series.Error.DataSource, errors.New(series.Error.Message), req)
+		return nil
+	}
+	db := drb.DataSeries(req)
+	if series.Truncated != nil {
+		drb.MarkTruncated(series.Truncated, req)
+	}
+	return util.Replay(db, series.Root, stringTable)
+}
+
+// LogInvocations returns an Interceptor logging, via the provided function
+// (e.g., log.Printf), the queries handled by each Invocation and the time it
+// took to handle them.
+func LogInvocations(logf func(format string, args ...any)) Interceptor {
+	return func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+		start := time.Now()
+		err := next(ctx, inv, drb)
+		queryNames := strings.Join(inv.QueryNames(), ", ")
+		if err != nil {
+			logf("%s failed [%s] queries in %s: %s", inv.DataSource, queryNames, time.Since(start), err)
+		} else {
+			logf("%s handled [%s] queries in %s", inv.DataSource, queryNames, time.Since(start))
+		}
+		return err
+	}
+}
@@ -0,0 +1,172 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+var (
+	// ErrOverloaded is returned, wrapped, when a dataSource invocation could
+	// not start within its DataSourceLimits' MaxQueueWait because the
+	// dataSource had too many invocations in progress.
+	ErrOverloaded = errors.New("data source overloaded")
+	// ErrTimedOut is returned, wrapped, when a dataSource invocation did not
+	// complete within its DataSourceLimits' Timeout.
+	ErrTimedOut = errors.New("data source timed out")
+)
+
+// DataSourceLimits bounds the work a QueryDispatcher gives a single
+// dataSource, so that one dataSource's heavy queries cannot starve others.
+// Zero-valued limits are unbounded.
+type DataSourceLimits struct {
+	// The maximum number of concurrent invocations of the dataSource.
+	// Further invocations wait for one in progress to complete.
+	MaxConcurrent int
+	// The maximum time an invocation may wait to start, if MaxConcurrent
+	// invocations are already in progress.  Invocations waiting longer fail
+	// with ErrOverloaded.
+	MaxQueueWait time.Duration
+	// The maximum time an invocation may take, once started.  Invocations
+	// taking longer fail with ErrTimedOut.  The invocation's Context is
+	// cancelled at this deadline, but a dataSource ignoring cancellation
+	// continues to count against MaxConcurrent until it returns.
+	Timeout time.Duration
+}
+
+// WithDefaultDataSourceLimits configures a QueryDispatcher to enforce the
+// provided limits on each of its dataSources, except those with their own
+// limits set by WithDataSourceLimits.
+func WithDefaultDataSourceLimits(limits DataSourceLimits) Option {
+	return func(qd *QueryDispatcher) {
+		qd.defaultDataSourceLimits = limits
+	}
+}
+
+// WithDataSourceLimits configures a QueryDispatcher to enforce the provided
+// limits on the provided dataSource.
+func WithDataSourceLimits(ds dataSource, limits DataSourceLimits) Option {
+	return func(qd *QueryDispatcher) {
+		qd.dataSourceLimits[ds] = limits
+	}
+}
+
+// limiter enforces DataSourceLimits on a single dataSource.
+type limiter struct {
+	limits DataSourceLimits
+	// Holds a token for each invocation in progress, if MaxConcurrent is set.
+	slots chan struct{}
+}
+
+func newLimiter(limits DataSourceLimits) *limiter {
+	ret := &limiter{
+		limits: limits,
+	}
+	if limits.MaxConcurrent > 0 {
+		ret.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return ret
+}
+
+// acquire waits for the receiver to admit a new invocation of the provided
+// Invocation's dataSource, returning an error if it is not admitted.  If it
+// is admitted, release must be called when the invocation completes.
+func (l *limiter) acquire(ctx context.Context, inv *Invocation) error {
+	if l.slots == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	var queueTimeout <-chan time.Time
+	if l.limits.MaxQueueWait > 0 {
+		timer := time.NewTimer(l.limits.MaxQueueWait)
+		defer timer.Stop()
+		queueTimeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-queueTimeout:
+		return fmt.Errorf("%w: %s had %d requests in progress for %s", ErrOverloaded, inv.DataSource, l.limits.MaxConcurrent, l.limits.MaxQueueWait)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// invoke is the innermost Handler, calling the Invocation's dataSource within
+// its limits.
+func (l *limiter) invoke(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder) error {
+	if err := l.acquire(ctx, inv); err != nil {
+		return err
+	}
+	if l.limits.Timeout <= 0 {
+		defer l.release()
+		return invokeDataSource(ctx, inv, drb)
+	}
+	timedOut := func() error {
+		return fmt.Errorf("%w: %s did not respond within %s", ErrTimedOut, inv.DataSource, l.limits.Timeout)
+	}
+	// The dataSource may not return promptly at its deadline, so it responds
+	// in its own DataResponseBuilder, which is abandoned if it times out.
+	execCtx, cancel := context.WithTimeout(ctx, l.limits.Timeout)
+	var rec *recording
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer l.release()
+		defer cancel()
+		rec, err = record(execCtx, inv, invokeDataSource)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-execCtx.Done():
+		select {
+		case <-done:
+		default:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return timedOut()
+		}
+	}
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(execCtx.Err(), context.DeadlineExceeded) {
+			return timedOut()
+		}
+		return err
+	}
+	for _, req := range inv.SeriesRequests {
+		if series, ok := rec.series[req]; ok {
+			if err := replaySeries(drb, req, series, rec.stringTable); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestDataSourceLimits(t *testing.T) {
+	bds := newBlockingDataSource()
+	bds.ignoreCancellation = true
+	cds := &countingDataSource{}
+	qd, err := NewWithOptions(
+		[]Option{
+			WithDefaultDataSourceLimits(DataSourceLimits{
+				MaxConcurrent: 1,
+				MaxQueueWait:  time.Millisecond,
+			}),
+			WithDataSourceLimits(bds, DataSourceLimits{
+				MaxConcurrent: 1,
+				MaxQueueWait:  10 * time.Millisecond,
+				Timeout:       10 * time.Millisecond,
+			}),
+		},
+		bds, cds,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	// The first request times out, though the data source keeps running...
+	if _, err := qd.HandleDataRequest(context.Background(), blockRequest("1", "a")); !errors.Is(err, ErrTimedOut) {
+		t.Errorf("HandleDataRequest() yielded error %v, want %v", err, ErrTimedOut)
+	}
+	// ...so the next can't start.
+	if _, err := qd.HandleDataRequest(context.Background(), blockRequest("1", "a")); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("HandleDataRequest() yielded error %v, want %v", err, ErrOverloaded)
+	}
+	// Other data sources are unaffected.
+	countReq := &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Count",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(1),
+			},
+		}},
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), countReq); err != nil {
+		t.Errorf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	// Once the first invocation completes, the data source accepts requests
+	// again.
+	close(bds.release)
+	for {
+		data, err := qd.HandleDataRequest(context.Background(), blockRequest("1", "a"))
+		if errors.Is(err, ErrOverloaded) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+		if len(data.DataSeries) != 1 {
+			t.Errorf("HandleDataRequest() yielded %d series, want 1", len(data.DataSeries))
+		}
+		break
+	}
+}
+
+func TestDataSourceLimitsPartialResults(t *testing.T) {
+	bds := newBlockingDataSource()
+	qd, err := NewWithOptions(
+		[]Option{
+			WithDataSourceLimits(bds, DataSourceLimits{
+				Timeout: time.Millisecond,
+			}),
+		},
+		bds,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	req := blockRequest("1", "a")
+	req.AllowPartialResults = true
+	data, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if len(data.DataSeries) != 1 || data.DataSeries[0].Error == nil {
+		t.Fatalf("HandleDataRequest() yielded %s, wanted one failed series", data.PrettyPrint())
+	}
+	want := "data source timed out: *querydispatcher.blockingDataSource did not respond within 1ms"
+	if got := data.DataSeries[0].Error.Message; got != want {
+		t.Errorf("Got series error '%s', want '%s'", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/metrics"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// errorKind classifies the provided dataSource error for metrics.
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrOverloaded):
+		return "overloaded"
+	case errors.Is(err, ErrTimedOut), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// RecordMetrics returns an Interceptor recording, in the provided Registry,
+// the number of times each dataSource handled each query, the latency of
+// those invocations, and their failures by kind ('overloaded', 'timeout',
+// 'canceled', or 'error').  An Invocation handling several queries is counted
+// once for each.  Placed before WithCache or WithDeduplication, it measures
+// the latency clients observe; placed after, only that of dataSources.
+func RecordMetrics(registry *metrics.Registry) Interceptor {
+	invocations := registry.Counter(
+		"traceviz_query_invocations_total",
+		"Data source invocations, by data source and query.",
+		"data_source", "query",
+	)
+	latencies := registry.Histogram(
+		"traceviz_query_duration_seconds",
+		"Data source invocation latency in seconds, by data source and query.",
+		metrics.DefaultLatencyBuckets,
+		"data_source", "query",
+	)
+	failures := registry.Counter(
+		"traceviz_query_errors_total",
+		"Failed data source invocations, by data source, query, and kind.",
+		"data_source", "query", "kind",
+	)
+	return func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+		start := time.Now()
+		err := next(ctx, inv, drb)
+		elapsed := time.Since(start).Seconds()
+		for _, queryName := range inv.QueryNames() {
+			invocations.With(inv.DataSource, queryName).Inc()
+			latencies.With(inv.DataSource, queryName).Observe(elapsed)
+			if err != nil {
+				failures.With(inv.DataSource, queryName, errorKind(err)).Inc()
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,79 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/metrics"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestRecordMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	qd, err := NewWithOptions(
+		[]Option{WithInterceptors(RecordMetrics(registry))},
+		&countingDataSource{},
+		&failingDataSource{
+			supportedDataSeriesQueries: []string{"Fail"},
+			returnErr:                  true,
+		},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		AllowPartialResults: true,
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Count",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(1),
+			},
+		}, {
+			QueryName:  "Fail",
+			SeriesName: "2",
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := qd.HandleDataRequest(context.Background(), req); err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+	}
+	var sb strings.Builder
+	if err := registry.WriteText(&sb); err != nil {
+		t.Fatalf("WriteText() yielded unexpected error %s", err)
+	}
+	var got []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if strings.HasPrefix(line, "traceviz_query_invocations_total{") ||
+			strings.HasPrefix(line, "traceviz_query_errors_total{") ||
+			strings.HasPrefix(line, "traceviz_query_duration_seconds_count{") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		`traceviz_query_duration_seconds_count{data_source="*querydispatcher.countingDataSource",query="Count"} 2`,
+		`traceviz_query_duration_seconds_count{data_source="*querydispatcher.failingDataSource",query="Fail"} 2`,
+		`traceviz_query_errors_total{data_source="*querydispatcher.failingDataSource",query="Fail",kind="error"} 2`,
+		`traceviz_query_invocations_total{data_source="*querydispatcher.countingDataSource",query="Count"} 2`,
+		`traceviz_query_invocations_total{data_source="*querydispatcher.failingDataSource",query="Fail"} 2`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got metrics %v, diff (-want +got) %s", got, diff)
+	}
+}
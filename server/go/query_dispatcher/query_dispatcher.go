@@ -18,7 +18,10 @@ package querydispatcher
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 
+	"github.com/ilhamster/traceviz/server/go/selftrace"
 	"github.com/ilhamster/traceviz/server/go/util"
 	"golang.org/x/sync/errgroup"
 )
@@ -35,7 +38,9 @@ type dataSource interface {
 	// supplied collection name, with the supplied global options.  dataSource
 	// implementations should use the provided DataResponseBuilder to add and
 	// populate a new DataSeries.  Any returned error will cancel the entire
-	// DataRequest and surface to the client.
+	// DataRequest and surface to the client, unless the DataRequest allows
+	// partial results, in which case it fails all of reqs.  To fail a single
+	// DataSeries, apply util.ErrorProperty to it.
 	HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error
 }
 
@@ -43,56 +48,310 @@ type dataSource interface {
 // entirely different datasets and analysis libraries, allowing common queries
 // to be satisfied by a variety of data providers.
 type QueryDispatcher struct {
-	dataSources []dataSource
-	// Maps data series query names to indices (in dataSources) of the
-	// dataSources that handle those queries.
-	dataSeriesQueryHandlers map[string]int
+	// Guards registrations and dataSeriesQueryHandlers.
+	mu sync.RWMutex
+	// The registered dataSources, in registration order.
+	registrations []*registration
+	// Maps data series query names to the registrations of the dataSources
+	// that handle those queries.  Only fan-out queries may have more than one.
+	dataSeriesQueryHandlers map[string][]*registration
+	// Maps fan-out query names to the Mergers that merge their responses.
+	fanOutMergers map[string]Merger
+	// The limits applied to every response.
+	responseLimits util.ResponseLimits
+	// Interceptors wrapping each dataSource invocation, outermost first.
+	interceptors []Interceptor
+	// Handles each dataSource invocation, applying interceptors.
+	handle Handler
+	// The limits applied to dataSources, by default and by dataSource.
+	defaultDataSourceLimits DataSourceLimits
+	dataSourceLimits        map[dataSource]DataSourceLimits
+	// If non-nil, records self-tracing spans for each request.
+	selfTraceRecorder *selftrace.Recorder
+}
+
+// Option configures a QueryDispatcher.
+type Option func(qd *QueryDispatcher)
+
+// WithResponseLimits configures a QueryDispatcher to enforce the provided
+// limits on each response it assembles.  DataSeries reaching these limits are
+// truncated; see util.ResponseLimits.
+func WithResponseLimits(limits util.ResponseLimits) Option {
+	return func(qd *QueryDispatcher) {
+		qd.responseLimits = limits
+	}
 }
 
 // New returns a *QueryDispatcher wrapping the provided dataSources.
 func New(dss ...dataSource) (*QueryDispatcher, error) {
+	return NewWithOptions(nil, dss...)
+}
+
+// NewWithOptions returns a *QueryDispatcher configured with the provided
+// Options and wrapping the provided dataSources.
+func NewWithOptions(opts []Option, dss ...dataSource) (*QueryDispatcher, error) {
 	qd := &QueryDispatcher{
-		dataSeriesQueryHandlers: map[string]int{},
-	}
-	for dsIdx, ds := range dss {
-		qd.dataSources = append(qd.dataSources, ds)
-		for _, traceQueryName := range ds.SupportedDataSeriesQueries() {
-			if _, ok := qd.dataSeriesQueryHandlers[traceQueryName]; ok {
-				return nil, fmt.Errorf(
-					"multiple dataSources handle trace query `%s`", traceQueryName)
-			}
-			qd.dataSeriesQueryHandlers[traceQueryName] = dsIdx
+		dataSeriesQueryHandlers: map[string][]*registration{},
+		fanOutMergers:           map[string]Merger{},
+		dataSourceLimits:        map[dataSource]DataSourceLimits{},
+	}
+	for _, opt := range opts {
+		opt(qd)
+	}
+	qd.handle = chain(qd.interceptors, func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder) error {
+		return inv.limiter.invoke(ctx, inv, drb)
+	})
+	for _, ds := range dss {
+		if err := qd.Register(ds); err != nil {
+			return nil, err
 		}
 	}
 	return qd, nil
 }
 
+// dataSourceName returns a name identifying the provided dataSource in error
+// messages.
+func dataSourceName(ds dataSource) string {
+	return fmt.Sprintf("%T", ds)
+}
+
+// DataSourceError is returned when a dataSource fails a DataRequest that
+// doesn't allow partial results.
+type DataSourceError struct {
+	// The name of the failing dataSource.
+	DataSource string
+	// The query names of the DataSeriesRequests the dataSource was handling.
+	QueryNames []string
+	// The dataSource's error.
+	Err error
+}
+
+func (dse *DataSourceError) Error() string {
+	return fmt.Sprintf("data source %s failed handling [%s]: %s", dse.DataSource, strings.Join(dse.QueryNames, ", "), dse.Err)
+}
+
+func (dse *DataSourceError) Unwrap() error {
+	return dse.Err
+}
+
+// dataSourceError annotates an error returned while handling the provided
+// Invocation.
+func dataSourceError(inv *Invocation, err error) error {
+	return &DataSourceError{
+		DataSource: inv.DataSource,
+		QueryNames: inv.QueryNames(),
+		Err:        err,
+	}
+}
+
+// newInvocation returns a new Invocation of the provided registration's
+// dataSource.
+func (qd *QueryDispatcher) newInvocation(reg *registration, globalFilters map[string]*util.V, seriesReqs []*util.DataSeriesRequest) *Invocation {
+	return &Invocation{
+		DataSource:     dataSourceName(reg.ds),
+		GlobalFilters:  globalFilters,
+		SeriesRequests: seriesReqs,
+		ds:             reg.ds,
+		limiter:        reg.limiter,
+		responseLimits: qd.responseLimits,
+	}
+}
+
+// UnsupportedQueryError is returned when a DataRequest that doesn't allow
+// partial results includes a query no dataSource supports.
+type UnsupportedQueryError struct {
+	QueryName string
+}
+
+func (uqe *UnsupportedQueryError) Error() string {
+	return fmt.Sprintf("unsupported data query `%s`", uqe.QueryName)
+}
+
+func unsupportedQueryError(seriesReq *util.DataSeriesRequest) error {
+	return &UnsupportedQueryError{
+		QueryName: seriesReq.QueryName,
+	}
+}
+
+// groupRequests groups the provided DataSeriesRequests by the registration of
+// the dataSource that handles them.  It separately returns requests for
+// fan-out queries, and any unsupported requests.  Each returned registration,
+// and each registration of each returned fanOut, counts an in-flight
+// invocation, which must be marked done once the invocation completes.
+func (qd *QueryDispatcher) groupRequests(seriesReqs []*util.DataSeriesRequest) (groupedReqs map[*registration][]*util.DataSeriesRequest, fanOuts []*fanOut, unsupported []*util.DataSeriesRequest) {
+	qd.mu.RLock()
+	defer qd.mu.RUnlock()
+	// A mapping from dataSource registration to a set of DataRequests that
+	// source can handle.
+	groupedReqs = map[*registration][]*util.DataSeriesRequest{}
+	for _, seriesReq := range seriesReqs {
+		regs, ok := qd.dataSeriesQueryHandlers[seriesReq.QueryName]
+		if !ok {
+			unsupported = append(unsupported, seriesReq)
+			continue
+		}
+		if merge, ok := qd.fanOutMergers[seriesReq.QueryName]; ok {
+			fo := &fanOut{
+				req:   seriesReq,
+				regs:  append([]*registration{}, regs...),
+				merge: merge,
+			}
+			for _, reg := range fo.regs {
+				reg.inflight.Add(1)
+			}
+			fanOuts = append(fanOuts, fo)
+			continue
+		}
+		groupedReqs[regs[0]] = append(groupedReqs[regs[0]], seriesReq)
+	}
+	for reg := range groupedReqs {
+		reg.inflight.Add(1)
+	}
+	return groupedReqs, fanOuts, unsupported
+}
+
+// abandon marks done the in-flight invocations counted by groupRequests, for
+// requests that will not be dispatched.
+func abandon(groupedReqs map[*registration][]*util.DataSeriesRequest, fanOuts []*fanOut) {
+	for reg := range groupedReqs {
+		reg.inflight.Done()
+	}
+	for _, fo := range fanOuts {
+		fo.done()
+	}
+}
+
 // HandleDataRequest distributes the provided tracevizpb.DataRequest's
 // constituent DataSeriesRequests to their appropriate dataSources for processing,
 // then assembles the returned tracevizpb.DataSeries into a
 // tracevizpb.DataResponse.
+//
+// If the DataRequest allows partial results, a failing dataSource, or an
+// unsupported query, fails only the affected DataSeries, which report the
+// failure in their Error; otherwise, it fails the whole request.  DataSeries
+// exceeding the QueryDispatcher's response limits are truncated, and if ctx
+// is done before the response is assembled, its error is returned.
 func (qd *QueryDispatcher) HandleDataRequest(ctx context.Context, req *util.DataRequest) (*util.Data, error) {
-	drb := util.NewDataResponseBuilder()
-	// A mapping from dataSource index to a set of DataRequests that source can
-	// handle.
-	groupedReqs := map[int][]*util.DataSeriesRequest{}
-	for _, seriesReq := range req.SeriesRequests {
-		dsIdx, ok := qd.dataSeriesQueryHandlers[seriesReq.QueryName]
-		if !ok {
-			return nil, fmt.Errorf("unsupported data query `%s`", seriesReq.QueryName)
+	ctx, finish := qd.traceRequest(ctx, "HandleDataRequest")
+	data, err := qd.handleDataRequest(ctx, req)
+	finish(err)
+	return data, err
+}
+
+func (qd *QueryDispatcher) handleDataRequest(ctx context.Context, req *util.DataRequest) (*util.Data, error) {
+	drb := util.NewDataResponseBuilder().WithLimits(qd.responseLimits).WithContext(ctx)
+	if req.AllowPartialResults {
+		drb.AllowPartialResults()
+	}
+	groupedReqs, fanOuts, unsupported := qd.groupRequests(req.SeriesRequests)
+	for _, seriesReq := range unsupported {
+		if !req.AllowPartialResults {
+			abandon(groupedReqs, fanOuts)
+			return nil, unsupportedQueryError(seriesReq)
 		}
-		groupedReqs[dsIdx] = append(groupedReqs[dsIdx], seriesReq)
+		drb.FailSeries("", unsupportedQueryError(seriesReq), seriesReq)
 	}
 	errg, ctx := errgroup.WithContext(ctx)
-	for dsIdx, seriesReqs := range groupedReqs {
-		func(ds dataSource, seriesReqs []*util.DataSeriesRequest) {
+	for reg, seriesReqs := range groupedReqs {
+		func(reg *registration, seriesReqs []*util.DataSeriesRequest) {
 			errg.Go(func() error {
-				return ds.HandleDataSeriesRequests(ctx, req.GlobalFilters, drb, seriesReqs)
+				defer reg.inflight.Done()
+				inv := qd.newInvocation(reg, req.GlobalFilters, seriesReqs)
+				err := qd.handle(ctx, inv, drb)
+				if err == nil {
+					return nil
+				}
+				if req.AllowPartialResults {
+					// Don't cancel other dataSources.
+					drb.FailSeries(inv.DataSource, err, seriesReqs...)
+					return nil
+				}
+				return dataSourceError(inv, err)
 			})
-		}(qd.dataSources[dsIdx], seriesReqs)
+		}(reg, seriesReqs)
+	}
+	for _, fo := range fanOuts {
+		errg.Go(func() error {
+			defer fo.done()
+			err := qd.handleFanOut(ctx, fo, req.GlobalFilters, drb)
+			if err == nil {
+				return nil
+			}
+			if req.AllowPartialResults {
+				drb.FailSeries("", err, fo.req)
+				return nil
+			}
+			return err
+		})
 	}
 	if err := errg.Wait(); err != nil {
 		return nil, err
 	}
 	return drb.Data()
 }
+
+// HandleDataRequestStreaming is like HandleDataRequest, but rather than
+// assembling a single response, it emits each dataSource's DataSeries to the
+// provided function as a util.DataFrame as soon as that dataSource completes.
+// Unless partial results are allowed, unsupported queries are reported before
+// anything is emitted, and any error returned by a dataSource or by emit
+// cancels the remaining work and is returned.
+func (qd *QueryDispatcher) HandleDataRequestStreaming(ctx context.Context, req *util.DataRequest, emit func(*util.DataFrame) error) error {
+	ctx, finish := qd.traceRequest(ctx, "HandleDataRequestStreaming")
+	err := qd.handleDataRequestStreaming(ctx, req, emit)
+	finish(err)
+	return err
+}
+
+func (qd *QueryDispatcher) handleDataRequestStreaming(ctx context.Context, req *util.DataRequest, emit func(*util.DataFrame) error) error {
+	sdrb := util.NewStreamingDataResponseBuilder(emit).WithLimits(qd.responseLimits).WithContext(ctx)
+	if req.AllowPartialResults {
+		sdrb.AllowPartialResults()
+	}
+	groupedReqs, fanOuts, unsupported := qd.groupRequests(req.SeriesRequests)
+	if len(unsupported) > 0 {
+		if !req.AllowPartialResults {
+			abandon(groupedReqs, fanOuts)
+			return unsupportedQueryError(unsupported[0])
+		}
+		part := sdrb.Part()
+		for _, seriesReq := range unsupported {
+			part.FailSeries("", unsupportedQueryError(seriesReq), seriesReq)
+		}
+		if err := sdrb.Flush(part); err != nil {
+			abandon(groupedReqs, fanOuts)
+			return err
+		}
+	}
+	errg, ctx := errgroup.WithContext(ctx)
+	for reg, seriesReqs := range groupedReqs {
+		func(reg *registration, seriesReqs []*util.DataSeriesRequest) {
+			errg.Go(func() error {
+				defer reg.inflight.Done()
+				part := sdrb.Part()
+				inv := qd.newInvocation(reg, req.GlobalFilters, seriesReqs)
+				if err := qd.handle(ctx, inv, part); err != nil {
+					if !req.AllowPartialResults {
+						return dataSourceError(inv, err)
+					}
+					part.FailSeries(inv.DataSource, err, seriesReqs...)
+				}
+				return sdrb.Flush(part)
+			})
+		}(reg, seriesReqs)
+	}
+	for _, fo := range fanOuts {
+		errg.Go(func() error {
+			defer fo.done()
+			part := sdrb.Part()
+			if err := qd.handleFanOut(ctx, fo, req.GlobalFilters, part); err != nil {
+				if !req.AllowPartialResults {
+					return err
+				}
+				part.FailSeries("", err, fo.req)
+			}
+			return sdrb.Flush(part)
+		})
+	}
+	return errg.Wait()
+}
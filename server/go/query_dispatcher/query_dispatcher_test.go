@@ -16,6 +16,7 @@ package querydispatcher
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 	"testing"
@@ -281,3 +282,379 @@ func TestHandleDataRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleDataRequestStreaming(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		req         *util.DataRequest
+		wantErr     bool
+		wantFrames  int
+		wantData    *util.Data
+	}{{
+		description: "multiple data sources",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("coll1"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				&util.DataSeriesRequest{
+					QueryName:  "ThreadIntervals",
+					SeriesName: "1",
+				},
+				&util.DataSeriesRequest{
+					QueryName:  "CPUIntervals",
+					SeriesName: "2",
+				},
+				&util.DataSeriesRequest{
+					QueryName:  "RPCIntervals",
+					SeriesName: "3",
+				},
+			},
+		},
+		wantFrames: 2,
+		wantData: &util.Data{
+			DataSeries: []*util.DataSeries{
+				&util.DataSeries{
+					SeriesName: "1",
+					Root:       emptyDatum(),
+				},
+				&util.DataSeries{
+					SeriesName: "2",
+					Root:       emptyDatum(),
+				},
+				&util.DataSeries{
+					SeriesName: "3",
+					Root:       emptyDatum(),
+				},
+			},
+		},
+	}, {
+		description: "trace failure",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("error"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				&util.DataSeriesRequest{
+					QueryName:  "ThreadIntervals",
+					SeriesName: "1",
+				},
+			},
+		},
+		wantErr: true,
+	}, {
+		description: "unknown query",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("coll1"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				&util.DataSeriesRequest{
+					QueryName:  "ThreadIntervals",
+					SeriesName: "1",
+				},
+				&util.DataSeriesRequest{
+					QueryName:  "MagicIntervals",
+					SeriesName: "2",
+				},
+			},
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			qd, err := New(newTestDataSource(queries[0]), newTestDataSource(queries[1]))
+			if err != nil {
+				t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+			}
+			var frames []*util.DataFrame
+			err = qd.HandleDataRequestStreaming(context.Background(), test.req, func(frame *util.DataFrame) error {
+				frames = append(frames, frame)
+				return nil
+			})
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequestStreaming() yielded unexpected error %s", err)
+			}
+			if err != nil {
+				if len(frames) != 0 {
+					t.Errorf("HandleDataRequestStreaming() emitted %d frames, wanted none", len(frames))
+				}
+				return
+			}
+			if len(frames) != test.wantFrames {
+				t.Errorf("HandleDataRequestStreaming() emitted %d frames, wanted %d", len(frames), test.wantFrames)
+			}
+			gotData := util.ReassembleDataFrames(frames...)
+			sort.Slice(gotData.DataSeries, func(a, b int) bool {
+				return gotData.DataSeries[a].SeriesName < gotData.DataSeries[b].SeriesName
+			})
+			if diff := cmp.Diff(gotData.PrettyPrint(), test.wantData.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+// failingDataSource fails every DataSeriesRequest it handles: if returnErr is
+// set, by returning an error, and otherwise by applying an ErrorProperty to
+// each requested series.
+type failingDataSource struct {
+	supportedDataSeriesQueries []string
+	returnErr                  bool
+}
+
+func (fds *failingDataSource) SupportedDataSeriesQueries() []string {
+	return fds.supportedDataSeriesQueries
+}
+
+func (fds *failingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	if fds.returnErr {
+		return errors.New("oops")
+	}
+	for _, req := range reqs {
+		drb.DataSeries(req).With(util.ErrorProperty(errors.New("bad series")))
+	}
+	return nil
+}
+
+func TestHandleDataRequestPartialResults(t *testing.T) {
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{
+			&util.DataSeriesRequest{
+				QueryName:  "ThreadIntervals",
+				SeriesName: "1",
+			},
+			&util.DataSeriesRequest{
+				QueryName:  "RPCIntervals",
+				SeriesName: "2",
+			},
+			&util.DataSeriesRequest{
+				QueryName:  "SchedulingIntervals",
+				SeriesName: "3",
+			},
+			&util.DataSeriesRequest{
+				QueryName:  "MagicIntervals",
+				SeriesName: "4",
+			},
+		},
+	}
+	wantData := &util.Data{
+		DataSeries: []*util.DataSeries{
+			&util.DataSeries{
+				SeriesName: "1",
+				Root:       emptyDatum(),
+			},
+			&util.DataSeries{
+				SeriesName: "2",
+				Root:       emptyDatum(),
+				Error: &util.SeriesError{
+					QueryName:  "RPCIntervals",
+					DataSource: "*querydispatcher.failingDataSource",
+					Message:    "oops",
+				},
+			},
+			&util.DataSeries{
+				SeriesName: "3",
+				Root:       emptyDatum(),
+				Error: &util.SeriesError{
+					QueryName: "SchedulingIntervals",
+					Message:   "bad series",
+				},
+			},
+			&util.DataSeries{
+				SeriesName: "4",
+				Root:       emptyDatum(),
+				Error: &util.SeriesError{
+					QueryName: "MagicIntervals",
+					Message:   "unsupported data query `MagicIntervals`",
+				},
+			},
+		},
+	}
+	newQD := func() *QueryDispatcher {
+		qd, err := New(
+			newTestDataSource(queries[0]),
+			&failingDataSource{
+				supportedDataSeriesQueries: queries[1],
+				returnErr:                  true,
+			},
+			&failingDataSource{
+				supportedDataSeriesQueries: []string{"SchedulingIntervals"},
+			},
+		)
+		if err != nil {
+			t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+		}
+		return qd
+	}
+	sortResultsByQuery := func(dataSeries []*util.DataSeries) {
+		sort.Slice(dataSeries, func(a, b int) bool {
+			return dataSeries[a].SeriesName < dataSeries[b].SeriesName
+		})
+	}
+	if _, err := newQD().HandleDataRequest(context.Background(), req); err == nil {
+		t.Errorf("HandleDataRequest() without partial results succeeded, wanted error")
+	}
+	req.AllowPartialResults = true
+	gotData, err := newQD().HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	sortResultsByQuery(gotData.DataSeries)
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+	var frames []*util.DataFrame
+	if err := newQD().HandleDataRequestStreaming(context.Background(), req, func(frame *util.DataFrame) error {
+		frames = append(frames, frame)
+		return nil
+	}); err != nil {
+		t.Fatalf("HandleDataRequestStreaming() yielded unexpected error %s", err)
+	}
+	gotData = util.ReassembleDataFrames(frames...)
+	sortResultsByQuery(gotData.DataSeries)
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got streamed data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+}
+
+// countingDataSource responds to each request with a series of children
+// counting from 0 to the value of its "count" option.
+type countingDataSource struct{}
+
+func (cds *countingDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Count"}
+}
+
+func (cds *countingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		count, err := util.ExpectIntegerValue(req.Options["count"])
+		if err != nil {
+			return err
+		}
+		series := drb.DataSeries(req)
+		for i := int64(0); i < count; i++ {
+			series.Child().With(util.IntegerProperty("i", i))
+		}
+	}
+	return nil
+}
+
+func TestHandleDataRequestResponseLimits(t *testing.T) {
+	qd, err := NewWithOptions(
+		[]Option{
+			WithResponseLimits(util.ResponseLimits{
+				MaxSeriesDatums: 2,
+			}),
+		},
+		&countingDataSource{},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	countReq := func(seriesName string, count int64) *util.DataSeriesRequest {
+		return &util.DataSeriesRequest{
+			QueryName:  "Count",
+			SeriesName: seriesName,
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(count),
+			},
+		}
+	}
+	req := &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{
+			countReq("1", 2),
+			countReq("2", 5),
+		},
+	}
+	want := `Data:
+  Series 1
+    Root:
+      Child:
+        Prop 'i': 0
+      Child:
+        Prop 'i': 1
+  Series 2
+    Truncated at MaxSeriesDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'i': 0
+      Child:
+        Prop 'i': 1`
+	gotData, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(want, gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := qd.HandleDataRequest(ctx, req); !errors.Is(err, context.Canceled) {
+		t.Errorf("HandleDataRequest() with cancelled context yielded error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	var calls []string
+	recording := func(name string) Interceptor {
+		return func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+			calls = append(calls, fmt.Sprintf("%s: %s [%s]", name, inv.DataSource, strings.Join(inv.QueryNames(), ", ")))
+			return next(ctx, inv, drb)
+		}
+	}
+	// Fails any invocation whose global filters request failure.
+	faultInjecting := func(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+		if _, ok := inv.GlobalFilters["fail"]; ok {
+			return errors.New("injected fault")
+		}
+		return next(ctx, inv, drb)
+	}
+	var logs []string
+	logf := func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	qd, err := NewWithOptions(
+		[]Option{
+			WithInterceptors(recording("outer"), LogInvocations(logf)),
+			WithInterceptors(recording("inner"), faultInjecting),
+		},
+		&countingDataSource{},
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Count",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(1),
+			},
+		}},
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), req); err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	wantCalls := []string{
+		"outer: *querydispatcher.countingDataSource [Count]",
+		"inner: *querydispatcher.countingDataSource [Count]",
+	}
+	if diff := cmp.Diff(wantCalls, calls); diff != "" {
+		t.Errorf("Got interceptor calls %v, diff (-want +got) %s", calls, diff)
+	}
+	req.GlobalFilters["fail"] = util.BoolValue(true)
+	if err := qd.HandleDataRequestStreaming(context.Background(), req, func(*util.DataFrame) error {
+		return nil
+	}); err == nil || !strings.Contains(err.Error(), "injected fault") {
+		t.Errorf("HandleDataRequestStreaming() yielded error %v, wanted injected fault", err)
+	}
+	if len(logs) != 2 ||
+		!strings.HasPrefix(logs[0], "*querydispatcher.countingDataSource handled [Count] queries in ") ||
+		!strings.HasPrefix(logs[1], "*querydispatcher.countingDataSource failed [Count] queries in ") {
+		t.Errorf("Got logs %v, wanted one successful and one failed invocation", logs)
+	}
+}
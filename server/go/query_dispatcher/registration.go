@@ -0,0 +1,118 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// registration is a dataSource registered with a QueryDispatcher.
+type registration struct {
+	ds dataSource
+	// Enforces the dataSource's limits.
+	limiter *limiter
+	// Tracks the dataSource's in-flight invocations, so that Unregister can
+	// await them.
+	inflight sync.WaitGroup
+}
+
+// Register adds the provided dataSource to the receiver.  It may be called
+// while the receiver is handling requests; requests dispatched after it
+// returns may use the new dataSource.  It returns an error, and registers
+// nothing, if the dataSource is already registered, or if any of its queries,
+// other than those configured with WithFanOut, are handled by a registered
+// dataSource.
+func (qd *QueryDispatcher) Register(ds dataSource) error {
+	qd.mu.Lock()
+	defer qd.mu.Unlock()
+	for _, reg := range qd.registrations {
+		if reg.ds == ds {
+			return fmt.Errorf("data source %s is already registered", dataSourceName(ds))
+		}
+	}
+	queryNames := ds.SupportedDataSeriesQueries()
+	seen := make(map[string]struct{}, len(queryNames))
+	for _, traceQueryName := range queryNames {
+		_, fanOut := qd.fanOutMergers[traceQueryName]
+		registered := !fanOut && len(qd.dataSeriesQueryHandlers[traceQueryName]) > 0
+		if _, duplicated := seen[traceQueryName]; registered || duplicated {
+			return fmt.Errorf(
+				"multiple dataSources handle trace query `%s`", traceQueryName)
+		}
+		seen[traceQueryName] = struct{}{}
+	}
+	limits, ok := qd.dataSourceLimits[ds]
+	if !ok {
+		limits = qd.defaultDataSourceLimits
+	}
+	reg := &registration{
+		ds:      ds,
+		limiter: newLimiter(limits),
+	}
+	qd.registrations = append(qd.registrations, reg)
+	for _, traceQueryName := range queryNames {
+		qd.dataSeriesQueryHandlers[traceQueryName] = append(qd.dataSeriesQueryHandlers[traceQueryName], reg)
+	}
+	return nil
+}
+
+// Unregister removes the provided dataSource from the receiver, so that
+// requests dispatched after it returns fail its queries as unsupported.  It
+// may be called while the receiver is handling requests.  Requests already
+// dispatched to the dataSource are allowed to complete: Unregister waits for
+// them to drain, returning early with ctx's error if ctx is done first.
+// Either way, once Unregister returns, the dataSource is no longer
+// registered.
+func (qd *QueryDispatcher) Unregister(ctx context.Context, ds dataSource) error {
+	qd.mu.Lock()
+	var reg *registration
+	for idx, r := range qd.registrations {
+		if r.ds == ds {
+			reg = r
+			qd.registrations = append(qd.registrations[:idx:idx], qd.registrations[idx+1:]...)
+			break
+		}
+	}
+	if reg == nil {
+		qd.mu.Unlock()
+		return fmt.Errorf("data source %s is not registered", dataSourceName(ds))
+	}
+	for traceQueryName, regs := range qd.dataSeriesQueryHandlers {
+		for idx, r := range regs {
+			if r == reg {
+				regs = append(regs[:idx:idx], regs[idx+1:]...)
+				break
+			}
+		}
+		if len(regs) == 0 {
+			delete(qd.dataSeriesQueryHandlers, traceQueryName)
+		} else {
+			qd.dataSeriesQueryHandlers[traceQueryName] = regs
+		}
+	}
+	qd.mu.Unlock()
+	drained := make(chan struct{})
+	go func() {
+		reg.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
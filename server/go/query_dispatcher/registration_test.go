@@ -0,0 +1,139 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+var countRequest = &util.DataRequest{
+	SeriesRequests: []*util.DataSeriesRequest{{
+		QueryName:  "Count",
+		SeriesName: "1",
+		Options: map[string]*util.V{
+			"count": util.IntegerValue(1),
+		},
+	}},
+}
+
+func TestRegister(t *testing.T) {
+	qd, err := New()
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), countRequest); err == nil {
+		t.Errorf("HandleDataRequest() with no data sources unexpectedly succeeded")
+	}
+	cds := &countingDataSource{}
+	if err := qd.Register(cds); err != nil {
+		t.Fatalf("Register() yielded unexpected error %s", err)
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), countRequest); err != nil {
+		t.Errorf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if err := qd.Register(cds); err == nil {
+		t.Errorf("Register() of a registered data source unexpectedly succeeded")
+	}
+	if err := qd.Register(&countingDataSource{}); err == nil {
+		t.Errorf("Register() of a data source with conflicting queries unexpectedly succeeded")
+	}
+	if err := qd.Register(newTestDataSource([]string{"a", "a"})); err == nil {
+		t.Errorf("Register() of a data source with duplicated queries unexpectedly succeeded")
+	}
+	if err := qd.Unregister(context.Background(), cds); err != nil {
+		t.Fatalf("Unregister() yielded unexpected error %s", err)
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), countRequest); err == nil {
+		t.Errorf("HandleDataRequest() for an unregistered data source unexpectedly succeeded")
+	}
+	if err := qd.Unregister(context.Background(), cds); err == nil {
+		t.Errorf("Unregister() of an unregistered data source unexpectedly succeeded")
+	}
+	// Once unregistered, a data source's queries may be registered again.
+	if err := qd.Register(&countingDataSource{}); err != nil {
+		t.Errorf("Register() yielded unexpected error %s", err)
+	}
+}
+
+func TestUnregisterDrains(t *testing.T) {
+	bds := newBlockingDataSource()
+	qd, err := New(bds)
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	type result struct {
+		data *util.Data
+		err  error
+	}
+	inFlight := make(chan result)
+	go func() {
+		data, err := qd.HandleDataRequest(context.Background(), blockRequest("1", "a"))
+		inFlight <- result{data, err}
+	}()
+	<-bds.started
+	// Unregistering doesn't complete while a request is in flight...
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := qd.Unregister(ctx, bds); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Unregister() yielded error %v, want %v", err, context.DeadlineExceeded)
+	}
+	// ...but new requests aren't dispatched to the data source...
+	if _, err := qd.HandleDataRequest(context.Background(), blockRequest("2", "b")); err == nil {
+		t.Errorf("HandleDataRequest() for an unregistering data source unexpectedly succeeded")
+	}
+	// ...and the request in flight completes normally.
+	close(bds.release)
+	res := <-inFlight
+	if res.err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", res.err)
+	}
+	if len(res.data.DataSeries) != 1 {
+		t.Errorf("HandleDataRequest() yielded %d series, want 1", len(res.data.DataSeries))
+	}
+}
+
+func TestUnregisterWaitsForDrain(t *testing.T) {
+	bds := newBlockingDataSource()
+	qd, err := New(bds)
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	inFlight := make(chan error)
+	go func() {
+		_, err := qd.HandleDataRequest(context.Background(), blockRequest("1", "a"))
+		inFlight <- err
+	}()
+	<-bds.started
+	unregistered := make(chan error)
+	go func() {
+		unregistered <- qd.Unregister(context.Background(), bds)
+	}()
+	select {
+	case err := <-unregistered:
+		t.Fatalf("Unregister() returned %v before in-flight requests drained", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(bds.release)
+	if err := <-inFlight; err != nil {
+		t.Errorf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if err := <-unregistered; err != nil {
+		t.Errorf("Unregister() yielded unexpected error %s", err)
+	}
+}
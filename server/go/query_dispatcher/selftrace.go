@@ -0,0 +1,56 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+
+	"github.com/ilhamster/traceviz/server/go/selftrace"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// WithSelfTracing configures a QueryDispatcher to record, in the provided
+// selftrace.Recorder, a span for each DataRequest it handles, with a child
+// span for each dataSource invocation.  Invocation spans are recorded by
+// an Interceptor, placed in the interceptor chain in the order this Option
+// appears among other Options; placed before WithCache, invocations served
+// from the Cache are traced too.
+func WithSelfTracing(recorder *selftrace.Recorder) Option {
+	return func(qd *QueryDispatcher) {
+		qd.selfTraceRecorder = recorder
+		WithInterceptors(traceInvocation)(qd)
+	}
+}
+
+// traceInvocation is an Interceptor recording a span for the provided
+// Invocation beneath the request span carried by ctx.
+func traceInvocation(ctx context.Context, inv *Invocation, drb *util.DataResponseBuilder, next Handler) error {
+	ctx, span := selftrace.StartSpan(ctx, inv.DataSource)
+	err := next(ctx, inv, drb)
+	span.End(err)
+	return err
+}
+
+// traceRequest starts a request span with the provided name if the receiver
+// is self-tracing, returning a Context carrying it and a function finishing
+// it with the request's error.
+func (qd *QueryDispatcher) traceRequest(ctx context.Context, name string) (context.Context, func(err error)) {
+	if qd.selfTraceRecorder == nil {
+		return ctx, func(error) {}
+	}
+	ctx, span := qd.selfTraceRecorder.StartRequest(ctx, name)
+	return ctx, func(err error) {
+		qd.selfTraceRecorder.FinishRequest(span, err)
+	}
+}
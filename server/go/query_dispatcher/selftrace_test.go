@@ -0,0 +1,105 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/selftrace"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestSelfTracing(t *testing.T) {
+	recorder := selftrace.NewRecorder(10)
+	qd, err := NewWithOptions(
+		[]Option{WithSelfTracing(recorder)},
+		&countingDataSource{},
+		&failingDataSource{
+			supportedDataSeriesQueries: []string{"Fail"},
+			returnErr:                  true,
+		},
+		selftrace.NewDataSource(recorder),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		AllowPartialResults: true,
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Count",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(1),
+			},
+		}, {
+			QueryName:  "Fail",
+			SeriesName: "2",
+		}},
+	}
+	if _, err := qd.HandleDataRequest(context.Background(), req); err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	recent := recorder.Recent()
+	if len(recent) != 1 {
+		t.Fatalf("Recorded %d requests, wanted 1", len(recent))
+	}
+	type spanShape struct {
+		Name     string
+		Err      string
+		Children []*spanShape
+	}
+	var toShape func(span *selftrace.SpanSnapshot) *spanShape
+	toShape = func(span *selftrace.SpanSnapshot) *spanShape {
+		ret := &spanShape{
+			Name: span.Name,
+			Err:  span.Err,
+		}
+		for _, child := range span.Children {
+			ret.Children = append(ret.Children, toShape(child))
+		}
+		return ret
+	}
+	got := toShape(recent[0])
+	// Invocations run concurrently, so may be recorded in either order.
+	if len(got.Children) == 2 && got.Children[0].Name > got.Children[1].Name {
+		got.Children[0], got.Children[1] = got.Children[1], got.Children[0]
+	}
+	want := &spanShape{
+		Name: "HandleDataRequest",
+		Children: []*spanShape{{
+			Name: "*querydispatcher.countingDataSource",
+		}, {
+			Name: "*querydispatcher.failingDataSource",
+			Err:  "oops",
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Recorded span %v, diff (-want +got) %s", got, diff)
+	}
+	// The self-trace data source renders the recorded request.
+	data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  selftrace.RecentRequestsQuery,
+			SeriesName: "1",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if len(data.DataSeries) != 1 || data.DataSeries[0].Error != nil {
+		t.Errorf("HandleDataRequest() yielded %v, wanted a single successful series", data.DataSeries)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// changeNotifyingDataSource is a dataSource that can signal that the data
+// underlying its queries has changed, such as a growing log or a trace corpus
+// to which new traces are arriving.
+type changeNotifyingDataSource interface {
+	dataSource
+	// NotifyChanges arranges for notify to be called whenever the data
+	// underlying the dataSource's queries with the provided global filters may
+	// have changed, until ctx is done.  It must not block; notify may be called
+	// from any goroutine, and does not block.
+	NotifyChanges(ctx context.Context, globalFilters map[string]*util.V, notify func())
+}
+
+// Subscribe handles the provided DataRequest as HandleDataRequest does,
+// emitting its response to the provided function, then continues to watch
+// for changes until ctx is done.  Whenever a dataSource handling any of the
+// request's queries signals a change (see changeNotifyingDataSource), the
+// DataSeriesRequests it handles are handled again and only their DataSeries
+// are emitted; clients should replace previously-emitted DataSeries with the
+// same SeriesName.  Changes signaled while a response is being assembled are
+// coalesced into a single update.  Updates are freshly computed, never served
+// from a Cache or shared with in-flight requests begun before the change.
+//
+// Subscribe returns nil once ctx is done, or the first error returned by
+// HandleDataRequest or by emit.  Requests none of whose dataSources signal
+// changes emit only their initial response.
+func (qd *QueryDispatcher) Subscribe(ctx context.Context, req *util.DataRequest, emit func(*util.Data) error) error {
+	// Watch for changes before handling the initial request, so that no change
+	// is missed.
+	var mu sync.Mutex
+	changed := map[*util.DataSeriesRequest]struct{}{}
+	wake := make(chan struct{}, 1)
+	for ds, seriesReqs := range qd.changeNotifiers(req.SeriesRequests) {
+		ds.NotifyChanges(ctx, req.GlobalFilters, func() {
+			mu.Lock()
+			for _, seriesReq := range seriesReqs {
+				changed[seriesReq] = struct{}{}
+			}
+			mu.Unlock()
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		})
+	}
+	data, err := qd.HandleDataRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := emit(data); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		}
+		mu.Lock()
+		update := &util.DataRequest{
+			GlobalFilters:       req.GlobalFilters,
+			AllowPartialResults: req.AllowPartialResults,
+		}
+		// Preserve the original request order.
+		for _, seriesReq := range req.SeriesRequests {
+			if _, ok := changed[seriesReq]; ok {
+				update.SeriesRequests = append(update.SeriesRequests, seriesReq)
+			}
+		}
+		changed = map[*util.DataSeriesRequest]struct{}{}
+		mu.Unlock()
+		if len(update.SeriesRequests) == 0 {
+			continue
+		}
+		data, err := qd.HandleDataRequest(withFreshResults(ctx), update)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := emit(data); err != nil {
+			return err
+		}
+	}
+}
+
+// changeNotifiers returns the currently-registered
+// changeNotifyingDataSources handling any of the provided
+// DataSeriesRequests, mapped to the requests they handle.
+func (qd *QueryDispatcher) changeNotifiers(seriesReqs []*util.DataSeriesRequest) map[changeNotifyingDataSource][]*util.DataSeriesRequest {
+	qd.mu.RLock()
+	defer qd.mu.RUnlock()
+	ret := map[changeNotifyingDataSource][]*util.DataSeriesRequest{}
+	for _, seriesReq := range seriesReqs {
+		for _, reg := range qd.dataSeriesQueryHandlers[seriesReq.QueryName] {
+			if cnds, ok := reg.ds.(changeNotifyingDataSource); ok {
+				ret[cnds] = append(ret[cnds], seriesReq)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,207 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// changingDataSource handles 'Value' queries, responding with its current
+// value, and notifies subscribers whenever its value changes.
+type changingDataSource struct {
+	mu       sync.Mutex
+	value    int64
+	notifies []func()
+}
+
+func (cds *changingDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"Value"}
+}
+
+func (cds *changingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	for _, req := range reqs {
+		drb.DataSeries(req).With(util.IntegerProperty("value", cds.value))
+	}
+	return nil
+}
+
+func (cds *changingDataSource) NotifyChanges(ctx context.Context, globalFilters map[string]*util.V, notify func()) {
+	cds.mu.Lock()
+	defer cds.mu.Unlock()
+	cds.notifies = append(cds.notifies, notify)
+}
+
+// set changes the receiver's value, notifying subscribers.
+func (cds *changingDataSource) set(value int64) {
+	cds.mu.Lock()
+	cds.value = value
+	notifies := cds.notifies
+	cds.mu.Unlock()
+	for _, notify := range notifies {
+		notify()
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	cds := &changingDataSource{}
+	qd, err := New(cds, &countingDataSource{})
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	req := &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Value",
+			SeriesName: "1",
+		}, {
+			QueryName:  "Count",
+			SeriesName: "2",
+			Options: map[string]*util.V{
+				"count": util.IntegerValue(1),
+			},
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan *util.Data)
+	done := make(chan error)
+	go func() {
+		done <- qd.Subscribe(ctx, req, func(data *util.Data) error {
+			emitted <- data
+			return nil
+		})
+	}()
+	next := func() *util.Data {
+		t.Helper()
+		select {
+		case data := <-emitted:
+			sortSeries(data.DataSeries)
+			return data
+		case err := <-done:
+			t.Fatalf("Subscribe() returned early with %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Subscribe() emitted nothing")
+		}
+		return nil
+	}
+	wantInitial := `Data:
+  Series 1
+    Root:
+      Prop 'value': 0
+  Series 2
+    Root:
+      Child:
+        Prop 'i': 0`
+	if diff := cmp.Diff(wantInitial, next().PrettyPrint()); diff != "" {
+		t.Errorf("Subscribe() emitted unexpected initial data, diff (-want +got):\n%s", diff)
+	}
+	cds.set(1)
+	// Only the changed series is emitted.
+	wantUpdate := `Data:
+  Series 1
+    Root:
+      Prop 'value': 1`
+	if diff := cmp.Diff(wantUpdate, next().PrettyPrint()); diff != "" {
+		t.Errorf("Subscribe() emitted unexpected update, diff (-want +got):\n%s", diff)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Subscribe() yielded unexpected error %s", err)
+	}
+}
+
+// cacheableChangingDataSource is a changingDataSource whose 'Value' queries
+// are cacheable.
+type cacheableChangingDataSource struct {
+	*changingDataSource
+}
+
+func (ccds cacheableChangingDataSource) CacheableQueries() map[string][]string {
+	return map[string][]string{
+		"Value": nil,
+	}
+}
+
+func TestSubscribeWithCache(t *testing.T) {
+	cds := &changingDataSource{}
+	qd, err := NewWithOptions([]Option{
+		WithCache(NewCache(1<<20, 0)),
+		WithDeduplication(),
+	}, cacheableChangingDataSource{cds})
+	if err != nil {
+		t.Fatalf("NewWithOptions() yielded unexpected error %s", err)
+	}
+	req := &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "Value",
+			SeriesName: "1",
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	emitted := make(chan *util.Data)
+	done := make(chan error)
+	go func() {
+		done <- qd.Subscribe(ctx, req, func(data *util.Data) error {
+			emitted <- data
+			return nil
+		})
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case data := <-emitted:
+			return data.PrettyPrint()
+		case err := <-done:
+			t.Fatalf("Subscribe() returned early with %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Subscribe() emitted nothing")
+		}
+		return ""
+	}
+	want := func(value int) string {
+		return fmt.Sprintf(`Data:
+  Series 1
+    Root:
+      Prop 'value': %d`, value)
+	}
+	if diff := cmp.Diff(want(0), next()); diff != "" {
+		t.Errorf("Subscribe() emitted unexpected initial data, diff (-want +got):\n%s", diff)
+	}
+	// Updates aren't served from the cache...
+	cds.set(1)
+	if diff := cmp.Diff(want(1), next()); diff != "" {
+		t.Errorf("Subscribe() emitted unexpected update, diff (-want +got):\n%s", diff)
+	}
+	// ...but do refresh it.
+	data, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(want(1), data.PrettyPrint()); diff != "" {
+		t.Errorf("HandleDataRequest() after update yielded unexpected data, diff (-want +got):\n%s", diff)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Subscribe() yielded unexpected error %s", err)
+	}
+}
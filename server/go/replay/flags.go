@@ -0,0 +1,54 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package replay
+
+import (
+	"flag"
+	"os"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+)
+
+// Flags configures request recording from command-line flags.
+type Flags struct {
+	recordRequests  *string
+	recordResponses *bool
+}
+
+// RegisterFlags registers flags configuring request recording on the
+// provided FlagSet, such as flag.CommandLine, returning a Flags from which
+// the configured WrapFuncs may be obtained once the flags are parsed.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		recordRequests:  fs.String("record_requests", "", "If set, the path to a JSONL file to which each data request, and its response's hash, is appended for later replay"),
+		recordResponses: fs.Bool("record_responses", false, "If true, --record_requests records full responses, rather than only their hashes"),
+	}
+}
+
+// WrapFuncs returns the WrapFuncs configured by the receiver's flags: a
+// Recorder's, if --record_requests is set, and none otherwise.
+func (f *Flags) WrapFuncs() ([]handlers.WrapFunc, error) {
+	if *f.recordRequests == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(*f.recordRequests, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	recorder := NewRecorder(file)
+	if *f.recordResponses {
+		recorder.WithBodies()
+	}
+	return []handlers.WrapFunc{recorder.Wrap}, nil
+}
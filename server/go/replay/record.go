@@ -0,0 +1,143 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package replay records the TraceViz data requests a server handles, with
+// their responses, and replays them against a QueryDispatcher, reporting any
+// responses that changed.  Replaying real user traffic against successive
+// backend builds helps reproduce broken views and bisect regressions.
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/util"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Record is a single recorded data request and the outcome of handling it.
+// Recordings are JSONL files of Records, one per line.
+type Record struct {
+	// When the request was handled.
+	Time time.Time
+	// The authenticated principal making the request, if any.
+	Principal *util.Principal `json:",omitempty"`
+	// The decoded request.
+	Request *util.DataRequest
+	// The response's HTTP status.
+	Status int
+	// For successful responses, the hex-encoded SHA-256 hash of the
+	// response's canonical form; see canonicalForm.
+	ResponseHash string `json:",omitempty"`
+	// For successful responses recorded with their bodies, the response.
+	Response *util.Data `json:",omitempty"`
+	// For failed responses, the response's ErrorCode.
+	ErrorCode handlers.ErrorCode `json:",omitempty"`
+}
+
+// canonicalForm returns a deterministic, human-readable form of the provided
+// response, independent of the order in which its DataSeries were assembled
+// and of its string table's order.
+func canonicalForm(data *util.Data) string {
+	sorted := &util.Data{
+		StringTable: data.StringTable,
+		DataSeries:  append([]*util.DataSeries{}, data.DataSeries...),
+	}
+	sort.SliceStable(sorted.DataSeries, func(a, b int) bool {
+		return sorted.DataSeries[a].SeriesName < sorted.DataSeries[b].SeriesName
+	})
+	return sorted.PrettyPrint()
+}
+
+// responseHash returns the hex-encoded SHA-256 hash of the provided
+// response's canonical form.
+func responseHash(data *util.Data) string {
+	sum := sha256.Sum256([]byte(canonicalForm(data)))
+	return hex.EncodeToString(sum[:])
+}
+
+// outcome is the decoded outcome of a /GetData request.
+type outcome struct {
+	status    int
+	data      *util.Data
+	errorCode handlers.ErrorCode
+}
+
+// decompress returns the provided response body, decoded from the provided
+// content coding.
+func decompress(contentEncoding string, body []byte) ([]byte, error) {
+	switch contentEncoding {
+	case "":
+		return body, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case "zstd":
+		zr, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return zr.DecodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("unsupported content coding '%s'", contentEncoding)
+	}
+}
+
+// decodeOutcome decodes a /GetData response with the provided status,
+// headers, and body, which may be compressed and in either of the JSON or
+// binary encodings.
+func decodeOutcome(status int, header http.Header, body []byte) (*outcome, error) {
+	ret := &outcome{
+		status: status,
+	}
+	body, err := decompress(header.Get("Content-Encoding"), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress response: %w", err)
+	}
+	if status != http.StatusOK {
+		errResp := &handlers.ErrorResponse{}
+		if err := json.Unmarshal(body, errResp); err == nil {
+			ret.errorCode = errResp.Code
+		}
+		return ret, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	ret.data = &util.Data{}
+	switch mediaType {
+	case handlers.BinaryContentType:
+		if err := ret.data.UnmarshalBinary(body); err != nil {
+			return nil, fmt.Errorf("failed to decode binary response: %w", err)
+		}
+	case handlers.JSONContentType:
+		if err := json.Unmarshal(body, ret.data); err != nil {
+			return nil, fmt.Errorf("failed to decode JSON response: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected response content type '%s'", mediaType)
+	}
+	return ret, nil
+}
@@ -0,0 +1,183 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Recorder records the data requests handled by the HandlerFuncs it wraps,
+// appending a Record for each to a Writer.  Only requests carrying a
+// DataRequest and answered with a complete JSON or binary response, such as
+// those to /GetData, are recorded; streamed responses, and 304 Not Modified
+// responses, which carry no body, are not.  Recorder is safe for concurrent
+// use.
+type Recorder struct {
+	withBodies bool
+	// Returns the current time.  Replaceable for testing.
+	now func() time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewRecorder returns a new Recorder appending Records, as JSONL, to the
+// provided Writer.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		now: time.Now,
+		enc: json.NewEncoder(w),
+	}
+}
+
+// WithBodies configures the receiver to record each successful response in
+// full, rather than only its hash, so that replays can show how responses
+// changed.
+func (r *Recorder) WithBodies() *Recorder {
+	r.withBodies = true
+	return r
+}
+
+// Wrap is a handlers.WrapFunc recording the requests handled by the provided
+// HandlerFunc.  It should be applied inside any authentication WrapFunc, so
+// that the requesting Principal is known and rejected requests aren't
+// recorded.
+func (r *Recorder) Wrap(next handlers.HandlerFunc) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		dataReq, ok := peekDataRequest(req)
+		if !ok {
+			next(w, req)
+			return
+		}
+		cw := &captureWriter{
+			ResponseWriter: w,
+		}
+		next(cw, req)
+		if !cw.capturing {
+			return
+		}
+		out, err := decodeOutcome(cw.status, w.Header(), cw.body.Bytes())
+		if err != nil {
+			log.Printf("Failed to record request: %s", err)
+			return
+		}
+		rec := &Record{
+			Time:      r.now(),
+			Principal: util.PrincipalFrom(req.Context()),
+			Request:   dataReq,
+			Status:    out.status,
+			ErrorCode: out.errorCode,
+		}
+		if out.data != nil {
+			rec.ResponseHash = responseHash(out.data)
+			if r.withBodies {
+				rec.Response = out.data
+			}
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if err := r.enc.Encode(rec); err != nil {
+			log.Printf("Failed to record request: %s", err)
+		}
+	}
+}
+
+// readCloser combines a Reader with a separate Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// peekDataRequest decodes the DataRequest carried by the provided request,
+// either as a JSON body or in the 'req' form value, leaving the request for
+// its handler to decode in turn.  Returns false if the request carries no
+// well-formed DataRequest.
+func peekDataRequest(req *http.Request) (*util.DataRequest, bool) {
+	var reqJSON []byte
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Method == http.MethodPost && mediaType == handlers.JSONContentType {
+		if req.Body == nil {
+			return nil, false
+		}
+		// Read no more than the handler would accept, then restore the body.
+		body, err := io.ReadAll(io.LimitReader(req.Body, handlers.DefaultMaxRequestBytes+1))
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		if err != nil || len(body) > handlers.DefaultMaxRequestBytes {
+			return nil, false
+		}
+		reqJSON = body
+	} else {
+		reqJSON = []byte(req.FormValue("req"))
+	}
+	if len(reqJSON) == 0 {
+		return nil, false
+	}
+	dataReq, err := util.DataRequestFromJSON(reqJSON)
+	if err != nil {
+		return nil, false
+	}
+	return dataReq, true
+}
+
+// captureWriter is an http.ResponseWriter capturing the status and body of
+// complete JSON or binary responses.
+type captureWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	capturing   bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.status = status
+		mediaType, _, _ := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+		cw.capturing = mediaType == handlers.JSONContentType || mediaType == handlers.BinaryContentType
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.capturing {
+		cw.body.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying ResponseWriter, if it supports flushing.
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,124 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Change describes a recorded request whose replayed outcome differs from its
+// recording.
+type Change struct {
+	// The position of the Record in the recording, from 1.
+	Index int
+	// The recorded request and outcome.
+	Record *Record
+	// A human-readable description of the change.
+	Description string
+}
+
+// Summary summarizes a replay.
+type Summary struct {
+	// The number of Records replayed.
+	Replayed int
+	// The number of Records whose outcome changed.
+	Changed int
+}
+
+// Replay re-issues each Record read from the provided recording as a /GetData
+// request to a QueryHandler serving the provided QueryDispatcher, calling
+// report with a Change for each whose outcome differs from its recording.
+// Each request is made with its recorded Principal.  Responses are compared
+// by hash; if a Record includes its response, its Change describes how the
+// response changed.
+func Replay(qd *querydispatcher.QueryDispatcher, recording io.Reader, report func(*Change)) (*Summary, error) {
+	handler := handlers.NewQueryHandler(qd).HandlersByPath()["/GetData"]
+	dec := json.NewDecoder(recording)
+	ret := &Summary{}
+	for {
+		rec := &Record{}
+		if err := dec.Decode(rec); err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return ret, fmt.Errorf("failed to read record %d: %w", ret.Replayed+1, err)
+		}
+		ret.Replayed++
+		out, err := replayRecord(handler, rec)
+		if err != nil {
+			return ret, fmt.Errorf("failed to replay record %d: %w", ret.Replayed, err)
+		}
+		if desc := describeChange(rec, out); desc != "" {
+			ret.Changed++
+			report(&Change{
+				Index:       ret.Replayed,
+				Record:      rec,
+				Description: desc,
+			})
+		}
+	}
+}
+
+// replayRecord issues the provided Record's request to the provided
+// /GetData handler, returning its outcome.
+func replayRecord(handler func(http.ResponseWriter, *http.Request), rec *Record) (*outcome, error) {
+	reqJSON, err := json.Marshal(rec.Request)
+	if err != nil {
+		return nil, err
+	}
+	req := httptest.NewRequest(http.MethodPost, "/GetData", bytes.NewReader(reqJSON))
+	req.Header.Set("Content-Type", handlers.JSONContentType)
+	if rec.Principal != nil {
+		req = req.WithContext(util.WithPrincipal(req.Context(), rec.Principal))
+	}
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	return decodeOutcome(rw.Code, rw.Header(), rw.Body.Bytes())
+}
+
+// describeChange returns a description of how the provided outcome differs
+// from the provided Record, or the empty string if it doesn't.
+func describeChange(rec *Record, out *outcome) string {
+	if rec.Status != out.status || rec.ErrorCode != out.errorCode {
+		return fmt.Sprintf("status changed from %s to %s", describeStatus(rec.Status, rec.ErrorCode), describeStatus(out.status, out.errorCode))
+	}
+	if out.data == nil || responseHash(out.data) == rec.ResponseHash {
+		return ""
+	}
+	if rec.Response == nil {
+		return "response changed"
+	}
+	diff := cmp.Diff(
+		strings.Split(canonicalForm(rec.Response), "\n"),
+		strings.Split(canonicalForm(out.data), "\n"),
+	)
+	return "response changed (-recorded +replayed):\n" + diff
+}
+
+func describeStatus(status int, code handlers.ErrorCode) string {
+	if code == "" {
+		return fmt.Sprintf("%d", status)
+	}
+	return fmt.Sprintf("%d (%s)", status, code)
+}
@@ -0,0 +1,280 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/handlers"
+	"github.com/ilhamster/traceviz/server/go/handlers/wrappers"
+	querydispatcher "github.com/ilhamster/traceviz/server/go/query_dispatcher"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// greetingDataSource greets the requesting principal with a configurable
+// greeting.
+type greetingDataSource struct {
+	greeting string
+}
+
+func (gds *greetingDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"greeting"}
+}
+
+func (gds *greetingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	name := "stranger"
+	if principal := util.PrincipalFrom(ctx); principal != nil {
+		name = principal.Name
+	}
+	for _, req := range reqs {
+		drb.DataSeries(req).With(util.StringProperty("greeting", gds.greeting+", "+name))
+	}
+	return nil
+}
+
+func dataRequestJSON(t *testing.T, queryNames ...string) string {
+	t.Helper()
+	req := &util.DataRequest{}
+	for idx, queryName := range queryNames {
+		req.SeriesRequests = append(req.SeriesRequests, &util.DataSeriesRequest{
+			QueryName:  queryName,
+			SeriesName: string(rune('a' + idx)),
+		})
+	}
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Failed to marshal DataRequest: %s", err)
+	}
+	return string(reqJSON)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	for _, test := range []struct {
+		description     string
+		withBodies      bool
+		replayGreeting  string
+		wantChanges     []string
+		wantRecordCount int
+	}{{
+		description:     "unchanged",
+		replayGreeting:  "hello",
+		wantRecordCount: 4,
+	}, {
+		description:     "changed, hashes only",
+		replayGreeting:  "howdy",
+		wantRecordCount: 4,
+		wantChanges: []string{
+			"1: response changed",
+			"2: response changed",
+			"3: response changed",
+		},
+	}, {
+		description:     "changed, with bodies",
+		withBodies:      true,
+		replayGreeting:  "howdy",
+		wantRecordCount: 4,
+		wantChanges: []string{
+			"1: response changed (-recorded +replayed):",
+			"2: response changed (-recorded +replayed):",
+			"3: response changed (-recorded +replayed):",
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gds := &greetingDataSource{greeting: "hello"}
+			qd, err := querydispatcher.New(gds)
+			if err != nil {
+				t.Fatalf("Failed to create QueryDispatcher: %s", err)
+			}
+			var recording bytes.Buffer
+			recorder := NewRecorder(&recording)
+			recorder.now = func() time.Time { return time.Unix(0, 0) }
+			if test.withBodies {
+				recorder.WithBodies()
+			}
+			// Authenticates requests bearing a token, but admits all requests.
+			authenticate := func(next handlers.HandlerFunc) handlers.HandlerFunc {
+				bearerTokens := wrappers.NewBearerTokens(map[string]string{"t0k3n": "alice"})
+				return func(w http.ResponseWriter, req *http.Request) {
+					if principal, ok := bearerTokens.Authenticate(req); ok {
+						req = req.WithContext(util.WithPrincipal(req.Context(), principal))
+					}
+					next(w, req)
+				}
+			}
+			handlersByPath := handlers.NewQueryHandler(qd).
+				Wrap(recorder.Wrap, authenticate).
+				HandlersByPath()
+			for _, req := range []*http.Request{
+				// A GET request accepting compressed, binary responses.
+				func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "/GetData?"+url.Values{"req": []string{dataRequestJSON(t, "greeting")}}.Encode(), nil)
+					req.Header.Set("Accept", handlers.BinaryContentType)
+					req.Header.Set("Accept-Encoding", "gzip")
+					return req
+				}(),
+				// A POSTed JSON request for several series.
+				func() *http.Request {
+					req := httptest.NewRequest(http.MethodPost, "/GetData", strings.NewReader(dataRequestJSON(t, "greeting", "greeting")))
+					req.Header.Set("Content-Type", handlers.JSONContentType)
+					return req
+				}(),
+				// A request by an authenticated principal.
+				func() *http.Request {
+					req := httptest.NewRequest(http.MethodGet, "/GetData?"+url.Values{"req": []string{dataRequestJSON(t, "greeting")}}.Encode(), nil)
+					req.Header.Set("Authorization", "Bearer t0k3n")
+					return req
+				}(),
+				// A failing request.
+				httptest.NewRequest(http.MethodGet, "/GetData?"+url.Values{"req": []string{dataRequestJSON(t, "farewell")}}.Encode(), nil),
+				// Requests without DataRequests aren't recorded.
+				httptest.NewRequest(http.MethodGet, "/ListQueries", nil),
+				httptest.NewRequest(http.MethodGet, "/GetData?req=%7B", nil),
+			} {
+				handlersByPath[req.URL.Path](httptest.NewRecorder(), req)
+			}
+			// Streamed responses aren't recorded.
+			req := httptest.NewRequest(http.MethodGet, "/GetDataStream?"+url.Values{"req": []string{dataRequestJSON(t, "greeting")}}.Encode(), nil)
+			handlersByPath["/GetDataStream"](httptest.NewRecorder(), req)
+
+			gds.greeting = test.replayGreeting
+			var gotChanges []string
+			summary, err := Replay(qd, &recording, func(change *Change) {
+				desc, _, _ := strings.Cut(change.Description, "\n")
+				gotChanges = append(gotChanges, strings.Join([]string{string(rune('0' + change.Index)), desc}, ": "))
+			})
+			if err != nil {
+				t.Fatalf("Replay() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.wantChanges, gotChanges); diff != "" {
+				t.Errorf("Replay() reported changes %v, diff (-want +got) %s", gotChanges, diff)
+			}
+			wantSummary := &Summary{
+				Replayed: test.wantRecordCount,
+				Changed:  len(test.wantChanges),
+			}
+			if diff := cmp.Diff(wantSummary, summary); diff != "" {
+				t.Errorf("Replay() = %v, diff (-want +got) %s", summary, diff)
+			}
+		})
+	}
+}
+
+func TestRecordZstdResponse(t *testing.T) {
+	// A greeting long enough that its response is compressed.
+	gds := &greetingDataSource{greeting: strings.Repeat("hello ", 500)}
+	qd, err := querydispatcher.New(gds)
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	var recording bytes.Buffer
+	handlersByPath := handlers.NewQueryHandler(qd).
+		Wrap(NewRecorder(&recording).Wrap).
+		HandlersByPath()
+	req := httptest.NewRequest(http.MethodGet, "/GetData?"+url.Values{"req": []string{dataRequestJSON(t, "greeting")}}.Encode(), nil)
+	req.Header.Set("Accept-Encoding", "gzip;q=0.5, zstd")
+	rec := httptest.NewRecorder()
+	handlersByPath["/GetData"](rec, req)
+	if got, want := rec.Header().Get("Content-Encoding"), "zstd"; got != want {
+		t.Errorf("Got Content-Encoding %q, want %q", got, want)
+	}
+	summary, err := Replay(qd, &recording, func(change *Change) {
+		t.Errorf("Replay() reported unexpected change %s", change.Description)
+	})
+	if err != nil {
+		t.Fatalf("Replay() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(&Summary{Replayed: 1}, summary); diff != "" {
+		t.Errorf("Replay() = %v, diff (-want +got) %s", summary, diff)
+	}
+}
+
+func TestDescribeChange(t *testing.T) {
+	greetingData := func(greeting string) *util.Data {
+		drb := util.NewDataResponseBuilder()
+		drb.DataSeries(&util.DataSeriesRequest{SeriesName: "a"}).With(util.StringProperty("greeting", greeting))
+		data, err := drb.Data()
+		if err != nil {
+			t.Fatalf("Data() yielded unexpected error %s", err)
+		}
+		return data
+	}
+	recorded, replayed := greetingData("hello"), greetingData("howdy")
+	for _, test := range []struct {
+		description string
+		rec         *Record
+		out         *outcome
+		want        string
+	}{{
+		description: "unchanged",
+		rec: &Record{
+			Status:       http.StatusOK,
+			ResponseHash: responseHash(recorded),
+		},
+		out: &outcome{
+			status: http.StatusOK,
+			data:   recorded,
+		},
+	}, {
+		description: "unchanged failure",
+		rec: &Record{
+			Status:    http.StatusBadRequest,
+			ErrorCode: handlers.ErrorCodeUnsupportedQuery,
+		},
+		out: &outcome{
+			status:    http.StatusBadRequest,
+			errorCode: handlers.ErrorCodeUnsupportedQuery,
+		},
+	}, {
+		description: "newly failing",
+		rec: &Record{
+			Status:       http.StatusOK,
+			ResponseHash: responseHash(recorded),
+		},
+		out: &outcome{
+			status:    http.StatusInternalServerError,
+			errorCode: handlers.ErrorCodeDataSourceError,
+		},
+		want: "status changed from 200 to 500 (DATA_SOURCE_ERROR)",
+	}, {
+		description: "changed response",
+		rec: &Record{
+			Status:       http.StatusOK,
+			ResponseHash: responseHash(recorded),
+			Response:     recorded,
+		},
+		out: &outcome{
+			status: http.StatusOK,
+			data:   replayed,
+		},
+		want: "response changed (-recorded +replayed):\n" + cmp.Diff(
+			strings.Split(canonicalForm(recorded), "\n"),
+			strings.Split(canonicalForm(replayed), "\n"),
+		),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if got := describeChange(test.rec, test.out); got != test.want {
+				t.Errorf("describeChange() = '%s', wanted '%s'", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package selftrace
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	categoryaxis "github.com/ilhamster/traceviz/server/go/category_axis"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/trace"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// RecentRequestsQuery is the query name of the self-trace of recently
+// handled requests.
+const RecentRequestsQuery = "selftrace.recent_requests"
+
+const (
+	// The name of a span.
+	nameKey = "selftrace_name"
+	// The error with which a span ended, if any.
+	errorKey = "selftrace_error"
+	// The duration of a span.
+	durationKey = "selftrace_duration"
+)
+
+var (
+	requestsCategory = category.New("selftrace_requests", "Requests", "Data requests handled by the server")
+
+	renderSettings = &trace.RenderSettings{
+		SpanWidthCatPx:   20,
+		SpanPaddingCatPx: 1,
+		CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+			CategoryHeaderCatPx:    20,
+			CategoryHandleValPx:    10,
+			CategoryPaddingCatPx:   3,
+			CategoryMarginValPx:    10,
+			CategoryMinWidthCatPx:  20,
+			CategoryBaseWidthValPx: 200,
+		},
+		ContinuousAxisRenderSettings: continuousaxis.NewXAxisRenderSettings(
+			continuousaxis.RenderSettings{
+				LabelHeightPx:   20,
+				MarkersHeightPx: 20,
+			},
+		),
+	}
+)
+
+// DataSource is a TraceViz data source rendering the requests retained by a
+// Recorder as a trace.  The trace has a 'Requests' category with a span for
+// each request, and a category for each data source, with a span for each of
+// its invocations whose children are any spans the data source recorded with
+// StartSpan.  Spans of concurrent requests may overlap within a category.
+type DataSource struct {
+	recorder *Recorder
+}
+
+// NewDataSource returns a new DataSource rendering the provided Recorder's
+// requests.
+func NewDataSource(recorder *Recorder) *DataSource {
+	return &DataSource{
+		recorder: recorder,
+	}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by the receiver.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{RecentRequestsQuery}
+}
+
+// HandleDataSeriesRequests handles the provided requests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	requests := ds.recorder.Recent()
+	for _, req := range reqs {
+		renderTrace(drb.DataSeries(req), requests)
+	}
+	return nil
+}
+
+// spanProperties returns the properties annotating the provided span.
+func spanProperties(span *SpanSnapshot) []util.PropertyUpdate {
+	ret := []util.PropertyUpdate{
+		util.StringProperty(nameKey, span.Name),
+		util.DurationProperty(durationKey, span.End.Sub(span.Start)),
+	}
+	if span.Err != "" {
+		ret = append(ret, util.StringProperty(errorKey, span.Err))
+	}
+	return ret
+}
+
+// spanParent is a trace Category or Span under which spans may be created.
+type spanParent interface {
+	Span(start, end time.Time, properties ...util.PropertyUpdate) *trace.Span[time.Time]
+}
+
+// renderSpan renders the provided span, and its descendants, under the
+// provided parent.
+func renderSpan(parent spanParent, span *SpanSnapshot) {
+	ts := parent.Span(span.Start, span.End, spanProperties(span)...)
+	for _, child := range span.Children {
+		renderSpan(ts, child)
+	}
+}
+
+// renderTrace renders the provided request spans as a trace into the provided
+// DataBuilder.
+func renderTrace(db util.DataBuilder, requests []*SpanSnapshot) {
+	var extents []time.Time
+	invocationsByDataSource := map[string][]*SpanSnapshot{}
+	for _, request := range requests {
+		extents = append(extents, request.Start, request.End)
+		for _, invocation := range request.Children {
+			invocationsByDataSource[invocation.Name] = append(invocationsByDataSource[invocation.Name], invocation)
+		}
+	}
+	if len(extents) == 0 {
+		now := time.Now()
+		extents = append(extents, now, now)
+	}
+	t := trace.New(
+		db,
+		continuousaxis.NewTimestampAxis(
+			category.New("x_axis", "Time", "Time at which requests were handled"),
+			extents...,
+		),
+		renderSettings,
+	)
+	requestsCat := t.Category(requestsCategory)
+	for _, request := range requests {
+		requestsCat.Span(request.Start, request.End, spanProperties(request)...)
+	}
+	dataSources := make([]string, 0, len(invocationsByDataSource))
+	for dataSource := range invocationsByDataSource {
+		dataSources = append(dataSources, dataSource)
+	}
+	sort.Strings(dataSources)
+	for _, dataSource := range dataSources {
+		cat := t.Category(category.New(dataSource, dataSource, "Invocations of data source "+dataSource))
+		for _, invocation := range invocationsByDataSource[dataSource] {
+			renderSpan(cat, invocation)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package selftrace records lightweight spans describing how a TraceViz
+// server handles its data requests, keeping the most recent requests in an
+// in-memory ring buffer, and provides a data source rendering them as a
+// TraceViz trace.  This allows TraceViz to be used to see why its own
+// requests were slow.
+//
+// A QueryDispatcher configured with querydispatcher.WithSelfTracing records a
+// span for each DataRequest it handles, and a child span for each data source
+// invocation.  Data sources handle an invocation's series together, so only
+// they know when each is done; they may record finer spans, such as for each
+// series they handle, with StartSpan.
+package selftrace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Span is a single timed operation.  Span is safe for concurrent use.
+type Span struct {
+	// The span's name.
+	Name  string
+	Start time.Time
+
+	mu       sync.Mutex
+	end      time.Time
+	err      string
+	children []*Span
+}
+
+// End ends the receiver, recording the provided error, if any.  Ending a nil
+// Span is a no-op.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.end = time.Now()
+	if err != nil {
+		s.err = err.Error()
+	}
+}
+
+// child starts and returns a new child span of the receiver.
+func (s *Span) child(name string) *Span {
+	ret := &Span{
+		Name:  name,
+		Start: time.Now(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.children = append(s.children, ret)
+	return ret
+}
+
+// SpanSnapshot is an immutable copy of a Span.
+type SpanSnapshot struct {
+	Name       string
+	Start, End time.Time
+	// The error with which the span ended, if any.
+	Err      string
+	Children []*SpanSnapshot
+}
+
+// Snapshot returns an immutable copy of the receiver and its descendants.
+// Spans not yet ended end at the time of the snapshot.
+func (s *Span) Snapshot() *SpanSnapshot {
+	s.mu.Lock()
+	ret := &SpanSnapshot{
+		Name:  s.Name,
+		Start: s.Start,
+		End:   s.end,
+		Err:   s.err,
+	}
+	children := append([]*Span{}, s.children...)
+	s.mu.Unlock()
+	if ret.End.IsZero() {
+		ret.End = time.Now()
+	}
+	for _, child := range children {
+		ret.Children = append(ret.Children, child.Snapshot())
+	}
+	return ret
+}
+
+type contextKey string
+
+var spanKey contextKey = "traceviz_selftrace_span"
+
+// StartSpan starts a new Span as a child of the Span carried by the provided
+// Context, returning a Context carrying the new Span.  If the provided
+// Context carries no Span, as when self-tracing is disabled, StartSpan
+// returns the provided Context and a nil Span, which may safely be ended.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanKey).(*Span)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.child(name)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Recorder records request spans, retaining the most recent in a ring
+// buffer.  Recorder is safe for concurrent use.
+type Recorder struct {
+	mu sync.Mutex
+	// The recorded request spans, a ring buffer whose oldest entry is at next
+	// once full.
+	requests []*Span
+	next     int
+	full     bool
+}
+
+// NewRecorder returns a new Recorder retaining the provided number of most
+// recent requests.
+func NewRecorder(capacity int) *Recorder {
+	return &Recorder{
+		requests: make([]*Span, capacity),
+	}
+}
+
+// StartRequest starts a new request Span, returning a Context carrying it.
+// The Span is retained in the receiver once passed to FinishRequest.
+func (r *Recorder) StartRequest(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		Name:  name,
+		Start: time.Now(),
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// FinishRequest ends the provided request Span with the provided error, if
+// any, and retains it, evicting the oldest retained request if the receiver
+// is full.
+func (r *Recorder) FinishRequest(span *Span, err error) {
+	span.End(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) == 0 {
+		return
+	}
+	r.requests[r.next] = span
+	r.next = (r.next + 1) % len(r.requests)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Recent returns snapshots of the receiver's retained requests, oldest first.
+func (r *Recorder) Recent() []*SpanSnapshot {
+	r.mu.Lock()
+	var spans []*Span
+	if r.full {
+		spans = append(spans, r.requests[r.next:]...)
+	}
+	spans = append(spans, r.requests[:r.next]...)
+	r.mu.Unlock()
+	ret := make([]*SpanSnapshot, len(spans))
+	for idx, span := range spans {
+		ret[idx] = span.Snapshot()
+	}
+	return ret
+}
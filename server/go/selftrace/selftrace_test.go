@@ -0,0 +1,147 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package selftrace
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/trace"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// shape returns the names, errors, and nesting of the provided spans.
+func shape(spans []*SpanSnapshot) []string {
+	var ret []string
+	var visit func(indent string, span *SpanSnapshot)
+	visit = func(indent string, span *SpanSnapshot) {
+		desc := indent + span.Name
+		if span.Err != "" {
+			desc += ": " + span.Err
+		}
+		ret = append(ret, desc)
+		for _, child := range span.Children {
+			visit(indent+"  ", child)
+		}
+	}
+	for _, span := range spans {
+		visit("", span)
+	}
+	return ret
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(2)
+	if _, span := StartSpan(context.Background(), "untraced"); span != nil {
+		t.Errorf("StartSpan() without a request span yielded a span, wanted nil")
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		ctx, req := r.StartRequest(context.Background(), name)
+		ctx, child := StartSpan(ctx, name+"/child")
+		_, grandchild := StartSpan(ctx, name+"/grandchild")
+		grandchild.End(nil)
+		child.End(errors.New("oops"))
+		r.FinishRequest(req, nil)
+	}
+	want := []string{
+		"b",
+		"  b/child: oops",
+		"    b/grandchild",
+		"c",
+		"  c/child: oops",
+		"    c/grandchild",
+	}
+	recent := r.Recent()
+	if diff := cmp.Diff(want, shape(recent)); diff != "" {
+		t.Errorf("Recent() = %v, diff (-want +got) %s", shape(recent), diff)
+	}
+	for _, span := range recent {
+		if span.End.Before(span.Start) {
+			t.Errorf("Span %s ended at %s, before it started at %s", span.Name, span.End, span.Start)
+		}
+	}
+}
+
+func TestRenderTrace(t *testing.T) {
+	at := func(ms int) time.Time {
+		return time.Unix(0, 0).Add(time.Duration(ms) * time.Millisecond)
+	}
+	requests := []*SpanSnapshot{{
+		Name:  "HandleDataRequest",
+		Start: at(0),
+		End:   at(10),
+		Children: []*SpanSnapshot{{
+			Name:  "ds1",
+			Start: at(1),
+			End:   at(9),
+			Children: []*SpanSnapshot{{
+				Name:  "q1 (series 1)",
+				Start: at(1),
+				End:   at(9),
+			}},
+		}, {
+			Name:  "ds2",
+			Start: at(1),
+			End:   at(5),
+			Err:   "oops",
+		}},
+	}}
+	got := util.NewDataResponseBuilder()
+	renderTrace(got.DataSeries(&util.DataSeriesRequest{SeriesName: "1"}), requests)
+	gotData, err := got.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+
+	want := util.NewDataResponseBuilder()
+	tr := trace.New(
+		want.DataSeries(&util.DataSeriesRequest{SeriesName: "1"}),
+		continuousaxis.NewTimestampAxis(
+			category.New("x_axis", "Time", "Time at which requests were handled"),
+			at(0), at(10),
+		),
+		renderSettings,
+	)
+	tr.Category(requestsCategory).Span(at(0), at(10),
+		util.StringProperty(nameKey, "HandleDataRequest"),
+		util.DurationProperty(durationKey, 10*time.Millisecond),
+	)
+	tr.Category(category.New("ds1", "ds1", "Invocations of data source ds1")).
+		Span(at(1), at(9),
+			util.StringProperty(nameKey, "ds1"),
+			util.DurationProperty(durationKey, 8*time.Millisecond),
+		).
+		Span(at(1), at(9),
+			util.StringProperty(nameKey, "q1 (series 1)"),
+			util.DurationProperty(durationKey, 8*time.Millisecond),
+		)
+	tr.Category(category.New("ds2", "ds2", "Invocations of data source ds2")).
+		Span(at(1), at(5),
+			util.StringProperty(nameKey, "ds2"),
+			util.DurationProperty(durationKey, 4*time.Millisecond),
+			util.StringProperty(errorKey, "oops"),
+		)
+	wantData, err := want.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("renderTrace() yielded %s, diff (-want +got) %s", gotData.PrettyPrint(), diff)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package table
+
+import (
+	"fmt"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	"github.com/ilhamster/traceviz/server/go/payload"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedTable is a table decoded from a Datum.  Each decoded element retains
+// a reader over its Datum's Properties, from which decorators may be read.
+type DecodedTable struct {
+	Properties *util.DatumReader
+	Columns    []*DecodedColumn
+	Rows       []*DecodedRow
+}
+
+// DecodedColumn is a table column decoded from a Datum.
+type DecodedColumn struct {
+	Properties *util.DatumReader
+	Category   *category.Category
+}
+
+// DecodedRow is a table row decoded from a Datum.
+type DecodedRow struct {
+	Properties *util.DatumReader
+	Cells      []*DecodedCell
+	// Readers over the row's payloads.  Their types may be read with
+	// payload.DecodeType.
+	Payloads []*util.DatumReader
+}
+
+// Cell returns the receiver's first cell in the specified column, or nil if
+// it has none.
+func (dr *DecodedRow) Cell(columnID string) *DecodedCell {
+	for _, cell := range dr.Cells {
+		if cell.ColumnID == columnID {
+			return cell
+		}
+	}
+	return nil
+}
+
+// DecodedCell is a table cell decoded from a Datum.
+type DecodedCell struct {
+	Properties *util.DatumReader
+	// The ID of the column to which the cell belongs.
+	ColumnID string
+	// Formatted is true if the cell is a FormattedCell.
+	Formatted bool
+	// The cell's value, as returned by util.DatumReader.Value.  For formatted
+	// cells, this is the format string.
+	Value    any
+	Payloads []*util.DatumReader
+}
+
+// Decode decodes the provided Datum, which must have been populated by New
+// and whose strings are in the provided string table, back into a
+// DecodedTable.
+func Decode(d *util.Datum, stringTable []string) (*DecodedTable, error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	children := dr.Children()
+	if len(children) == 0 {
+		return nil, fmt.Errorf("table has no header row")
+	}
+	ret := &DecodedTable{
+		Properties: dr,
+	}
+	for _, colDr := range children[0].Children() {
+		cat, err := category.Decode(colDr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode table column: %w", err)
+		}
+		ret.Columns = append(ret.Columns, &DecodedColumn{
+			Properties: colDr,
+			Category:   cat,
+		})
+	}
+	for _, rowDr := range children[1:] {
+		row := &DecodedRow{
+			Properties: rowDr,
+		}
+		for _, child := range rowDr.Children() {
+			if child.Has(payload.TypeKey) {
+				row.Payloads = append(row.Payloads, child)
+				continue
+			}
+			cell, err := decodeCell(child)
+			if err != nil {
+				return nil, err
+			}
+			row.Cells = append(row.Cells, cell)
+		}
+		ret.Rows = append(ret.Rows, row)
+	}
+	return ret, nil
+}
+
+func decodeCell(dr *util.DatumReader) (*DecodedCell, error) {
+	columnIDs, err := category.DecodeTags(dr)
+	if err != nil {
+		return nil, err
+	}
+	if len(columnIDs) != 1 {
+		return nil, fmt.Errorf("table cell must belong to exactly one column, got %v", columnIDs)
+	}
+	ret := &DecodedCell{
+		Properties: dr,
+		ColumnID:   columnIDs[0],
+		Formatted:  dr.Has(formattedCellKey),
+		Payloads:   dr.Children(),
+	}
+	if ret.Formatted {
+		ret.Value, err = dr.String(formattedCellKey)
+	} else {
+		ret.Value, err = dr.Value(cellKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode table cell in column '%s': %w", ret.ColumnID, err)
+	}
+	return ret, nil
+}
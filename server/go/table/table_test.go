@@ -16,6 +16,7 @@ package table
 import (
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ilhamster/traceviz/server/go/category"
 	"github.com/ilhamster/traceviz/server/go/payload"
 	testutil "github.com/ilhamster/traceviz/server/go/test_util"
@@ -153,3 +154,68 @@ func TestColumns(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		table := New(db, renderSettings, puzzleCol, answerCol, hintCol)
+		table.Row(
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(answerCol, util.Integer(12)),
+		)
+		row := table.Row(
+			FormattedCell(puzzleCol, "$(first) and $(second)",
+				util.StringProperty("first", "S"),
+				util.StringProperty("second", "W"),
+			),
+		).With(util.StringProperty("row_color", "red"))
+		payload.New(row.AddCell(Cell(hintCol, util.Strings("count", "letters"))), "thumbnail")
+		payload.New(row, "details")
+	})
+	got, err := Decode(root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	var gotColumnIDs []string
+	for _, col := range got.Columns {
+		gotColumnIDs = append(gotColumnIDs, col.Category.ID())
+	}
+	if diff := cmp.Diff([]string{"puzzle", "answer", "hint"}, gotColumnIDs); diff != "" {
+		t.Errorf("Decode() yielded columns %v, diff (-want +got) %s", gotColumnIDs, diff)
+	}
+	// Render each row as a map from column ID to value.
+	var gotRows []map[string]any
+	for _, row := range got.Rows {
+		gotRow := map[string]any{}
+		for _, cell := range row.Cells {
+			gotRow[cell.ColumnID] = cell.Value
+		}
+		gotRows = append(gotRows, gotRow)
+	}
+	wantRows := []map[string]any{{
+		"puzzle": "I in a F",
+		"answer": int64(12),
+	}, {
+		"puzzle": "$(first) and $(second)",
+		"hint":   []string{"count", "letters"},
+	}}
+	if diff := cmp.Diff(wantRows, gotRows); diff != "" {
+		t.Errorf("Decode() yielded rows %v, diff (-want +got) %s", gotRows, diff)
+	}
+	row := got.Rows[1]
+	if color, err := row.Properties.String("row_color"); err != nil || color != "red" {
+		t.Errorf("Decode() yielded row color %q (err %v), want 'red'", color, err)
+	}
+	if len(row.Payloads) != 1 {
+		t.Errorf("Decode() yielded %d row payloads, want 1", len(row.Payloads))
+	}
+	puzzle := row.Cell("puzzle")
+	if !puzzle.Formatted {
+		t.Errorf("Decode() yielded unformatted cell, want formatted")
+	}
+	if first, err := puzzle.Properties.String("first"); err != nil || first != "S" {
+		t.Errorf("Decode() yielded format argument %q (err %v), want 'S'", first, err)
+	}
+	if len(row.Cell("hint").Payloads) != 1 {
+		t.Errorf("Decode() yielded %d cell payloads, want 1", len(row.Cell("hint").Payloads))
+	}
+}
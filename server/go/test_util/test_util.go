@@ -206,3 +206,17 @@ func NewDataBuilder() util.DataBuilder {
 		},
 	)
 }
+
+// Build builds a single data series with the provided callback, returning its
+// root Datum and the response's string table.  It facilitates testing typed
+// decoders against builder output.
+func Build(t *testing.T, build func(db util.DataBuilder)) (*util.Datum, []string) {
+	t.Helper()
+	drb := util.NewDataResponseBuilder()
+	build(drb.DataSeries(&util.DataSeriesRequest{}))
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("encountered unexpected error building the response: %s", err)
+	}
+	return data.DataSeries[0].Root, data.StringTable
+}
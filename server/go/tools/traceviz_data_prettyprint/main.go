@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,8 +12,12 @@ import (
 )
 
 func prettyPrintDataResponse(input io.Reader) (string, error) {
+	bufInput := bufio.NewReader(input)
+	if magic, err := bufInput.Peek(len(util.BinaryMagic)); err == nil && string(magic) == util.BinaryMagic {
+		return prettyPrintBinaryDataResponse(bufInput)
+	}
 	var data util.Data
-	decoder := json.NewDecoder(input)
+	decoder := json.NewDecoder(bufInput)
 	decoder.UseNumber()
 	if err := decoder.Decode(&data); err != nil {
 		return "", fmt.Errorf("decode TraceViz data response: %w", err)
@@ -24,21 +29,18 @@ func prettyPrintDataResponse(input io.Reader) (string, error) {
 		}
 		return "", fmt.Errorf("decode TraceViz data response: trailing JSON value")
 	}
-	pretty, err := prettyPrintWellFormedData(data)
-	if err != nil {
-		return "", err
-	}
-	return pretty, nil
+	return data.PrettyPrint(), nil
 }
 
-func prettyPrintWellFormedData(data util.Data) (pretty string, err error) {
-	defer func() {
-		if recovered := recover(); recovered != nil {
-			// The util.Data pretty-printer assumes a well-formed TraceViz
-			// response; convert malformed-response panics into CLI errors.
-			err = fmt.Errorf("pretty-print TraceViz data response: malformed response: %v", recovered)
-		}
-	}()
+func prettyPrintBinaryDataResponse(input io.Reader) (string, error) {
+	contents, err := io.ReadAll(input)
+	if err != nil {
+		return "", fmt.Errorf("read TraceViz data response: %w", err)
+	}
+	var data util.Data
+	if err := data.UnmarshalBinary(contents); err != nil {
+		return "", fmt.Errorf("decode binary TraceViz data response: %w", err)
+	}
 	return data.PrettyPrint(), nil
 }
 
@@ -55,8 +57,8 @@ func inputReader(path string) (io.ReadCloser, error) {
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [response.json|-]\n", os.Args[0])
-		fmt.Fprintln(flag.CommandLine.Output(), "Pretty-prints a compact TraceViz /GetData JSON response.")
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [response|-]\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Pretty-prints a compact TraceViz /GetData JSON or binary response.")
 	}
 	flag.Parse()
 	if flag.NArg() > 1 {
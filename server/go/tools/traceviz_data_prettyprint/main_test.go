@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"strings"
 	"testing"
+
+	"github.com/ilhamster/traceviz/server/go/util"
 )
 
 func TestPrettyPrintDataResponse(t *testing.T) {
@@ -75,7 +79,45 @@ func TestPrettyPrintDataResponseRejectsMalformedTraceVizData(t *testing.T) {
 	if err == nil {
 		t.Fatal("prettyPrintDataResponse() succeeded, want malformed response error")
 	}
-	if !strings.Contains(err.Error(), "malformed response") {
-		t.Fatalf("prettyPrintDataResponse() error = %q, want malformed response error", err)
+	var decodeErr *util.DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("prettyPrintDataResponse() error = %q, want a *util.DecodeError", err)
+	}
+	if decodeErr.Path != "DataSeries[0].Root" {
+		t.Errorf("prettyPrintDataResponse() error path = %q, want %q", decodeErr.Path, "DataSeries[0].Root")
+	}
+}
+
+func TestPrettyPrintBinaryDataResponse(t *testing.T) {
+	data := &util.Data{
+		StringTable: []string{"greeting", "Hello!", "count"},
+		DataSeries: []*util.DataSeries{{
+			SeriesName: "trace",
+			Root: &util.Datum{
+				Children: []*util.Datum{{
+					Properties: map[int64]*util.V{
+						0: util.StringIndexValue(1),
+						2: util.IntegerValue(100),
+					},
+				}},
+			},
+		}},
+	}
+	bin, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() failed: %v", err)
+	}
+	want := `Data:
+  Series trace
+    Root:
+      Child:
+        Prop 'count': 100
+        Prop 'greeting': 'Hello!'`
+	got, err := prettyPrintDataResponse(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatalf("prettyPrintDataResponse() failed: %v", err)
+	}
+	if got != want {
+		t.Fatalf("prettyPrintDataResponse() =\n%s\nwant:\n%s", got, want)
 	}
 }
@@ -0,0 +1,205 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/payload"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedTrace is a Trace decoded from a Datum.  Each decoded element retains
+// a reader over its Datum's Properties, from which decorators may be read.
+type DecodedTrace[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Axis       *continuousaxis.DecodedAxis[T]
+	Categories []*DecodedCategory[T]
+}
+
+// DecodedCategory is a trace Category decoded from a Datum.
+type DecodedCategory[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Category   *category.Category
+	Categories []*DecodedCategory[T]
+	Spans      []*DecodedSpan[T]
+}
+
+// DecodedSpan is a Span decoded from a Datum.
+type DecodedSpan[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Start, End T
+	Spans      []*DecodedSpan[T]
+	Subspans   []*DecodedSubspan[T]
+	// Readers over the span's payloads.  Their types may be read with
+	// payload.DecodeType.
+	Payloads []*util.DatumReader
+}
+
+// DecodedSubspan is a Subspan decoded from a Datum.
+type DecodedSubspan[T float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Start, End T
+	Payloads   []*util.DatumReader
+}
+
+// Decode decodes the provided Datum, which must have been populated by a
+// Trace[T] and whose strings are in the provided string table, back into a
+// DecodedTrace.
+func Decode[T float64 | time.Duration | time.Time](d *util.Datum, stringTable []string) (*DecodedTrace[T], error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	axis, err := continuousaxis.Decode[T](dr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trace axis: %w", err)
+	}
+	ret := &DecodedTrace[T]{
+		Properties: dr,
+		Axis:       axis,
+	}
+	for _, child := range dr.Children() {
+		if err := expectNodeType(child, categoryNodeType); err != nil {
+			return nil, err
+		}
+		cat, err := decodeCategory[T](child)
+		if err != nil {
+			return nil, err
+		}
+		ret.Categories = append(ret.Categories, cat)
+	}
+	return ret, nil
+}
+
+// nodeType returns the trace node type of the provided DatumReader, or false
+// if it is not a trace node (for instance, if it is a payload).
+func nodeType(dr *util.DatumReader) (traceNodeType, bool, error) {
+	if !dr.Has(nodeTypeKey) {
+		return 0, false, nil
+	}
+	nt, err := dr.Integer(nodeTypeKey)
+	return traceNodeType(nt), true, err
+}
+
+func expectNodeType(dr *util.DatumReader, want traceNodeType) error {
+	nt, ok, err := nodeType(dr)
+	if err != nil {
+		return err
+	}
+	if !ok || nt != want {
+		return fmt.Errorf("expected a trace node of type %d", want)
+	}
+	return nil
+}
+
+func decodeCategory[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedCategory[T], error) {
+	cat, err := category.Decode(dr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trace category: %w", err)
+	}
+	ret := &DecodedCategory[T]{
+		Properties: dr,
+		Category:   cat,
+	}
+	for _, child := range dr.Children() {
+		nt, ok, err := nodeType(child)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nil, fmt.Errorf("trace category '%s' has unexpected non-trace child", cat.ID())
+		case nt == categoryNodeType:
+			subcat, err := decodeCategory[T](child)
+			if err != nil {
+				return nil, err
+			}
+			ret.Categories = append(ret.Categories, subcat)
+		case nt == spanNodeType:
+			span, err := decodeSpan[T](child)
+			if err != nil {
+				return nil, err
+			}
+			ret.Spans = append(ret.Spans, span)
+		default:
+			return nil, fmt.Errorf("trace category '%s' has unexpected child of type %d", cat.ID(), nt)
+		}
+	}
+	return ret, nil
+}
+
+func decodeExtent[T float64 | time.Duration | time.Time](dr *util.DatumReader) (start, end T, err error) {
+	if start, err = continuousaxis.DecodeValue[T](dr, startKey); err != nil {
+		return start, end, err
+	}
+	end, err = continuousaxis.DecodeValue[T](dr, endKey)
+	return start, end, err
+}
+
+func decodeSpan[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedSpan[T], error) {
+	ret := &DecodedSpan[T]{
+		Properties: dr,
+	}
+	var err error
+	if ret.Start, ret.End, err = decodeExtent[T](dr); err != nil {
+		return nil, err
+	}
+	for _, child := range dr.Children() {
+		nt, ok, err := nodeType(child)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			if _, err := payload.DecodeType(child); err != nil {
+				return nil, err
+			}
+			ret.Payloads = append(ret.Payloads, child)
+		case nt == spanNodeType:
+			span, err := decodeSpan[T](child)
+			if err != nil {
+				return nil, err
+			}
+			ret.Spans = append(ret.Spans, span)
+		case nt == subspanNodeType:
+			subspan, err := decodeSubspan[T](child)
+			if err != nil {
+				return nil, err
+			}
+			ret.Subspans = append(ret.Subspans, subspan)
+		default:
+			return nil, fmt.Errorf("trace span has unexpected child of type %d", nt)
+		}
+	}
+	return ret, nil
+}
+
+func decodeSubspan[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedSubspan[T], error) {
+	ret := &DecodedSubspan[T]{
+		Properties: dr,
+	}
+	var err error
+	if ret.Start, ret.End, err = decodeExtent[T](dr); err != nil {
+		return nil, err
+	}
+	for _, child := range dr.Children() {
+		if _, err := payload.DecodeType(child); err != nil {
+			return nil, fmt.Errorf("trace subspans may only have payload children: %w", err)
+		}
+		ret.Payloads = append(ret.Payloads, child)
+	}
+	return ret, nil
+}
@@ -0,0 +1,179 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Property keys annotating the aggregate spans emitted by an Aggregator.
+const (
+	// AggregateCountKey is an aggregate span's count of merged spans, as an
+	// integer.
+	AggregateCountKey = "trace_aggregate_count"
+	// AggregateWidthKey is the total width of an aggregate span's merged
+	// spans, as a Duration for Duration and Time axes and as a double for
+	// float64 axes.  It may be less than the aggregate span's own width, if
+	// the merged spans had gaps between them.
+	AggregateWidthKey = "trace_aggregate_width"
+)
+
+// LevelOfDetail describes the resolution at which a trace will be rendered:
+// the temporal domain that will be shown, and the number of pixels across
+// which it will be drawn.
+type LevelOfDetail[T float64 | time.Duration | time.Time] struct {
+	// The extent of the temporal domain to be shown.
+	Start, End T
+	// The width, in pixels, of the rendered temporal domain.
+	WidthPx int64
+	// The width, in pixels, below which spans are merged.  If not positive, 1
+	// is used.
+	MinimumSpanWidthPx float64
+}
+
+// threshold returns the width, in axis units, below which spans are merged.
+// It is 0, so that no spans are merged, if the receiver's domain or width is
+// empty.
+func (lod *LevelOfDetail[T]) threshold() float64 {
+	if lod.WidthPx <= 0 {
+		return 0
+	}
+	minWidthPx := lod.MinimumSpanWidthPx
+	if minWidthPx <= 0 {
+		minWidthPx = 1
+	}
+	domainWidth := width(lod.Start, lod.End)
+	if domainWidth <= 0 {
+		return 0
+	}
+	return domainWidth / float64(lod.WidthPx) * minWidthPx
+}
+
+// Aggregator adds spans to a Category at a LevelOfDetail, merging runs of
+// adjacent spans that are each too narrow to be seen into single aggregate
+// spans.  This allows data sources to serve very large traces without sending
+// the frontend more spans than it can usefully draw.
+//
+// Spans should be added to an Aggregator in nondecreasing order of start
+// point; a span starting before the current run never joins it.  A narrow
+// span joins the current run if it starts
+// within one minimum span width of the run's start; otherwise, the run is
+// complete.  A run of more than one span is emitted as a single span from its
+// first span's start to its last span's end, with properties:
+//   - AggregateCountKey: the number of spans in the run, as an integer;
+//   - AggregateWidthKey: the total width of the spans in the run, as a
+//     Duration for Duration and Time axes and as a double for float64 axes;
+//
+// and the Aggregator's aggregate properties.  A run of a single span is
+// emitted as that span, with its own properties.
+type Aggregator[T float64 | time.Duration | time.Time] struct {
+	cat                 *Category[T]
+	threshold           float64
+	aggregateProperties []util.PropertyUpdate
+	run                 *spanRun[T]
+}
+
+// spanRun is a run of narrow, adjacent spans awaiting aggregation.
+type spanRun[T float64 | time.Duration | time.Time] struct {
+	start, end T
+	count      int64
+	totalWidth float64
+	// The properties of the run's first span, used if the run has no others.
+	properties []util.PropertyUpdate
+}
+
+// NewAggregator returns a new Aggregator adding spans to the provided
+// Category at the provided LevelOfDetail.  The provided aggregate properties,
+// such as decorators, are applied to each aggregate span.  Flush must be
+// called once all spans have been added.
+func NewAggregator[T float64 | time.Duration | time.Time](cat *Category[T], lod *LevelOfDetail[T], aggregateProperties ...util.PropertyUpdate) *Aggregator[T] {
+	return &Aggregator[T]{
+		cat:                 cat,
+		threshold:           lod.threshold(),
+		aggregateProperties: aggregateProperties,
+	}
+}
+
+// Span adds a span with the specified start and end points to the receiver.
+// If the span is wide enough to be seen, it is created under the receiver's
+// Category and returned, and may be given children, subspans, and payloads.
+// Otherwise, it is held for aggregation and nil is returned.
+func (a *Aggregator[T]) Span(start, end T, properties ...util.PropertyUpdate) *Span[T] {
+	spanWidth := width(start, end)
+	if spanWidth >= a.threshold {
+		a.Flush()
+		return a.cat.Span(start, end, properties...)
+	}
+	if a.run != nil && !before(start, a.run.start) && width(a.run.start, start) < a.threshold {
+		if before(a.run.end, end) {
+			a.run.end = end
+		}
+		a.run.count++
+		a.run.totalWidth += spanWidth
+		return nil
+	}
+	a.Flush()
+	a.run = &spanRun[T]{
+		start:      start,
+		end:        end,
+		count:      1,
+		totalWidth: spanWidth,
+		properties: properties,
+	}
+	return nil
+}
+
+// Flush emits any span held for aggregation.  It must be called after the
+// last span is added to the receiver.
+func (a *Aggregator[T]) Flush() {
+	run := a.run
+	if run == nil {
+		return
+	}
+	a.run = nil
+	if run.count == 1 {
+		a.cat.Span(run.start, run.end, run.properties...)
+		return
+	}
+	a.cat.Span(run.start, run.end,
+		util.IntegerProperty(AggregateCountKey, run.count),
+		widthProperty[T](AggregateWidthKey, run.totalWidth),
+	).With(a.aggregateProperties...)
+}
+
+// width returns the extent from start to end, in axis units: nanoseconds for
+// Duration and Time axes.
+func width[T float64 | time.Duration | time.Time](start, end T) float64 {
+	switch sv := any(start).(type) {
+	case float64:
+		return any(end).(float64) - sv
+	case time.Duration:
+		return float64(any(end).(time.Duration) - sv)
+	case time.Time:
+		return float64(any(end).(time.Time).Sub(sv))
+	}
+	return 0
+}
+
+// widthProperty returns a property with the provided key and width, in the
+// units of T's axis.
+func widthProperty[T float64 | time.Duration | time.Time](key string, w float64) util.PropertyUpdate {
+	var zero T
+	if _, ok := any(zero).(float64); ok {
+		return util.DoubleProperty(key, w)
+	}
+	return util.DurationProperty(key, time.Duration(w))
+}
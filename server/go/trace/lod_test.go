@@ -0,0 +1,156 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	testutil "github.com/ilhamster/traceviz/server/go/test_util"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestAggregator(t *testing.T) {
+	var (
+		xAxis = category.New("x_axis", "Trace time", "Time from start of trace")
+		cpu0  = category.New("cpu0", "CPU 0", "CPU 0")
+		pid   = func(pid int64) util.PropertyUpdate {
+			return util.IntegerProperty("pid", pid)
+		}
+		gray = util.StringProperty("color", "gray")
+	)
+	for _, test := range []struct {
+		description string
+		buildTrace  func(db util.DataBuilder)
+		buildWant   func(db util.DataBuilder)
+	}{{
+		// At 10px across 100ns, spans narrower than 10ns are merged.
+		description: "duration axis",
+		buildTrace: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			agg := NewAggregator(trace.Category(cpu0), &LevelOfDetail[time.Duration]{
+				Start:   ns(0),
+				End:     ns(100),
+				WidthPx: 10,
+			})
+			if span := agg.Span(ns(0), ns(20), pid(1)); span != nil {
+				span.Span(ns(5), ns(15), pid(2))
+			} else {
+				t.Errorf("Span() yielded nil for a wide span, wanted a span")
+			}
+			agg.Span(ns(20), ns(22), pid(3))
+			agg.Span(ns(22), ns(25), pid(4))
+			if span := agg.Span(ns(25), ns(29), pid(5)); span != nil {
+				t.Errorf("Span() yielded a span for a narrow span, wanted nil")
+			}
+			agg.Span(ns(30), ns(31), pid(6))
+			agg.Span(ns(50), ns(80), pid(7))
+			agg.Flush()
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			cat := trace.Category(cpu0)
+			cat.Span(ns(0), ns(20), pid(1)).Span(ns(5), ns(15), pid(2))
+			cat.Span(ns(20), ns(29),
+				util.IntegerProperty(AggregateCountKey, 3),
+				util.DurationProperty(AggregateWidthKey, ns(9)),
+			)
+			cat.Span(ns(30), ns(31), pid(6))
+			cat.Span(ns(50), ns(80), pid(7))
+		},
+	}, {
+		// At 100px across 100 units, spans narrower than 2px are merged.
+		description: "float64 axis with minimum width and aggregate properties",
+		buildTrace: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDoubleAxis(xAxis, 0, 100), rs)
+			agg := NewAggregator(trace.Category(cpu0), &LevelOfDetail[float64]{
+				Start:              0,
+				End:                100,
+				WidthPx:            100,
+				MinimumSpanWidthPx: 2,
+			}, gray)
+			agg.Span(0, .5, pid(1))
+			agg.Span(.5, 1, pid(2))
+			agg.Span(1, 1.5, pid(3))
+			agg.Span(1.5, 2.5, pid(4))
+			agg.Span(2.5, 3, pid(5))
+			agg.Span(3, 3.5, pid(6))
+			agg.Flush()
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDoubleAxis(xAxis, 0, 100), rs)
+			cat := trace.Category(cpu0)
+			cat.Span(0, 2.5,
+				util.IntegerProperty(AggregateCountKey, 4),
+				util.DoubleProperty(AggregateWidthKey, 2.5),
+				gray,
+			)
+			cat.Span(2.5, 3.5,
+				util.IntegerProperty(AggregateCountKey, 2),
+				util.DoubleProperty(AggregateWidthKey, 1),
+				gray,
+			)
+		},
+	}, {
+		description: "timestamp axis with out-of-order span",
+		buildTrace: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewTimestampAxis(xAxis, ts(0), ts(1000)), rs)
+			agg := NewAggregator(trace.Category(cpu0), &LevelOfDetail[time.Time]{
+				Start:   ts(0),
+				End:     ts(1000),
+				WidthPx: 100,
+			})
+			agg.Span(ts(500), ts(501), pid(1))
+			agg.Span(ts(502), ts(503), pid(2))
+			agg.Span(ts(400), ts(401), pid(3))
+			agg.Flush()
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewTimestampAxis(xAxis, ts(0), ts(1000)), rs)
+			cat := trace.Category(cpu0)
+			cat.Span(ts(500), ts(503),
+				util.IntegerProperty(AggregateCountKey, 2),
+				util.DurationProperty(AggregateWidthKey, ns(2)),
+			)
+			cat.Span(ts(400), ts(401), pid(3))
+		},
+	}, {
+		description: "empty domain merges nothing",
+		buildTrace: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			agg := NewAggregator(trace.Category(cpu0), &LevelOfDetail[time.Duration]{
+				Start:   ns(0),
+				End:     ns(0),
+				WidthPx: 100,
+			})
+			agg.Span(ns(0), ns(1), pid(1))
+			agg.Span(ns(1), ns(2), pid(2))
+			agg.Flush()
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			cat := trace.Category(cpu0)
+			cat.Span(ns(0), ns(1), pid(1))
+			cat.Span(ns(1), ns(2), pid(2))
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if err := testutil.CompareResponses(t, test.buildTrace, test.buildWant); err != nil {
+				t.Fatalf("encountered unexpected error building the trace: %s", err)
+			}
+		})
+	}
+}
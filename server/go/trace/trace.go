@@ -88,6 +88,16 @@
 // which allocate the payload and return its *util.DataBuilder.  See payload.go
 // for more detail.
 //
+// A trace of very many spans may have far more spans than the rendered trace
+// has pixels.  Data sources may add such spans via an Aggregator at a
+// LevelOfDetail describing the rendered temporal domain and its width in
+// pixels, which merges runs of adjacent spans too narrow to be seen into
+// single aggregate spans annotated with their span count and total width:
+//
+//	agg := NewAggregator(cat, &LevelOfDetail[T]{Start: start, End: end, WidthPx: widthPx})
+//	agg.Span(start, end, properties...)
+//	agg.Flush()
+//
 // This format supports composition, or 'unioning', on the frontend, which
 // allows multiple distinct data sources to contribute to a single trace view
 // on the frontend without needing to be aware of one another.  The union U of
@@ -118,6 +128,9 @@
 //     single category is probably a sign that the data sources themselves
 //     should be merged.
 //
+// Union performs the same composition on the backend, enforcing these
+// restrictions, for traces that must be served as a single data series.
+//
 // Encoded into the TraceViz data model, a trace is:
 //
 // trace
@@ -143,6 +156,10 @@
 //	  * nodeTypeKey: spanNodeType
 //	  * startKey: axis value type
 //	  * endKey: axis value type
+//	  * for aggregate spans emitted by an Aggregator:
+//	    * AggregateCountKey: integer
+//	    * AggregateWidthKey: Duration for Duration and Time axes, double for
+//	      float64 axes
 //	  * <decorators>
 //	children
 //	  * repeated spans, subspans, and payloads
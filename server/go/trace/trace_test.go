@@ -463,3 +463,96 @@ func TestTraceData(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	rpcA := category.New("rpc a", "RPC a", "RPC a")
+	rpcB := category.New("rpc b", "RPC a/b", "RPC a/b")
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		trace := New(db, continuousaxis.NewDurationAxis(cat, ns(0), ns(100)), rs)
+		a := trace.Category(rpcA)
+		aSpan := a.Span(ns(0), ns(100)).With(util.StringProperty("function", "a"))
+		aSpan.Subspan(ns(10), ns(20)).With(util.StringProperty("state", "waiting"))
+		aSpan.Span(ns(30), ns(40))
+		payload.New(aSpan, "thumbnail")
+		a.Category(rpcB).Span(ns(50), ns(60))
+	})
+	got, err := Decode[time.Duration](root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if got.Axis.Category.ID() != cat.ID() || got.Axis.Min != 0 || got.Axis.Max != ns(100) {
+		t.Errorf("Decode() yielded axis %v, want a %s axis from 0 to 100ns", got.Axis, cat.ID())
+	}
+	if len(got.Categories) != 1 || got.Categories[0].Category.ID() != rpcA.ID() {
+		t.Fatalf("Decode() yielded categories %v, want only %s", got.Categories, rpcA.ID())
+	}
+	a := got.Categories[0]
+	if len(a.Spans) != 1 || len(a.Categories) != 1 {
+		t.Fatalf("Decode() yielded %d spans and %d subcategories under %s, want 1 of each", len(a.Spans), len(a.Categories), rpcA.ID())
+	}
+	aSpan := a.Spans[0]
+	if aSpan.Start != 0 || aSpan.End != ns(100) {
+		t.Errorf("Decode() yielded span from %v to %v, want 0 to 100ns", aSpan.Start, aSpan.End)
+	}
+	if fn, err := aSpan.Properties.String("function"); err != nil || fn != "a" {
+		t.Errorf("Decode() yielded span function %q (err %v), want 'a'", fn, err)
+	}
+	if len(aSpan.Subspans) != 1 || aSpan.Subspans[0].Start != ns(10) || aSpan.Subspans[0].End != ns(20) {
+		t.Errorf("Decode() yielded subspans %v, want one from 10ns to 20ns", aSpan.Subspans)
+	}
+	if len(aSpan.Spans) != 1 || aSpan.Spans[0].Start != ns(30) || aSpan.Spans[0].End != ns(40) {
+		t.Errorf("Decode() yielded child spans %v, want one from 30ns to 40ns", aSpan.Spans)
+	}
+	if len(aSpan.Payloads) != 1 {
+		t.Fatalf("Decode() yielded %d payloads, want 1", len(aSpan.Payloads))
+	}
+	if pt, err := payload.DecodeType(aSpan.Payloads[0]); err != nil || pt != "thumbnail" {
+		t.Errorf("Decode() yielded payload type %q (err %v), want 'thumbnail'", pt, err)
+	}
+	b := a.Categories[0]
+	if b.Category.ID() != rpcB.ID() || len(b.Spans) != 1 || b.Spans[0].Start != ns(50) {
+		t.Errorf("Decode() yielded subcategory %v, want %s with a span at 50ns", b, rpcB.ID())
+	}
+	if _, err := Decode[time.Time](root, st); err == nil {
+		t.Errorf("Decode() with the wrong axis type succeeded, wanted error")
+	}
+}
+
+func TestDecodeAggregateSpan(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	cpu0 := category.New("cpu0", "CPU 0", "CPU 0")
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		trace := New(db, continuousaxis.NewDurationAxis(cat, ns(0), ns(100)), rs)
+		// At 10px across 100ns, spans narrower than 10ns are merged.
+		agg := NewAggregator(trace.Category(cpu0), &LevelOfDetail[time.Duration]{
+			Start:   ns(0),
+			End:     ns(100),
+			WidthPx: 10,
+		}, util.StringProperty("color", "gray"))
+		agg.Span(ns(20), ns(22))
+		agg.Span(ns(23), ns(25))
+		agg.Span(ns(26), ns(29))
+		agg.Flush()
+	})
+	got, err := Decode[time.Duration](root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if len(got.Categories) != 1 || len(got.Categories[0].Spans) != 1 {
+		t.Fatalf("Decode() yielded categories %v, want one with a single aggregate span", got.Categories)
+	}
+	span := got.Categories[0].Spans[0]
+	if span.Start != ns(20) || span.End != ns(29) {
+		t.Errorf("Decode() yielded aggregate span from %v to %v, want 20ns to 29ns", span.Start, span.End)
+	}
+	if count, err := span.Properties.Integer(AggregateCountKey); err != nil || count != 3 {
+		t.Errorf("Decode() yielded aggregate count %d (err %v), want 3", count, err)
+	}
+	if width, err := span.Properties.Duration(AggregateWidthKey); err != nil || width != ns(7) {
+		t.Errorf("Decode() yielded aggregate width %v (err %v), want 7ns", width, err)
+	}
+	if color, err := span.Properties.String("color"); err != nil || color != "gray" {
+		t.Errorf("Decode() yielded aggregate color %q (err %v), want 'gray'", color, err)
+	}
+}
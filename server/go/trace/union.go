@@ -0,0 +1,209 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// Union populates the provided data builder, which is dedicated to the trace
+// as for New, with the union of the provided traces, as described in the
+// package documentation.  Each trace must have been populated by a Trace[T],
+// and its strings must be in the corresponding string table.  Union enforces
+// the documented restrictions, returning an error if:
+//   - the traces have different axis types;
+//   - two traces define a category with the same path but with different
+//     display names or descriptions;
+//   - two traces both have spans at the same category path.
+//
+// Union cannot tell whether Duration-type axes share a start point; data
+// sources must ensure that they do.  Where the traces' other properties,
+// such as decorators or render settings, differ, those of earlier traces take
+// precedence.  If any error is returned, the data builder may be partially
+// populated.
+func Union(db util.DataBuilder, traces []*util.Datum, stringTables [][]string) error {
+	if len(traces) != len(stringTables) {
+		return fmt.Errorf("can't union %d traces with %d string tables", len(traces), len(stringTables))
+	}
+	if len(traces) == 0 {
+		return fmt.Errorf("can't union no traces")
+	}
+	nodes := make([]*unionNode, len(traces))
+	for idx, d := range traces {
+		dr, err := util.NewDatumReader(d, stringTables[idx])
+		if err != nil {
+			return fmt.Errorf("can't union trace %d: %w", idx, err)
+		}
+		nodes[idx] = &unionNode{
+			traceIdx:    idx,
+			dr:          dr,
+			stringTable: stringTables[idx],
+		}
+	}
+	// The first trace determines the axis type.
+	if _, err := continuousaxis.Decode[float64](nodes[0].dr); err == nil {
+		return union[float64](db, nodes)
+	}
+	if _, err := continuousaxis.Decode[time.Duration](nodes[0].dr); err == nil {
+		return union[time.Duration](db, nodes)
+	}
+	if _, err := continuousaxis.Decode[time.Time](nodes[0].dr); err == nil {
+		return union[time.Time](db, nodes)
+	}
+	return fmt.Errorf("can't union trace 0: it has no valid axis")
+}
+
+// unionNode is a trace node contributed to a union by one of its traces.
+type unionNode struct {
+	traceIdx    int
+	dr          *util.DatumReader
+	stringTable []string
+}
+
+// before returns true if a precedes b.
+func before[T float64 | time.Duration | time.Time](a, b T) bool {
+	switch av := any(a).(type) {
+	case float64:
+		return av < any(b).(float64)
+	case time.Duration:
+		return av < any(b).(time.Duration)
+	case time.Time:
+		return av.Before(any(b).(time.Time))
+	}
+	return false
+}
+
+// union populates db with the union of the provided trace roots, whose axes
+// are of type T.
+func union[T float64 | time.Duration | time.Time](db util.DataBuilder, roots []*unionNode) error {
+	var axisCat *category.Category
+	var min, max T
+	for idx, root := range roots {
+		axis, err := continuousaxis.Decode[T](root.dr)
+		if err != nil {
+			return fmt.Errorf("can't union trace %d: %w", root.traceIdx, err)
+		}
+		if idx == 0 {
+			axisCat, min, max = axis.Category, axis.Min, axis.Max
+			continue
+		}
+		if before(axis.Min, min) {
+			min = axis.Min
+		}
+		if before(max, axis.Max) {
+			max = axis.Max
+		}
+	}
+	if err := unionProperties(db, roots); err != nil {
+		return err
+	}
+	axis, err := continuousaxis.NewAxis[T](axisCat, min, max)
+	if err != nil {
+		return err
+	}
+	db.With(axis.Define())
+	return unionChildren(db, roots, nil)
+}
+
+// unionProperties populates db with the properties of the provided nodes.
+// Where several nodes have the same property, the earliest takes precedence.
+func unionProperties(db util.DataBuilder, nodes []*unionNode) error {
+	for idx := len(nodes) - 1; idx >= 0; idx-- {
+		node := nodes[idx]
+		props := &util.Datum{
+			Properties: node.dr.Datum().Properties,
+		}
+		if err := util.Replay(db, props, node.stringTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// categoryGroup is a set of identical categories, at the same path, from
+// different traces.
+type categoryGroup struct {
+	cat   *category.Category
+	nodes []*unionNode
+}
+
+// unionChildren populates db with the union of the children of the provided
+// nodes, all at the provided category path.  Child categories with the same
+// ID are merged, and child spans are included as they are.
+func unionChildren(db util.DataBuilder, nodes []*unionNode, path []string) error {
+	// The union's children, in order of first appearance.  Each is either a
+	// *categoryGroup or a *unionNode.
+	var children []any
+	groupsByID := map[string]*categoryGroup{}
+	spansFrom := -1
+	for _, node := range nodes {
+		for _, child := range node.dr.Children() {
+			childNode := &unionNode{
+				traceIdx:    node.traceIdx,
+				dr:          child,
+				stringTable: node.stringTable,
+			}
+			nt, ok, err := nodeType(child)
+			if err != nil {
+				return err
+			}
+			if !ok || nt != categoryNodeType {
+				if spansFrom >= 0 && spansFrom != node.traceIdx {
+					return fmt.Errorf("can't union traces %d and %d: both have spans in category '%s'", spansFrom, node.traceIdx, strings.Join(path, "/"))
+				}
+				spansFrom = node.traceIdx
+				children = append(children, childNode)
+				continue
+			}
+			cat, err := category.Decode(child)
+			if err != nil {
+				return fmt.Errorf("failed to decode trace category: %w", err)
+			}
+			group, ok := groupsByID[cat.ID()]
+			if !ok {
+				group = &categoryGroup{
+					cat: cat,
+				}
+				groupsByID[cat.ID()] = group
+				children = append(children, group)
+			} else if cat.DisplayName() != group.cat.DisplayName() || cat.Description() != group.cat.Description() {
+				return fmt.Errorf("can't union traces %d and %d: they define category '%s' differently", group.nodes[0].traceIdx, node.traceIdx, strings.Join(append(path, cat.ID()), "/"))
+			}
+			group.nodes = append(group.nodes, childNode)
+		}
+	}
+	for _, child := range children {
+		switch c := child.(type) {
+		case *categoryGroup:
+			catDb := db.Child()
+			if err := unionProperties(catDb, c.nodes); err != nil {
+				return err
+			}
+			if err := unionChildren(catDb, c.nodes, append(path[:len(path):len(path)], c.cat.ID())); err != nil {
+				return err
+			}
+		case *unionNode:
+			if err := util.Replay(db.Child(), c.dr.Datum(), c.stringTable); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
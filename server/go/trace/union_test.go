@@ -0,0 +1,168 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	testutil "github.com/ilhamster/traceviz/server/go/test_util"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+func TestUnion(t *testing.T) {
+	var (
+		xAxis    = category.New("x_axis", "Trace time", "Time from start of trace")
+		machineA = category.New("machine a", "Machine A", "Machine A")
+		machineB = category.New("machine b", "Machine B", "Machine B")
+		cpu0     = category.New("cpu0", "CPU 0", "CPU 0")
+		cpu1     = category.New("cpu1", "CPU 1", "CPU 1")
+		fn       = func(name string) util.PropertyUpdate {
+			return util.StringProperty("function", name)
+		}
+	)
+	for _, test := range []struct {
+		description string
+		traces      []func(db util.DataBuilder)
+		buildWant   func(db util.DataBuilder)
+	}{{
+		description: "disjoint categories",
+		traces: []func(db util.DataBuilder){
+			func(db util.DataBuilder) {
+				trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(10), ns(50)), rs)
+				trace.Category(machineA).Span(ns(10), ns(50), fn("a"))
+			},
+			func(db util.DataBuilder) {
+				trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(40)), rs)
+				trace.Category(machineB).Span(ns(0), ns(40), fn("b"))
+			},
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(50)), rs)
+			trace.Category(machineA).Span(ns(10), ns(50), fn("a"))
+			trace.Category(machineB).Span(ns(0), ns(40), fn("b"))
+		},
+	}, {
+		description: "shared categories",
+		traces: []func(db util.DataBuilder){
+			func(db util.DataBuilder) {
+				trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+				trace.Category(machineA).Category(cpu0).Span(ns(0), ns(100), fn("a"))
+			},
+			func(db util.DataBuilder) {
+				trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+				a := trace.Category(machineA)
+				a.Category(cpu1).Span(ns(20), ns(30), fn("b"))
+				a.Category(cpu0).Category(cpu1).Span(ns(30), ns(40), fn("c"))
+			},
+		},
+		buildWant: func(db util.DataBuilder) {
+			trace := New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			a := trace.Category(machineA)
+			a0 := a.Category(cpu0)
+			a0.Span(ns(0), ns(100), fn("a"))
+			a0.Category(cpu1).Span(ns(30), ns(40), fn("c"))
+			a.Category(cpu1).Span(ns(20), ns(30), fn("b"))
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var traces []*util.Datum
+			var stringTables [][]string
+			for _, buildTrace := range test.traces {
+				root, st := testutil.Build(t, buildTrace)
+				traces = append(traces, root)
+				stringTables = append(stringTables, st)
+			}
+			if err := testutil.CompareResponses(t,
+				func(db util.DataBuilder) {
+					if err := Union(db, traces, stringTables); err != nil {
+						t.Fatalf("Union() yielded unexpected error %s", err)
+					}
+				},
+				test.buildWant,
+			); err != nil {
+				t.Fatalf("encountered unexpected error building the trace: %s", err)
+			}
+		})
+	}
+}
+
+func TestUnionErrors(t *testing.T) {
+	var (
+		xAxis    = category.New("x_axis", "Trace time", "Time from start of trace")
+		machineA = category.New("machine a", "Machine A", "Machine A")
+		cpu0     = category.New("cpu0", "CPU 0", "CPU 0")
+	)
+	for _, test := range []struct {
+		description string
+		traces      []func(db util.DataBuilder)
+		wantErr     string
+	}{{
+		description: "mismatched axis types",
+		traces: []func(db util.DataBuilder){
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs)
+			},
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewTimestampAxis(xAxis, ts(0), ts(100)), rs)
+			},
+		},
+		wantErr: "can't union trace 1: expected a duration axis, got a timestamp axis",
+	}, {
+		description: "differently-defined categories",
+		traces: []func(db util.DataBuilder){
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs).
+					Category(machineA).Category(cpu0)
+			},
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs).
+					Category(machineA).Category(category.New("cpu0", "CPU zero", "CPU 0"))
+			},
+		},
+		wantErr: "can't union traces 0 and 1: they define category 'machine a/cpu0' differently",
+	}, {
+		description: "conflicting spans",
+		traces: []func(db util.DataBuilder){
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs).
+					Category(machineA).Span(ns(0), ns(10))
+			},
+			func(db util.DataBuilder) {
+				New(db, continuousaxis.NewDurationAxis(xAxis, ns(0), ns(100)), rs).
+					Category(machineA).Span(ns(20), ns(30))
+			},
+		},
+		wantErr: "can't union traces 0 and 1: both have spans in category 'machine a'",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var traces []*util.Datum
+			var stringTables [][]string
+			for _, buildTrace := range test.traces {
+				root, st := testutil.Build(t, buildTrace)
+				traces = append(traces, root)
+				stringTables = append(stringTables, st)
+			}
+			err := Union(testutil.NewDataBuilder(), traces, stringTables)
+			if err == nil {
+				t.Fatalf("Union() yielded no error, wanted %q", test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantErr, err.Error()); diff != "" {
+				t.Errorf("Union() yielded error %q, diff (-want +got) %s", err, diff)
+			}
+		})
+	}
+}
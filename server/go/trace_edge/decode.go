@@ -0,0 +1,87 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package traceedge
+
+import (
+	"fmt"
+	"time"
+
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/payload"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedNode is a trace edge Node decoded from a Datum.  It retains a reader
+// over its Datum's Properties, from which decorators may be read.
+type DecodedNode[T float64 | time.Duration | time.Time] struct {
+	Properties      *util.DatumReader
+	ID              string
+	Start           T
+	EndpointNodeIDs []string
+}
+
+// Decode decodes the provided payload, which must have been populated by
+// New[T], back into a DecodedNode.
+func Decode[T float64 | time.Duration | time.Time](dr *util.DatumReader) (*DecodedNode[T], error) {
+	payloadType, err := payload.DecodeType(dr)
+	if err != nil {
+		return nil, err
+	}
+	if payloadType != PayloadType {
+		return nil, fmt.Errorf("expected a '%s' payload, got '%s'", PayloadType, payloadType)
+	}
+	ret := &DecodedNode[T]{
+		Properties: dr,
+	}
+	if ret.ID, err = dr.String(nodeIDKey); err != nil {
+		return nil, err
+	}
+	if ret.Start, err = continuousaxis.DecodeValue[T](dr, startKey); err != nil {
+		return nil, err
+	}
+	if ret.EndpointNodeIDs, err = dr.Strings(endpointNodeIDsKey); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// DecodeAll decodes all trace edge Nodes anywhere beneath the provided Datum,
+// whose strings are in the provided string table, in depth-first order.
+func DecodeAll[T float64 | time.Duration | time.Time](d *util.Datum, stringTable []string) ([]*DecodedNode[T], error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*DecodedNode[T]
+	var visit func(dr *util.DatumReader) error
+	visit = func(dr *util.DatumReader) error {
+		if payloadType, err := payload.DecodeType(dr); err == nil && payloadType == PayloadType {
+			node, err := Decode[T](dr)
+			if err != nil {
+				return err
+			}
+			ret = append(ret, node)
+		}
+		for _, child := range dr.Children() {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := visit(dr); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ilhamster/traceviz/server/go/category"
 	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
 	"github.com/ilhamster/traceviz/server/go/payload"
@@ -72,3 +73,37 @@ func TestTraceEdges(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	axis := continuousaxis.NewDurationAxis(cat, 300*time.Nanosecond)
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		New(axis, newTestPayloader(db), 50*time.Second, "A", "B", "C").With(
+			util.StringProperty("label", "Howdy partner I'm A"),
+		)
+		payload.New(newTestPayloader(db), "thumbnail")
+		New(axis, newTestPayloader(db.Child()), 60*time.Second, "B")
+	})
+	got, err := DecodeAll[time.Duration](root, st)
+	if err != nil {
+		t.Fatalf("DecodeAll() yielded unexpected error %s", err)
+	}
+	type edgeNode struct {
+		ID              string
+		Start           time.Duration
+		EndpointNodeIDs []string
+	}
+	var gotNodes []edgeNode
+	for _, node := range got {
+		gotNodes = append(gotNodes, edgeNode{node.ID, node.Start, node.EndpointNodeIDs})
+	}
+	wantNodes := []edgeNode{
+		{"A", 50 * time.Second, []string{"B", "C"}},
+		{"B", 60 * time.Second, []string{}},
+	}
+	if diff := cmp.Diff(wantNodes, gotNodes); diff != "" {
+		t.Errorf("DecodeAll() yielded nodes %v, diff (-want +got) %s", gotNodes, diff)
+	}
+	if label, err := got[0].Properties.String("label"); err != nil || label != "Howdy partner I'm A" {
+		t.Errorf("DecodeAll() yielded label %q (err %v), want 'Howdy partner I'm A'", label, err)
+	}
+}
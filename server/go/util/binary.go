@@ -0,0 +1,584 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// BinaryMagic prefixes every binary-encoded Data.  It allows readers to
+// distinguish the binary encoding from the JSON encoding.
+const BinaryMagic = "TVZ\x01"
+
+// The binary encoding is a length-prefixed varint format mirroring the
+// compact JSON encoding.  Signed integers are zig-zag varints; lengths,
+// counts, and value types are unsigned varints; strings are a length followed
+// by that many bytes.  A Data is encoded as:
+//
+//	Data        = BinaryMagic, count, string*, count, DataSeries*
+//	DataSeries  = string, Datum, SeriesError, Truncation
+//	SeriesError = 0 |                         ; if the series succeeded
+//	              1, string, string, string   ; query, data source, message
+//	Truncation  = 0 |                         ; if the series is complete
+//	              1, string, uvarint, uvarint ; limit, dropped datums, updates
+//	Datum       = count, (varint key, V)*, count, Datum*
+//	V           = uvarint valueType, payload
+//
+// where the payload of a V depends on its valueType:
+//
+//	unset                         ; empty
+//	string                        ; string
+//	string index, integer         ; varint
+//	duration                      ; varint nanoseconds
+//	strings                       ; count, string*
+//	string indices, integers      ; count, varint*
+//	double                        ; 8 bytes, little-endian IEEE 754
+//	timestamp                     ; varint seconds, varint nanoseconds
+//	bool                          ; uvarint 0 (false) or 1 (true)
+//	list                          ; count, V*
+//	map                           ; count, (string key, V)*
+//
+// As in the JSON encoding, Datum property keys and string index values refer
+// into the Data's string table.
+
+// binaryWriter accumulates a binary encoding.
+type binaryWriter struct {
+	buf     bytes.Buffer
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func (bw *binaryWriter) uvarint(u uint64) {
+	n := binary.PutUvarint(bw.scratch[:], u)
+	bw.buf.Write(bw.scratch[:n])
+}
+
+func (bw *binaryWriter) varint(i int64) {
+	n := binary.PutVarint(bw.scratch[:], i)
+	bw.buf.Write(bw.scratch[:n])
+}
+
+func (bw *binaryWriter) str(s string) {
+	bw.uvarint(uint64(len(s)))
+	bw.buf.WriteString(s)
+}
+
+func (bw *binaryWriter) double(f float64) {
+	binary.LittleEndian.PutUint64(bw.scratch[:8], math.Float64bits(f))
+	bw.buf.Write(bw.scratch[:8])
+}
+
+func (bw *binaryWriter) value(v *V) error {
+	bw.uvarint(uint64(v.T))
+	switch v.T {
+	case unsetValue:
+	case StringValueType:
+		str, ok := v.V.(string)
+		if !ok {
+			return fmt.Errorf("string Value holds %T", v.V)
+		}
+		bw.str(str)
+	case StringIndexValueType, IntegerValueType:
+		i, ok := v.V.(int64)
+		if !ok {
+			return fmt.Errorf("integer Value holds %T", v.V)
+		}
+		bw.varint(i)
+	case DurationValueType:
+		dur, ok := v.V.(time.Duration)
+		if !ok {
+			return fmt.Errorf("duration Value holds %T", v.V)
+		}
+		bw.varint(int64(dur))
+	case StringsValueType:
+		strs, ok := v.V.([]string)
+		if !ok {
+			return fmt.Errorf("strings Value holds %T", v.V)
+		}
+		bw.uvarint(uint64(len(strs)))
+		for _, str := range strs {
+			bw.str(str)
+		}
+	case StringIndicesValueType, IntegersValueType:
+		ints, ok := v.V.([]int64)
+		if !ok {
+			return fmt.Errorf("integers Value holds %T", v.V)
+		}
+		bw.uvarint(uint64(len(ints)))
+		for _, i := range ints {
+			bw.varint(i)
+		}
+	case DoubleValueType:
+		f, ok := v.V.(float64)
+		if !ok {
+			return fmt.Errorf("double Value holds %T", v.V)
+		}
+		bw.double(f)
+	case TimestampValueType:
+		ts, ok := v.V.(timestamp)
+		if !ok {
+			return fmt.Errorf("timestamp Value holds %T", v.V)
+		}
+		bw.varint(ts.UnixSeconds)
+		bw.varint(ts.UnixNanos)
+	case BoolValueType:
+		b, ok := v.V.(bool)
+		if !ok {
+			return fmt.Errorf("bool Value holds %T", v.V)
+		}
+		if b {
+			bw.uvarint(1)
+		} else {
+			bw.uvarint(0)
+		}
+	case ListValueType:
+		vals, ok := v.V.([]*V)
+		if !ok {
+			return fmt.Errorf("list Value holds %T", v.V)
+		}
+		bw.uvarint(uint64(len(vals)))
+		for _, val := range vals {
+			if err := bw.value(val); err != nil {
+				return err
+			}
+		}
+	case MapValueType:
+		vals, ok := v.V.(map[string]*V)
+		if !ok {
+			return fmt.Errorf("map Value holds %T", v.V)
+		}
+		keys := make([]string, 0, len(vals))
+		for k := range vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		bw.uvarint(uint64(len(keys)))
+		for _, k := range keys {
+			bw.str(k)
+			if err := bw.value(vals[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot binary-encode Value of unknown type %d", v.T)
+	}
+	return nil
+}
+
+func (bw *binaryWriter) datum(d *Datum) error {
+	// Emit properties in increasing key order, as MarshalJSON does, so that
+	// encodings are deterministic.
+	bw.uvarint(uint64(len(d.Properties)))
+	for _, k := range sortedKeys(d.Properties) {
+		bw.varint(k)
+		if err := bw.value(d.Properties[k]); err != nil {
+			return err
+		}
+	}
+	bw.uvarint(uint64(len(d.Children)))
+	for _, child := range d.Children {
+		if err := bw.datum(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the receiving Data in the binary encoding described
+// above.
+func (d *Data) MarshalBinary() ([]byte, error) {
+	bw := &binaryWriter{}
+	bw.buf.WriteString(BinaryMagic)
+	bw.uvarint(uint64(len(d.StringTable)))
+	for _, str := range d.StringTable {
+		bw.str(str)
+	}
+	bw.uvarint(uint64(len(d.DataSeries)))
+	for _, series := range d.DataSeries {
+		bw.str(series.SeriesName)
+		if err := bw.datum(series.Root); err != nil {
+			return nil, fmt.Errorf("series '%s': %w", series.SeriesName, err)
+		}
+		if series.Error == nil {
+			bw.uvarint(0)
+		} else {
+			bw.uvarint(1)
+			bw.str(series.Error.QueryName)
+			bw.str(series.Error.DataSource)
+			bw.str(series.Error.Message)
+		}
+		if series.Truncated == nil {
+			bw.uvarint(0)
+		} else {
+			bw.uvarint(1)
+			bw.str(series.Truncated.Limit)
+			bw.uvarint(uint64(series.Truncated.DroppedDatums))
+			bw.uvarint(uint64(series.Truncated.DroppedUpdates))
+		}
+	}
+	return bw.buf.Bytes(), nil
+}
+
+// maxBinaryNestingDepth is the deepest that Datums, and list and map values,
+// may nest in binary input, as for encoding/json, so that hostile input
+// can't exhaust the stack.
+const maxBinaryNestingDepth = 10000
+
+// binaryReader consumes a binary encoding.
+type binaryReader struct {
+	r *bytes.Reader
+	// The current nesting depth of Datums and values.
+	depth int
+	// Whether maxBinaryNestingDepth was exceeded.
+	tooDeep bool
+}
+
+// enter descends one level into nested Datums or values, returning a
+// DecodeError if this exceeds maxBinaryNestingDepth.  Each successful call
+// must be paired with a call to leave.
+func (br *binaryReader) enter() error {
+	if br.depth == maxBinaryNestingDepth {
+		br.tooDeep = true
+		return decodeErrorf("Datums and values nest more than %d deep", maxBinaryNestingDepth)
+	}
+	br.depth++
+	return nil
+}
+
+// leave ascends one level from nested Datums or values.
+func (br *binaryReader) leave() {
+	br.depth--
+}
+
+// at is like at(elem, err), but leaves the errors of too-deeply nested input
+// unlocated, since locating them within each enclosing level would take time
+// quadratic in the depth.
+func (br *binaryReader) at(elem string, err error) error {
+	if br.tooDeep {
+		return err
+	}
+	return at(elem, err)
+}
+
+func (br *binaryReader) uvarint() (uint64, error) {
+	u, err := binary.ReadUvarint(br.r)
+	if err != nil {
+		return 0, readError(err)
+	}
+	return u, nil
+}
+
+func (br *binaryReader) varint() (int64, error) {
+	i, err := binary.ReadVarint(br.r)
+	if err != nil {
+		return 0, readError(err)
+	}
+	return i, nil
+}
+
+// count reads a length or count, rejecting any that cannot possibly be
+// satisfied by the remaining input (every counted element occupies at least
+// one byte).
+func (br *binaryReader) count() (int, error) {
+	u, err := br.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if u > uint64(br.r.Len()) {
+		return 0, decodeErrorf("length %d exceeds remaining input", u)
+	}
+	return int(u), nil
+}
+
+func (br *binaryReader) str() (string, error) {
+	n, err := br.count()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(br.r, buf); err != nil {
+		return "", readError(err)
+	}
+	return string(buf), nil
+}
+
+func (br *binaryReader) double() (float64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(br.r, buf[:]); err != nil {
+		return 0, readError(err)
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:])), nil
+}
+
+func (br *binaryReader) value() (*V, error) {
+	if err := br.enter(); err != nil {
+		return nil, err
+	}
+	defer br.leave()
+	t, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	v := &V{T: valueType(t)}
+	switch v.T {
+	case unsetValue:
+	case StringValueType:
+		v.V, err = br.str()
+	case StringIndexValueType, IntegerValueType:
+		v.V, err = br.varint()
+	case DurationValueType:
+		var durNs int64
+		durNs, err = br.varint()
+		v.V = time.Duration(durNs)
+	case StringsValueType:
+		var n int
+		if n, err = br.count(); err != nil {
+			return nil, err
+		}
+		strs := make([]string, n)
+		for idx := range strs {
+			if strs[idx], err = br.str(); err != nil {
+				return nil, err
+			}
+		}
+		v.V = strs
+	case StringIndicesValueType, IntegersValueType:
+		var n int
+		if n, err = br.count(); err != nil {
+			return nil, err
+		}
+		ints := make([]int64, n)
+		for idx := range ints {
+			if ints[idx], err = br.varint(); err != nil {
+				return nil, err
+			}
+		}
+		v.V = ints
+	case DoubleValueType:
+		v.V, err = br.double()
+	case TimestampValueType:
+		var ts timestamp
+		if ts.UnixSeconds, err = br.varint(); err != nil {
+			return nil, err
+		}
+		if ts.UnixNanos, err = br.varint(); err != nil {
+			return nil, err
+		}
+		v.V = ts
+	case BoolValueType:
+		var b uint64
+		if b, err = br.uvarint(); err != nil {
+			return nil, err
+		}
+		if b > 1 {
+			return nil, decodeErrorf("invalid bool value %d", b)
+		}
+		v.V = b == 1
+	case ListValueType:
+		var n int
+		if n, err = br.count(); err != nil {
+			return nil, err
+		}
+		vals := make([]*V, n)
+		for idx := range vals {
+			if vals[idx], err = br.value(); err != nil {
+				return nil, br.at(idxElem(idx), err)
+			}
+		}
+		v.V = vals
+	case MapValueType:
+		var n int
+		if n, err = br.count(); err != nil {
+			return nil, err
+		}
+		vals := make(map[string]*V, n)
+		for i := 0; i < n; i++ {
+			k, err := br.str()
+			if err != nil {
+				return nil, err
+			}
+			if vals[k], err = br.value(); err != nil {
+				return nil, br.at(keyElem(k), err)
+			}
+		}
+		v.V = vals
+	default:
+		return nil, decodeErrorf("unknown value type %d", t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (br *binaryReader) datum() (*Datum, error) {
+	if err := br.enter(); err != nil {
+		return nil, err
+	}
+	defer br.leave()
+	numProps, err := br.count()
+	if err != nil {
+		return nil, err
+	}
+	d := &Datum{
+		Properties: make(map[int64]*V, numProps),
+	}
+	for i := 0; i < numProps; i++ {
+		k, err := br.varint()
+		if err != nil {
+			return nil, err
+		}
+		if d.Properties[k], err = br.value(); err != nil {
+			return nil, br.at(fmt.Sprintf("Properties[%d]", k), err)
+		}
+	}
+	numChildren, err := br.count()
+	if err != nil {
+		return nil, err
+	}
+	d.Children = make([]*Datum, numChildren)
+	for idx := range d.Children {
+		if d.Children[idx], err = br.datum(); err != nil {
+			return nil, br.at(fmt.Sprintf("Children[%d]", idx), err)
+		}
+	}
+	return d, nil
+}
+
+func (br *binaryReader) seriesError() (*SeriesError, error) {
+	present, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	switch present {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, decodeErrorf("invalid series error marker %d", present)
+	}
+	se := &SeriesError{}
+	if se.QueryName, err = br.str(); err != nil {
+		return nil, err
+	}
+	if se.DataSource, err = br.str(); err != nil {
+		return nil, err
+	}
+	if se.Message, err = br.str(); err != nil {
+		return nil, err
+	}
+	return se, nil
+}
+
+func (br *binaryReader) truncation() (*Truncation, error) {
+	present, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	switch present {
+	case 0:
+		return nil, nil
+	case 1:
+	default:
+		return nil, decodeErrorf("invalid truncation marker %d", present)
+	}
+	tr := &Truncation{}
+	if tr.Limit, err = br.str(); err != nil {
+		return nil, err
+	}
+	droppedDatums, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	droppedUpdates, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	tr.DroppedDatums, tr.DroppedUpdates = int64(droppedDatums), int64(droppedUpdates)
+	return tr, nil
+}
+
+// UnmarshalBinary decodes the provided binary-encoded bytes into the
+// receiving Data, returning a DecodeError if they do not describe a
+// well-formed Data.
+func (d *Data) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(BinaryMagic)) {
+		return decodeErrorf("binary TraceViz data must begin with %q", BinaryMagic)
+	}
+	br := &binaryReader{
+		r: bytes.NewReader(data[len(BinaryMagic):]),
+	}
+	numStrs, err := br.count()
+	if err != nil {
+		return err
+	}
+	st := make([]string, numStrs)
+	for idx := range st {
+		if st[idx], err = br.str(); err != nil {
+			return err
+		}
+	}
+	numSeries, err := br.count()
+	if err != nil {
+		return err
+	}
+	series := make([]*DataSeries, numSeries)
+	for idx := range series {
+		elem := fmt.Sprintf("DataSeries[%d]", idx)
+		seriesName, err := br.str()
+		if err != nil {
+			return at(elem, at("SeriesName", err))
+		}
+		root, err := br.datum()
+		if err != nil {
+			return at(elem, at("Root", err))
+		}
+		series[idx] = &DataSeries{
+			SeriesName: seriesName,
+			Root:       root,
+		}
+		if series[idx].Error, err = br.seriesError(); err != nil {
+			return at(elem, at("Error", err))
+		}
+		if series[idx].Truncated, err = br.truncation(); err != nil {
+			return at(elem, at("Truncated", err))
+		}
+	}
+	if br.r.Len() != 0 {
+		return decodeErrorf("%d trailing bytes after binary TraceViz data", br.r.Len())
+	}
+	decoded := &Data{
+		StringTable: st,
+		DataSeries:  series,
+	}
+	if err := decoded.validate(); err != nil {
+		return err
+	}
+	*d = *decoded
+	return nil
+}
+
+// readError converts an error encountered while reading binary input into a
+// DecodeError.
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return decodeErrorf("unexpected end of input")
+	}
+	return decodeErrorf("%s", err)
+}
@@ -0,0 +1,222 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBinaryEncodingAndDecoding(t *testing.T) {
+	// Test that a round-trip to and from the binary encoding yields the same
+	// Data as before, and the same Data as a round-trip through JSON.
+	d := &Data{
+		StringTable: []string{
+			"stridx", "stridxs", "int", "ints", "dbl", "dur", "ts", "str", "strs",
+			"unset", "bool", "list", "map", "hello", "goodbye",
+		},
+		DataSeries: []*DataSeries{
+			&DataSeries{
+				SeriesName: "0",
+				Root: &Datum{
+					Properties: map[int64]*V{},
+					Children: []*Datum{
+						&Datum{
+							Properties: map[int64]*V{
+								0:  StringIndexValue(13),
+								1:  StringIndicesValue(13, 14),
+								2:  IntValue(-100),
+								3:  IntsValue(50, -150, 250),
+								4:  DoubleValue(3.14159),
+								5:  DurationValue(time.Millisecond * 150),
+								6:  TimestampValue(time.Unix(500, 100)),
+								7:  StringValue("hello"),
+								8:  StringsValue("hello", "goodbye"),
+								9:  &V{},
+								10: BoolValue(true),
+								11: ListValue(IntValue(1), StringValue("a"), ListValue()),
+								12: MapValue(map[string]*V{
+									"yes": BoolValue(true),
+									"no":  BoolValue(false),
+								}),
+							},
+							Children: []*Datum{},
+						},
+					},
+				},
+			},
+			&DataSeries{
+				SeriesName: "empty",
+				Root: &Datum{
+					Properties: map[int64]*V{},
+					Children:   []*Datum{},
+				},
+			},
+			&DataSeries{
+				SeriesName: "failed",
+				Root: &Datum{
+					Properties: map[int64]*V{},
+					Children:   []*Datum{},
+				},
+				Error: &SeriesError{
+					QueryName:  "query",
+					DataSource: "source",
+					Message:    "oops",
+				},
+			},
+			&DataSeries{
+				SeriesName: "truncated",
+				Root: &Datum{
+					Properties: map[int64]*V{},
+					Children:   []*Datum{},
+				},
+				Truncated: &Truncation{
+					Limit:          MaxDatumsLimit,
+					DroppedDatums:  100,
+					DroppedUpdates: 250,
+				},
+			},
+		},
+	}
+	bin, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() yielded unexpected error %s", err)
+	}
+	gotData := &Data{}
+	if err := gotData.UnmarshalBinary(bin); err != nil {
+		t.Fatalf("UnmarshalBinary() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(d, gotData); diff != "" {
+		t.Errorf("Binary round-trip yielded %v, diff (-orig +decoded) %s", gotData, diff)
+	}
+	dj, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("failed to marshal Data: %s", err)
+	}
+	if len(bin) >= len(dj) {
+		t.Errorf("Binary encoding was %d bytes, wanted fewer than JSON's %d", len(bin), len(dj))
+	}
+}
+
+func TestBinaryDecodingErrors(t *testing.T) {
+	d := &Data{
+		StringTable: []string{"int", "strs"},
+		DataSeries: []*DataSeries{
+			&DataSeries{
+				SeriesName: "0",
+				Root: &Datum{
+					Properties: map[int64]*V{
+						0: IntValue(100),
+						1: StringsValue("a", "b"),
+					},
+				},
+			},
+		},
+	}
+	bin, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary() yielded unexpected error %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		input       []byte
+	}{{
+		description: "empty",
+		input:       []byte{},
+	}, {
+		description: "JSON",
+		input:       []byte(`{"StringTable":[],"DataSeries":[]}`),
+	}, {
+		description: "truncated",
+		input:       bin[:len(bin)-1],
+	}, {
+		description: "trailing bytes",
+		input:       append(append([]byte{}, bin...), 0),
+	}, {
+		description: "oversized length",
+		input:       []byte(BinaryMagic + "\xff\xff\xff\xff\x0f"),
+	}, {
+		description: "unknown value type",
+		input:       []byte(BinaryMagic + "\x00\x01\x00\x01\x00\x7f\x00"),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if err := (&Data{}).UnmarshalBinary(test.input); err == nil {
+				t.Errorf("UnmarshalBinary() succeeded, wanted error")
+			}
+		})
+	}
+}
+
+func TestBinaryDecodingNestingDepth(t *testing.T) {
+	// nestedData returns a binary encoding of a single DataSeries whose root
+	// Datum has a chain of depth-1 descendants.
+	nestedData := func(depth int) []byte {
+		bin := []byte(BinaryMagic + "\x00\x01\x00")
+		for i := 1; i < depth; i++ {
+			bin = append(bin, 0, 1)
+		}
+		return append(bin, 0, 0, 0, 0)
+	}
+	// nestedValue returns a binary encoding of a single DataSeries whose root
+	// Datum has a single property, a list value in which lists nest to the
+	// specified depth.
+	nestedValue := func(depth int) []byte {
+		bin := []byte(BinaryMagic + "\x01\x01k\x01\x00\x01\x00")
+		for i := 1; i < depth; i++ {
+			bin = append(bin, byte(ListValueType), 1)
+		}
+		return append(bin, byte(ListValueType), 0, 0, 0, 0)
+	}
+	for _, test := range []struct {
+		description string
+		input       []byte
+		wantErr     bool
+	}{{
+		description: "deepest Datums",
+		input:       nestedData(maxBinaryNestingDepth),
+	}, {
+		description: "too-deep Datums",
+		input:       nestedData(maxBinaryNestingDepth + 1),
+		wantErr:     true,
+	}, {
+		description: "deepest values",
+		// The root Datum is the first level.
+		input: nestedValue(maxBinaryNestingDepth - 1),
+	}, {
+		description: "too-deep values",
+		input:       nestedValue(maxBinaryNestingDepth),
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			err := (&Data{}).UnmarshalBinary(test.input)
+			if !test.wantErr {
+				if err != nil {
+					t.Errorf("UnmarshalBinary() yielded unexpected error %s", err)
+				}
+				return
+			}
+			var de *DecodeError
+			if !goerrors.As(err, &de) {
+				t.Fatalf("UnmarshalBinary() yielded error %v, wanted a DecodeError", err)
+			}
+			if got, want := de.Path, "DataSeries[0].Root"; got != want {
+				t.Errorf("UnmarshalBinary() yielded error at %s, want %s", got, want)
+			}
+		})
+	}
+}
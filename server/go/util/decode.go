@@ -0,0 +1,388 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DecodeError describes a malformed TraceViz request or response encountered
+// while decoding it.
+type DecodeError struct {
+	// Path locates the malformed element within the decoded value, as in
+	// `DataSeries[0].Root.Children[2].Properties[5]`.  It is empty if the
+	// decoded value is itself malformed.
+	Path string
+	// Msg describes what is wrong with the malformed element.
+	Msg string
+}
+
+func (de *DecodeError) Error() string {
+	if de.Path == "" {
+		return "malformed TraceViz data: " + de.Msg
+	}
+	return fmt.Sprintf("malformed TraceViz data at %s: %s", de.Path, de.Msg)
+}
+
+func decodeErrorf(format string, args ...any) *DecodeError {
+	return &DecodeError{
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// at returns the provided error, located within the specified path element.
+// Path elements are either field names, like `Root`, or subscripts, like `[3]`.
+// Errors that are not DecodeErrors are converted to DecodeErrors.
+func at(elem string, err error) error {
+	if err == nil {
+		return nil
+	}
+	de, ok := err.(*DecodeError)
+	if !ok {
+		return &DecodeError{
+			Path: elem,
+			Msg:  err.Error(),
+		}
+	}
+	path := elem
+	switch {
+	case de.Path == "":
+	case strings.HasPrefix(de.Path, "["):
+		path += de.Path
+	default:
+		path += "." + de.Path
+	}
+	return &DecodeError{
+		Path: path,
+		Msg:  de.Msg,
+	}
+}
+
+func idxElem(idx int) string {
+	return fmt.Sprintf("[%d]", idx)
+}
+
+func keyElem(key string) string {
+	return fmt.Sprintf("[%q]", key)
+}
+
+// The following helpers check the shapes of values decoded from JSON with
+// json.Decoder.UseNumber.
+
+func sliceFromAny(got any, what string) ([]any, error) {
+	ret, ok := got.([]any)
+	if !ok {
+		return nil, decodeErrorf("%s must be an array, got %s", what, jsonKind(got))
+	}
+	return ret, nil
+}
+
+func int64FromAny(got any, what string) (int64, error) {
+	num, ok := got.(json.Number)
+	if !ok {
+		return 0, decodeErrorf("%s must be a number, got %s", what, jsonKind(got))
+	}
+	ret, err := num.Int64()
+	if err != nil {
+		return 0, decodeErrorf("%s must be an integer, got %s", what, num)
+	}
+	return ret, nil
+}
+
+func float64FromAny(got any, what string) (float64, error) {
+	num, ok := got.(json.Number)
+	if !ok {
+		return 0, decodeErrorf("%s must be a number, got %s", what, jsonKind(got))
+	}
+	ret, err := num.Float64()
+	if err != nil {
+		return 0, decodeErrorf("%s must be a number, got %s", what, num)
+	}
+	return ret, nil
+}
+
+func stringFromAny(got any, what string) (string, error) {
+	ret, ok := got.(string)
+	if !ok {
+		return "", decodeErrorf("%s must be a string, got %s", what, jsonKind(got))
+	}
+	return ret, nil
+}
+
+// jsonKind returns a description of the kind of the provided JSON-decoded
+// value, for use in DecodeErrors.
+func jsonKind(got any) string {
+	switch got.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", got)
+	}
+}
+
+// validateValue checks that the provided decoded V is present and that any
+// string indices within it are within the bounds of a string table with
+// numStrs entries.
+func validateValue(v *V, numStrs int) error {
+	if v == nil {
+		return decodeErrorf("missing value")
+	}
+	checkStrIdx := func(strIdx int64) error {
+		if strIdx < 0 || strIdx >= int64(numStrs) {
+			return decodeErrorf("string index %d is out of bounds of the %d-entry string table", strIdx, numStrs)
+		}
+		return nil
+	}
+	switch v.T {
+	case StringIndexValueType:
+		return checkStrIdx(v.V.(int64))
+	case StringIndicesValueType:
+		for idx, strIdx := range v.V.([]int64) {
+			if err := checkStrIdx(strIdx); err != nil {
+				return at(idxElem(idx), err)
+			}
+		}
+	case ListValueType:
+		for idx, elem := range v.V.([]*V) {
+			if err := validateValue(elem, numStrs); err != nil {
+				return at(idxElem(idx), err)
+			}
+		}
+	case MapValueType:
+		for k, entry := range v.V.(map[string]*V) {
+			if err := validateValue(entry, numStrs); err != nil {
+				return at(keyElem(k), err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateValues checks each value in the provided map with validateValue.
+func validateValues(vals map[string]*V, numStrs int) error {
+	for k, v := range vals {
+		if err := validateValue(v, numStrs); err != nil {
+			return at(keyElem(k), err)
+		}
+	}
+	return nil
+}
+
+func (d *Datum) validate(numStrs int) error {
+	if d == nil {
+		return decodeErrorf("missing datum")
+	}
+	for k, v := range d.Properties {
+		if k < 0 || k >= int64(numStrs) {
+			return at("Properties", decodeErrorf("property key %d is out of bounds of the %d-entry string table", k, numStrs))
+		}
+		if err := validateValue(v, numStrs); err != nil {
+			return at(fmt.Sprintf("Properties[%d]", k), err)
+		}
+	}
+	for idx, child := range d.Children {
+		if err := child.validate(numStrs); err != nil {
+			return at(fmt.Sprintf("Children[%d]", idx), err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the receiver, which was just decoded, is well-formed:
+// that all its series and datums are present, and that all its property keys
+// and string index values lie within its string table.  A Data that validates
+// may be safely prettyprinted.
+func (d *Data) validate() error {
+	for idx, series := range d.DataSeries {
+		elem := fmt.Sprintf("DataSeries[%d]", idx)
+		if series == nil {
+			return at(elem, decodeErrorf("missing data series"))
+		}
+		if series.Root == nil {
+			return at(elem, at("Root", decodeErrorf("missing root datum")))
+		}
+		if err := series.Root.validate(len(d.StringTable)); err != nil {
+			return at(elem, at("Root", err))
+		}
+	}
+	return nil
+}
+
+// validate checks that the receiver, which was just decoded, is well-formed:
+// that all its series requests and values are present.  Requests have no
+// string table, so string index values are not permitted.
+func (dr *DataRequest) validate() error {
+	if err := validateValues(dr.GlobalFilters, 0); err != nil {
+		return at("GlobalFilters", err)
+	}
+	for idx, sr := range dr.SeriesRequests {
+		elem := fmt.Sprintf("SeriesRequests[%d]", idx)
+		if sr == nil {
+			return at(elem, decodeErrorf("missing series request"))
+		}
+		if err := validateValues(sr.Options, 0); err != nil {
+			return at(elem, at("Options", err))
+		}
+	}
+	return nil
+}
+
+// isJSONNull returns true if the provided raw JSON value is null.
+func isJSONNull(raw json.RawMessage) bool {
+	return string(raw) == "null"
+}
+
+// decodeValues decodes the provided raw JSON values as Vs, locating any error
+// at the failing value's key.
+func decodeValues(raw map[string]json.RawMessage) (map[string]*V, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	ret := make(map[string]*V, len(raw))
+	for k, rawV := range raw {
+		var v *V
+		if err := json.Unmarshal(rawV, &v); err != nil {
+			return nil, at(keyElem(k), err)
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// decodeDataSeriesRequest decodes the provided raw JSON DataSeriesRequest.
+func decodeDataSeriesRequest(raw json.RawMessage) (*DataSeriesRequest, error) {
+	if isJSONNull(raw) {
+		return nil, nil
+	}
+	type rawDataSeriesRequest DataSeriesRequest
+	// Options are decoded separately, so that their errors can be located.
+	var shadow struct {
+		rawDataSeriesRequest
+		Options map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &shadow); err != nil {
+		return nil, err
+	}
+	ret := DataSeriesRequest(shadow.rawDataSeriesRequest)
+	var err error
+	if ret.Options, err = decodeValues(shadow.Options); err != nil {
+		return nil, at("Options", err)
+	}
+	return &ret, nil
+}
+
+// decodeDataSeries decodes the provided raw JSON DataSeries.
+func decodeDataSeries(raw json.RawMessage) (*DataSeries, error) {
+	if isJSONNull(raw) {
+		return nil, nil
+	}
+	type rawDataSeries DataSeries
+	// The root is decoded separately, so that its errors can be located.
+	var shadow struct {
+		rawDataSeries
+		Root json.RawMessage
+	}
+	if err := json.Unmarshal(raw, &shadow); err != nil {
+		return nil, err
+	}
+	ret := DataSeries(shadow.rawDataSeries)
+	if shadow.Root != nil {
+		if err := json.Unmarshal(shadow.Root, &ret.Root); err != nil {
+			return nil, at("Root", err)
+		}
+	}
+	return &ret, nil
+}
+
+// UnmarshalJSON unmarshals the provided JSON bytes into the receiving Data,
+// returning a DecodeError if they do not describe a well-formed Data.
+func (d *Data) UnmarshalJSON(data []byte) error {
+	// rawData has Data's fields but not its methods, avoiding recursion here.
+	type rawData Data
+	// Series are decoded separately, so that their errors can be located.
+	var shadow struct {
+		rawData
+		DataSeries []json.RawMessage
+	}
+	if err := json.Unmarshal(data, &shadow); err != nil {
+		return err
+	}
+	rd := Data(shadow.rawData)
+	if shadow.DataSeries != nil {
+		rd.DataSeries = make([]*DataSeries, 0, len(shadow.DataSeries))
+	}
+	for idx, rawSeries := range shadow.DataSeries {
+		series, err := decodeDataSeries(rawSeries)
+		if err != nil {
+			return at(fmt.Sprintf("DataSeries[%d]", idx), err)
+		}
+		rd.DataSeries = append(rd.DataSeries, series)
+	}
+	if err := rd.validate(); err != nil {
+		return err
+	}
+	*d = rd
+	return nil
+}
+
+// UnmarshalJSON unmarshals the provided JSON bytes into the receiving
+// DataRequest, returning a DecodeError if they do not describe a well-formed
+// DataRequest.
+func (dr *DataRequest) UnmarshalJSON(data []byte) error {
+	// rawDataRequest has DataRequest's fields but not its methods, avoiding
+	// recursion here.
+	type rawDataRequest DataRequest
+	// Filters and series requests are decoded separately, so that their errors
+	// can be located.
+	var shadow struct {
+		rawDataRequest
+		GlobalFilters  map[string]json.RawMessage
+		SeriesRequests []json.RawMessage
+	}
+	if err := json.Unmarshal(data, &shadow); err != nil {
+		return err
+	}
+	rdr := DataRequest(shadow.rawDataRequest)
+	var err error
+	if rdr.GlobalFilters, err = decodeValues(shadow.GlobalFilters); err != nil {
+		return at("GlobalFilters", err)
+	}
+	if shadow.SeriesRequests != nil {
+		rdr.SeriesRequests = make([]*DataSeriesRequest, 0, len(shadow.SeriesRequests))
+	}
+	for idx, rawSR := range shadow.SeriesRequests {
+		sr, err := decodeDataSeriesRequest(rawSR)
+		if err != nil {
+			return at(fmt.Sprintf("SeriesRequests[%d]", idx), err)
+		}
+		rdr.SeriesRequests = append(rdr.SeriesRequests, sr)
+	}
+	if err := rdr.validate(); err != nil {
+		return err
+	}
+	*dr = rdr
+	return nil
+}
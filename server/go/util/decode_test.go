@@ -0,0 +1,235 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	goerrors "errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDataRequestDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		input       string
+		wantPath    string
+	}{{
+		description: "value type is not a number",
+		input:       `{"GlobalFilters":{"a":["x",1]}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "value has too few elements",
+		input:       `{"GlobalFilters":{"a":[5]}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "unknown value type",
+		input:       `{"GlobalFilters":{"a":[99,1]}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "string holds a number",
+		input:       `{"GlobalFilters":{"a":[1,1]}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "integers holds a string",
+		input:       `{"GlobalFilters":{"a":[6,[1,"2"]]}}`,
+		wantPath:    `GlobalFilters["a"][1]`,
+	}, {
+		description: "nested list element is malformed",
+		input:       `{"GlobalFilters":{"a":[11,[[5,1],[5,"x"]]]}}`,
+		wantPath:    `GlobalFilters["a"][1]`,
+	}, {
+		description: "null filter",
+		input:       `{"GlobalFilters":{"a":null}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "string index filter",
+		input:       `{"GlobalFilters":{"a":[2,0]}}`,
+		wantPath:    `GlobalFilters["a"]`,
+	}, {
+		description: "malformed option",
+		input:       `{"SeriesRequests":[{"QueryName":"q"},{"Options":{"o":[5]}}]}`,
+		wantPath:    `SeriesRequests[1].Options["o"]`,
+	}, {
+		description: "null series request",
+		input:       `{"SeriesRequests":[null]}`,
+		wantPath:    "SeriesRequests[0]",
+	}, {
+		description: "string index nested in option",
+		input:       `{"SeriesRequests":[{"Options":{"o":[12,{"k":[4,[3]]}]}}]}`,
+		wantPath:    `SeriesRequests[0].Options["o"]["k"][0]`,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := DataRequestFromJSON([]byte(test.input))
+			var decodeErr *DecodeError
+			if !goerrors.As(err, &decodeErr) {
+				t.Fatalf("DataRequestFromJSON() yielded error %v, want a *DecodeError", err)
+			}
+			if decodeErr.Path != test.wantPath {
+				t.Errorf("DataRequestFromJSON() yielded error at %q, want %q (%s)", decodeErr.Path, test.wantPath, err)
+			}
+		})
+	}
+}
+
+func TestDataDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		input       string
+		wantPath    string
+	}{{
+		description: "null series",
+		input:       `{"StringTable":[],"DataSeries":[null]}`,
+		wantPath:    "DataSeries[0]",
+	}, {
+		description: "missing root",
+		input:       `{"StringTable":[],"DataSeries":[{"SeriesName":"s"}]}`,
+		wantPath:    "DataSeries[0].Root",
+	}, {
+		description: "property key out of bounds",
+		input:       `{"StringTable":["a"],"DataSeries":[{"Root":[[[1,[5,1]]],[]]}]}`,
+		wantPath:    "DataSeries[0].Root.Properties",
+	}, {
+		description: "string index out of bounds",
+		input:       `{"StringTable":["a"],"DataSeries":[{"Root":[[],[[[[0,[2,1]]],[]]]]}]}`,
+		wantPath:    "DataSeries[0].Root.Children[0].Properties[0]",
+	}, {
+		description: "negative string index",
+		input:       `{"StringTable":["a"],"DataSeries":[{"Root":[[[0,[4,[0,-1]]]],[]]}]}`,
+		wantPath:    "DataSeries[0].Root.Properties[0][1]",
+	}, {
+		description: "datum is not an array pair",
+		input:       `{"StringTable":[],"DataSeries":[{"Root":[[]]}]}`,
+		wantPath:    "DataSeries[0].Root",
+	}, {
+		description: "child is not an array",
+		input:       `{"StringTable":[],"DataSeries":[{"Root":[[],[3]]}]}`,
+		wantPath:    "DataSeries[0].Root.Children[0]",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			err := json.Unmarshal([]byte(test.input), &Data{})
+			var decodeErr *DecodeError
+			if !goerrors.As(err, &decodeErr) {
+				t.Fatalf("Unmarshal() yielded error %v, want a *DecodeError", err)
+			}
+			if decodeErr.Path != test.wantPath {
+				t.Errorf("Unmarshal() yielded error at %q, want %q (%s)", decodeErr.Path, test.wantPath, err)
+			}
+		})
+	}
+}
+
+func FuzzDataRequestFromJSON(f *testing.F) {
+	for _, seed := range []string{
+		`{}`,
+		`{"GlobalFilters":{"a":[1,"x"],"b":[10,true]},"SeriesRequests":[{"QueryName":"q","SeriesName":"1","Options":{"c":[9,[1,2]]}}]}`,
+		`{"SeriesRequests":[{"Options":{"o":[12,{"k":[11,[[6,[1,2]],[3,["a"]]]]}]}}],"AllowPartialResults":true}`,
+		`{"GlobalFilters":{"a":[7,1.5],"b":[8,100],"c":[0,null]}}`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		req, err := DataRequestFromJSON(input)
+		if err != nil {
+			return
+		}
+		// A successfully-decoded request must be usable.
+		for _, v := range req.GlobalFilters {
+			v.PrettyPrint(nil)
+		}
+		for _, sr := range req.SeriesRequests {
+			for _, v := range sr.Options {
+				v.PrettyPrint(nil)
+			}
+		}
+		if _, err := json.Marshal(req); err != nil {
+			t.Errorf("Marshal() of decoded DataRequest yielded unexpected error %s", err)
+		}
+	})
+}
+
+// dataFuzzSeed returns a Data exercising every value type, for seeding the
+// Data fuzz targets.
+func dataFuzzSeed() *Data {
+	return &Data{
+		StringTable: []string{"a", "b", "c"},
+		DataSeries: []*DataSeries{{
+			SeriesName: "s",
+			Root: &Datum{
+				Properties: map[int64]*V{
+					0: StringIndexValue(1),
+					1: StringIndicesValue(0, 2),
+					2: ListValue(IntValue(1), MapValue(map[string]*V{"k": BoolValue(true)})),
+				},
+				Children: []*Datum{{
+					Properties: map[int64]*V{
+						0: StringsValue("x", "y"),
+						1: TimestampValue(time.Unix(100, 5)),
+						2: DoubleValue(2.5),
+					},
+				}},
+			},
+			Error: &SeriesError{QueryName: "q", Message: "oops"},
+			Truncated: &Truncation{
+				Limit:         MaxSeriesDatumsLimit,
+				DroppedDatums: 1,
+			},
+		}},
+	}
+}
+
+func FuzzDataUnmarshalJSON(f *testing.F) {
+	seed, err := json.Marshal(dataFuzzSeed())
+	if err != nil {
+		f.Fatalf("failed to marshal seed: %s", err)
+	}
+	f.Add(seed)
+	f.Add([]byte(`{"StringTable":[],"DataSeries":[]}`))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		d := &Data{}
+		if err := json.Unmarshal(input, d); err != nil {
+			return
+		}
+		// A successfully-decoded Data must be safe to prettyprint.
+		d.PrettyPrint()
+	})
+}
+
+func FuzzDataUnmarshalBinary(f *testing.F) {
+	seed, err := dataFuzzSeed().MarshalBinary()
+	if err != nil {
+		f.Fatalf("failed to marshal seed: %s", err)
+	}
+	f.Add(seed)
+	f.Add([]byte(BinaryMagic + "\x00\x00"))
+	// Datums nested too deeply.
+	f.Add([]byte(BinaryMagic + "\x00\x01\x00" + strings.Repeat("\x00\x01", maxBinaryNestingDepth) + "\x00\x00\x00\x00"))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		d := &Data{}
+		if err := d.UnmarshalBinary(input); err != nil {
+			var decodeErr *DecodeError
+			if !goerrors.As(err, &decodeErr) {
+				t.Errorf("UnmarshalBinary() yielded error %v, want a *DecodeError", err)
+			}
+			return
+		}
+		// A successfully-decoded Data must be safe to prettyprint and
+		// re-encode.
+		d.PrettyPrint()
+		if _, err := d.MarshalBinary(); err != nil {
+			t.Errorf("MarshalBinary() of decoded Data yielded unexpected error %s", err)
+		}
+	})
+}
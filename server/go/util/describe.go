@@ -0,0 +1,44 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+// OptionDescription describes a single DataSeriesRequest option accepted by a
+// query.
+type OptionDescription struct {
+	// The option's key in DataSeriesRequest.Options.
+	Key string
+	// The name of the option's value type, such as 'string', 'integer', or
+	// 'timestamp'.
+	Type string
+	// The value used if the option is not specified, if any.
+	Default *V `json:",omitempty"`
+	// Whether the option must be specified.
+	Required bool `json:",omitempty"`
+	// A human-readable description of the option.
+	Description string `json:",omitempty"`
+}
+
+// QueryDescription describes a single data series query, so that frontend
+// authors can discover it without reading its data source's source.  Data
+// sources return these from their DescribeQueries methods.
+type QueryDescription struct {
+	// The query's DataSeriesRequest.QueryName.
+	Name string
+	// A human-readable description of the query and its response.
+	Description string `json:",omitempty"`
+	// The DataSeriesRequest options the query accepts.
+	Options []*OptionDescription `json:",omitempty"`
+	// The keys of the DataRequest global filters the query reads.
+	GlobalFilters []string `json:",omitempty"`
+}
@@ -0,0 +1,182 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+)
+
+// ResponseLimits bounds the size of a response assembled by a
+// DataResponseBuilder.  Zero-valued limits are unbounded.
+//
+// When a limit is reached, the DataSeries under construction stops growing:
+// subsequent Datums and property updates in that series are dropped, and the
+// series is marked with a Truncation describing what was dropped.
+type ResponseLimits struct {
+	// The maximum number of Datums in the response, across all its series.
+	// Series roots are not counted.
+	MaxDatums int
+	// The maximum number of Datums in any single series.  Series roots are not
+	// counted.
+	MaxSeriesDatums int
+	// The maximum total length, in bytes, of the response's string table.  This
+	// limit is checked before each property update, so a single update may
+	// exceed it.
+	MaxStringTableBytes int
+}
+
+// Names of the ResponseLimits, as reported in Truncations.
+const (
+	MaxDatumsLimit           = "MaxDatums"
+	MaxSeriesDatumsLimit     = "MaxSeriesDatums"
+	MaxStringTableBytesLimit = "MaxStringTableBytes"
+)
+
+// Truncation describes how a DataSeries was truncated to keep its response
+// within its ResponseLimits.  A truncated series is incomplete; clients
+// should generally prompt the user to narrow their query.
+type Truncation struct {
+	// The name of the limit that was reached, e.g. MaxDatumsLimit.
+	Limit string
+	// The number of Datums dropped from the series.
+	DroppedDatums int64
+	// The number of property updates dropped from the series.
+	DroppedUpdates int64
+}
+
+// responseBudget enforces a DataResponseBuilder's ResponseLimits and context
+// across all of its series.
+type responseBudget struct {
+	ctx    context.Context
+	limits ResponseLimits
+	st     *stringTable
+	mu     sync.Mutex
+	// The number of Datums admitted so far, across all series.
+	datums int
+}
+
+// newResponseBudget returns a new responseBudget over the provided string
+// table, with no limits and a background context.
+func newResponseBudget(st *stringTable) *responseBudget {
+	return &responseBudget{
+		ctx: context.Background(),
+		st:  st,
+	}
+}
+
+// err returns the error, if any, of the receiver's context.
+func (rb *responseBudget) err() error {
+	if rb == nil {
+		return nil
+	}
+	return rb.ctx.Err()
+}
+
+// series returns a new seriesBudget drawing from the receiver.
+func (rb *responseBudget) series() *seriesBudget {
+	if rb == nil {
+		return nil
+	}
+	return &seriesBudget{
+		resp: rb,
+	}
+}
+
+// seriesBudget enforces ResponseLimits on a single DataSeries.  A nil
+// seriesBudget admits everything.
+type seriesBudget struct {
+	resp *responseBudget
+	// The following are guarded by resp.mu.
+	datums     int
+	truncation *Truncation
+}
+
+// truncateLocked marks the receiver truncated by the specified limit, if it
+// isn't already.  resp.mu must be held.
+func (sb *seriesBudget) truncateLocked(limit string) {
+	if sb.truncation == nil {
+		sb.truncation = &Truncation{
+			Limit: limit,
+		}
+	}
+}
+
+// admitDatum returns true if a new Datum may be added to the receiver's
+// series, counting it against the receiver's limits if so.  Otherwise, it
+// counts the Datum as dropped.
+func (sb *seriesBudget) admitDatum() bool {
+	if sb == nil {
+		return true
+	}
+	rb := sb.resp
+	if rb.ctx.Err() != nil {
+		return false
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if sb.truncation == nil {
+		switch {
+		case rb.limits.MaxDatums > 0 && rb.datums >= rb.limits.MaxDatums:
+			sb.truncateLocked(MaxDatumsLimit)
+		case rb.limits.MaxSeriesDatums > 0 && sb.datums >= rb.limits.MaxSeriesDatums:
+			sb.truncateLocked(MaxSeriesDatumsLimit)
+		}
+	}
+	if sb.truncation != nil {
+		sb.truncation.DroppedDatums++
+		return false
+	}
+	rb.datums++
+	sb.datums++
+	return true
+}
+
+// admitUpdate returns true if a property update may be applied within the
+// receiver's series.  Otherwise, it counts the update as dropped.
+func (sb *seriesBudget) admitUpdate() bool {
+	if sb == nil {
+		return true
+	}
+	rb := sb.resp
+	if rb.ctx.Err() != nil {
+		return false
+	}
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if sb.truncation == nil && rb.limits.MaxStringTableBytes > 0 &&
+		rb.st.size() >= rb.limits.MaxStringTableBytes {
+		sb.truncateLocked(MaxStringTableBytesLimit)
+	}
+	if sb.truncation != nil {
+		sb.truncation.DroppedUpdates++
+		return false
+	}
+	return true
+}
+
+// truncated returns the receiver's Truncation, or nil if it was not
+// truncated.
+func (sb *seriesBudget) truncated() *Truncation {
+	if sb == nil {
+		return nil
+	}
+	sb.resp.mu.Lock()
+	defer sb.resp.mu.Unlock()
+	if sb.truncation == nil {
+		return nil
+	}
+	ret := *sb.truncation
+	return &ret
+}
@@ -0,0 +1,255 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResponseLimits(t *testing.T) {
+	// buildSeries adds a series with the specified name and number of
+	// children, each with a property and a grandchild with a property.
+	buildSeries := func(drb *DataResponseBuilder, seriesName string, children int) {
+		series := drb.DataSeries(&DataSeriesRequest{SeriesName: seriesName})
+		for i := 0; i < children; i++ {
+			series.Child().With(
+				IntegerProperty("idx", int64(i)),
+			).Child().With(
+				IntegerProperty("leaf", int64(i)),
+			)
+		}
+	}
+	for _, test := range []struct {
+		description string
+		limits      ResponseLimits
+		build       func(drb *DataResponseBuilder)
+		want        string
+	}{{
+		description: "within limits",
+		limits: ResponseLimits{
+			MaxDatums:       4,
+			MaxSeriesDatums: 4,
+		},
+		build: func(drb *DataResponseBuilder) {
+			buildSeries(drb, "s", 2)
+		},
+		want: `Data:
+  Series s
+    Root:
+      Child:
+        Prop 'idx': 0
+        Child:
+          Prop 'leaf': 0
+      Child:
+        Prop 'idx': 1
+        Child:
+          Prop 'leaf': 1`,
+	}, {
+		description: "series datums",
+		limits: ResponseLimits{
+			MaxSeriesDatums: 3,
+		},
+		build: func(drb *DataResponseBuilder) {
+			buildSeries(drb, "s", 3)
+			buildSeries(drb, "t", 1)
+		},
+		// The second child's grandchild, and the third child and its
+		// grandchild, are dropped, along with their updates.
+		want: `Data:
+  Series s
+    Truncated at MaxSeriesDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'idx': 0
+        Child:
+          Prop 'leaf': 0
+      Child:
+        Prop 'idx': 1
+  Series t
+    Root:
+      Child:
+        Prop 'idx': 0
+        Child:
+          Prop 'leaf': 0`,
+	}, {
+		description: "total datums",
+		limits: ResponseLimits{
+			MaxDatums: 3,
+		},
+		build: func(drb *DataResponseBuilder) {
+			buildSeries(drb, "s", 1)
+			buildSeries(drb, "t", 2)
+		},
+		want: `Data:
+  Series s
+    Root:
+      Child:
+        Prop 'idx': 0
+        Child:
+          Prop 'leaf': 0
+  Series t
+    Truncated at MaxDatums: dropped 3 datums, 3 updates
+    Root:
+      Child:
+        Prop 'idx': 0`,
+	}, {
+		description: "string table bytes",
+		limits: ResponseLimits{
+			MaxStringTableBytes: 8,
+		},
+		build: func(drb *DataResponseBuilder) {
+			drb.DataSeries(&DataSeriesRequest{SeriesName: "s"}).
+				With(StringProperty("a", "bcdef")).
+				With(StringProperty("g", "hi")).
+				With(StringProperty("j", "k")).
+				Child().With(StringProperty("l", "m"))
+		},
+		want: `Data:
+  Series s
+    Truncated at MaxStringTableBytes: dropped 1 datums, 2 updates
+    Root:
+      Prop 'a': 'bcdef'
+      Prop 'g': 'hi'`,
+	}, {
+		description: "marked truncated",
+		limits: ResponseLimits{
+			MaxSeriesDatums: 1,
+		},
+		build: func(drb *DataResponseBuilder) {
+			sReq := &DataSeriesRequest{SeriesName: "s"}
+			drb.DataSeries(sReq).Child().With(IntegerProperty("idx", 0))
+			drb.MarkTruncated(&Truncation{Limit: MaxDatumsLimit, DroppedDatums: 2}, sReq)
+			tReq := &DataSeriesRequest{SeriesName: "t"}
+			series := drb.DataSeries(tReq)
+			series.Child().With(IntegerProperty("idx", 0))
+			series.Child().With(IntegerProperty("idx", 1))
+			drb.MarkTruncated(&Truncation{Limit: MaxStringTableBytesLimit, DroppedUpdates: 3}, tReq)
+		},
+		// Earlier truncations are reported along with the builder's own.
+		want: `Data:
+  Series s
+    Truncated at MaxDatums: dropped 2 datums, 0 updates
+    Root:
+      Child:
+        Prop 'idx': 0
+  Series t
+    Truncated at MaxStringTableBytes: dropped 1 datums, 4 updates
+    Root:
+      Child:
+        Prop 'idx': 0`,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := NewDataResponseBuilder().WithLimits(test.limits)
+			test.build(drb)
+			data, err := drb.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, data.PrettyPrint()); diff != "" {
+				t.Errorf("Got data %s, diff (-want +got) %s", data.PrettyPrint(), diff)
+			}
+		})
+	}
+}
+
+func TestResponseLimitsWithPartialResults(t *testing.T) {
+	drb := NewDataResponseBuilder().AllowPartialResults().WithLimits(ResponseLimits{
+		MaxSeriesDatums: 1,
+	})
+	failed := drb.DataSeries(&DataSeriesRequest{QueryName: "q", SeriesName: "failed"})
+	failed.Child()
+	failed.Child().With(ErrorProperty(fmt.Errorf("oops")))
+	drb.DataSeries(&DataSeriesRequest{SeriesName: "truncated"}).Child().With(
+		IntegerProperty("idx", 0),
+	).Child()
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	// A failed series isn't also reported as truncated.  Its root is empty, so
+	// prettyprints as an empty line.
+	want := `Data:
+  Series failed
+    Error in query 'q' (data source ''): oops
+    Root:
+
+  Series truncated
+    Truncated at MaxSeriesDatums: dropped 1 datums, 0 updates
+    Root:
+      Child:
+        Prop 'idx': 0`
+	if diff := cmp.Diff(want, data.PrettyPrint()); diff != "" {
+		t.Errorf("Got data %s, diff (-want +got) %s", data.PrettyPrint(), diff)
+	}
+}
+
+func TestResponseContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	drb := NewDataResponseBuilder().WithContext(ctx)
+	series := drb.DataSeries(&DataSeriesRequest{SeriesName: "s"})
+	series.Child().With(IntegerProperty("before", 1))
+	cancel()
+	series.Child().With(IntegerProperty("after", 2))
+	if got := len(series.(*datumBuilder).d.Children); got != 1 {
+		t.Errorf("Got %d children after cancellation, want 1", got)
+	}
+	if _, err := drb.Data(); !goerrors.Is(err, context.Canceled) {
+		t.Errorf("Data() yielded error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStreamingResponseLimits(t *testing.T) {
+	var frames []*DataFrame
+	sdrb := NewStreamingDataResponseBuilder(func(frame *DataFrame) error {
+		frames = append(frames, frame)
+		return nil
+	}).WithLimits(ResponseLimits{
+		MaxDatums: 2,
+	})
+	// Parts share a single budget.
+	first, second := sdrb.Part(), sdrb.Part()
+	first.DataSeries(&DataSeriesRequest{SeriesName: "first"}).Child().With(
+		IntegerProperty("idx", 0),
+	).Child().With(
+		IntegerProperty("leaf", 0),
+	)
+	second.DataSeries(&DataSeriesRequest{SeriesName: "second"}).Child().With(
+		IntegerProperty("idx", 0),
+	)
+	for _, part := range []*DataResponseBuilder{first, second} {
+		if err := sdrb.Flush(part); err != nil {
+			t.Fatalf("Flush() yielded unexpected error %s", err)
+		}
+	}
+	want := `Data:
+  Series first
+    Root:
+      Child:
+        Prop 'idx': 0
+        Child:
+          Prop 'leaf': 0
+  Series second
+    Truncated at MaxDatums: dropped 1 datums, 1 updates
+    Root:
+`
+	got := ReassembleDataFrames(frames...).PrettyPrint()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got reassembled data %s, diff (-want +got) %s", got, diff)
+	}
+}
@@ -0,0 +1,374 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueUnmarshaler is implemented by types that decode themselves from a
+// single option value, such as those accepting several value types for
+// compatibility.  DecodeOptions and DecodeGlobalFilters use it in preference
+// to their own decoding.
+type ValueUnmarshaler interface {
+	UnmarshalValue(v *V) error
+}
+
+var (
+	valueUnmarshalerType = reflect.TypeOf((*ValueUnmarshaler)(nil)).Elem()
+	durationType         = reflect.TypeOf(time.Duration(0))
+	timeType             = reflect.TypeOf(time.Time{})
+	vType                = reflect.TypeOf(&V{})
+)
+
+// OptionError describes DataSeriesRequest options or global filters that a
+// request supplied incorrectly: missing, unknown, or malformed.  Since the
+// request, not its handler, is at fault, it is reported as a bad request.
+type OptionError struct {
+	Err error
+}
+
+func (oe *OptionError) Error() string {
+	return oe.Err.Error()
+}
+
+func (oe *OptionError) Unwrap() error {
+	return oe.Err
+}
+
+func optionErrorf(format string, args ...any) error {
+	return &OptionError{
+		Err: fmt.Errorf(format, args...),
+	}
+}
+
+// DecodeOptions decodes the provided DataSeriesRequest options into the
+// struct pointed to by dst, as directed by its fields' `traceviz` tags.  A tag
+// is a comma-separated list whose first element is the option's key, and
+// whose remaining elements may be:
+//
+//   - 'required': the option must be present;
+//   - 'default=<value>': the value used if the option is absent;
+//   - 'enum=<a>|<b>|...': the option's value, or each of its values, must be
+//     one of those listed.
+//
+// For example:
+//
+//	type timeseriesOptions struct {
+//		BinCount    int64  `traceviz:"bin_count,required"`
+//		AggregateBy string `traceviz:"aggregate_by,default=level_name,enum=level_name"`
+//	}
+//
+// Fields may be strings, []string, integers, []int64, float64,
+// time.Duration, time.Time, bool, *V, any type implementing ValueUnmarshaler,
+// or a pointer to any of these, which is left nil if the option is absent and
+// has no default.  Types defined on these, like `type Theme string`, are also
+// supported.  Untagged fields, and fields tagged "-", are ignored.  Defaults
+// are supported for scalar fields other than time.Time, and are written as
+// they would be parsed by the strconv package, or, for time.Duration, by
+// time.ParseDuration.  ValueUnmarshalers receive their defaults as string
+// values.
+//
+// Options whose keys match no field, and option values of the wrong type, are
+// errors.  Errors caused by the options themselves, rather than by dst, are
+// *OptionErrors.
+func DecodeOptions(opts map[string]*V, dst any) error {
+	return decodeOptions("option", opts, dst, false)
+}
+
+// DecodeGlobalFilters decodes the provided DataRequest global filters into the
+// struct pointed to by dst, like DecodeOptions.  Since global filters are
+// shared by all of a DataRequest's data sources, filters whose keys match no
+// field are ignored.
+func DecodeGlobalFilters(globalFilters map[string]*V, dst any) error {
+	return decodeOptions("global filter", globalFilters, dst, true)
+}
+
+// optionTag is a parsed `traceviz` struct field tag.
+type optionTag struct {
+	key        string
+	required   bool
+	defaultVal *string
+	enum       []string
+}
+
+func parseOptionTag(tag string) (*optionTag, error) {
+	parts := strings.Split(tag, ",")
+	ret := &optionTag{
+		key: parts[0],
+	}
+	if ret.key == "" {
+		return nil, fmt.Errorf("missing key")
+	}
+	for _, part := range parts[1:] {
+		name, val, hasVal := strings.Cut(part, "=")
+		switch {
+		case name == "required" && !hasVal:
+			ret.required = true
+		case name == "default" && hasVal:
+			ret.defaultVal = &val
+		case name == "enum" && hasVal:
+			ret.enum = strings.Split(val, "|")
+		default:
+			return nil, fmt.Errorf("unsupported element '%s'", part)
+		}
+	}
+	if ret.required && ret.defaultVal != nil {
+		return nil, fmt.Errorf("required options cannot have defaults")
+	}
+	return ret, nil
+}
+
+// decodeOptions implements DecodeOptions and DecodeGlobalFilters.  what names
+// the decoded options in errors.
+func decodeOptions(what string, opts map[string]*V, dst any, allowUnknown bool) error {
+	dstVal := reflect.ValueOf(dst)
+	if dstVal.Kind() != reflect.Pointer || dstVal.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't decode %ss into %T: must be a pointer to a struct", what, dst)
+	}
+	structVal := dstVal.Elem()
+	structType := structVal.Type()
+	decodedKeys := make(map[string]struct{}, structType.NumField())
+	for idx := 0; idx < structType.NumField(); idx++ {
+		field := structType.Field(idx)
+		tagStr, ok := field.Tag.Lookup("traceviz")
+		if !ok || tagStr == "-" {
+			continue
+		}
+		tag, err := parseOptionTag(tagStr)
+		if err != nil {
+			return fmt.Errorf("invalid traceviz tag on %s.%s: %w", structType.Name(), field.Name, err)
+		}
+		if !field.IsExported() {
+			return fmt.Errorf("can't decode %s '%s' into unexported field %s.%s", what, tag.key, structType.Name(), field.Name)
+		}
+		decodedKeys[tag.key] = struct{}{}
+		fieldVal := structVal.Field(idx)
+		v, ok := opts[tag.key]
+		if !ok {
+			if tag.required {
+				return optionErrorf("missing required %s '%s'", what, tag.key)
+			}
+			if tag.defaultVal != nil {
+				if err := decodeDefault(fieldVal, *tag.defaultVal); err != nil {
+					return fmt.Errorf("invalid default for %s '%s': %w", what, tag.key, err)
+				}
+			}
+			continue
+		}
+		if err := decodeOptionValue(fieldVal, v); err != nil {
+			return optionErrorf("%s '%s': %w", what, tag.key, err)
+		}
+		if err := checkEnum(fieldVal, tag.enum); err != nil {
+			return optionErrorf("%s '%s': %w", what, tag.key, err)
+		}
+	}
+	if allowUnknown {
+		return nil
+	}
+	var unknownKeys []string
+	for key := range opts {
+		if _, ok := decodedKeys[key]; !ok {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return optionErrorf("unsupported %s '%s'", what, strings.Join(unknownKeys, "', '"))
+	}
+	return nil
+}
+
+// decodeOptionValue decodes the provided value into the provided field.
+func decodeOptionValue(field reflect.Value, v *V) error {
+	if v == nil {
+		return fmt.Errorf("missing value")
+	}
+	if field.Type() == vType {
+		field.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if reflect.PointerTo(field.Type()).Implements(valueUnmarshalerType) {
+		return field.Addr().Interface().(ValueUnmarshaler).UnmarshalValue(v)
+	}
+	switch field.Type() {
+	case durationType:
+		d, err := ExpectDurationValue(v)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	case timeType:
+		ts, err := ExpectTimestampValue(v)
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.ValueOf(ts))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := decodeOptionValue(elem.Elem(), v); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.String:
+		s, err := ExpectStringValue(v)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := ExpectIntegerValue(v)
+		if err != nil {
+			return err
+		}
+		if field.OverflowInt(i) {
+			return fmt.Errorf("value %d overflows %s", i, field.Type())
+		}
+		field.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := ExpectDoubleValue(v)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := ExpectBoolValue(v)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		switch field.Type().Elem().Kind() {
+		case reflect.String:
+			strs, err := ExpectStringsValue(v)
+			if err != nil {
+				return err
+			}
+			ret := reflect.MakeSlice(field.Type(), len(strs), len(strs))
+			for idx, s := range strs {
+				ret.Index(idx).SetString(s)
+			}
+			field.Set(ret)
+			return nil
+		case reflect.Int64:
+			ints, err := ExpectIntegersValue(v)
+			if err != nil {
+				return err
+			}
+			ret := reflect.MakeSlice(field.Type(), len(ints), len(ints))
+			for idx, i := range ints {
+				ret.Index(idx).SetInt(i)
+			}
+			field.Set(ret)
+			return nil
+		}
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// decodeDefault decodes the provided default value into the provided field.
+func decodeDefault(field reflect.Value, def string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(def)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	if reflect.PointerTo(field.Type()).Implements(valueUnmarshalerType) {
+		return field.Addr().Interface().(ValueUnmarshaler).UnmarshalValue(StringValue(def))
+	}
+	if field.Type() == timeType || field.Type() == vType {
+		return fmt.Errorf("defaults are unsupported for field type %s", field.Type())
+	}
+	switch field.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := decodeDefault(elem.Elem(), def); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.String:
+		field.SetString(def)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(def, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(def, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("defaults are unsupported for field type %s", field.Type())
+	}
+	return nil
+}
+
+// checkEnum returns an error if the provided string or string-slice field has
+// a value not in the provided enum.  An empty enum permits all values.
+func checkEnum(field reflect.Value, enum []string) error {
+	if len(enum) == 0 {
+		return nil
+	}
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return nil
+		}
+		field = field.Elem()
+	}
+	check := func(s string) error {
+		for _, allowed := range enum {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("value '%s' is not one of [%s]", s, strings.Join(enum, ", "))
+	}
+	switch {
+	case field.Kind() == reflect.String:
+		return check(field.String())
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		for idx := 0; idx < field.Len(); idx++ {
+			if err := check(field.Index(idx).String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("enums are unsupported for field type %s", field.Type())
+	}
+}
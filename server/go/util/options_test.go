@@ -0,0 +1,254 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	goerrors "errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testTheme string
+
+// lenientBool accepts bools, or the strings 'true' and 'false'.
+type lenientBool bool
+
+func (lb *lenientBool) UnmarshalValue(v *V) error {
+	if v.T == BoolValueType {
+		b, err := ExpectBoolValue(v)
+		*lb = lenientBool(b)
+		return err
+	}
+	s, err := ExpectStringValue(v)
+	if err != nil {
+		return err
+	}
+	switch s {
+	case "true":
+		*lb = true
+	case "false":
+		*lb = false
+	default:
+		return fmt.Errorf("expected 'true' or 'false'")
+	}
+	return nil
+}
+
+type testOptions struct {
+	Name     string        `traceviz:"name,required"`
+	Count    int64         `traceviz:"count,default=3"`
+	Ratio    float64       `traceviz:"ratio,default=0.5"`
+	Timeout  time.Duration `traceviz:"timeout,default=1s"`
+	Start    time.Time     `traceviz:"start"`
+	Enabled  bool          `traceviz:"enabled"`
+	Tags     []string      `traceviz:"tags,enum=a|b"`
+	IDs      []int64       `traceviz:"ids"`
+	Theme    testTheme     `traceviz:"theme,default=light,enum=light|dark"`
+	Limit    *int32        `traceviz:"limit"`
+	Raw      *V            `traceviz:"raw"`
+	Lenient  lenientBool   `traceviz:"lenient,default=true"`
+	Skipped  string        `traceviz:"-"`
+	Untagged string
+}
+
+func TestDecodeOptions(t *testing.T) {
+	limit := int32(10)
+	for _, test := range []struct {
+		description   string
+		opts          map[string]*V
+		globalFilters bool
+		want          *testOptions
+		wantErr       string
+	}{{
+		description: "defaults",
+		opts: map[string]*V{
+			"name": StringValue("n"),
+		},
+		want: &testOptions{
+			Name:    "n",
+			Count:   3,
+			Ratio:   0.5,
+			Timeout: time.Second,
+			Theme:   "light",
+			Lenient: true,
+		},
+	}, {
+		description: "all options",
+		opts: map[string]*V{
+			"name":    StringValue("n"),
+			"count":   IntegerValue(5),
+			"ratio":   DoubleValue(0.25),
+			"timeout": DurationValue(time.Minute),
+			"start":   TimestampValue(time.Unix(100, 0)),
+			"enabled": BoolValue(true),
+			"tags":    StringsValue("b", "a"),
+			"ids":     IntegersValue(1, 2),
+			"theme":   StringValue("dark"),
+			"limit":   IntegerValue(10),
+			"raw":     StringsValue("x"),
+			"lenient": StringValue("false"),
+		},
+		want: &testOptions{
+			Name:    "n",
+			Count:   5,
+			Ratio:   0.25,
+			Timeout: time.Minute,
+			Start:   time.Unix(100, 0),
+			Enabled: true,
+			Tags:    []string{"b", "a"},
+			IDs:     []int64{1, 2},
+			Theme:   "dark",
+			Limit:   &limit,
+			Raw:     StringsValue("x"),
+		},
+	}, {
+		description: "missing required option",
+		opts:        map[string]*V{},
+		wantErr:     "missing required option 'name'",
+	}, {
+		description: "type mismatch",
+		opts: map[string]*V{
+			"name":  StringValue("n"),
+			"count": StringValue("5"),
+		},
+		wantErr: "option 'count': expected value type 'int'",
+	}, {
+		description: "ValueUnmarshaler error",
+		opts: map[string]*V{
+			"name":    StringValue("n"),
+			"lenient": StringValue("maybe"),
+		},
+		wantErr: "option 'lenient': expected 'true' or 'false'",
+	}, {
+		description: "enum violation",
+		opts: map[string]*V{
+			"name":  StringValue("n"),
+			"theme": StringValue("sepia"),
+		},
+		wantErr: "option 'theme': value 'sepia' is not one of [light, dark]",
+	}, {
+		description: "slice enum violation",
+		opts: map[string]*V{
+			"name": StringValue("n"),
+			"tags": StringsValue("a", "c"),
+		},
+		wantErr: "option 'tags': value 'c' is not one of [a, b]",
+	}, {
+		description: "overflow",
+		opts: map[string]*V{
+			"name":  StringValue("n"),
+			"limit": IntegerValue(1 << 40),
+		},
+		wantErr: "option 'limit': value 1099511627776 overflows int32",
+	}, {
+		description: "unknown options",
+		opts: map[string]*V{
+			"name":     StringValue("n"),
+			"untagged": StringValue("u"),
+			"-":        StringValue("s"),
+		},
+		wantErr: "unsupported option '-', 'untagged'",
+	}, {
+		description:   "unknown global filters",
+		globalFilters: true,
+		opts: map[string]*V{
+			"name":     StringValue("n"),
+			"untagged": StringValue("u"),
+		},
+		want: &testOptions{
+			Name:    "n",
+			Count:   3,
+			Ratio:   0.5,
+			Timeout: time.Second,
+			Theme:   "light",
+			Lenient: true,
+		},
+	}, {
+		description:   "global filter type mismatch",
+		globalFilters: true,
+		opts: map[string]*V{
+			"name": IntegerValue(1),
+		},
+		wantErr: "global filter 'name': expected value type 'str'",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			got := &testOptions{}
+			decode := DecodeOptions
+			if test.globalFilters {
+				decode = DecodeGlobalFilters
+			}
+			err := decode(test.opts, got)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("DecodeOptions() yielded error %v, want %s", err, test.wantErr)
+				}
+				var oe *OptionError
+				if !goerrors.As(err, &oe) {
+					t.Errorf("DecodeOptions() yielded error %v, want an *OptionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DecodeOptions() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("DecodeOptions() = diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeOptionsInvalidTargets(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		dst         any
+		wantErr     string
+	}{{
+		description: "non-pointer",
+		dst:         testOptions{},
+		wantErr:     "can't decode options into util.testOptions: must be a pointer to a struct",
+	}, {
+		description: "bad tag",
+		dst: &struct {
+			A string `traceviz:"a,optional"`
+		}{},
+		wantErr: "invalid traceviz tag on .A: unsupported element 'optional'",
+	}, {
+		description: "required with default",
+		dst: &struct {
+			A string `traceviz:"a,required,default=x"`
+		}{},
+		wantErr: "invalid traceviz tag on .A: required options cannot have defaults",
+	}, {
+		description: "bad default",
+		dst: &struct {
+			A int64 `traceviz:"a,default=x"`
+		}{},
+		wantErr: `invalid default for option 'a': strconv.ParseInt: parsing "x": invalid syntax`,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			err := DecodeOptions(map[string]*V{}, test.dst)
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("DecodeOptions() yielded error %v, want %s", err, test.wantErr)
+			}
+			var oe *OptionError
+			if goerrors.As(err, &oe) {
+				t.Errorf("DecodeOptions() yielded *OptionError %v, but the fault lies with dst", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+)
+
+// Principal is an authenticated client on whose behalf a DataRequest is
+// made.
+type Principal struct {
+	// The principal's name: the user name for basic auth, or the name
+	// associated with a bearer token.
+	Name string
+	// The HTTP authentication scheme with which the principal authenticated,
+	// like 'Basic' or 'Bearer'.
+	Scheme string
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a copy of the provided Context carrying the provided
+// Principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFrom returns the Principal carried by the provided Context, such
+// as the one passed to a data source's HandleDataSeriesRequests, or nil if
+// the request was not authenticated.  Data sources may use it to authorize
+// requests per collection.
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
@@ -0,0 +1,300 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DatumReader reads the properties and children of a Datum produced by a
+// DataBuilder, resolving property keys and string-index values against the
+// Datum's string table.  It is the basis of the typed decoders in the
+// structured-data packages (trace, table, and so forth), which read builder
+// output back into Go structures.
+type DatumReader struct {
+	d  *Datum
+	st []string
+	// Maps strings to their indices in st.  Shared by all readers over the
+	// same string table.
+	strIdxs map[string]int64
+}
+
+// NewDatumReader returns a new DatumReader over the provided Datum, whose
+// property keys and string-index values refer to the provided string table
+// (generally, the StringTable of the Data containing the Datum).  It returns
+// a DecodeError if the Datum refers to strings outside that table.
+func NewDatumReader(d *Datum, stringTable []string) (*DatumReader, error) {
+	if err := d.validate(len(stringTable)); err != nil {
+		return nil, err
+	}
+	strIdxs := make(map[string]int64, len(stringTable))
+	for idx := len(stringTable) - 1; idx >= 0; idx-- {
+		strIdxs[stringTable[idx]] = int64(idx)
+	}
+	return &DatumReader{
+		d:       d,
+		st:      stringTable,
+		strIdxs: strIdxs,
+	}, nil
+}
+
+// Datum returns the receiver's Datum.
+func (dr *DatumReader) Datum() *Datum {
+	return dr.d
+}
+
+// Keys returns the receiver's property keys, in sorted order.
+func (dr *DatumReader) Keys() []string {
+	ret := make([]string, 0, len(dr.d.Properties))
+	for k := range dr.d.Properties {
+		ret = append(ret, dr.st[k])
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Has returns true if the receiver has a property with the specified key.
+func (dr *DatumReader) Has(key string) bool {
+	_, ok := dr.Raw(key)
+	return ok
+}
+
+// Raw returns the receiver's property with the specified key, as it appears
+// in the Datum, and true; or nil and false if there is no such property.
+// Raw string-index values must be resolved against the string table; most
+// callers should prefer Value or one of the typed accessors.
+func (dr *DatumReader) Raw(key string) (*V, bool) {
+	strIdx, ok := dr.strIdxs[key]
+	if !ok {
+		return nil, false
+	}
+	v, ok := dr.d.Properties[strIdx]
+	return v, ok
+}
+
+func (dr *DatumReader) property(key string) (*V, error) {
+	v, ok := dr.Raw(key)
+	if !ok {
+		return nil, fmt.Errorf("missing property '%s'", key)
+	}
+	return v, nil
+}
+
+// Value returns the receiver's property with the specified key as a native Go
+// value: a string, []string, int64, []int64, float64, time.Duration,
+// time.Time, bool, []any, or map[string]any, or nil for unset values.  String
+// indices are resolved to their strings.
+func (dr *DatumReader) Value(key string) (any, error) {
+	v, err := dr.property(key)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := dr.goValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("property '%s': %w", key, err)
+	}
+	return ret, nil
+}
+
+func (dr *DatumReader) goValue(v *V) (any, error) {
+	switch v.T {
+	case unsetValue:
+		return nil, nil
+	case StringValueType, StringIndexValueType:
+		return dr.str(v)
+	case StringsValueType, StringIndicesValueType:
+		return dr.strs(v)
+	case IntegerValueType:
+		return ExpectIntegerValue(v)
+	case IntegersValueType:
+		return ExpectIntegersValue(v)
+	case DoubleValueType:
+		return ExpectDoubleValue(v)
+	case DurationValueType:
+		return ExpectDurationValue(v)
+	case TimestampValueType:
+		return ExpectTimestampValue(v)
+	case BoolValueType:
+		return ExpectBoolValue(v)
+	case ListValueType:
+		vals, err := ExpectListValue(v)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]any, len(vals))
+		for idx, val := range vals {
+			if ret[idx], err = dr.goValue(val); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case MapValueType:
+		vals, err := ExpectMapValue(v)
+		if err != nil {
+			return nil, err
+		}
+		ret := make(map[string]any, len(vals))
+		for k, val := range vals {
+			if ret[k], err = dr.goValue(val); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("unsupported value type %d", v.T)
+	}
+}
+
+// str returns the string held in the provided string or string-index value.
+// Builders only produce string-index values; literal string values are read
+// with ExpectStringValue, as they are in requests.
+func (dr *DatumReader) str(v *V) (string, error) {
+	if v.T == StringValueType {
+		return ExpectStringValue(v)
+	}
+	strIdx, err := expectStringIndexValue(v)
+	if err != nil {
+		return "", fmt.Errorf("expected value type 'str' or 'str_idx'")
+	}
+	return dr.st[strIdx], nil
+}
+
+// strs returns the strings held in the provided strings or string-indices
+// value.
+func (dr *DatumReader) strs(v *V) ([]string, error) {
+	if v.T == StringsValueType {
+		return ExpectStringsValue(v)
+	}
+	strIdxs, err := expectStringIndicesValue(v)
+	if err != nil {
+		return nil, fmt.Errorf("expected value type 'strs' or 'str_idxs'")
+	}
+	ret := make([]string, len(strIdxs))
+	for idx, strIdx := range strIdxs {
+		ret[idx] = dr.st[strIdx]
+	}
+	return ret, nil
+}
+
+// typed returns the receiver's property with the specified key, converted by
+// the provided function.
+func typed[T any](dr *DatumReader, key string, conv func(v *V) (T, error)) (T, error) {
+	v, err := dr.property(key)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	ret, err := conv(v)
+	if err != nil {
+		return ret, fmt.Errorf("property '%s': %w", key, err)
+	}
+	return ret, nil
+}
+
+// String returns the receiver's string property with the specified key.
+func (dr *DatumReader) String(key string) (string, error) {
+	return typed(dr, key, dr.str)
+}
+
+// Strings returns the receiver's strings property with the specified key.
+func (dr *DatumReader) Strings(key string) ([]string, error) {
+	return typed(dr, key, dr.strs)
+}
+
+// Integer returns the receiver's integer property with the specified key.
+func (dr *DatumReader) Integer(key string) (int64, error) {
+	return typed(dr, key, ExpectIntegerValue)
+}
+
+// Integers returns the receiver's integers property with the specified key.
+func (dr *DatumReader) Integers(key string) ([]int64, error) {
+	return typed(dr, key, ExpectIntegersValue)
+}
+
+// Double returns the receiver's double property with the specified key.
+func (dr *DatumReader) Double(key string) (float64, error) {
+	return typed(dr, key, ExpectDoubleValue)
+}
+
+// Duration returns the receiver's duration property with the specified key.
+func (dr *DatumReader) Duration(key string) (time.Duration, error) {
+	return typed(dr, key, ExpectDurationValue)
+}
+
+// Timestamp returns the receiver's timestamp property with the specified
+// key.
+func (dr *DatumReader) Timestamp(key string) (time.Time, error) {
+	return typed(dr, key, ExpectTimestampValue)
+}
+
+// Bool returns the receiver's bool property with the specified key.
+func (dr *DatumReader) Bool(key string) (bool, error) {
+	return typed(dr, key, ExpectBoolValue)
+}
+
+// Children returns readers over the receiver's children, in order.
+func (dr *DatumReader) Children() []*DatumReader {
+	ret := make([]*DatumReader, len(dr.d.Children))
+	for idx, child := range dr.d.Children {
+		ret[idx] = &DatumReader{
+			d:       child,
+			st:      dr.st,
+			strIdxs: dr.strIdxs,
+		}
+	}
+	return ret
+}
+
+// Replay populates the provided DataBuilder with the properties and children
+// of the provided Datum, whose property keys and string-index values refer to
+// the provided string table.  This allows a previously-built Datum, such as a
+// cached response, to be reproduced in a new response; replayed Datums are
+// subject to the DataBuilder's ResponseLimits like any others.  It returns a
+// DecodeError if the Datum refers to strings outside the string table.
+func Replay(db DataBuilder, d *Datum, stringTable []string) error {
+	if err := d.validate(len(stringTable)); err != nil {
+		return err
+	}
+	replay(db, d, stringTable)
+	return nil
+}
+
+func replay(db DataBuilder, d *Datum, st []string) {
+	db.With(func(db *datumBuilder) error {
+		for _, keyIdx := range sortedKeys(d.Properties) {
+			key, v := st[keyIdx], d.Properties[keyIdx]
+			switch v.T {
+			case StringIndexValueType:
+				db.withStr(key, st[v.V.(int64)])
+			case StringIndicesValueType:
+				strIdxs := v.V.([]int64)
+				strs := make([]string, len(strIdxs))
+				for idx, strIdx := range strIdxs {
+					strs[idx] = st[strIdx]
+				}
+				db.withStrs(key, strs...)
+			default:
+				// Other values don't refer to the string table, and are never
+				// modified once built, so may be shared.
+				db.valsByKey[db.st.stringIndex(key)] = v
+			}
+		}
+		return nil
+	})
+	for _, child := range d.Children {
+		replay(db.Child(), child, st)
+	}
+}
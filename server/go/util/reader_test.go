@@ -0,0 +1,158 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	goerrors "errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDatumReader(t *testing.T) {
+	drb := NewDataResponseBuilder()
+	drb.DataSeries(&DataSeriesRequest{SeriesName: "s"}).With(
+		StringProperty("str", "a b%"),
+		StringsProperty("strs", "c", "d"),
+		IntegerProperty("int", 1),
+		IntegersProperty("ints", 2, 3),
+		DoubleProperty("dbl", 1.5),
+		DurationProperty("dur", time.Second),
+		TimestampProperty("ts", time.Unix(100, 0)),
+		BoolProperty("bool", true),
+		ListProperty("list", StringValue("e"), IntValue(4)),
+	).Child().With(
+		StringProperty("child", "f"),
+	)
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	dr, err := NewDatumReader(data.DataSeries[0].Root, data.StringTable)
+	if err != nil {
+		t.Fatalf("NewDatumReader() yielded unexpected error %s", err)
+	}
+	wantKeys := []string{"bool", "dbl", "dur", "int", "ints", "list", "str", "strs", "ts"}
+	if diff := cmp.Diff(wantKeys, dr.Keys()); diff != "" {
+		t.Errorf("Keys() = %v, diff (-want +got) %s", dr.Keys(), diff)
+	}
+	for _, test := range []struct {
+		description string
+		get         func() (any, error)
+		want        any
+	}{{
+		description: "string",
+		get:         func() (any, error) { return dr.String("str") },
+		want:        "a b%",
+	}, {
+		description: "strings",
+		get:         func() (any, error) { return dr.Strings("strs") },
+		want:        []string{"c", "d"},
+	}, {
+		description: "integer",
+		get:         func() (any, error) { return dr.Integer("int") },
+		want:        int64(1),
+	}, {
+		description: "integers",
+		get:         func() (any, error) { return dr.Integers("ints") },
+		want:        []int64{2, 3},
+	}, {
+		description: "double",
+		get:         func() (any, error) { return dr.Double("dbl") },
+		want:        1.5,
+	}, {
+		description: "duration",
+		get:         func() (any, error) { return dr.Duration("dur") },
+		want:        time.Second,
+	}, {
+		description: "timestamp",
+		get:         func() (any, error) { return dr.Timestamp("ts") },
+		want:        time.Unix(100, 0),
+	}, {
+		description: "bool",
+		get:         func() (any, error) { return dr.Bool("bool") },
+		want:        true,
+	}, {
+		description: "value",
+		get:         func() (any, error) { return dr.Value("list") },
+		want:        []any{"e", int64(4)},
+	}, {
+		description: "child",
+		get:         func() (any, error) { return dr.Children()[0].String("child") },
+		want:        "f",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := test.get()
+			if err != nil {
+				t.Fatalf("yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("got %v, diff (-want +got) %s", got, diff)
+			}
+		})
+	}
+	if _, err := dr.Integer("str"); err == nil {
+		t.Errorf("Integer() of a string property succeeded, wanted error")
+	}
+	if _, err := dr.String("missing"); err == nil {
+		t.Errorf("String() of a missing property succeeded, wanted error")
+	}
+}
+
+func TestDatumReaderRejectsMalformedDatum(t *testing.T) {
+	_, err := NewDatumReader(&Datum{
+		Properties: map[int64]*V{0: StringIndexValue(1)},
+	}, []string{"a"})
+	var decodeErr *DecodeError
+	if !goerrors.As(err, &decodeErr) {
+		t.Errorf("NewDatumReader() yielded error %v, want a *DecodeError", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	src := NewDataResponseBuilder()
+	src.DataSeries(&DataSeriesRequest{SeriesName: "s"}).With(
+		StringProperty("str", "a"),
+		StringsProperty("strs", "b", "c"),
+		IntegerProperty("int", 1),
+	).Child().With(
+		StringProperty("child", "d"),
+	)
+	srcData, err := src.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	// Replay into a response whose string table already holds other strings,
+	// so indices differ from the source's.
+	dest := NewDataResponseBuilder()
+	dest.DataSeries(&DataSeriesRequest{SeriesName: "other"}).With(
+		StringProperty("x", "y"),
+	)
+	if err := Replay(dest.DataSeries(&DataSeriesRequest{SeriesName: "s"}), srcData.DataSeries[0].Root, srcData.StringTable); err != nil {
+		t.Fatalf("Replay() yielded unexpected error %s", err)
+	}
+	destData, err := dest.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	want := srcData.DataSeries[0].PrettyPrint("", srcData.StringTable)
+	got := destData.DataSeries[1].PrettyPrint("", destData.StringTable)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Replay() yielded %s, diff (-want +got) %s", got, diff)
+	}
+	if err := Replay(dest.DataSeries(&DataSeriesRequest{SeriesName: "bad"}), srcData.DataSeries[0].Root, nil); err == nil {
+		t.Errorf("Replay() with a missing string table succeeded, wanted error")
+	}
+}
@@ -0,0 +1,142 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+)
+
+// DataFrame is one increment of a streamed TraceViz data response.  A client
+// reassembles the full response by appending each frame's StringTableDelta to
+// its string table, in the order frames are received, and collecting each
+// frame's DataSeries.  String indices in a frame's DataSeries refer into the
+// string table as reassembled through that frame.
+type DataFrame struct {
+	StringTableDelta []string
+	DataSeries       []*DataSeries
+}
+
+// StreamingDataResponseBuilder assembles a response to a DataRequest as a
+// sequence of DataFrames, so that completed DataSeries may be sent without
+// waiting for slower ones.  Each independently-completing portion of the
+// response is built in its own DataResponseBuilder, obtained from Part; all
+// parts share a single string table.  When a part is complete, Flush emits it
+// as a DataFrame.
+type StreamingDataResponseBuilder struct {
+	st   *stringTable
+	emit func(*DataFrame) error
+	// If true, parts allow partial results.
+	partial bool
+	// Enforces ResponseLimits and a context across all parts, if non-nil.
+	budget *responseBudget
+	// The number of string table entries emitted in previous frames.
+	sentStrings int
+	mu          sync.Mutex
+}
+
+// NewStreamingDataResponseBuilder returns a new StreamingDataResponseBuilder
+// emitting its DataFrames to the provided function.  emit is never invoked
+// concurrently, and is invoked in the order frames must be delivered.
+func NewStreamingDataResponseBuilder(emit func(*DataFrame) error) *StreamingDataResponseBuilder {
+	return &StreamingDataResponseBuilder{
+		st:   newStringTable(),
+		emit: emit,
+	}
+}
+
+// AllowPartialResults configures all parts subsequently returned by the
+// receiver's Part to allow partial results (see
+// DataResponseBuilder.AllowPartialResults).  It returns the receiver to
+// facilitate chaining.
+func (sdrb *StreamingDataResponseBuilder) AllowPartialResults() *StreamingDataResponseBuilder {
+	sdrb.partial = true
+	return sdrb
+}
+
+// WithLimits configures the receiver to enforce the provided ResponseLimits
+// across all parts subsequently returned by its Part (see
+// DataResponseBuilder.WithLimits).  It returns the receiver to facilitate
+// chaining.
+func (sdrb *StreamingDataResponseBuilder) WithLimits(limits ResponseLimits) *StreamingDataResponseBuilder {
+	if sdrb.budget == nil {
+		sdrb.budget = newResponseBudget(sdrb.st)
+	}
+	sdrb.budget.limits = limits
+	return sdrb
+}
+
+// WithContext configures all parts subsequently returned by the receiver's
+// Part to stop building once the provided context is done (see
+// DataResponseBuilder.WithContext).  It returns the receiver to facilitate
+// chaining.
+func (sdrb *StreamingDataResponseBuilder) WithContext(ctx context.Context) *StreamingDataResponseBuilder {
+	if sdrb.budget == nil {
+		sdrb.budget = newResponseBudget(sdrb.st)
+	}
+	sdrb.budget.ctx = ctx
+	return sdrb
+}
+
+// Part returns a new, empty DataResponseBuilder sharing the receiver's string
+// table.  Part is safe for concurrent use.
+func (sdrb *StreamingDataResponseBuilder) Part() *DataResponseBuilder {
+	return &DataResponseBuilder{
+		st:   sdrb.st,
+		errs: &errors{},
+		d: &Data{
+			StringTable: []string{},
+			DataSeries:  []*DataSeries{},
+		},
+		partial: sdrb.partial,
+		budget:  sdrb.budget,
+	}
+}
+
+// Flush completes the provided part, which must have been returned by the
+// receiver's Part and must not be further modified, and emits it as a
+// DataFrame along with any string table entries not emitted in a previous
+// frame.  If the part encountered errors during its construction and does not
+// allow partial results, they are returned and nothing is emitted.  Flush is
+// safe for concurrent use.
+func (sdrb *StreamingDataResponseBuilder) Flush(part *DataResponseBuilder) error {
+	dataSeries, err := part.dataSeries()
+	if err != nil {
+		return err
+	}
+	sdrb.mu.Lock()
+	defer sdrb.mu.Unlock()
+	sdrb.st.mu.RLock()
+	delta := append([]string{}, sdrb.st.stringsByIndex[sdrb.sentStrings:]...)
+	sdrb.st.mu.RUnlock()
+	sdrb.sentStrings += len(delta)
+	return sdrb.emit(&DataFrame{
+		StringTableDelta: delta,
+		DataSeries:       dataSeries,
+	})
+}
+
+// ReassembleDataFrames reassembles a complete Data from the provided frames,
+// which must be in the order they were emitted.
+func ReassembleDataFrames(frames ...*DataFrame) *Data {
+	ret := &Data{
+		StringTable: []string{},
+		DataSeries:  []*DataSeries{},
+	}
+	for _, frame := range frames {
+		ret.StringTable = append(ret.StringTable, frame.StringTableDelta...)
+		ret.DataSeries = append(ret.DataSeries, frame.DataSeries...)
+	}
+	return ret
+}
@@ -0,0 +1,95 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStreamingDataResponseBuilder(t *testing.T) {
+	var frames []*DataFrame
+	sdrb := NewStreamingDataResponseBuilder(func(frame *DataFrame) error {
+		// Round-trip each frame through JSON, as a streaming client would.
+		fj, err := json.Marshal(frame)
+		if err != nil {
+			return err
+		}
+		decodedFrame := &DataFrame{}
+		if err := json.Unmarshal(fj, decodedFrame); err != nil {
+			return err
+		}
+		frames = append(frames, decodedFrame)
+		return nil
+	})
+	slow, fast := sdrb.Part(), sdrb.Part()
+	slow.DataSeries(&DataSeriesRequest{SeriesName: "slow"}).With(
+		StringProperty("greeting", "hello"),
+	)
+	fast.DataSeries(&DataSeriesRequest{SeriesName: "fast"}).With(
+		StringProperty("greeting", "goodbye"),
+		StringsProperty("items", "apple", "banana"),
+	)
+	if err := sdrb.Flush(fast); err != nil {
+		t.Fatalf("Flush() yielded unexpected error %s", err)
+	}
+	slow.DataSeries(&DataSeriesRequest{SeriesName: "slower"}).With(
+		StringsProperty("items", "banana", "coconut"),
+	)
+	if err := sdrb.Flush(slow); err != nil {
+		t.Fatalf("Flush() yielded unexpected error %s", err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("Got %d frames, want 2", len(frames))
+	}
+	if got := len(frames[1].StringTableDelta); got != 1 {
+		t.Errorf("Got second frame string table delta %v, want only 'coconut'", frames[1].StringTableDelta)
+	}
+	want := `Data:
+  Series fast
+    Root:
+      Prop 'greeting': 'goodbye'
+      Prop 'items': [ 'apple', 'banana' ]
+  Series slow
+    Root:
+      Prop 'greeting': 'hello'
+  Series slower
+    Root:
+      Prop 'items': [ 'banana', 'coconut' ]`
+	got := ReassembleDataFrames(frames...).PrettyPrint()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got reassembled data %s, diff (-want +got) %s", got, diff)
+	}
+}
+
+func TestStreamingDataResponseBuilderErrors(t *testing.T) {
+	emitted := false
+	sdrb := NewStreamingDataResponseBuilder(func(frame *DataFrame) error {
+		emitted = true
+		return nil
+	})
+	part := sdrb.Part()
+	part.DataSeries(&DataSeriesRequest{SeriesName: "broken"}).With(
+		ErrorProperty(fmt.Errorf("oops")),
+	)
+	if err := sdrb.Flush(part); err == nil {
+		t.Errorf("Flush() succeeded, wanted error")
+	}
+	if emitted {
+		t.Errorf("Flush() emitted a frame for an errored part")
+	}
+}
@@ -16,8 +16,8 @@
 // DataResponseBuilder, for populating responses to DataRequests;
 //
 // {type}Value functions (type={String, StringIndex, Strings, StringIndices,
-// Int, Ints, Double, Duration, TImestamp}) for safely constructing Values of
-// the specified type;
+// Int, Ints, Double, Duration, TImestamp, Bool, List, Map}) for safely
+// constructing Values of the specified type;
 //
 // Expect{type}Value functions, over the same types, for safely retrieving
 // values of the specified types from Values, returning an error if there's a
@@ -28,6 +28,7 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/url"
 	"sort"
@@ -53,6 +54,12 @@ const (
 	DoubleValueType
 	DurationValueType
 	TimestampValueType
+	BoolValueType
+	// ListValueType values hold a list of other Values, of any types.
+	ListValueType
+	// MapValueType values hold a string-keyed map of other Values, of any
+	// types.
+	MapValueType
 )
 
 // V represents a value in a TraceViz request or response.
@@ -123,6 +130,35 @@ func (v *V) PrettyPrint(st []string) string {
 		var ts time.Time
 		ts, err = ExpectTimestampValue(v)
 		ret = ts.String()
+	case BoolValueType:
+		var b bool
+		b, err = ExpectBoolValue(v)
+		ret = strconv.FormatBool(b)
+	case ListValueType:
+		var vals []*V
+		vals, err = ExpectListValue(v)
+		if err == nil {
+			strs := make([]string, len(vals))
+			for idx, val := range vals {
+				strs[idx] = val.PrettyPrint(st)
+			}
+			ret = "[ " + strings.Join(strs, ", ") + " ]"
+		}
+	case MapValueType:
+		var vals map[string]*V
+		vals, err = ExpectMapValue(v)
+		if err == nil {
+			keys := make([]string, 0, len(vals))
+			for k := range vals {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			strs := make([]string, len(keys))
+			for idx, k := range keys {
+				strs[idx] = "'" + k + "': " + vals[k].PrettyPrint(st)
+			}
+			ret = "{ " + strings.Join(strs, ", ") + " }"
+		}
 	}
 	if err != nil {
 		return "error: " + err.Error()
@@ -148,7 +184,10 @@ func (ts timestamp) MarshalJSON() ([]byte, error) {
 //	  number   |                      ; if integer, string index, double, or duration
 //	  string[] |                      ; if strings
 //	  number[] |                      ; if integers or string indices
-//	  [number, number]                ; if timestamp ([secs, nanos] from epoch)
+//	  [number, number] |              ; if timestamp ([secs, nanos] from epoch)
+//	  boolean          |              ; if bool
+//	  V[]              |              ; if list
+//	  {[key: string]: V}              ; if map
 //	]
 func (v *V) MarshalJSON() ([]byte, error) {
 	ret := [2]any{v.T, v.V}
@@ -156,58 +195,77 @@ func (v *V) MarshalJSON() ([]byte, error) {
 }
 
 func (v *V) fromAny(got []any) error {
-	t, err := got[0].(json.Number).Int64()
+	if len(got) != 2 {
+		return decodeErrorf("Value must have 2 elements, got %d", len(got))
+	}
+	t, err := int64FromAny(got[0], "Value type")
 	if err != nil {
 		return err
 	}
 	v.T = valueType(t)
 	tv := got[1]
 	switch v.T {
+	case unsetValue:
+		v.V = nil
+	case StringValueType:
+		if v.V, err = stringFromAny(tv, "string Value"); err != nil {
+			return err
+		}
 	case StringIndexValueType, IntegerValueType:
-		if v.V, err = tv.(json.Number).Int64(); err != nil {
+		if v.V, err = int64FromAny(tv, "integer Value"); err != nil {
 			return err
 		}
 	case StringsValueType:
-		strIfs := tv.([]any)
+		strIfs, err := sliceFromAny(tv, "strings Value")
+		if err != nil {
+			return err
+		}
 		strs := make([]string, len(strIfs))
 		for idx, strIf := range strIfs {
-			str, err := url.QueryUnescape(strIf.(string))
+			str, err := stringFromAny(strIf, "strings Value element")
 			if err != nil {
-				return err
+				return at(idxElem(idx), err)
+			}
+			if strs[idx], err = url.QueryUnescape(str); err != nil {
+				return at(idxElem(idx), err)
 			}
-			strs[idx] = str
 		}
 		v.V = strs
 	case DoubleValueType:
-		if v.V, err = tv.(json.Number).Float64(); err != nil {
+		if v.V, err = float64FromAny(tv, "double Value"); err != nil {
 			return err
 		}
 	case StringIndicesValueType, IntegersValueType:
-		nums := tv.([]any)
+		nums, err := sliceFromAny(tv, "integers Value")
+		if err != nil {
+			return err
+		}
 		ints := make([]int64, len(nums))
 		for idx, num := range nums {
-			ints[idx], err = num.(json.Number).Int64()
-			if err != nil {
-				return err
+			if ints[idx], err = int64FromAny(num, "integers Value element"); err != nil {
+				return at(idxElem(idx), err)
 			}
 		}
 		v.V = ints
 	case DurationValueType:
-		durNs, err := tv.(json.Number).Int64()
+		durNs, err := int64FromAny(tv, "duration Value")
 		if err != nil {
 			return err
 		}
 		v.V = time.Duration(durNs)
 	case TimestampValueType:
-		parts := tv.([]any)
+		parts, err := sliceFromAny(tv, "timestamp Value")
+		if err != nil {
+			return err
+		}
 		if len(parts) != 2 {
-			return fmt.Errorf("timestamp Value is improperly formed")
+			return decodeErrorf("timestamp Value must have 2 elements, got %d", len(parts))
 		}
-		unixSecs, err := parts[0].(json.Number).Int64()
+		unixSecs, err := int64FromAny(parts[0], "timestamp Value seconds")
 		if err != nil {
 			return err
 		}
-		unixNanos, err := parts[1].(json.Number).Int64()
+		unixNanos, err := int64FromAny(parts[1], "timestamp Value nanoseconds")
 		if err != nil {
 			return err
 		}
@@ -215,10 +273,50 @@ func (v *V) fromAny(got []any) error {
 			UnixSeconds: unixSecs,
 			UnixNanos:   unixNanos,
 		}
+	case BoolValueType:
+		b, ok := tv.(bool)
+		if !ok {
+			return decodeErrorf("bool Value must be a boolean, got %s", jsonKind(tv))
+		}
+		v.V = b
+	case ListValueType:
+		elems, err := sliceFromAny(tv, "list Value")
+		if err != nil {
+			return err
+		}
+		vals := make([]*V, len(elems))
+		for idx, elem := range elems {
+			elemParts, err := sliceFromAny(elem, "list Value element")
+			if err != nil {
+				return at(idxElem(idx), err)
+			}
+			vals[idx] = &V{}
+			if err := vals[idx].fromAny(elemParts); err != nil {
+				return at(idxElem(idx), err)
+			}
+		}
+		v.V = vals
+	case MapValueType:
+		entries, ok := tv.(map[string]any)
+		if !ok {
+			return decodeErrorf("map Value must be an object, got %s", jsonKind(tv))
+		}
+		vals := make(map[string]*V, len(entries))
+		for k, entry := range entries {
+			entryParts, err := sliceFromAny(entry, "map Value entry")
+			if err != nil {
+				return at(keyElem(k), err)
+			}
+			vals[k] = &V{}
+			if err := vals[k].fromAny(entryParts); err != nil {
+				return at(keyElem(k), err)
+			}
+		}
+		v.V = vals
 	default:
-		v.V = tv
+		return decodeErrorf("unknown value type %d", t)
 	}
-	return err
+	return nil
 }
 
 // UnmarshalJSON unmarshals the provided JSON bytes into the receiving V.
@@ -264,6 +362,19 @@ func (d *Datum) PrettyPrint(indent string, st []string) string {
 	return strings.Join(ret, "\n")
 }
 
+// sortedKeys returns the keys of the provided property map in increasing
+// order.
+func sortedKeys(props map[int64]*V) []int64 {
+	keys := make([]int64, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return keys[a] < keys[b]
+	})
+	return keys
+}
+
 // MarshalJSON overrides the default JSON marshaling behavior for Datum to
 // reduce response sizes.  A Datum is encoded as the JS object `Datum`:
 //
@@ -276,14 +387,7 @@ func (d *Datum) PrettyPrint(indent string, st []string) string {
 func (d *Datum) MarshalJSON() ([]byte, error) {
 	props := make([]any, len(d.Properties))
 	children := make([]any, len(d.Children))
-	keys := make([]int64, 0, len(d.Properties))
-	for k := range d.Properties {
-		keys = append(keys, k)
-	}
-	sort.Slice(keys, func(a, b int) bool {
-		return keys[a] < keys[b]
-	})
-	for idx, k := range keys {
+	for idx, k := range sortedKeys(d.Properties) {
 		props[idx] = []any{k, d.Properties[k]}
 	}
 	for idx, child := range d.Children {
@@ -293,25 +397,49 @@ func (d *Datum) MarshalJSON() ([]byte, error) {
 }
 
 func (d *Datum) fromAny(sd []any) error {
-	props := sd[0]
-	children := sd[1]
-	d.Properties = make(map[int64]*V, len(props.([]any)))
-	d.Children = make([]*Datum, len(children.([]any)))
-	for _, val := range props.([]any) {
-		k, err := ((val.([]any))[0].(json.Number)).Int64()
+	if len(sd) != 2 {
+		return decodeErrorf("Datum must have 2 elements, got %d", len(sd))
+	}
+	props, err := sliceFromAny(sd[0], "Datum properties")
+	if err != nil {
+		return err
+	}
+	children, err := sliceFromAny(sd[1], "Datum children")
+	if err != nil {
+		return err
+	}
+	d.Properties = make(map[int64]*V, len(props))
+	d.Children = make([]*Datum, len(children))
+	for idx, val := range props {
+		kv, err := sliceFromAny(val, "Datum property")
 		if err != nil {
-			return err
+			return at("Properties", at(idxElem(idx), err))
+		}
+		if len(kv) != 2 {
+			return at("Properties", at(idxElem(idx), decodeErrorf("Datum property must have 2 elements, got %d", len(kv))))
+		}
+		k, err := int64FromAny(kv[0], "Datum property key")
+		if err != nil {
+			return at("Properties", at(idxElem(idx), err))
+		}
+		vParts, err := sliceFromAny(kv[1], "Datum property value")
+		if err != nil {
+			return at(fmt.Sprintf("Properties[%d]", k), err)
 		}
 		v := &V{}
-		if err := v.fromAny((val.([]any))[1].([]any)); err != nil {
-			return err
+		if err := v.fromAny(vParts); err != nil {
+			return at(fmt.Sprintf("Properties[%d]", k), err)
 		}
 		d.Properties[k] = v
 	}
-	for idx, val := range children.([]any) {
+	for idx, val := range children {
+		childParts, err := sliceFromAny(val, "Datum child")
+		if err != nil {
+			return at(fmt.Sprintf("Children[%d]", idx), err)
+		}
 		child := &Datum{}
-		if err := child.fromAny(val.([]any)); err != nil {
-			return err
+		if err := child.fromAny(childParts); err != nil {
+			return at(fmt.Sprintf("Children[%d]", idx), err)
 		}
 		d.Children[idx] = child
 	}
@@ -337,34 +465,67 @@ type DataSeriesRequest struct {
 	Options    map[string]*V
 }
 
+// SeriesError describes why a DataSeries failed, in a response with partial
+// results allowed.
+type SeriesError struct {
+	// The query name of the failed DataSeriesRequest.
+	QueryName string
+	// The data source that failed, if known.
+	DataSource string `json:",omitempty"`
+	// A human-readable description of the failure.
+	Message string
+}
+
 // DataSeries represents a complete TraceViz data series response.
 type DataSeries struct {
 	SeriesName string
 	Root       *Datum
+	// If non-nil, this series failed, and Root is empty.  Only set in responses
+	// allowing partial results.
+	Error *SeriesError `json:",omitempty"`
+	// If non-nil, this series is incomplete because its response reached one of
+	// its ResponseLimits.
+	Truncated *Truncation `json:",omitempty"`
 }
 
 // PrettyPrint returns the receiver deterministically prettyprinted.
 // Only for use in tests.
 func (ds *DataSeries) PrettyPrint(indent string, st []string) string {
-	return strings.Join([]string{
+	ret := []string{
 		fmt.Sprintf("%sSeries %s", indent, ds.SeriesName),
-		indent + "  " + "Root:",
+	}
+	if ds.Error != nil {
+		ret = append(ret, fmt.Sprintf("%s  Error in query '%s' (data source '%s'): %s", indent, ds.Error.QueryName, ds.Error.DataSource, ds.Error.Message))
+	}
+	if ds.Truncated != nil {
+		ret = append(ret, fmt.Sprintf("%s  Truncated at %s: dropped %d datums, %d updates", indent, ds.Truncated.Limit, ds.Truncated.DroppedDatums, ds.Truncated.DroppedUpdates))
+	}
+	ret = append(ret,
+		indent+"  "+"Root:",
 		ds.Root.PrettyPrint(indent+"    ", st),
-	}, "\n")
+	)
+	return strings.Join(ret, "\n")
 }
 
 // DataRequest is a request for one or more data series from a TraceViz client.
 type DataRequest struct {
 	GlobalFilters  map[string]*V
 	SeriesRequests []*DataSeriesRequest
+	// If true, the client accepts partial results: a failure in one data series
+	// is reported in that series' Error, rather than failing the whole
+	// request.
+	AllowPartialResults bool `json:",omitempty"`
 }
 
 // DataRequestFromJSON attempts to construct a DataRequest from the provided
-// JSON.
+// JSON.  If the JSON is well-formed but does not describe a well-formed
+// DataRequest, the returned error is a *DecodeError.
 func DataRequestFromJSON(j []byte) (*DataRequest, error) {
 	ret := &DataRequest{}
-	err := json.Unmarshal(j, ret)
-	return ret, err
+	if err := json.Unmarshal(j, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
 }
 
 // Data represents a complete TraceViz data response.
@@ -388,7 +549,9 @@ func (d *Data) PrettyPrint() string {
 type stringTable struct {
 	stringsToIndices map[string]int64
 	stringsByIndex   []string
-	mu               sync.RWMutex
+	// The total length, in bytes, of stringsByIndex.
+	bytes int
+	mu    sync.RWMutex
 }
 
 // newStringTable returns a new stringTable populated with the provided
@@ -427,9 +590,17 @@ func (st *stringTable) stringIndex(str string) int64 {
 	idx = int64(len(st.stringsByIndex))
 	st.stringsByIndex = append(st.stringsByIndex, str)
 	st.stringsToIndices[str] = idx
+	st.bytes += len(str)
 	return idx
 }
 
+// size returns the total length, in bytes, of the strings in the receiver.
+func (st *stringTable) size() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.bytes
+}
+
 type errors struct {
 	hasError bool
 	errs     []error
@@ -466,7 +637,30 @@ type DataResponseBuilder struct {
 	st   *stringTable
 	errs *errors
 	d    *Data
-	mu   sync.Mutex
+	// If true, errors encountered while building a DataSeries fail only that
+	// series; see AllowPartialResults.
+	partial bool
+	// Enforces the receiver's ResponseLimits and context, if any; see WithLimits
+	// and WithContext.
+	budget *responseBudget
+	series []*seriesBuilder
+	mu     sync.Mutex
+}
+
+// seriesBuilder tracks the construction of a single DataSeries.
+type seriesBuilder struct {
+	req *DataSeriesRequest
+	ds  *DataSeries
+	// The errors encountered building this series.  Unless partial results are
+	// allowed, this is shared with the DataResponseBuilder.
+	errs *errors
+	// The data source that failed this series, if known.
+	dataSource string
+	// Enforces the DataResponseBuilder's ResponseLimits on this series.
+	budget *seriesBudget
+	// How this series was truncated before it was built, if it was; see
+	// MarkTruncated.
+	truncated *Truncation
 }
 
 // NewDataResponseBuilder returns a new DataResponseBuilder configured with the
@@ -482,6 +676,40 @@ func NewDataResponseBuilder() *DataResponseBuilder {
 	}
 }
 
+// AllowPartialResults configures the receiver to return partial results:
+// rather than failing the entire response, an error encountered while
+// building a DataSeries (e.g., via ErrorProperty or FailSeries) replaces that
+// series' contents with a SeriesError, and other series are returned as
+// usual.  It must be invoked before any DataSeries are added, and returns the
+// receiver to facilitate chaining.
+func (drb *DataResponseBuilder) AllowPartialResults() *DataResponseBuilder {
+	drb.partial = true
+	return drb
+}
+
+// WithLimits configures the receiver to enforce the provided ResponseLimits,
+// truncating any DataSeries that reach them.  It must be invoked before any
+// DataSeries are added, and returns the receiver to facilitate chaining.
+func (drb *DataResponseBuilder) WithLimits(limits ResponseLimits) *DataResponseBuilder {
+	if drb.budget == nil {
+		drb.budget = newResponseBudget(drb.st)
+	}
+	drb.budget.limits = limits
+	return drb
+}
+
+// WithContext configures the receiver to stop building once the provided
+// context is done, whereupon Data returns the context's error.  It must be
+// invoked before any DataSeries are added, and returns the receiver to
+// facilitate chaining.
+func (drb *DataResponseBuilder) WithContext(ctx context.Context) *DataResponseBuilder {
+	if drb.budget == nil {
+		drb.budget = newResponseBudget(drb.st)
+	}
+	drb.budget.ctx = ctx
+	return drb
+}
+
 // DataBuilder is implemented by types that can assemble TraceViz responses.
 type DataBuilder interface {
 	With(updates ...PropertyUpdate) DataBuilder
@@ -491,26 +719,169 @@ type DataBuilder interface {
 // DataSeries returns a new DataBuilder for assembling the response to the
 // provided DataSeriesRequest.  DataSeries is safe for concurrent use.
 func (drb *DataResponseBuilder) DataSeries(req *DataSeriesRequest) DataBuilder {
-	ret := newDatumBuilder(drb.errs, drb.st)
+	errs := drb.errs
+	if drb.partial {
+		errs = &errors{}
+	}
+	ret := newDatumBuilder(errs, drb.st)
+	ret.budget = drb.budget.series()
 	ds := &DataSeries{
 		SeriesName: req.SeriesName,
 		Root:       ret.d,
 	}
 	drb.mu.Lock()
 	drb.d.DataSeries = append(drb.d.DataSeries, ds)
+	drb.series = append(drb.series, &seriesBuilder{
+		req:    req,
+		ds:     ds,
+		errs:   errs,
+		budget: ret.budget,
+	})
 	drb.mu.Unlock()
 	return ret
 }
 
+// FailSeries records that the provided DataSeriesRequests failed in the
+// specified data source with the provided error.  Unless partial results are
+// allowed, this fails the entire response.  FailSeries is safe for concurrent
+// use.
+func (drb *DataResponseBuilder) FailSeries(dataSource string, err error, reqs ...*DataSeriesRequest) {
+	if !drb.partial {
+		drb.errs.add(err)
+		return
+	}
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	for _, req := range reqs {
+		found := false
+		for _, sb := range drb.series {
+			if sb.req == req {
+				found = true
+				sb.dataSource = dataSource
+				sb.errs.add(err)
+			}
+		}
+		if !found {
+			// The request's series was never added; add an empty one to carry the
+			// error.
+			sb := &seriesBuilder{
+				req: req,
+				ds: &DataSeries{
+					SeriesName: req.SeriesName,
+				},
+				errs:       &errors{},
+				dataSource: dataSource,
+			}
+			sb.errs.add(err)
+			drb.d.DataSeries = append(drb.d.DataSeries, sb.ds)
+			drb.series = append(drb.series, sb)
+		}
+	}
+}
+
+// MarkTruncated records that the DataSeries responding to the provided
+// DataSeriesRequests were truncated, as described by the provided Truncation,
+// before they were built in the receiver: for instance, because they are
+// replayed from a response that was itself truncated.  Each series must
+// already have been added with DataSeries.  If a series is marked more than
+// once, or also reaches the receiver's own limits, its Truncation reports the
+// first limit reached and all dropped Datums and updates.  MarkTruncated is
+// safe for concurrent use.
+func (drb *DataResponseBuilder) MarkTruncated(truncation *Truncation, reqs ...*DataSeriesRequest) {
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	for _, req := range reqs {
+		for _, sb := range drb.series {
+			if sb.req == req {
+				sb.truncated = combineTruncations(sb.truncated, truncation)
+			}
+		}
+	}
+}
+
+// combineTruncations returns a Truncation reporting the limit of the first
+// of the provided Truncations, and the Datums and updates dropped by both.
+// Either may be nil.
+func combineTruncations(first, second *Truncation) *Truncation {
+	if first == nil && second == nil {
+		return nil
+	}
+	ret := &Truncation{}
+	for _, t := range []*Truncation{first, second} {
+		if t == nil {
+			continue
+		}
+		if ret.Limit == "" {
+			ret.Limit = t.Limit
+		}
+		ret.DroppedDatums += t.DroppedDatums
+		ret.DroppedUpdates += t.DroppedUpdates
+	}
+	return ret
+}
+
+// dataSeries completes and returns the DataSeries under construction.
+// Truncated series are marked with Truncations.  If partial results are
+// allowed, failed series are marked with SeriesErrors; otherwise, any error
+// fails the whole response.  If the receiver's context is done, its error is
+// returned.
+func (drb *DataResponseBuilder) dataSeries() ([]*DataSeries, error) {
+	if err := drb.budget.err(); err != nil {
+		return nil, err
+	}
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	if !drb.partial && drb.errs.hasError {
+		return nil, drb.errs.toError()
+	}
+	for _, sb := range drb.series {
+		sb.ds.Truncated = combineTruncations(sb.truncated, sb.budget.truncated())
+		if !drb.partial || !sb.errs.hasError {
+			continue
+		}
+		sb.ds.Truncated = nil
+		sb.ds.Root = &Datum{
+			Properties: map[int64]*V{},
+			Children:   []*Datum{},
+		}
+		sb.ds.Error = &SeriesError{
+			QueryName:  sb.req.QueryName,
+			DataSource: sb.dataSource,
+			Message:    sb.errs.Error(),
+		}
+	}
+	return drb.d.DataSeries, nil
+}
+
 // Data completes and returns the Data under construction.
 func (drb *DataResponseBuilder) Data() (*Data, error) {
-	if drb.errs.hasError {
-		return nil, drb.errs.toError()
+	dataSeries, err := drb.dataSeries()
+	if err != nil {
+		return nil, err
 	}
+	drb.d.DataSeries = dataSeries
 	drb.d.StringTable = drb.st.stringsByIndex
 	return drb.d, nil
 }
 
+// DataByRequest completes and returns the Data under construction, as Data
+// does, along with its DataSeries keyed by the DataSeriesRequests they respond
+// to.  Unlike SeriesNames, which several requests may share, requests
+// identify their DataSeries unambiguously.
+func (drb *DataResponseBuilder) DataByRequest() (*Data, map[*DataSeriesRequest]*DataSeries, error) {
+	data, err := drb.Data()
+	if err != nil {
+		return nil, nil, err
+	}
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	seriesByRequest := make(map[*DataSeriesRequest]*DataSeries, len(drb.series))
+	for _, sb := range drb.series {
+		seriesByRequest[sb.req] = sb.ds
+	}
+	return data, seriesByRequest, nil
+}
+
 // Quick builders for Value types.
 
 // StringValue returns a new Value wrapping the provided string.
@@ -596,6 +967,36 @@ func TimestampValue(t time.Time) *V {
 	}
 }
 
+// BoolValue returns a new Value wrapping the provided bool.
+func BoolValue(b bool) *V {
+	return &V{
+		V: b,
+		T: BoolValueType,
+	}
+}
+
+// ListValue returns a new Value wrapping the provided Values.
+func ListValue(vals ...*V) *V {
+	if vals == nil {
+		vals = []*V{}
+	}
+	return &V{
+		V: vals,
+		T: ListValueType,
+	}
+}
+
+// MapValue returns a new Value wrapping the provided string-keyed Values.
+func MapValue(vals map[string]*V) *V {
+	if vals == nil {
+		vals = map[string]*V{}
+	}
+	return &V{
+		V: vals,
+		T: MapValueType,
+	}
+}
+
 // ExpectStringValue expects the provided Value to be a string, returning
 // that string or an error if it isn't.
 func ExpectStringValue(val *V) (string, error) {
@@ -648,7 +1049,7 @@ func ExpectIntegerValue(val *V) (int64, error) {
 // that Integer's contained int64 slice or an error if it isn't.
 func ExpectIntegersValue(val *V) ([]int64, error) {
 	if val.T != IntegersValueType {
-		return nil, fmt.Errorf("expected value type 'str_idxs'")
+		return nil, fmt.Errorf("expected value type 'ints'")
 	}
 	return val.V.([]int64), nil
 }
@@ -675,12 +1076,39 @@ func ExpectDurationValue(val *V) (time.Duration, error) {
 // that timestamp or an error if it isn't.
 func ExpectTimestampValue(val *V) (time.Time, error) {
 	if val.T != TimestampValueType {
-		return time.Time{}, fmt.Errorf("expected value type 'duration'")
+		return time.Time{}, fmt.Errorf("expected value type 'timestamp'")
 	}
 	ts := val.V.(timestamp)
 	return time.Unix(ts.UnixSeconds, ts.UnixNanos), nil
 }
 
+// ExpectBoolValue expects the provided Value to be a bool, returning that bool
+// or an error if it isn't.
+func ExpectBoolValue(val *V) (bool, error) {
+	if val.T != BoolValueType {
+		return false, fmt.Errorf("expected value type 'bool'")
+	}
+	return val.V.(bool), nil
+}
+
+// ExpectListValue expects the provided Value to be a list, returning its
+// contained Values or an error if it isn't.
+func ExpectListValue(val *V) ([]*V, error) {
+	if val.T != ListValueType {
+		return nil, fmt.Errorf("expected value type 'list'")
+	}
+	return val.V.([]*V), nil
+}
+
+// ExpectMapValue expects the provided Value to be a map, returning its
+// contained string-keyed Values or an error if it isn't.
+func ExpectMapValue(val *V) (map[string]*V, error) {
+	if val.T != MapValueType {
+		return nil, fmt.Errorf("expected value type 'map'")
+	}
+	return val.V.(map[string]*V), nil
+}
+
 // PropertyUpdate is a function that updates a provided datumBuilder.  A nil
 // PropertyUpdate does nothing.
 type PropertyUpdate func(db *datumBuilder) error
@@ -707,6 +1135,8 @@ type datumBuilder struct {
 	st        *stringTable
 	valsByKey map[int64]*V
 	d         *Datum
+	// If non-nil, limits the growth of this datumBuilder's series.
+	budget *seriesBudget
 }
 
 // newDatumBuilder returns a new, empty datumBuilder.
@@ -727,11 +1157,18 @@ func newDatumBuilder(errs *errors, st *stringTable) *datumBuilder {
 func (db *datumBuilder) With(updates ...PropertyUpdate) DataBuilder {
 	if !db.errs.hasError {
 		for _, update := range updates {
-			if update != nil {
-				if err := update(db); err != nil {
-					db.errs.add(err)
-					break
-				}
+			if update == nil {
+				continue
+			}
+			target := db
+			if !db.budget.admitUpdate() {
+				// The series is truncated, so discard the update's effects, but
+				// still surface any error it yields.
+				target = newDatumBuilder(db.errs, newStringTable())
+			}
+			if err := update(target); err != nil {
+				db.errs.add(err)
+				break
 			}
 		}
 	}
@@ -740,7 +1177,12 @@ func (db *datumBuilder) With(updates ...PropertyUpdate) DataBuilder {
 
 func (db *datumBuilder) Child() DataBuilder {
 	child := newDatumBuilder(db.errs, db.st)
-	db.d.Children = append(db.d.Children, child.d)
+	child.budget = db.budget
+	if db.budget.admitDatum() {
+		db.d.Children = append(db.d.Children, child.d)
+	}
+	// Otherwise, the child is dropped; since its series is truncated, any
+	// updates to it will be dropped too.
 	return child
 }
 
@@ -815,6 +1257,27 @@ func (db *datumBuilder) withTimestamp(key string, value time.Time) *datumBuilder
 	return db
 }
 
+// withBool sets the specified bool value to the specified key within the map.
+// It supports chaining.
+func (db *datumBuilder) withBool(key string, value bool) *datumBuilder {
+	db.valsByKey[db.st.stringIndex(key)] = BoolValue(value)
+	return db
+}
+
+// withList sets the specified list value to the specified key within the map.
+// It supports chaining.
+func (db *datumBuilder) withList(key string, values ...*V) *datumBuilder {
+	db.valsByKey[db.st.stringIndex(key)] = ListValue(values...)
+	return db
+}
+
+// withMap sets the specified map value to the specified key within the map.
+// It supports chaining.
+func (db *datumBuilder) withMap(key string, values map[string]*V) *datumBuilder {
+	db.valsByKey[db.st.stringIndex(key)] = MapValue(values)
+	return db
+}
+
 // indexedValueMap returns the string-indexing value map.
 func (db *datumBuilder) indexedValueMap() map[int64]*V {
 	return db.valsByKey
@@ -903,6 +1366,27 @@ func Timestamp(value time.Time) Value {
 	}
 }
 
+// Bool produces a Value setting the specified bool value.
+func Bool(value bool) Value {
+	return func(key string) PropertyUpdate {
+		return BoolProperty(key, value)
+	}
+}
+
+// List produces a Value setting the specified list value.
+func List(values ...*V) Value {
+	return func(key string) PropertyUpdate {
+		return ListProperty(key, values...)
+	}
+}
+
+// Map produces a Value setting the specified map value.
+func Map(values map[string]*V) Value {
+	return func(key string) PropertyUpdate {
+		return MapProperty(key, values)
+	}
+}
+
 // Error produces a Value which, when invoked, errors the DataBuilder.
 func Error(err error) Value {
 	return func(key string) PropertyUpdate {
@@ -977,3 +1461,31 @@ func TimestampProperty(key string, value time.Time) PropertyUpdate {
 		return nil
 	}
 }
+
+// BoolProperty returns a PropertyUpdate adding the specified bool property.
+func BoolProperty(key string, value bool) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		db.withBool(key, value)
+		return nil
+	}
+}
+
+// ListProperty returns a PropertyUpdate adding the specified list property.
+// Nested Values are sent as-is; in particular, strings within them are not
+// string-indexed.
+func ListProperty(key string, values ...*V) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		db.withList(key, values...)
+		return nil
+	}
+}
+
+// MapProperty returns a PropertyUpdate adding the specified map property.
+// Nested Values are sent as-is; in particular, strings within them are not
+// string-indexed.
+func MapProperty(key string, values map[string]*V) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		db.withMap(key, values)
+		return nil
+	}
+}
@@ -321,6 +321,28 @@ func TestValueEncodingAndDecoding(t *testing.T) {
 	}, {
 		description: "ts",
 		value:       TimestampValue(time.Unix(500, 1000)),
+	}, {
+		description: "bool",
+		value:       BoolValue(true),
+	}, {
+		description: "list",
+		value: ListValue(
+			BoolValue(false),
+			StringValue("hello"),
+			ListValue(IntValue(1), DurationValue(time.Second)),
+		),
+	}, {
+		description: "empty list",
+		value:       ListValue(),
+	}, {
+		description: "map",
+		value: MapValue(map[string]*V{
+			"enabled": BoolValue(true),
+			"weights": MapValue(map[string]*V{
+				"a": DoubleValue(0.5),
+			}),
+			"ts": TimestampValue(time.Unix(500, 1000)),
+		}),
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			vj, err := json.Marshal(test.value)
@@ -469,9 +491,27 @@ func TestExpectValues(t *testing.T) {
 				"dbl":  DoubleValue(3.14159),
 				"dur":  DurationValue(100 * time.Millisecond),
 				"ts":   TimestampValue(time.Unix(100, 1000)),
+				"bool": BoolValue(true),
+				"list": ListValue(IntValue(1), StringValue("a")),
+				"map":  MapValue(map[string]*V{"k": BoolValue(false)}),
 			},
 		},
 		expect: func(req *DataRequest) error {
+			if got, err := ExpectBoolValue(req.GlobalFilters["bool"]); err != nil {
+				return err
+			} else if !got {
+				return fmt.Errorf("got wrong value '%v' for 'bool'", got)
+			}
+			if got, err := ExpectListValue(req.GlobalFilters["list"]); err != nil {
+				return err
+			} else if diff := cmp.Diff([]*V{IntValue(1), StringValue("a")}, got); diff != "" {
+				return fmt.Errorf("got wrong value '%v' for 'list'", got)
+			}
+			if got, err := ExpectMapValue(req.GlobalFilters["map"]); err != nil {
+				return err
+			} else if diff := cmp.Diff(map[string]*V{"k": BoolValue(false)}, got); diff != "" {
+				return fmt.Errorf("got wrong value '%v' for 'map'", got)
+			}
 			if got, err := ExpectStringValue(req.GlobalFilters["str"]); err != nil {
 				return err
 			} else if got != "global_filter" {
@@ -696,6 +736,26 @@ func TestPrettyPrint(t *testing.T) {
       Child:
         Prop 'items': [ 'apple', 'banana', 'coconut' ]
         Prop 'temp_f': 60.000000`,
+	}, {
+		description: "structured values",
+		builder: func() *DataResponseBuilder {
+			drb := NewDataResponseBuilder()
+			drb.DataSeries(&DataSeriesRequest{SeriesName: "0"}).With(
+				BoolProperty("enabled", true),
+				ListProperty("mixed", IntValue(1), StringValue("two"), BoolValue(false)),
+				Map(map[string]*V{
+					"b": ListValue(),
+					"a": DoubleValue(1),
+				})("settings"),
+			)
+			return drb
+		},
+		want: `Data:
+  Series 0
+    Root:
+      Prop 'enabled': true
+      Prop 'mixed': [ 1, 'two', false ]
+      Prop 'settings': { 'a': 1.000000, 'b': [  ] }`,
 	}} {
 		drb := test.builder()
 		got, err := drb.Data()
@@ -0,0 +1,108 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package weightedtree
+
+import (
+	"fmt"
+
+	"github.com/ilhamster/traceviz/server/go/magnitude"
+	"github.com/ilhamster/traceviz/server/go/payload"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedTree is a Tree decoded from a Datum.  Each decoded element retains a
+// reader over its Datum's Properties, from which decorators may be read.
+type DecodedTree struct {
+	Properties *util.DatumReader
+	// BottomUp is true if the tree was marked BottomUp().
+	BottomUp bool
+	Roots    []*DecodedNode
+}
+
+// DecodedNode is a tree Node decoded from a Datum.
+type DecodedNode struct {
+	Properties    *util.DatumReader
+	SelfMagnitude float64
+	Children      []*DecodedNode
+	// Readers over the node's payloads.  Their types may be read with
+	// payload.DecodeType.
+	Payloads []*util.DatumReader
+}
+
+// TotalMagnitude returns the sum of the receiver's self-magnitude and the
+// total magnitudes of all its children.
+func (dn *DecodedNode) TotalMagnitude() float64 {
+	ret := dn.SelfMagnitude
+	for _, child := range dn.Children {
+		ret += child.TotalMagnitude()
+	}
+	return ret
+}
+
+// Decode decodes the provided Datum, which must have been populated by a Tree
+// and whose strings are in the provided string table, back into a
+// DecodedTree.
+func Decode(d *util.Datum, stringTable []string) (*DecodedTree, error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	ret := &DecodedTree{
+		Properties: dr,
+	}
+	if dr.Has(directionKey) {
+		direction, err := dr.String(directionKey)
+		if err != nil {
+			return nil, err
+		}
+		switch direction {
+		case topDown:
+		case bottomUp:
+			ret.BottomUp = true
+		default:
+			return nil, fmt.Errorf("unsupported weighted tree direction '%s'", direction)
+		}
+	}
+	for _, child := range dr.Children() {
+		root, err := decodeNode(child)
+		if err != nil {
+			return nil, err
+		}
+		ret.Roots = append(ret.Roots, root)
+	}
+	return ret, nil
+}
+
+func decodeNode(dr *util.DatumReader) (*DecodedNode, error) {
+	selfMagnitude, err := magnitude.DecodeSelfMagnitude(dr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode weighted tree node: %w", err)
+	}
+	ret := &DecodedNode{
+		Properties:    dr,
+		SelfMagnitude: selfMagnitude,
+	}
+	for _, child := range dr.Children() {
+		if child.Has(payload.TypeKey) {
+			ret.Payloads = append(ret.Payloads, child)
+			continue
+		}
+		node, err := decodeNode(child)
+		if err != nil {
+			return nil, err
+		}
+		ret.Children = append(ret.Children, node)
+	}
+	return ret, nil
+}
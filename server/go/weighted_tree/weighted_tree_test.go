@@ -14,8 +14,10 @@
 package weightedtree
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ilhamster/traceviz/server/go/magnitude"
 	"github.com/ilhamster/traceviz/server/go/payload"
 	"github.com/ilhamster/traceviz/server/go/test_util"
@@ -93,3 +95,45 @@ func TestTreeConstruction(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		tree := New(db, defaultRenderSettings).BottomUp()
+		a := tree.Node(1, name("a"))
+		a.Node(2, name("b"))
+		payload.New(a.Node(3, name("c")), "thumbnail")
+		tree.Node(4, name("d"))
+	})
+	got, err := Decode(root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if !got.BottomUp {
+		t.Errorf("Decode() yielded a top-down tree, want bottom-up")
+	}
+	// Render each node as a line with its name, self-magnitude,
+	// total-magnitude, and payload count, indented by depth.
+	var gotLines []string
+	var render func(indent string, nodes []*DecodedNode)
+	render = func(indent string, nodes []*DecodedNode) {
+		for _, node := range nodes {
+			nodeName, err := node.Properties.String("name")
+			if err != nil {
+				t.Fatalf("failed to read node name: %s", err)
+			}
+			gotLines = append(gotLines, fmt.Sprintf("%s%s self %v total %v payloads %d",
+				indent, nodeName, node.SelfMagnitude, node.TotalMagnitude(), len(node.Payloads)))
+			render(indent+"  ", node.Children)
+		}
+	}
+	render("", got.Roots)
+	wantLines := []string{
+		"a self 1 total 6 payloads 0",
+		"  b self 2 total 2 payloads 0",
+		"  c self 3 total 3 payloads 1",
+		"d self 4 total 4 payloads 0",
+	}
+	if diff := cmp.Diff(wantLines, gotLines); diff != "" {
+		t.Errorf("Decode() yielded tree %v, diff (-want +got) %s", gotLines, diff)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xychart
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ilhamster/traceviz/server/go/category"
+	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
+	"github.com/ilhamster/traceviz/server/go/util"
+)
+
+// DecodedXYChart is an XYChart decoded from a Datum.  Each decoded element
+// retains a reader over its Datum's Properties, from which decorators may be
+// read.
+type DecodedXYChart[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	XAxis      *continuousaxis.DecodedAxis[X]
+	YAxis      *continuousaxis.DecodedAxis[Y]
+	Series     []*DecodedSeries[X, Y]
+}
+
+// DecodedSeries is an xy-chart Series decoded from a Datum.
+type DecodedSeries[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	Category   *category.Category
+	Points     []*DecodedPoint[X, Y]
+}
+
+// DecodedPoint is an xy-chart point decoded from a Datum.
+type DecodedPoint[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time] struct {
+	Properties *util.DatumReader
+	X          X
+	Y          Y
+}
+
+// Decode decodes the provided Datum, which must have been populated by an
+// XYChart[X, Y] and whose strings are in the provided string table, back into
+// a DecodedXYChart.
+func Decode[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time](
+	d *util.Datum, stringTable []string,
+) (*DecodedXYChart[X, Y], error) {
+	dr, err := util.NewDatumReader(d, stringTable)
+	if err != nil {
+		return nil, err
+	}
+	children := dr.Children()
+	if len(children) == 0 || len(children[0].Children()) != 2 {
+		return nil, fmt.Errorf("xy chart must have x and y axes")
+	}
+	axes := children[0].Children()
+	ret := &DecodedXYChart[X, Y]{
+		Properties: dr,
+	}
+	if ret.XAxis, err = continuousaxis.Decode[X](axes[0]); err != nil {
+		return nil, fmt.Errorf("failed to decode x axis: %w", err)
+	}
+	if ret.YAxis, err = continuousaxis.Decode[Y](axes[1]); err != nil {
+		return nil, fmt.Errorf("failed to decode y axis: %w", err)
+	}
+	for _, seriesDr := range children[1:] {
+		cat, err := category.Decode(seriesDr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode xy chart series: %w", err)
+		}
+		series := &DecodedSeries[X, Y]{
+			Properties: seriesDr,
+			Category:   cat,
+		}
+		for _, pointDr := range seriesDr.Children() {
+			point := &DecodedPoint[X, Y]{
+				Properties: pointDr,
+			}
+			if point.X, err = continuousaxis.DecodeValue[X](pointDr, ret.XAxis.Category.ID()); err != nil {
+				return nil, err
+			}
+			if point.Y, err = continuousaxis.DecodeValue[Y](pointDr, ret.YAxis.Category.ID()); err != nil {
+				return nil, err
+			}
+			series.Points = append(series.Points, point)
+		}
+		ret.Series = append(ret.Series, series)
+	}
+	return ret, nil
+}
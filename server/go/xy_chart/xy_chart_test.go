@@ -17,6 +17,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/ilhamster/traceviz/server/go/category"
 	"github.com/ilhamster/traceviz/server/go/color"
 	continuousaxis "github.com/ilhamster/traceviz/server/go/continuous_axis"
@@ -132,3 +133,55 @@ func TestXYChart(t *testing.T) {
 		})
 	}
 }
+
+func TestDecode(t *testing.T) {
+	xAxisCat := category.New("x_axis", "time from start", "Time from start")
+	yAxisCat := category.New("y_axis", "events per second", "Events per second")
+	thingsCat := category.New("things", "Remembered Things", "Things we remembered")
+	stuffCat := category.New("stuff", "Forgotten Stuff", "Stuff we forgot")
+	root, st := testutil.Build(t, func(db util.DataBuilder) {
+		xyChart := New(db,
+			continuousaxis.NewDurationAxis(xAxisCat, 0, 10*time.Second),
+			continuousaxis.NewDoubleAxis(yAxisCat, 0, 100),
+			util.StringProperty("title", "events"),
+		)
+		xyChart.AddSeries(thingsCat).
+			WithPoint(0, 10).
+			WithPoint(time.Second, 20, util.StringProperty("note", "peak"))
+		xyChart.AddSeries(stuffCat).
+			WithPoint(2*time.Second, 5)
+	})
+	got, err := Decode[time.Duration, float64](root, st)
+	if err != nil {
+		t.Fatalf("Decode() yielded unexpected error %s", err)
+	}
+	if got.XAxis.Category.ID() != xAxisCat.ID() || got.XAxis.Max != 10*time.Second {
+		t.Errorf("Decode() yielded x axis %v, want a %s axis to 10s", got.XAxis, xAxisCat.ID())
+	}
+	if got.YAxis.Category.ID() != yAxisCat.ID() || got.YAxis.Max != 100 {
+		t.Errorf("Decode() yielded y axis %v, want a %s axis to 100", got.YAxis, yAxisCat.ID())
+	}
+	type point struct {
+		X time.Duration
+		Y float64
+	}
+	gotSeries := map[string][]point{}
+	for _, series := range got.Series {
+		for _, p := range series.Points {
+			gotSeries[series.Category.ID()] = append(gotSeries[series.Category.ID()], point{p.X, p.Y})
+		}
+	}
+	wantSeries := map[string][]point{
+		"things": {{0, 10}, {time.Second, 20}},
+		"stuff":  {{2 * time.Second, 5}},
+	}
+	if diff := cmp.Diff(wantSeries, gotSeries); diff != "" {
+		t.Errorf("Decode() yielded series %v, diff (-want +got) %s", gotSeries, diff)
+	}
+	if note, err := got.Series[0].Points[1].Properties.String("note"); err != nil || note != "peak" {
+		t.Errorf("Decode() yielded point note %q (err %v), want 'peak'", note, err)
+	}
+	if _, err := Decode[time.Time, float64](root, st); err == nil {
+		t.Errorf("Decode() with the wrong x axis type succeeded, wanted error")
+	}
+}